@@ -41,6 +41,8 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/boot/menu"
 	"github.com/u-root/u-root/pkg/cmdline"
 	"github.com/u-root/u-root/pkg/kexec"
 )
@@ -51,6 +53,7 @@ const (
 )
 
 type bootEntry struct {
+	name    string
 	kernel  string
 	initrd  string
 	cmdline string
@@ -62,6 +65,7 @@ var (
 	debug             = func(string, ...interface{}) {}
 	dryRun            = flag.Bool("dry-run", false, "download kernel, but don't kexec it")
 	defaultBoot       = flag.String("boot", "default", "Default entry to boot")
+	timeout           = flag.Duration("timeout", 0, "Show a boot menu for this long before booting the default entry")
 	uroot             string
 	removeCmdlineItem = flag.String("remove", "console", "comma separated list of kernel params value to remove from parsed kernel configuration (default to console)")
 	reuseCmdlineItem  = flag.String("reuse", "console", "comma separated list of kernel params value to reuse from current kernel (default to console)")
@@ -248,7 +252,7 @@ func getBootEntries(lines []string) (map[string]*bootEntry, map[string]string, e
 			// nasty, but the alternatives are not much better.
 			// grub config language is kind of arbitrary
 			curEntry = fmt.Sprintf("\"%s\"", strconv.Itoa(numEntry))
-			be = &bootEntry{}
+			be = &bootEntry{name: entryName(line)}
 			curLabel = f[1]
 			bootEntries[f[1]] = be
 			bootEntries[curEntry] = be
@@ -290,6 +294,19 @@ func getBootEntries(lines []string) (map[string]*bootEntry, map[string]string, e
 
 }
 
+// entryName returns the title of a menuentry or label line, which GRUB may
+// quote.
+func entryName(line string) string {
+	f := strings.Fields(line)
+	title := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), f[0]))
+	if len(title) > 0 && (title[0] == '\'' || title[0] == '"') {
+		if i := strings.IndexByte(title[1:], title[0]); i >= 0 {
+			return title[1 : i+1]
+		}
+	}
+	return f[1]
+}
+
 func copyLocal(path string) (string, error) {
 	var dest string
 	var err error
@@ -346,6 +363,33 @@ func kexecLoad(grubConfPath string, grub []string, mountPoint string) error {
 		return fmt.Errorf("Entry %v not found in boot entries file", entry)
 	}
 
+	// Entries are also keyed by their number, in order. The menu's images
+	// only carry the command line to edit; the kernel is copied and loaded
+	// below.
+	m := &menu.Menu{Timeout: *timeout, Terminals: menu.Consoles()}
+	var entries []*bootEntry
+	for i := 0; b[fmt.Sprintf("\"%d\"", i)] != nil; i++ {
+		e := b[fmt.Sprintf("\"%d\"", i)]
+		if e == be {
+			m.Default = i
+		}
+		entries = append(entries, e)
+		m.Entries = append(m.Entries, &menu.Entry{
+			Label: e.name,
+			Image: &boot.LinuxImage{Cmdline: updateBootCmdline(e.cmdline)},
+		})
+	}
+	chosen, err := m.Choose()
+	if err != nil {
+		return err
+	}
+	cl, _ := menu.Cmdline(chosen.Image)
+	for i, e := range m.Entries {
+		if e == chosen {
+			be = entries[i]
+		}
+	}
+
 	debug("Boot params: %q", be)
 	localKernelPath, err := copyLocal(filepath.Join(mountPoint, be.kernel))
 	if err != nil {
@@ -377,8 +421,6 @@ func kexecLoad(grubConfPath string, grub []string, mountPoint string) error {
 	}
 	// defer ramfs.Close()
 
-	cl := updateBootCmdline(be.cmdline)
-
 	log.Printf("Kernel cmdline %s", cl)
 
 	if err := kexec.FileLoad(kernelDesc, ramfs, cl); err != nil {
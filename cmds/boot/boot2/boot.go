@@ -11,115 +11,118 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/boot/menu"
+	"github.com/u-root/u-root/pkg/boot/vboot"
 	"github.com/u-root/u-root/pkg/cmdline"
 	"github.com/u-root/u-root/pkg/diskboot"
-	"github.com/u-root/u-root/pkg/kexec"
 	"github.com/u-root/u-root/pkg/mount"
 )
 
 var (
 	v       = flag.Bool("v", false, "Print debug messages")
 	verbose = func(string, ...interface{}) {}
-	dryrun  = flag.Bool("dryrun", false, "Only print out kexec commands")
+	dryrun  = flag.Bool("dryrun", false, "Only print out the entry that would be booted")
 
 	devGlob           = flag.String("dev", "/sys/class/block/*", "Device glob")
-	sDeviceIndex      = flag.String("d", "", "Device index")
-	sConfigIndex      = flag.String("c", "", "Config index")
-	sEntryIndex       = flag.String("n", "", "Entry index")
+	sDeviceIndex      = flag.String("d", "", "Device index, to only show entries of that device")
+	sConfigIndex      = flag.String("c", "", "Config index, to only show entries of that config")
+	sEntryIndex       = flag.String("n", "", "Entry index in the first config, to boot it without waiting")
+	timeout           = flag.Duration("timeout", 10*time.Second, "How long to show the boot menu before booting the default entry")
 	removeCmdlineItem = flag.String("remove", "console", "comma separated list of kernel params value to remove from parsed kernel configuration (default to console)")
 	reuseCmdlineItem  = flag.String("reuse", "console", "comma separated list of kernel params value to reuse from current kernel (default to console)")
 	appendCmdline     = flag.String("append", "", "Additional kernel params")
+	verify            = flag.String("verify", "off", "Whether kernels, initramfs and command lines must have detached signatures: off, warn or enforce")
+	keyring           = flag.String("keyring", vboot.DefaultKeyring, "File or directory with the public keys trusted to sign kernels")
 
 	devices []*diskboot.Device
 )
 
-func getDevice() (*diskboot.Device, error) {
-	devices = diskboot.FindDevices(*devGlob)
-	if len(devices) == 0 {
-		return nil, errors.New("No devices found")
+// index parses the index flag s for a list of n things. It returns -1 if
+// the flag is not set.
+func index(s string, n int, what string) (int, error) {
+	if s == "" {
+		return -1, nil
 	}
-
-	verbose("Got devices: %#v", devices)
-	var err error
-	deviceIndex := 0
-	if len(devices) > 1 {
-		if *sDeviceIndex == "" {
-			for i, device := range devices {
-				log.Printf("Device #%v: path: %v type: %v",
-					i, device.DevPath, device.Fstype)
-			}
-			return nil, errors.New("Multiple devices found - must specify a device index")
-		}
-		if deviceIndex, err = strconv.Atoi(*sDeviceIndex); err != nil ||
-			deviceIndex < 0 || deviceIndex >= len(devices) {
-			return nil, fmt.Errorf("invalid device index %q", *sDeviceIndex)
-		}
+	i, err := strconv.Atoi(s)
+	if err != nil || i < 0 || i >= n {
+		return 0, fmt.Errorf("invalid %s index %q", what, s)
 	}
-	return devices[deviceIndex], nil
+	return i, nil
 }
 
-func getConfig(device *diskboot.Device) (*diskboot.Config, error) {
-	configs := device.Configs
-	if len(configs) == 0 {
-		return nil, errors.New("No config found")
+// getMenu returns a boot menu of the entries of all configs on all devices,
+// or those -d and -c choose. The default entry is the default of the first
+// config that has one, or -n.
+func getMenu() (*menu.Menu, error) {
+	policy, err := vboot.ParsePolicy(*verify, *keyring)
+	if err != nil {
+		return nil, err
 	}
 
-	verbose("Got configs: %#v", configs)
-	var err error
-	configIndex := 0
-	if len(configs) > 1 {
-		if *sConfigIndex == "" {
-			for i, config := range configs {
-				log.Printf("Config #%v: path: %v", i, config.ConfigPath)
-			}
-			return nil, errors.New("Multiple configs found - must specify a config index")
-		}
-		if configIndex, err = strconv.Atoi(*sConfigIndex); err != nil ||
-			configIndex < 0 || configIndex >= len(configs) {
-			return nil, fmt.Errorf("invalid config index %q", *sConfigIndex)
-		}
+	devices = diskboot.FindDevices(*devGlob)
+	if len(devices) == 0 {
+		return nil, errors.New("No devices found")
 	}
-	return configs[configIndex], nil
-}
+	verbose("Got devices: %#v", devices)
 
-func getEntry(config *diskboot.Config) (*diskboot.Entry, error) {
-	verbose("Got entries: %#v", config.Entries)
-	var err error
-	entryIndex := 0
-	if *sEntryIndex != "" {
-		if entryIndex, err = strconv.Atoi(*sEntryIndex); err != nil ||
-			entryIndex < 0 || entryIndex >= len(config.Entries) {
-			return nil, fmt.Errorf("invalid entry index %q", *sEntryIndex)
-		}
-	} else if config.DefaultEntry >= 0 {
-		entryIndex = config.DefaultEntry
-	} else {
-		for i, entry := range config.Entries {
-			log.Printf("Entry #%v: %#v", i, entry)
-		}
-		return nil, errors.New("No entry specified")
+	devs := devices
+	d, err := index(*sDeviceIndex, len(devices), "device")
+	if err != nil {
+		return nil, err
+	}
+	if d >= 0 {
+		devs = devices[d : d+1]
 	}
-	return &config.Entries[entryIndex], nil
-}
 
-func bootEntry(config *diskboot.Config, entry *diskboot.Entry) error {
-	verbose("Booting entry: %v", entry)
 	filter := cmdline.NewUpdateFilter(*appendCmdline, strings.Split(*removeCmdlineItem, ","), strings.Split(*reuseCmdlineItem, ","))
-	err := entry.KexecLoad(config.MountPath, filter, *dryrun)
-	if err != nil {
-		return fmt.Errorf("wrror doing kexec load: %v", err)
-	}
+	m := &menu.Menu{Default: -1, Timeout: *timeout, Terminals: menu.Consoles()}
+	for _, device := range devs {
+		configs := device.Configs
+		verbose("Got configs: %#v", configs)
+		c, err := index(*sConfigIndex, len(configs), "config")
+		if err != nil {
+			return nil, err
+		}
+		if c >= 0 {
+			configs = configs[c : c+1]
+		}
 
-	if *dryrun {
-		return nil
+		for _, config := range configs {
+			verbose("Got entries: %#v", config.Entries)
+			def := config.DefaultEntry
+			if m.Entries == nil && *sEntryIndex != "" {
+				if def, err = index(*sEntryIndex, len(config.Entries), "entry"); err != nil {
+					return nil, err
+				}
+				m.Timeout = 0
+			}
+			for i := range config.Entries {
+				entry := &config.Entries[i]
+				img, err := entry.OSImage(config.MountPath, filter)
+				if err != nil {
+					log.Printf("Skipping entry %q of %s: %v", entry.Name, config.ConfigPath, err)
+					continue
+				}
+				if err := boot.SetVerifyPolicy(img, policy); err != nil {
+					return nil, err
+				}
+				if i == def && m.Default < 0 {
+					m.Default = len(m.Entries)
+				}
+				m.Entries = append(m.Entries, &menu.Entry{
+					Label: fmt.Sprintf("%s (%s on %s)", entry.Name, config.ConfigPath, device.DevPath),
+					Image: img,
+				})
+			}
+		}
 	}
-
-	err = kexec.Reboot()
-	if err != nil {
-		return fmt.Errorf("error doing kexec reboot: %v", err)
+	if len(m.Entries) == 0 {
+		return nil, errors.New("No entry found")
 	}
-	return nil
+	return m, nil
 }
 
 func cleanDevices() {
@@ -137,19 +140,19 @@ func main() {
 	}
 	defer cleanDevices()
 
-	device, err := getDevice()
-	if err != nil {
-		log.Panic(err)
-	}
-	config, err := getConfig(device)
+	m, err := getMenu()
 	if err != nil {
 		log.Panic(err)
 	}
-	entry, err := getEntry(config)
-	if err != nil {
-		log.Panic(err)
+	if *dryrun {
+		entry, err := m.Choose()
+		if err != nil {
+			log.Panic(err)
+		}
+		log.Printf("Would boot %s: %v", entry, entry.Image)
+		return
 	}
-	if err := bootEntry(config, entry); err != nil {
+	if err := m.Boot(*v); err != nil {
 		log.Panic(err)
 	}
 }
@@ -0,0 +1,91 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Show and change the persistent boot state.
+//
+// Synopsis:
+//     bootstate -dir DIR [show]
+//     bootstate -dir DIR confirm [ENTRY]
+//     bootstate -dir DIR once ENTRY
+//     bootstate -dir DIR clear
+//
+// Description:
+//     The boot commands count the boots of each entry and skip entries that
+//     were booted too often. Run `bootstate confirm` from the booted OS once
+//     it came up to reset the count of the entry it was booted from, or of
+//     ENTRY.
+//
+//     `bootstate once ENTRY` boots ENTRY on the next boot only. `bootstate
+//     clear` forgets all counts and the entry to boot once.
+//
+//     The state is kept in a file in DIR, which must be on a persistent
+//     partition that both the boot commands and the booted OS can reach,
+//     such as the ESP: pass the same directory as `localboot -state-dir`, as
+//     seen from each side. bootstate fails if DIR is on a tmpfs or ramfs,
+//     such as the initramfs, where the state would be lost at reboot. The
+//     read-write VPD is read too, but cannot be written from Linux.
+//
+// Options:
+//     -dir: directory of the state file, on a persistent partition
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/u-root/u-root/pkg/boot/bootstate"
+)
+
+var dir = flag.String("dir", "", "Directory of the state file, on a persistent partition such as the ESP")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s -dir DIR [show | confirm [ENTRY] | once ENTRY | clear]\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	store, err := bootstate.New(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"show"}
+	}
+	var update func(*bootstate.State)
+	switch {
+	case args[0] == "show" && len(args) == 1:
+		b, err := json.MarshalIndent(store.Load(), "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+		return
+	case args[0] == "confirm" && len(args) <= 2:
+		entry := ""
+		if len(args) == 2 {
+			entry = args[1]
+		}
+		update = func(s *bootstate.State) { s.Confirm(entry) }
+	case args[0] == "once" && len(args) == 2:
+		update = func(s *bootstate.State) { s.NextBoot = args[1] }
+	case args[0] == "clear" && len(args) == 1:
+		update = func(s *bootstate.State) {
+			s.NextBoot = ""
+			s.Attempts = nil
+		}
+	default:
+		usage()
+	}
+	if err := store.Update(update); err != nil {
+		log.Fatal(err)
+	}
+}
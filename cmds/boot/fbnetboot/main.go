@@ -6,7 +6,6 @@ package main
 
 import (
 	"crypto/tls"
-	"crypto/x509"
 	"errors"
 	"flag"
 	"fmt"
@@ -26,8 +25,10 @@ import (
 	"github.com/insomniacslk/dhcp/iana"
 	"github.com/insomniacslk/dhcp/interfaces"
 	"github.com/insomniacslk/dhcp/netboot"
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/boot/menu"
 	"github.com/u-root/u-root/pkg/crypto"
-	"github.com/u-root/u-root/pkg/kexec"
+	"github.com/u-root/u-root/pkg/urlfetch"
 )
 
 var (
@@ -44,6 +45,7 @@ var (
 	caCertFile         = flag.String("cacerts", "/etc/cacerts.pem", "CA cert file")
 	skipCertVerify     = flag.Bool("skip-cert-verify", false, "Don't authenticate https certs")
 	doFix              = flag.Bool("fix", false, "Try to run fixmynetboot if netboot fails")
+	menuTimeout        = flag.Duration("menu-timeout", 0, "Show a boot menu for this long, e.g. to edit the kernel command line, before booting")
 )
 
 const (
@@ -113,7 +115,7 @@ func main() {
 			dhcp = append(dhcp, dhcp4)
 		}
 		for _, d := range dhcp {
-			if err := boot(iface.Name, d); err != nil {
+			if err := bootIface(iface.Name, d); err != nil {
 				if *doFix {
 					cmd := exec.Command("fixmynetboot", iface.Name)
 					log.Printf("Running %s", strings.Join(cmd.Args, " "))
@@ -155,7 +157,7 @@ func retryableHTTPError(resp *http.Response) bool {
 	return false
 }
 
-func boot(ifname string, dhcp dhcpFunc) error {
+func bootIface(ifname string, dhcp dhcpFunc) error {
 	var (
 		netconf  *netboot.NetConf
 		bootfile string
@@ -249,10 +251,14 @@ func boot(ifname string, dhcp dhcpFunc) error {
 		if err != nil {
 			return fmt.Errorf("DHCP: cannot open file %s: %v", filename, err)
 		}
-		if err = kexec.FileLoad(kernel, nil /* ramfs */, "" /* cmdline */); err != nil {
-			return fmt.Errorf("DHCP: kexec.FileLoad failed: %v", err)
+		img := &boot.LinuxImage{Kernel: kernel}
+		if _, err := menu.New(*menuTimeout, menu.Consoles(), img).Choose(); err != nil {
+			return fmt.Errorf("DHCP: boot menu failed: %v", err)
 		}
-		if err = kexec.Reboot(); err != nil {
+		if err = img.Load(*doDebug); err != nil {
+			return fmt.Errorf("DHCP: kexec load failed: %v", err)
+		}
+		if err = boot.Execute(); err != nil {
 			return fmt.Errorf("DHCP: kexec.Reboot failed: %v", err)
 		}
 	}
@@ -271,30 +277,6 @@ func getScheme(urlstring string) (string, error) {
 	return scheme, nil
 }
 
-func loadCaCerts() (*x509.CertPool, error) {
-	rootCAs, err := x509.SystemCertPool()
-	if err != nil {
-		return nil, err
-	}
-	if rootCAs == nil {
-		debug("certs: rootCAs == nil")
-		rootCAs = x509.NewCertPool()
-	}
-	caCerts, err := ioutil.ReadFile(*caCertFile)
-	if err != nil {
-		return nil, fmt.Errorf("could not find cert file '%v' - %v", *caCertFile, err)
-	}
-	// TODO: Decide if this should also support compressed certs
-	// Might be better to have a generic compressed config API
-	if ok := rootCAs.AppendCertsFromPEM(caCerts); !ok {
-		debug("Failed to append CA Certs from %s, using system certs only", *caCertFile)
-	} else {
-		debug("CA certs appended from PEM")
-	}
-	return rootCAs, nil
-
-}
-
 func getClientForBootfile(bootfile string) (*http.Client, error) {
 	var client *http.Client
 	scheme, err := getScheme(bootfile)
@@ -304,22 +286,22 @@ func getClientForBootfile(bootfile string) (*http.Client, error) {
 
 	switch scheme {
 	case "https":
-		var config *tls.Config
 		if *skipCertVerify {
-			config = &tls.Config{
+			config := &tls.Config{
 				InsecureSkipVerify: true,
 			}
-		} else if *caCertFile != "" {
-			rootCAs, err := loadCaCerts()
+			client = &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		} else {
+			config := &urlfetch.TLSConfig{SystemCAs: true}
+			if *caCertFile != "" {
+				config.CAFiles = []string{*caCertFile}
+			}
+			tr, err := config.Transport()
 			if err != nil {
 				return nil, err
 			}
-			config = &tls.Config{
-				RootCAs: rootCAs,
-			}
+			client = &http.Client{Transport: tr}
 		}
-		tr := &http.Transport{TLSClientConfig: config}
-		client = &http.Client{Transport: tr}
 		debug("https client setup (use certs from VPD: %t, skipCertVerify %t)",
 			*skipCertVerify, *caCertFile != "")
 	case "http":
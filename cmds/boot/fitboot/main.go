@@ -0,0 +1,105 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Boot a configuration of a U-Boot FIT image.
+//
+// Synopsis:
+//     fitboot [-l] [-config NAME] [-cmdline CMDLINE] [-verify MODE] [-keyring PATH] FILE
+//
+// Description:
+//     fitboot loads the kernel, ramdisk and device tree of a configuration of
+//     the FIT image FILE (.itb) and kexecs into it. The hashes of the images
+//     are always verified; with -verify, the configuration, which signs the
+//     hashes, must also be signed by one of the keys in -keyring. The
+//     command line is signed by FILE.cmdline.sig.
+//
+// Options:
+//     -l:       list the configurations and images and exit
+//     -config:  configuration to boot, the default configuration if empty
+//     -cmdline: kernel command line
+//     -verify:  off, warn or enforce
+//     -keyring: file or directory with the trusted public keys
+//     -dryrun:  load, but do not boot
+//     -v:       print debug output
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/boot/fit"
+	"github.com/u-root/u-root/pkg/boot/vboot"
+)
+
+var (
+	list    = flag.Bool("l", false, "List the configurations and images and exit")
+	config  = flag.String("config", "", "Configuration to boot, the default configuration if empty")
+	cmdline = flag.String("cmdline", "", "Kernel command line")
+	verify  = flag.String("verify", "off", "Whether the configuration must be signed: off, warn or enforce")
+	keyring = flag.String("keyring", vboot.DefaultKeyring, "File or directory with the public keys trusted to sign images")
+	dryrun  = flag.Bool("dryrun", false, "Load, but do not boot")
+	verbose = flag.Bool("v", false, "Print debug output")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] FILE\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+	}
+	path := flag.Arg(0)
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	img, err := fit.Parse(f)
+	if err != nil {
+		log.Fatalf("%s: %v", path, err)
+	}
+
+	if *list {
+		fmt.Printf("%s (default configuration %q)\n", img.Description, img.DefaultConfig)
+		for _, c := range img.Configs {
+			fmt.Printf("  config %s\n", c)
+		}
+		for _, i := range img.Images {
+			fmt.Printf("  image %s\n", i)
+		}
+		return
+	}
+
+	p, err := vboot.ParsePolicy(*verify, *keyring)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bi := &fit.BootImage{
+		FIT:        img,
+		Config:     *config,
+		Cmdline:    *cmdline,
+		Verify:     p,
+		CmdlineSig: vboot.DetachedCmdline(path),
+	}
+	log.Printf("Loading %s", bi)
+	if err := bi.Load(*verbose); err != nil {
+		log.Fatal(err)
+	}
+	if *dryrun {
+		log.Printf("Not booting %s: dry run", path)
+		return
+	}
+	if err := boot.Execute(); err != nil {
+		log.Fatal(err)
+	}
+}
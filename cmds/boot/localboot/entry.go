@@ -0,0 +1,78 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/boot/bls"
+	"github.com/u-root/u-root/pkg/boot/grub"
+	"github.com/u-root/u-root/pkg/boot/menu"
+	"github.com/u-root/u-root/pkg/crypto"
+)
+
+// bootEntry is a kernel found in a GRUB configuration or a BootLoaderSpec
+// entry.
+type bootEntry struct {
+	name  string
+	image boot.OSImage
+
+	// files are the files the image is loaded from. They are measured
+	// into the TPM before booting.
+	files []string
+
+	// bootConfig returns the boot configuration to measure into the TPM,
+	// given the command line to boot with.
+	bootConfig func(cmdline string) []byte
+}
+
+func grubEntry(e *grub.Entry) bootEntry {
+	files := append([]string{e.Kernel}, e.Initrds...)
+	for _, module := range e.Modules {
+		if f := strings.Fields(module); len(f) > 0 {
+			files = append(files, f[0])
+		}
+	}
+	return bootEntry{
+		name:  e.Title,
+		image: e.OSImage(),
+		files: files,
+		// This is the layout localboot has always measured GRUB
+		// entries with, so that their PCR values do not change.
+		bootConfig: func(cmdline string) []byte {
+			return []byte(e.Title + e.Kernel + cmdline + strings.Join(files[1:], ""))
+		},
+	}
+}
+
+func blsEntry(e *bls.Entry) bootEntry {
+	files := append([]string{e.Linux}, e.Initrds...)
+	return bootEntry{
+		name:  e.String(),
+		image: e.OSImage(),
+		files: files,
+		// BootLoaderSpec entries are tagged and NUL-separated, so that
+		// they never measure the same as a GRUB entry or each other.
+		bootConfig: func(cmdline string) []byte {
+			fields := append([]string{"bls", e.ID, e.Title, cmdline}, files...)
+			return []byte(strings.Join(fields, "\x00"))
+		},
+	}
+}
+
+// boot measures the files of the entry into the TPM, if there is one, and
+// boots it. The command line is measured as it is after editing in the menu.
+func (e bootEntry) boot() error {
+	cmdline, _ := menu.Cmdline(e.image)
+	crypto.TryMeasureData(crypto.BootConfigPCR, e.bootConfig(cmdline), "bootconfig")
+	crypto.TryMeasureFiles(e.files...)
+
+	if err := e.image.Load(*flagDebug); err != nil {
+		return fmt.Errorf("failed to load %s: %v", e.files[0], err)
+	}
+	return boot.Execute()
+}
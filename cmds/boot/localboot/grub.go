@@ -5,8 +5,6 @@
 package main
 
 import (
-	"encoding/hex"
-	"io/ioutil"
 	"log"
 	"os"
 	"path"
@@ -17,8 +15,7 @@ import (
 	"golang.org/x/text/transform"
 	"golang.org/x/text/unicode/norm"
 
-	"github.com/u-root/u-root/pkg/bootconfig"
-	"github.com/u-root/u-root/pkg/storage"
+	"github.com/u-root/u-root/pkg/boot/grub"
 )
 
 // List of directories where to recursively look for grub config files. The root dorectory
@@ -39,13 +36,6 @@ var (
 // at /boot/efi/EFI/distro/ , 4 might be a good choice.
 const searchDepth = 4
 
-type grubVersion int
-
-var (
-	grubV1 grubVersion = 1
-	grubV2 grubVersion = 2
-)
-
 func isGrubSearchDir(dirname string) bool {
 	for _, dir := range GrubSearchDirectories {
 		if dirname == dir {
@@ -55,144 +45,18 @@ func isGrubSearchDir(dirname string) bool {
 	return false
 }
 
-// ParseGrubCfg parses the content of a grub.cfg and returns a list of
-// BootConfig structures, one for each menuentry, in the same order as they
-// appear in grub.cfg. All opened kernel and initrd files are relative to
-// basedir.
-func ParseGrubCfg(ver grubVersion, devices []storage.BlockDev, grubcfg string, basedir string) []bootconfig.BootConfig {
-	// This parser sucks. It's not even a parser, it just looks for lines
-	// starting with menuentry, linux or initrd.
-	// TODO use a parser, e.g. https://github.com/alecthomas/participle
-	if ver != grubV1 && ver != grubV2 {
-		log.Printf("Warning: invalid GRUB version: %d", ver)
-		return nil
-	}
-	kernelBasedir := basedir
-	bootconfigs := make([]bootconfig.BootConfig, 0)
-	inMenuEntry := false
-	var cfg *bootconfig.BootConfig
-	for _, line := range strings.Split(grubcfg, "\n") {
-		// remove all leading spaces as they are not relevant for the config
-		// line
-		line = strings.TrimLeft(line, " ")
-		sline := strings.Fields(line)
-		if len(sline) == 0 {
-			continue
-		}
-		if sline[0] == "menuentry" {
-			// if a "menuentry", start a new boot config
-			if cfg != nil {
-				// save the previous boot config, if any
-				if cfg.IsValid() {
-					// only consider valid boot configs, i.e. the ones that have
-					// both kernel and initramfs
-					bootconfigs = append(bootconfigs, *cfg)
-				}
-				// reset kernelBaseDir
-				kernelBasedir = basedir
-			}
-			inMenuEntry = true
-			cfg = new(bootconfig.BootConfig)
-			name := ""
-			if len(sline) > 1 {
-				name = strings.Join(sline[1:], " ")
-				name = unquoteGrubString(name)
-				name = strings.Split(name, "--")[0]
-			}
-			cfg.Name = name
-		} else if inMenuEntry {
-			// check if location of kernel is at an other partition
-			// see https://www.gnu.org/software/grub/manual/grub/html_node/search.html
-			if sline[0] == "search" {
-				for _, str1 := range sline {
-					if str1 == "--set=root" {
-						log.Printf("Kernel seems to be on an other partitioin then the grub.cfg file")
-						for _, str2 := range sline {
-							if isValidFsUUID(str2) {
-								kernelFsUUID := str2
-								log.Printf("fs-uuid: %s", kernelFsUUID)
-								partitions := storage.PartitionsByFsUUID(devices, kernelFsUUID)
-								if len(partitions) == 0 {
-									log.Printf("WARNING: No partition found with filesystem UUID:'%s' to load kernel from!", kernelFsUUID) // TODO throw error ?
-									continue
-								}
-								if len(partitions) > 1 {
-									log.Printf("WARNING: more than one partition found with the given filesystem UUID. Using the first one")
-								}
-								dev := partitions[0]
-								kernelBasedir = path.Dir(kernelBasedir)
-								kernelBasedir = path.Join(kernelBasedir, dev.Name)
-								log.Printf("Kernel is on: %s", dev.Name)
-							}
-						}
-					}
-				}
-			}
-			// otherwise look for kernel and initramfs configuration
-			if len(sline) < 2 {
-				// surely not a valid linux or initrd directive, skip it
-				continue
-			}
-			if sline[0] == "linux" || sline[0] == "linux16" || sline[0] == "linuxefi" {
-				kernel := sline[1]
-				cmdline := strings.Join(sline[2:], " ")
-				cmdline = unquoteGrubString(cmdline)
-				cfg.Kernel = path.Join(kernelBasedir, kernel)
-				cfg.KernelArgs = cmdline
-			} else if sline[0] == "initrd" || sline[0] == "initrd16" || sline[0] == "initrdefi" {
-				initrd := sline[1]
-				cfg.Initramfs = path.Join(kernelBasedir, initrd)
-			} else if sline[0] == "multiboot" || sline[0] == "multiboot2" {
-				multiboot := sline[1]
-				cmdline := strings.Join(sline[2:], " ")
-				cmdline = unquoteGrubString(cmdline)
-				cfg.Multiboot = path.Join(kernelBasedir, multiboot)
-				cfg.MultibootArgs = cmdline
-			} else if sline[0] == "module" || sline[0] == "module2" {
-				module := sline[1]
-				cmdline := strings.Join(sline[2:], " ")
-				cmdline = unquoteGrubString(cmdline)
-				module = path.Join(kernelBasedir, module)
-				if cmdline != "" {
-					module = module + " " + cmdline
-				}
-				cfg.Modules = append(cfg.Modules, module)
-			}
-		}
-	}
-
-	// append last kernel config if it wasn't already
-	if inMenuEntry && cfg.IsValid() {
-		bootconfigs = append(bootconfigs, *cfg)
-	}
-	return bootconfigs
-}
-
-func isValidFsUUID(uuid string) bool {
-	for _, h := range strings.Split(uuid, "-") {
-		if _, err := hex.DecodeString(h); err != nil {
-			return false
-		}
-	}
-	return true
-}
-
-func unquoteGrubString(text string) string {
-	// unquote the string to prevent special characters used by GRUB
-	// from being passed thru kexec
-	// https://www.gnu.org/software/grub/manual/grub/grub.html#Quoting
-	// TODO unquote everything, not just \$
-	return strings.Replace(text, `\$`, "$", -1)
-}
-
 func isMn(r rune) bool {
 	return unicode.Is(unicode.Mn, r) // Mn: nonspacing marks
 }
 
 // ScanGrubConfigs looks for grub2 and grub legacy config files in the known
-// locations and returns a list of boot configurations.
-func ScanGrubConfigs(devices []storage.BlockDev, basedir string) []bootconfig.BootConfig {
-	bootconfigs := make([]bootconfig.BootConfig, 0)
+// locations under basedir, evaluates them and returns the menu entries that
+// boot a kernel. The default entry of each config file comes first.
+//
+// mounts are the file systems the config files may load kernels from, e.g.
+// with search --fs-uuid.
+func ScanGrubConfigs(mounts []grub.Mount, basedir string) []*grub.Entry {
+	var entries []*grub.Entry
 	err := filepath.Walk(basedir, func(currentPath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -219,27 +83,27 @@ func ScanGrubConfigs(devices []storage.BlockDev, basedir string) []bootconfig.Bo
 			// continue
 			return nil
 		}
-		cfgname := info.Name()
-		var ver grubVersion
-		switch cfgname {
-		case "grub.cfg":
-			ver = grubV1
-		case "grub2.cfg":
-			ver = grubV2
-		default:
+		if name := info.Name(); name != "grub.cfg" && name != "grub2.cfg" {
 			return nil
 		}
 		log.Printf("Parsing %s", currentPath)
-		data, err := ioutil.ReadFile(currentPath)
+		config, err := grub.ParseConfigFile(currentPath, mounts)
 		if err != nil {
-			return err
+			log.Printf("Failed to parse %s: %v", currentPath, err)
+			return nil
+		}
+		if d := config.DefaultEntry; d >= 0 {
+			entries = append(entries, config.Entries[d])
+		}
+		for i, entry := range config.Entries {
+			if i != config.DefaultEntry {
+				entries = append(entries, entry)
+			}
 		}
-		cfgs := ParseGrubCfg(ver, devices, string(data), basedir)
-		bootconfigs = append(bootconfigs, cfgs...)
 		return nil
 	})
 	if err != nil {
 		log.Printf("filepath.Walk error: %v", err)
 	}
-	return bootconfigs
+	return entries
 }
@@ -12,12 +12,17 @@ import (
 	"path"
 	"syscall"
 
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/boot/bls"
+	"github.com/u-root/u-root/pkg/boot/bootstate"
+	"github.com/u-root/u-root/pkg/boot/grub"
+	"github.com/u-root/u-root/pkg/boot/menu"
+	"github.com/u-root/u-root/pkg/boot/vboot"
 	"github.com/u-root/u-root/pkg/bootconfig"
 	"github.com/u-root/u-root/pkg/storage"
 )
 
 // TODO backward compatibility for BIOS mode with partition type 0xee
-// TODO use a proper parser for grub config (see grub.go)
 
 var (
 	flagBaseMountPoint = flag.String("m", "/mnt", "Base mount point where to mount partitions")
@@ -29,9 +34,17 @@ var (
 	flagInitramfsPath  = flag.String("initramfs", "", "Specify the path of the initramfs to load. If using -grub, this argument is ignored")
 	flagKernelCmdline  = flag.String("cmdline", "", "Specify the kernel command line. If using -grub, this argument is ignored")
 	flagDeviceGUID     = flag.String("guid", "", "GUID of the device where the kernel (and optionally initramfs) are located. Ignored if -grub is set or if -kernel is not specified")
+	flagTimeout        = flag.Duration("timeout", 0, "In GRUB mode, show a boot menu for this long before booting the first configuration")
+	flagStateDir       = flag.String("state-dir", "", "In GRUB mode, directory of the boot state file, on a persistent partition such as the ESP. Without it, there is no boot-once or boot counting")
+	flagMaxBoots       = flag.Int("maxboots", 0, "In GRUB mode, skip configurations booted this often without `bootstate confirm`. 0 never skips. Requires -state-dir")
+	flagVerify         = flag.String("verify", "off", "Whether kernels, initramfs and command lines must have detached signatures: off, warn or enforce")
+	flagKeyring        = flag.String("keyring", vboot.DefaultKeyring, "File or directory with the public keys trusted to sign kernels")
 )
 
-var debug = func(string, ...interface{}) {}
+var (
+	debug        = func(string, ...interface{}) {}
+	verifyPolicy *vboot.Policy
+)
 
 // mountByGUID looks for a partition with the given GUID, and tries to mount it
 // in a subdirectory under the specified mount point. The subdirectory has the
@@ -64,9 +77,10 @@ func mountByGUID(devices []storage.BlockDev, filesystems []string, guid, baseMou
 // * look for the partition with the specified GUID, and mount it
 // * if no GUID is specified, mount all of the specified devices
 // * try to mount the device(s) using any of the kernel-supported filesystems
-// * look for a GRUB configuration in various well-known locations
+// * look for GRUB configurations and BootLoaderSpec entries in well-known locations
 // * build a list of valid boot configurations from the found GRUB configuration files
-// * try to boot every valid boot configuration until one succeeds
+// * show them in a boot menu on the consoles for -timeout
+// * try to boot the chosen configuration, then every other one until one succeeds
 //
 // The first parameter, `devices` is a list of storage.BlockDev . The function
 // will look for bootable configurations on these devices
@@ -116,50 +130,127 @@ func BootGrubMode(devices []storage.BlockDev, baseMountpoint string, guid string
 		mounted = []storage.Mountpoint{*mount}
 	}
 
-	// search for a valid grub config and extracts the boot configuration
-	bootconfigs := make([]bootconfig.BootConfig, 0)
+	// GRUB refers to file systems by device name, file system UUID or
+	// label
+	mounts := make([]grub.Mount, 0, len(mounted))
 	for _, mountpoint := range mounted {
-		bootconfigs = append(bootconfigs, ScanGrubConfigs(devices, mountpoint.Path)...)
+		name := path.Base(mountpoint.DeviceName)
+		m := grub.Mount{Name: name, Path: mountpoint.Path}
+		for _, dev := range devices {
+			if dev.Name == name {
+				m.UUID = dev.FsUUID
+				m.Label = dev.FsLabel
+			}
+		}
+		mounts = append(mounts, m)
 	}
-	if len(bootconfigs) == 0 {
+
+	// search for a valid grub config and extracts the boot entries
+	entries := make([]bootEntry, 0)
+	for _, mountpoint := range mounted {
+		for _, entry := range ScanGrubConfigs(mounts, mountpoint.Path) {
+			entries = append(entries, grubEntry(entry))
+		}
+	}
+	// then for BootLoaderSpec entries, which e.g. Fedora's grub.cfg
+	// loads with blscfg
+	for _, entry := range bls.ScanMountpoints(mounted) {
+		entries = append(entries, blsEntry(entry))
+	}
+	for _, entry := range entries {
+		if err := boot.SetVerifyPolicy(entry.image, verifyPolicy); err != nil {
+			return err
+		}
+	}
+	if len(entries) == 0 {
 		return fmt.Errorf("No boot configuration found")
 	}
-	log.Printf("Found %d boot configs", len(bootconfigs))
-	for _, cfg := range bootconfigs {
-		debug("%+v", cfg)
+	log.Printf("Found %d boot configs", len(entries))
+	for _, entry := range entries {
+		debug("%+v", entry)
 	}
-	for n, cfg := range bootconfigs {
-		log.Printf("  %d: %s\n", n, cfg.Name)
+	for n, entry := range entries {
+		log.Printf("  %d: %s\n", n, entry.name)
 	}
 	if configIdx > -1 {
-		for n, cfg := range bootconfigs {
-			if configIdx == n {
-				if dryrun {
-					debug("Dry-run mode: will not boot the found configuration")
-					debug("Boot configuration: %+v", cfg)
-					return nil
-				}
-				if err := cfg.Boot(); err != nil {
-					log.Printf("Failed to boot kernel %s: %v", cfg.Kernel, err)
-				}
-			}
+		if configIdx >= len(entries) {
+			log.Printf("Invalid arg -config %d: there are only %d bootconfigs available\n", configIdx, len(entries))
+			return nil
+		}
+		entry := entries[configIdx]
+		if dryrun {
+			debug("Dry-run mode: will not boot the found configuration")
+			debug("Boot configuration: %+v", entry)
+			return nil
+		}
+		if err := entry.boot(); err != nil {
+			log.Printf("Failed to boot %s: %v", entry.name, err)
 		}
-		log.Printf("Invalid arg -config %d: there are only %d bootconfigs available\n", configIdx, len(bootconfigs))
 		return nil
 	}
+
+	m := &menu.Menu{Timeout: *flagTimeout, Terminals: menu.Consoles()}
+	configs := make(map[*menu.Entry]bootEntry)
+	for _, entry := range entries {
+		e := &menu.Entry{Label: entry.name, Image: entry.image}
+		m.Entries = append(m.Entries, e)
+		configs[e] = entry
+	}
+
+	// Boot the entry to boot once, or skip those that did not come up.
+	// The state directory may be on one of the partitions mounted above.
+	var store *bootstate.Store
+	state := &bootstate.State{}
+	if *flagStateDir != "" {
+		var err error
+		if store, err = bootstate.New(*flagStateDir); err != nil {
+			return err
+		}
+		state = store.Load()
+	}
+	next, once := state.TakeNextBoot()
+	for _, e := range append([]*menu.Entry(nil), m.Entries...) {
+		if e.Label != next && state.Exhausted(e.Label, *flagMaxBoots) {
+			log.Printf("Skipping %s: booted %d times without confirmation", e, state.Attempts[e.Label])
+			m.Remove(e)
+		}
+	}
+	for i, e := range m.Entries {
+		if once && e.Label == next {
+			m.Default = i
+		}
+	}
+	if len(m.Entries) == 0 {
+		return fmt.Errorf("No boot configuration left to boot")
+	}
 	if dryrun {
-		cfg := bootconfigs[0]
+		e, err := m.Choose()
+		if err != nil {
+			return err
+		}
 		debug("Dry-run mode: will not boot the found configuration")
-		debug("Boot configuration: %+v", cfg)
+		debug("Boot configuration: %+v", configs[e])
 		return nil
 	}
 
-	// try to kexec into every boot config kernel until one succeeds
-	for _, cfg := range bootconfigs {
-		debug("Trying boot configuration %+v", cfg)
-		if err := cfg.Boot(); err != nil {
-			log.Printf("Failed to boot kernel %s: %v", cfg.Kernel, err)
+	// try to kexec into the chosen boot entry, then every other one until
+	// one succeeds
+	for len(m.Entries) > 0 {
+		e, err := m.Choose()
+		if err != nil {
+			return err
+		}
+		debug("Trying boot configuration %+v", configs[e])
+		state.StartBoot(e.Label)
+		if store != nil {
+			if err := store.Save(state); err != nil {
+				log.Printf("Failed to save boot state: %v", err)
+			}
+		}
+		if err := configs[e].boot(); err != nil {
+			log.Printf("Failed to boot %s: %v", e, err)
 		}
+		m.Remove(e)
 	}
 	// if we reach this point, no boot configuration succeeded
 	log.Print("No boot configuration succeeded")
@@ -198,6 +289,7 @@ func BootPathMode(devices []storage.BlockDev, baseMountpoint string, guid string
 		Kernel:     fullKernelPath,
 		Initramfs:  fullInitramfsPath,
 		KernelArgs: *flagKernelCmdline,
+		Verify:     verifyPolicy,
 	}
 	debug("Trying boot configuration %+v", cfg)
 	if dryrun {
@@ -218,6 +310,13 @@ func main() {
 	if *flagDebug {
 		debug = log.Printf
 	}
+	if *flagMaxBoots > 0 && *flagStateDir == "" {
+		log.Fatal("Option -maxboots requires -state-dir")
+	}
+	var err error
+	if verifyPolicy, err = vboot.ParsePolicy(*flagVerify, *flagKeyring); err != nil {
+		log.Fatal(err)
+	}
 
 	// Get all the available block devices
 	devices, err := storage.GetBlockStats()
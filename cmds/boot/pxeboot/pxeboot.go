@@ -17,6 +17,10 @@
 //
 // - a pxelinux.0, in which case we will ignore the pxelinux and try to parse
 //   pxelinux.cfg/<files>
+//
+// Files may be fetched over HTTPS. Servers are verified with the system's CAs
+// or those of -cacerts, and may be required to have a pinned public key and
+// to staple a good OCSP response.
 package main
 
 import (
@@ -24,9 +28,11 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/boot/menu"
 	"github.com/u-root/u-root/pkg/dhclient"
 	"github.com/u-root/u-root/pkg/netboot"
 	"github.com/u-root/u-root/pkg/urlfetch"
@@ -37,6 +43,12 @@ var (
 	noLoad  = flag.Bool("no-load", false, "get DHCP response, but don't load the kernel")
 	dryRun  = flag.Bool("dry-run", false, "download kernel, but don't kexec it")
 	verbose = flag.Bool("v", false, "Verbose output")
+	timeout = flag.Duration("timeout", 0, "Show a boot menu for this long, e.g. to edit the kernel command line, before booting")
+	caCerts = flag.String("cacerts", "", "Comma-separated PEM files of the CAs that HTTPS servers must chain to, instead of the system's")
+	cert    = flag.String("cert", "", "PEM file of the client certificate for HTTPS")
+	key     = flag.String("key", "", "PEM file of the key of the client certificate for HTTPS")
+	pins    = flag.String("pins", "", "Comma-separated base64 SHA-256 hashes of public keys, one of which HTTPS servers must have in their chain")
+	ocsp    = flag.Bool("ocsp", false, "Require HTTPS servers to staple a good OCSP response")
 )
 
 const (
@@ -97,6 +109,10 @@ func Netboot(ifaceNames string) error {
 			if *noLoad {
 				return nil
 			}
+			// The menu edits img's command line.
+			if _, err := menu.New(*timeout, menu.Consoles(), img).Choose(); err != nil {
+				return err
+			}
 			if err := img.Load(*dryRun); err != nil {
 				return fmt.Errorf("kexec load of %v failed: %v", img, err)
 			}
@@ -123,7 +139,27 @@ func main() {
 		ifName = flag.Args()[0]
 	}
 
+	https, err := urlfetch.NewHTTPSClient(&urlfetch.TLSConfig{
+		CAFiles:     list(*caCerts),
+		CertFile:    *cert,
+		KeyFile:     *key,
+		Pins:        list(*pins),
+		RequireOCSP: *ocsp,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	urlfetch.RegisterScheme("https", https)
+
 	if err := Netboot(ifName); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// list splits a comma-separated flag.
+func list(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
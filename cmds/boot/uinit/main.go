@@ -12,7 +12,11 @@ import (
 	"os/signal"
 	"time"
 
+	"github.com/u-root/u-root/pkg/boot/bootstate"
+	"github.com/u-root/u-root/pkg/boot/policy"
+	"github.com/u-root/u-root/pkg/boot/vboot"
 	"github.com/u-root/u-root/pkg/booter"
+	"github.com/u-root/u-root/pkg/recovery"
 )
 
 var (
@@ -20,6 +24,14 @@ var (
 	doQuiet          = flag.Bool("q", false, "Disable verbose output")
 	interval         = flag.Int("I", 1, "Interval in seconds before looping to the next boot command")
 	noDefaultBoot    = flag.Bool("nodefault", false, "Do not attempt default boot entries if regular ones fail")
+	orchestrate      = flag.Bool("orchestrate", false, "Before the default boot sequence, boot with the boot policy of the BootPolicy VPD variable, -policy or the kernel command line")
+	policyFile       = flag.String("policy", "/etc/bootpolicy.json", "Boot policy file, used with -orchestrate")
+	bootconfigZip    = flag.String("bootconfig", "", "Signed bootconfig ZIP file to boot from, used with -orchestrate")
+	bootconfigPubKey = flag.String("pubkey", "", "Public key the bootconfig ZIP file must be signed with")
+	recoveryCmd      = flag.String("recovery", "", "Command to run when no boot policy candidate boots, used with -orchestrate")
+	stateDir         = flag.String("state-dir", "", "Directory of the boot state file, on a persistent partition such as the ESP, used with -orchestrate. Without it, there is no boot-once or boot counting")
+	verify           = flag.String("verify", "off", "Whether boot policy candidates must have detached signatures: off, warn or enforce, used with -orchestrate")
+	keyring          = flag.String("keyring", vboot.DefaultKeyring, "File or directory with the public keys trusted to sign kernels, used with -orchestrate")
 )
 
 var defaultBootsequence = [][]string{
@@ -27,6 +39,40 @@ var defaultBootsequence = [][]string{
 	{"localboot", "-grub"},
 }
 
+// bootPolicy boots the candidates of the local disks, ESXi, the network and
+// the bootconfig file in the order of the boot policy.
+func bootPolicy() error {
+	p, err := policy.Load(*policyFile)
+	if err != nil {
+		return err
+	}
+	log.Printf("Boot policy: %+v", *p)
+	sources := map[string]policy.Source{
+		policy.Disk: policy.DiskSource{Glob: "/sys/class/block/*"},
+		policy.ESXi: policy.ESXiSource{Glob: "/sys/block/*"},
+		policy.Net: policy.NetSource{
+			Interfaces: "^e.*",
+			Timeout:    5 * time.Second,
+			Retries:    3,
+			Verbose:    !*doQuiet,
+		},
+	}
+	if *bootconfigZip != "" {
+		sources[policy.BootConfig] = policy.BootConfigSource{Path: *bootconfigZip, PubKey: *bootconfigPubKey}
+	}
+	o := policy.New(p, sources, recovery.PermissiveRecoverer{RecoveryCommand: *recoveryCmd})
+	o.Verbose = !*doQuiet
+	if *stateDir != "" {
+		if o.State, err = bootstate.New(*stateDir); err != nil {
+			return err
+		}
+	}
+	if o.Verify, err = vboot.ParsePolicy(*verify, *keyring); err != nil {
+		return err
+	}
+	return o.Boot()
+}
+
 func main() {
 	flag.Parse()
 
@@ -70,6 +116,12 @@ func main() {
 	// if boot entries failed, use the default boot sequence
 	log.Printf("Boot entries failed")
 
+	if *orchestrate {
+		if err := bootPolicy(); err != nil {
+			log.Printf("Boot policy failed: %v", err)
+		}
+	}
+
 	if !*noDefaultBoot {
 		log.Print("Falling back to the default boot sequence")
 		for {
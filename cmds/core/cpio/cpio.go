@@ -16,6 +16,8 @@
 //     i: output files from a stdin stream
 //     t: print table of contents
 //     -v: debug prints
+//     -H: format: newc, crc, odc, or bin. When reading, the format is
+//         detected if -H is not given; when writing, it defaults to newc.
 //
 // Bugs: in i mode, it can't use non-seekable stdin, i.e. a pipe. Yep, this sucks.
 // But if we implement seek on such things, we have to do it by reading, which
@@ -39,7 +41,7 @@ import (
 var (
 	debug  = func(string, ...interface{}) {}
 	d      = flag.Bool("v", false, "Debug prints")
-	format = flag.String("H", "newc", "format")
+	format = flag.String("H", "", "format (newc, crc, odc, or bin)")
 )
 
 func usage() {
@@ -59,14 +61,25 @@ func main() {
 	}
 	op := a[0]
 
-	archiver, err := cpio.Format(*format)
-	if err != nil {
-		log.Fatalf("Format %q not supported: %v", *format, err)
+	archiver := cpio.Newc
+	if *format != "" {
+		f, err := cpio.Format(*format)
+		if err != nil {
+			log.Fatalf("Format %q not supported: %v", *format, err)
+		}
+		archiver = f
+	} else if op != "o" {
+		f, err := cpio.Detect(os.Stdin)
+		if err != nil {
+			log.Fatalf("Detecting archive format: %v", err)
+		}
+		archiver = f
 	}
 
 	switch op {
 	case "i":
 		rr := archiver.Reader(os.Stdin)
+		e := cpio.NewExtractor(".", true)
 		for {
 			rec, err := rr.ReadRecord()
 			if err == io.EOF {
@@ -76,7 +89,7 @@ func main() {
 				log.Fatalf("error reading records: %v", err)
 			}
 			debug("Creating %s\n", rec)
-			if err := cpio.CreateFile(rec); err != nil {
+			if err := e.CreateFile(rec); err != nil {
 				log.Printf("Creating %q failed: %v", rec.Name, err)
 			}
 		}
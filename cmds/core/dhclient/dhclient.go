@@ -7,10 +7,19 @@
 // Synopsis:
 //     dhclient [OPTIONS...]
 //
+// Description:
+//     dhclient configures the interfaces matching the regular expression
+//     (default ^e.*) with DHCPv4 and DHCPv6 and exits. For IPv6, it
+//     solicits routers and uses SLAAC, stateless DHCPv6 or stateful DHCPv6
+//     as their advertisements say. With -d, it keeps running to renew the
+//     leases and refresh the router advertisements, gets new ones when they
+//     expire, and rebinds them when a link comes back up.
+//
 // Options:
 //     -timeout:  lease timeout in seconds
 //     -renewals: number of DHCP renewals before exiting
 //     -verbose:  verbose output
+//     -d:        keep renewing the leases
 package main
 
 import (
@@ -31,6 +40,7 @@ var (
 	vverbose = flag.Bool("vv", false, "Really verbose output (print all message options for each DHCP message sent/received)")
 	ipv4     = flag.Bool("ipv4", true, "use IPV4")
 	ipv6     = flag.Bool("ipv6", true, "use IPV6")
+	daemon   = flag.Bool("d", false, "Keep running to renew the leases")
 )
 
 func main() {
@@ -48,14 +58,15 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if *daemon {
+		log.Fatal(dhclient.Daemon(context.Background(), filteredIfs, *ipv4, *ipv6, config()))
+	}
 	configureAll(filteredIfs)
 }
 
-func configureAll(ifs []netlink.Link) {
-	packetTimeout := time.Duration(*timeout) * time.Second
-
+func config() dhclient.Config {
 	c := dhclient.Config{
-		Timeout: packetTimeout,
+		Timeout: time.Duration(*timeout) * time.Second,
 		Retries: *retry,
 	}
 	if *verbose {
@@ -64,7 +75,11 @@ func configureAll(ifs []netlink.Link) {
 	if *vverbose {
 		c.LogLevel = dhclient.LogDebug
 	}
-	r := dhclient.SendRequests(context.Background(), ifs, *ipv4, *ipv6, c)
+	return c
+}
+
+func configureAll(ifs []netlink.Link) {
+	r := dhclient.SendRequests(context.Background(), ifs, *ipv4, *ipv6, config())
 
 	for result := range r {
 		if result.Err != nil {
@@ -23,14 +23,22 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/u-root/u-root/pkg/uroot/hashmanifest"
 	"github.com/u-root/u-root/pkg/uroot/util"
+	"github.com/u-root/u-root/pkg/vpd"
+	"golang.org/x/crypto/ed25519"
 )
 
+// manifestKeyVPD is the read-only VPD variable that holds the PEM-encoded
+// ed25519 public key the initramfs must be signed with.
+const manifestKeyVPD = "uroot_manifest_key"
+
 var (
 	verbose  = flag.Bool("v", false, "print all build commands")
 	test     = flag.Bool("test", false, "Test mode: don't try to set control tty")
 	debug    = func(string, ...interface{}) {}
 	osInitGo = func() {}
+	measure  = func(info string, data []byte) {}
 	cmdList  []string
 	cmdCount int
 	envs     []string
@@ -59,9 +67,22 @@ func main() {
 	fmt.Println(`  | |_| |____| | | (_) | (_) | |_`)
 	fmt.Println(`   \__,_|    |_|  \___/ \___/ \__|`)
 	fmt.Println()
+
+	// The initramfs is verified before Rootfs mounts over parts of it, and
+	// measured after Rootfs has made the TPM available.
+	key, manifest, verifyErr := verifyRootfs()
 	util.Rootfs()
 	log.Printf("Done Rootfs")
 
+	measureRootfs(key, manifest, verifyErr)
+	switch {
+	case verifyErr != nil:
+		log.Printf("init: Verifying initramfs failed, not running uinit: %v", verifyErr)
+		cmdList = withoutUinit(cmdList)
+	case key != nil:
+		log.Printf("init: Verified initramfs manifest")
+	}
+
 	if *verbose {
 		debug = log.Printf
 	}
@@ -226,3 +247,74 @@ func main() {
 	syscall.Sync()
 	log.Printf("init: Exiting...")
 }
+
+// trustedKey returns the public key that the initramfs must be signed with, or
+// nil if none is provisioned. The key comes from firmware, never from the
+// initramfs it verifies. sysfs is only mounted while the key is read, so
+// that nothing is mounted over the initramfs while it is verified.
+var trustedKey = func() (ed25519.PublicKey, error) {
+	if err := os.MkdirAll("/sys", 0555); err != nil {
+		return nil, err
+	}
+	if err := syscall.Mount("sysfs", "/sys", "sysfs", 0, ""); err != nil {
+		return nil, fmt.Errorf("could not mount sysfs to read VPD: %v", err)
+	}
+	defer syscall.Unmount("/sys", 0)
+
+	pemKey, err := vpd.Get(manifestKeyVPD, true)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hashmanifest.DecodePublicKey(pemKey)
+}
+
+// verifyRootfs verifies the initramfs against its signed hash manifest if a
+// trusted key is provisioned. It returns the key, which is nil if the
+// initramfs need not be signed, and the manifest if the initramfs matches it.
+//
+// With a key, a missing manifest, a bad signature, and any file that is not
+// in the manifest fail verification.
+func verifyRootfs() (ed25519.PublicKey, []byte, error) {
+	key, err := trustedKey()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not load %s: %v", manifestKeyVPD, err)
+	}
+	if key == nil {
+		return nil, nil, nil
+	}
+	manifest, err := hashmanifest.Verify(util.UrootPath(), key)
+	return key, manifest, err
+}
+
+// measureRootfs measures the outcome of verifyRootfs into the TPM. It always
+// extends the PCR with whether the initramfs is unsigned, verified, or failed
+// verification, so that no outcome leaves the PCR as if nothing ran. The key
+// and the verified manifest are measured as events of their own.
+func measureRootfs(key ed25519.PublicKey, manifest []byte, verifyErr error) {
+	if key != nil {
+		measure(manifestKeyVPD, key)
+	}
+	switch {
+	case verifyErr != nil:
+		measure("initramfs verification", []byte("failed: "+verifyErr.Error()))
+	case key == nil:
+		measure("initramfs verification", []byte("unsigned"))
+	default:
+		measure("initramfs verification", []byte("verified"))
+		measure(hashmanifest.Path, manifest)
+	}
+}
+
+// withoutUinit returns cmds without the uinit commands and inito.
+func withoutUinit(cmds []string) []string {
+	var c []string
+	for _, v := range cmds {
+		if b := filepath.Base(v); b != "uinit" && b != "inito" {
+			c = append(c, v)
+		}
+	}
+	return c
+}
@@ -5,11 +5,15 @@
 package main
 
 import (
+	"context"
 	"log"
 	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/u-root/u-root/pkg/cmdline"
+	"github.com/u-root/u-root/pkg/dhclient"
+	"github.com/u-root/u-root/pkg/ipconfig"
 )
 
 func init() {
@@ -35,4 +39,11 @@ func runOSInitGo() {
 		cmdList = cmdList[1:]
 		cmdCount++
 	}
+
+	// uinit may need the network that ip= or the iBFT describe, e.g. to
+	// netboot or to log in to the iSCSI target.
+	c := dhclient.Config{Timeout: 15 * time.Second, Retries: 3}
+	if err := ipconfig.Setup(context.Background(), c); err != nil {
+		log.Printf("Could not configure the network: %v", err)
+	}
 }
@@ -169,10 +169,20 @@ func linkset() error {
 	}
 
 	cursor++
-	whatIWant = []string{"address", "up", "down"}
+	whatIWant = []string{"address", "up", "down", "master", "nomaster"}
 	switch one(arg[cursor], whatIWant) {
 	case "address":
 		return setHardwareAddress(iface)
+	case "master":
+		master, err := dev()
+		if err != nil {
+			return err
+		}
+		return setmaster(iface, master)
+	case "nomaster":
+		if err := netlink.LinkSetNoMaster(iface); err != nil {
+			return fmt.Errorf("%v can't unset master: %v", iface.Attrs().Name, err)
+		}
 	case "up":
 		if err := netlink.LinkSetUp(iface); err != nil {
 			return fmt.Errorf("%v can't make it up: %v", iface.Attrs().Name, err)
@@ -193,7 +203,7 @@ func link() error {
 	}
 
 	cursor++
-	whatIWant = []string{"show", "set"}
+	whatIWant = []string{"show", "set", "add", "delete"}
 	cmd := arg[cursor]
 
 	switch one(cmd, whatIWant) {
@@ -201,6 +211,10 @@ func link() error {
 		return linkshow()
 	case "set":
 		return linkset()
+	case "add":
+		return linkadd()
+	case "delete":
+		return linkdel()
 	}
 	return usage()
 }
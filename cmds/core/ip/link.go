@@ -0,0 +1,303 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/vishvananda/netlink"
+)
+
+// linkTypes are the types of links that ip link add can create.
+var linkTypes = map[string]func(netlink.LinkAttrs) error{
+	"bond":    bond,
+	"bridge":  bridge,
+	"dummy":   dummy,
+	"macvlan": macvlan,
+	"veth":    veth,
+	"vlan":    vlan,
+}
+
+var macvlanModes = map[string]netlink.MacvlanMode{
+	"private":  netlink.MACVLAN_MODE_PRIVATE,
+	"vepa":     netlink.MACVLAN_MODE_VEPA,
+	"bridge":   netlink.MACVLAN_MODE_BRIDGE,
+	"passthru": netlink.MACVLAN_MODE_PASSTHRU,
+	"source":   netlink.MACVLAN_MODE_SOURCE,
+}
+
+// more returns whether there are args left.
+func more() bool {
+	return cursor+1 < len(arg)
+}
+
+// number parses the next arg as a decimal number in [min, max].
+func number(what string, min, max int) (int, error) {
+	cursor++
+	whatIWant = []string{what}
+	n, err := strconv.Atoi(arg[cursor])
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%s %q is not a number from %d to %d", what, arg[cursor], min, max)
+	}
+	return n, nil
+}
+
+// linkadd parses
+//
+//	ip link add [link DEV] [name] NAME [address LLADDR] [mtu MTU] type TYPE [ARGS]
+func linkadd() error {
+	attrs := netlink.NewLinkAttrs()
+	for {
+		cursor++
+		whatIWant = []string{"link", "name", "address", "mtu", "type"}
+		switch one(arg[cursor], whatIWant) {
+		case "link":
+			parent, err := dev()
+			if err != nil {
+				return err
+			}
+			attrs.ParentIndex = parent.Attrs().Index
+		case "name":
+			cursor++
+			whatIWant = []string{"device name"}
+			attrs.Name = arg[cursor]
+		case "address":
+			cursor++
+			whatIWant = []string{"link layer address"}
+			hw, err := net.ParseMAC(arg[cursor])
+			if err != nil {
+				return fmt.Errorf("can't parse mac addr %v: %v", arg[cursor], err)
+			}
+			attrs.HardwareAddr = hw
+		case "mtu":
+			mtu, err := number("MTU", 68, 65535)
+			if err != nil {
+				return err
+			}
+			attrs.MTU = mtu
+		case "type":
+			if attrs.Name == "" {
+				return fmt.Errorf("ip link add needs a device name")
+			}
+			cursor++
+			whatIWant = []string{"bond", "bridge", "dummy", "macvlan", "veth", "vlan"}
+			add, ok := linkTypes[one(arg[cursor], whatIWant)]
+			if !ok {
+				return usage()
+			}
+			return add(attrs)
+		default:
+			// The name is the only argument without a keyword.
+			if attrs.Name != "" {
+				return usage()
+			}
+			attrs.Name = arg[cursor]
+		}
+	}
+}
+
+// addlink creates l, and says which of its args were not understood.
+func addlink(l netlink.Link) error {
+	if more() {
+		cursor++
+		whatIWant = []string{"<nothing>"}
+		return usage()
+	}
+	if err := netlink.LinkAdd(l); err != nil {
+		return fmt.Errorf("can't add %s %v: %v", l.Type(), l.Attrs().Name, err)
+	}
+	return nil
+}
+
+// dummy parses
+//
+//	ip link add NAME type dummy
+func dummy(attrs netlink.LinkAttrs) error {
+	return addlink(&netlink.Dummy{LinkAttrs: attrs})
+}
+
+// bridge parses
+//
+//	ip link add NAME type bridge
+//
+// Use ip link set DEV master NAME to add ports.
+func bridge(attrs netlink.LinkAttrs) error {
+	return addlink(&netlink.Bridge{LinkAttrs: attrs})
+}
+
+// vlan parses
+//
+//	ip link add link DEV NAME type vlan id ID [protocol 802.1q|802.1ad]
+func vlan(attrs netlink.LinkAttrs) error {
+	if attrs.ParentIndex == 0 {
+		return fmt.Errorf("vlan %v needs a parent: link DEV", attrs.Name)
+	}
+	v := &netlink.Vlan{LinkAttrs: attrs, VlanId: -1}
+	for more() {
+		cursor++
+		whatIWant = []string{"id", "protocol"}
+		switch one(arg[cursor], whatIWant) {
+		case "id":
+			id, err := number("VLAN ID", 0, 4094)
+			if err != nil {
+				return err
+			}
+			v.VlanId = id
+		case "protocol":
+			cursor++
+			whatIWant = []string{"802.1q", "802.1ad"}
+			if v.VlanProtocol = netlink.StringToVlanProtocol(arg[cursor]); v.VlanProtocol == netlink.VLAN_PROTOCOL_UNKNOWN {
+				return usage()
+			}
+		default:
+			return usage()
+		}
+	}
+	if v.VlanId < 0 {
+		return fmt.Errorf("vlan %v needs an id", attrs.Name)
+	}
+	return addlink(v)
+}
+
+// macvlan parses
+//
+//	ip link add link DEV NAME type macvlan [mode private|vepa|bridge|passthru|source]
+func macvlan(attrs netlink.LinkAttrs) error {
+	if attrs.ParentIndex == 0 {
+		return fmt.Errorf("macvlan %v needs a parent: link DEV", attrs.Name)
+	}
+	m := &netlink.Macvlan{LinkAttrs: attrs}
+	for more() {
+		cursor++
+		whatIWant = []string{"mode"}
+		if one(arg[cursor], whatIWant) != "mode" {
+			return usage()
+		}
+		cursor++
+		whatIWant = []string{"private", "vepa", "bridge", "passthru", "source"}
+		mode, ok := macvlanModes[one(arg[cursor], whatIWant)]
+		if !ok {
+			return usage()
+		}
+		m.Mode = mode
+	}
+	return addlink(m)
+}
+
+// veth parses
+//
+//	ip link add NAME type veth peer [name] PEER
+func veth(attrs netlink.LinkAttrs) error {
+	cursor++
+	whatIWant = []string{"peer"}
+	if one(arg[cursor], whatIWant) != "peer" {
+		return usage()
+	}
+	cursor++
+	whatIWant = []string{"name", "peer device name"}
+	if arg[cursor] == "name" {
+		cursor++
+	}
+	whatIWant = []string{"peer device name"}
+	return addlink(&netlink.Veth{LinkAttrs: attrs, PeerName: arg[cursor]})
+}
+
+// bond parses
+//
+//	ip link add NAME type bond [mode MODE] [miimon MS] [lacp_rate slow|fast]
+//	  [xmit_hash_policy POLICY] [slaves DEV...]
+//
+// The slaves are brought down and enslaved once the bond exists, as with
+// ip link set DEV master NAME.
+func bond(attrs netlink.LinkAttrs) error {
+	b := netlink.NewLinkBond(attrs)
+	var slaves []netlink.Link
+	for more() {
+		cursor++
+		whatIWant = []string{"mode", "miimon", "lacp_rate", "xmit_hash_policy", "slaves"}
+		switch one(arg[cursor], whatIWant) {
+		case "mode":
+			cursor++
+			whatIWant = []string{"balance-rr", "active-backup", "balance-xor", "broadcast", "802.3ad", "balance-tlb", "balance-alb"}
+			if b.Mode = netlink.StringToBondMode(arg[cursor]); b.Mode == netlink.BOND_MODE_UNKNOWN {
+				// Modes may also be given by number.
+				n, err := strconv.Atoi(arg[cursor])
+				if err != nil || n < 0 || netlink.BondMode(n) >= netlink.BOND_MODE_UNKNOWN {
+					return usage()
+				}
+				b.Mode = netlink.BondMode(n)
+			}
+		case "miimon":
+			ms, err := number("milliseconds", 0, 1<<31-1)
+			if err != nil {
+				return err
+			}
+			b.Miimon = ms
+		case "lacp_rate":
+			cursor++
+			whatIWant = []string{"slow", "fast"}
+			if b.LacpRate = netlink.StringToBondLacpRate(arg[cursor]); b.LacpRate == netlink.BOND_LACP_RATE_UNKNOWN {
+				return usage()
+			}
+		case "xmit_hash_policy":
+			cursor++
+			whatIWant = []string{"layer2", "layer2+3", "layer3+4", "encap2+3", "encap3+4"}
+			if b.XmitHashPolicy = netlink.StringToBondXmitHashPolicy(arg[cursor]); b.XmitHashPolicy == netlink.BOND_XMIT_HASH_POLICY_UNKNOWN {
+				return usage()
+			}
+		case "slaves":
+			whatIWant = []string{"device name"}
+			for more() {
+				cursor++
+				s, err := netlink.LinkByName(arg[cursor])
+				if err != nil {
+					return fmt.Errorf("slave %v: %v", arg[cursor], err)
+				}
+				slaves = append(slaves, s)
+			}
+		default:
+			return usage()
+		}
+	}
+	if err := addlink(b); err != nil {
+		return err
+	}
+	for _, s := range slaves {
+		if err := setmaster(s, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setmaster makes master, a bond or a bridge, the master of iface. Bonds
+// only take slaves that are down.
+func setmaster(iface, master netlink.Link) error {
+	if master.Type() == "bond" {
+		if err := netlink.LinkSetDown(iface); err != nil {
+			return fmt.Errorf("%v can't make it down: %v", iface.Attrs().Name, err)
+		}
+	}
+	if err := netlink.LinkSetMasterByIndex(iface, master.Attrs().Index); err != nil {
+		return fmt.Errorf("%v can't set master %v: %v", iface.Attrs().Name, master.Attrs().Name, err)
+	}
+	return nil
+}
+
+// linkdel parses
+//
+//	ip link del [dev] DEV
+func linkdel() error {
+	iface, err := dev()
+	if err != nil {
+		return err
+	}
+	if err := netlink.LinkDel(iface); err != nil {
+		return fmt.Errorf("%v can't delete it: %v", iface.Attrs().Name, err)
+	}
+	return nil
+}
@@ -5,7 +5,7 @@
 // kexec executes a new kernel over the running kernel (u-root).
 //
 // Synopsis:
-//     kexec [--initrd=FILE] [--command-line=STRING] [-l] [-e] [KERNELIMAGE]
+//     kexec [--initrd=FILE] [--command-line=STRING] [--kexec-syscall] [-l] [-e] [KERNELIMAGE]
 //
 // Description:
 //		 Loads a kernel for later execution.
@@ -16,6 +16,7 @@
 //     --i=FILE or --initrd=FILE:     Use file as the kernel's initial ramdisk
 //     -l or --load:                  Load the new kernel into the current kernel
 //     -e or --exec:                  Execute a currently loaded kernel
+//     --kexec-syscall:               Load Linux kernels with kexec_load instead of kexec_file_load
 package main
 
 import (
@@ -38,6 +39,7 @@ type options struct {
 	exec         bool
 	debug        bool
 	modules      []string
+	kexecLoad    bool
 }
 
 func registerFlags() *options {
@@ -48,6 +50,7 @@ func registerFlags() *options {
 	flag.BoolVarP(&o.load, "load", "l", false, "Load the new kernel into the current kernel")
 	flag.BoolVarP(&o.exec, "exec", "e", false, "Execute a currently loaded kernel")
 	flag.BoolVarP(&o.debug, "debug", "d", false, "Print debug info")
+	flag.BoolVar(&o.kexecLoad, "kexec-syscall", false, "Load Linux kernels with kexec_load instead of kexec_file_load")
 	flag.StringArrayVar(&o.modules, "module", nil, `Load module with command line args (e.g --module="mod arg1")`)
 	return o
 }
@@ -92,9 +95,10 @@ func main() {
 			}
 		} else {
 			image = &boot.LinuxImage{
-				Kernel:  uio.NewLazyFile(kernelpath),
-				Initrd:  uio.NewLazyFile(opts.initramfs),
-				Cmdline: newCmdline,
+				Kernel:    uio.NewLazyFile(kernelpath),
+				Initrd:    uio.NewLazyFile(opts.initramfs),
+				Cmdline:   newCmdline,
+				KexecLoad: opts.kexecLoad,
 			}
 		}
 		if err := image.Load(opts.debug); err != nil {
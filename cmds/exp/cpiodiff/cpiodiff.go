@@ -0,0 +1,211 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// cpiodiff compares two initramfs archives, or inspects one.
+//
+// Synopsis:
+//     cpiodiff [-json] [-depth N] OLD NEW
+//     cpiodiff [-json] [-depth N] ARCHIVE
+//
+// Description:
+//     Given two archives, cpiodiff lists the records that were added, removed
+//     or changed, how the mode, owner, size or contents of changed records
+//     differ, and how the size of each directory changed.
+//
+//     Given one archive, cpiodiff lists the size of each directory.
+//
+//     Archives may be in any cpio format and compressed with gzip, xz, or
+//     lz4. Sizes are the sum of the sizes of all files in a directory and its
+//     subdirectories, in bytes.
+//
+// Options:
+//     -json:  print JSON, e.g. for a CI size check
+//     -depth: only list directories up to this depth; "." has depth 0
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/uroot/initramfs"
+)
+
+var (
+	asJSON = flag.Bool("json", false, "Print JSON")
+	depth  = flag.Int("depth", -1, "Only list directories up to this depth; -1 lists all")
+)
+
+// DirSize is the size of a directory of an inspected archive.
+type DirSize struct {
+	Name string `json:"name"`
+	Size uint64 `json:"size"`
+}
+
+// Inspection summarizes an archive.
+type Inspection struct {
+	Records int       `json:"records"`
+	Size    uint64    `json:"size"`
+	Dirs    []DirSize `json:"dirs"`
+}
+
+func readArchive(path string) (*cpio.Archive, error) {
+	// Records read their contents from the archive lazily, so keep the
+	// whole archive in memory.
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	a, err := cpio.ArchiveFromReader(initramfs.CPIOArchiver{RecordFormat: cpio.Newc}.Reader(bytes.NewReader(b)))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	return a, nil
+}
+
+// dirDepth returns the depth of directory name, where "." has depth 0.
+func dirDepth(name string) int {
+	if name == "." {
+		return 0
+	}
+	return strings.Count(name, "/") + 1
+}
+
+func inspect(a *cpio.Archive, maxDepth int) *Inspection {
+	sizes := cpio.DirSizes(a)
+	in := &Inspection{
+		Records: len(a.Files),
+		Size:    sizes["."],
+		Dirs:    []DirSize{},
+	}
+	for name, size := range sizes {
+		if maxDepth < 0 || dirDepth(name) <= maxDepth {
+			in.Dirs = append(in.Dirs, DirSize{Name: name, Size: size})
+		}
+	}
+	sort.Slice(in.Dirs, func(i, j int) bool {
+		if in.Dirs[i].Size != in.Dirs[j].Size {
+			return in.Dirs[i].Size > in.Dirs[j].Size
+		}
+		return in.Dirs[i].Name < in.Dirs[j].Name
+	})
+	return in
+}
+
+func diff(old, new *cpio.Archive, maxDepth int) *cpio.ArchiveDiff {
+	d := cpio.Diff(old, new)
+	if maxDepth >= 0 {
+		dirs := d.Dirs[:0]
+		for _, dir := range d.Dirs {
+			if dirDepth(dir.Name) <= maxDepth {
+				dirs = append(dirs, dir)
+			}
+		}
+		d.Dirs = dirs
+	}
+	return d
+}
+
+func printInspection(w io.Writer, in *Inspection) {
+	for _, dir := range in.Dirs {
+		fmt.Fprintf(w, "%12d  %s\n", dir.Size, dir.Name)
+	}
+	fmt.Fprintf(w, "%d records, %d bytes\n", in.Records, in.Size)
+}
+
+// fieldChange formats how field changed from o to n.
+func fieldChange(field string, o, n *cpio.RecordSummary) string {
+	switch field {
+	case "mode":
+		return fmt.Sprintf("mode %#o -> %#o", o.Mode, n.Mode)
+	case "uid":
+		return fmt.Sprintf("uid %d -> %d", o.UID, n.UID)
+	case "gid":
+		return fmt.Sprintf("gid %d -> %d", o.GID, n.GID)
+	case "size":
+		return fmt.Sprintf("size %d -> %d", o.Size, n.Size)
+	case "rdev":
+		return fmt.Sprintf("rdev %d:%d -> %d:%d", o.Rmajor, o.Rminor, n.Rmajor, n.Rminor)
+	default:
+		return field
+	}
+}
+
+func printDiff(w io.Writer, d *cpio.ArchiveDiff) {
+	for _, r := range d.Added {
+		fmt.Fprintf(w, "+ %s (%d bytes)\n", r.Name, r.New.Size)
+	}
+	for _, r := range d.Removed {
+		fmt.Fprintf(w, "- %s (%d bytes)\n", r.Name, r.Old.Size)
+	}
+	for _, r := range d.Changed {
+		var changes []string
+		for _, field := range r.Changes {
+			changes = append(changes, fieldChange(field, r.Old, r.New))
+		}
+		fmt.Fprintf(w, "~ %s: %s\n", r.Name, strings.Join(changes, ", "))
+	}
+	if len(d.Dirs) > 0 {
+		fmt.Fprintf(w, "\ndirectory size changes:\n")
+		for _, dir := range d.Dirs {
+			fmt.Fprintf(w, "%+12d  %s\n", dir.Delta, dir.Name)
+		}
+	}
+	fmt.Fprintf(w, "\ntotal: %d -> %d bytes (%+d)\n", d.OldSize, d.NewSize, int64(d.NewSize)-int64(d.OldSize))
+}
+
+func run(w io.Writer, args []string, asJSON bool, maxDepth int) error {
+	var archives []*cpio.Archive
+	for _, path := range args {
+		a, err := readArchive(path)
+		if err != nil {
+			return err
+		}
+		archives = append(archives, a)
+	}
+
+	var v interface{}
+	switch len(archives) {
+	case 1:
+		v = inspect(archives[0], maxDepth)
+	case 2:
+		v = diff(archives[0], archives[1], maxDepth)
+	default:
+		return fmt.Errorf("want 1 or 2 archives, got %d", len(archives))
+	}
+
+	if asJSON {
+		out, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(out))
+		return err
+	}
+	switch v := v.(type) {
+	case *Inspection:
+		printInspection(w, v)
+	case *cpio.ArchiveDiff:
+		printDiff(w, v)
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		log.Fatalf("usage: %s [-json] [-depth N] OLD NEW | ARCHIVE", os.Args[0])
+	}
+	if err := run(os.Stdout, flag.Args(), *asJSON, *depth); err != nil {
+		log.Fatal(err)
+	}
+}
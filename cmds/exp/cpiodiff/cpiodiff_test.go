@@ -0,0 +1,156 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/uroot/initramfs"
+)
+
+func writeArchive(t *testing.T, path, compress string, records ...cpio.Record) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var w io.Writer = f
+	if compress != "" {
+		c, err := initramfs.GetCompressor(compress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw, err := c.Writer(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zw.Close()
+		w = zw
+	}
+	rw := cpio.Newc.Writer(w)
+	if err := cpio.WriteRecords(rw, records); err != nil {
+		t.Fatal(err)
+	}
+	if err := cpio.WriteTrailer(rw); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cpiodiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := filepath.Join(dir, "old.cpio")
+	new := filepath.Join(dir, "new.cpio.gz")
+	writeArchive(t, old, "",
+		cpio.Directory("bin", 0755),
+		cpio.StaticFile("bin/ls", "ls", 0755),
+		cpio.StaticFile("bin/rm", "rm", 0755),
+		cpio.StaticFile("init", "init", 0755),
+	)
+	writeArchive(t, new, "gzip",
+		cpio.Directory("bin", 0755),
+		cpio.StaticFile("bin/ls", "ls2", 0700),
+		cpio.StaticFile("init", "init", 0755),
+		cpio.StaticFile("lib/libc.so", "libc", 0644),
+	)
+
+	for _, tt := range []struct {
+		name  string
+		args  []string
+		depth int
+		want  string
+	}{
+		{
+			name:  "diff",
+			args:  []string{old, new},
+			depth: -1,
+			want: `+ lib/libc.so (4 bytes)
+- bin/rm (2 bytes)
+~ bin/ls: mode 0100755 -> 0100700, size 2 -> 3, content
+
+directory size changes:
+          +4  lib
+          +3  .
+          -1  bin
+
+total: 8 -> 11 bytes (+3)
+`,
+		},
+		{
+			name:  "diff depth 0",
+			args:  []string{old, new},
+			depth: 0,
+			want: `+ lib/libc.so (4 bytes)
+- bin/rm (2 bytes)
+~ bin/ls: mode 0100755 -> 0100700, size 2 -> 3, content
+
+directory size changes:
+          +3  .
+
+total: 8 -> 11 bytes (+3)
+`,
+		},
+		{
+			name:  "same",
+			args:  []string{new, new},
+			depth: -1,
+			want: `
+total: 11 -> 11 bytes (+0)
+`,
+		},
+		{
+			name:  "inspect",
+			args:  []string{new},
+			depth: -1,
+			want: `          11  .
+           4  lib
+           3  bin
+4 records, 11 bytes
+`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := run(&out, tt.args, false, tt.depth); err != nil {
+				t.Fatalf("run(%v) = %v", tt.args, err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("run(%v) =\n%s\nwant\n%s", tt.args, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("json", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := run(&out, []string{old, new}, true, -1); err != nil {
+			t.Fatalf("run = %v", err)
+		}
+		var d cpio.ArchiveDiff
+		if err := json.Unmarshal(out.Bytes(), &d); err != nil {
+			t.Fatalf("output is not JSON: %v\n%s", err, out.String())
+		}
+		if len(d.Added) != 1 || len(d.Removed) != 1 || len(d.Changed) != 1 || d.OldSize != 8 || d.NewSize != 11 {
+			t.Errorf("run = %+v, want 1 added, 1 removed, 1 changed, 8 -> 11 bytes", d)
+		}
+	})
+
+	t.Run("missing archive", func(t *testing.T) {
+		if err := run(ioutil.Discard, []string{filepath.Join(dir, "nope")}, false, -1); err == nil {
+			t.Errorf("run(nonexistent) = nil, want error")
+		}
+	})
+}
@@ -5,17 +5,18 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha1"
-	"crypto/sha256"
 	"flag"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"syscall"
 
 	"github.com/google/go-tpm/tpm"
-	"golang.org/x/crypto/ed25519"
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/boot/vboot"
+	"github.com/u-root/u-root/pkg/uio"
 )
 
 const (
@@ -25,13 +26,15 @@ const (
 )
 
 var (
-	publicKey            = flag.String("pubkey", "/etc/sig.pub", "A public key which should verify the signature.")
+	publicKey            = flag.String("pubkey", "/etc/sig.pub", "A public key, or a directory of public keys, which should verify the signatures.")
 	pcr                  = flag.Uint("pcr", 12, "The pcr index used for measuring the kernel before kexec.")
 	bootDev              = flag.String("boot-device", "/dev/sda1", "The boot device which is used to kexec into a signed kernel.")
 	linuxKernel          = flag.String("kernel", "/mnt/vboot/kernel", "Kernel image file path.")
 	linuxKernelSignature = flag.String("kernel-sig", "/mnt/vboot/kernel.sig", "Kernel image signature file path.")
 	initrd               = flag.String("initrd", "/mnt/vboot/initrd", "Initrd file path.")
 	initrdSignature      = flag.String("initrd-sig", "/mnt/vboot/initrd.sig", "Initrd signature file path.")
+	cmdline              = flag.String("cmdline", "", "Kernel command line.")
+	cmdlineSignature     = flag.String("cmdline-sig", "", "Kernel command line signature file path, e.g. /mnt/vboot/kernel.cmdline.sig. The command line is only verified if this is set.")
 	debug                = flag.Bool("debug", false, "Enables debug mode.")
 	noTPM                = flag.Bool("no-tpm", false, "Disables tpm measuring process.")
 )
@@ -56,50 +59,55 @@ func main() {
 		die(err)
 	}
 
-	paths := []string{*publicKey, *linuxKernel, *linuxKernelSignature, *initrd, *initrdSignature}
-	files := make(map[string][]byte)
+	keys, err := vboot.ReadKeyring(*publicKey)
+	if err != nil {
+		die(err)
+	}
 
-	for _, element := range paths {
-		data, err := ioutil.ReadFile(element)
-		if err != nil {
+	// The kernel and initrd are read once, so that the bytes that are
+	// verified are the ones that are measured and loaded.
+	kernel, err := ioutil.ReadFile(*linuxKernel)
+	if err != nil {
+		die(err)
+	}
+	initramfs, err := ioutil.ReadFile(*initrd)
+	if err != nil {
+		die(err)
+	}
+	verify := &vboot.Policy{Mode: vboot.Enforce, Keys: keys}
+	if err := verify.Check("kernel", bytes.NewReader(kernel), uio.NewLazyFile(*linuxKernelSignature)); err != nil {
+		die(err)
+	}
+	if err := verify.Check("initrd", bytes.NewReader(initramfs), uio.NewLazyFile(*initrdSignature)); err != nil {
+		die(err)
+	}
+	if *cmdlineSignature != "" {
+		if err := verify.CheckString("command line", *cmdline, uio.NewLazyFile(*cmdlineSignature)); err != nil {
 			die(err)
-		} else {
-			files[element] = data
 		}
 	}
 
-	kernelDigest := sha256.Sum256(files[*linuxKernel])
-	initrdDigest := sha256.Sum256(files[*initrd])
-
-	pcrDigestKernel := sha1.Sum(files[*linuxKernel])
-	pcrDigestInitrd := sha1.Sum(files[*initrd])
-
-	kernelSuccess := ed25519.Verify(files[*publicKey], kernelDigest[:], files[*linuxKernelSignature])
-	initrdSuccess := ed25519.Verify(files[*publicKey], initrdDigest[:], files[*initrdSignature])
-
-	if !kernelSuccess || !initrdSuccess {
-		die(nil)
-	}
-
 	if !*noTPM {
 		rwc, err := tpm.OpenTPM(tpmDevice)
 		if err != nil {
 			die(err)
 		}
 
-		tpm.PcrExtend(rwc, uint32(*pcr), pcrDigestKernel)
-		tpm.PcrExtend(rwc, uint32(*pcr), pcrDigestInitrd)
+		for _, data := range [][]byte{kernel, initramfs} {
+			tpm.PcrExtend(rwc, uint32(*pcr), sha1.Sum(data))
+		}
 	}
 
-	binary, lookErr := exec.LookPath("kexec")
-	if lookErr != nil {
-		die(lookErr)
+	img := &boot.LinuxImage{
+		Kernel:  bytes.NewReader(kernel),
+		Initrd:  bytes.NewReader(initramfs),
+		Cmdline: *cmdline,
+	}
+	if err := img.Load(*debug); err != nil {
+		die(err)
 	}
 
-	args := []string{"kexec", "-initrd", *initrd, *linuxKernel}
-	env := os.Environ()
-
-	if execErr := syscall.Exec(binary, args, env); execErr != nil {
-		die(execErr)
+	if err := boot.Execute(); err != nil {
+		die(err)
 	}
 }
@@ -17,8 +17,11 @@ package acpi
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"net"
 	"reflect"
+	"strconv"
 )
 
 const (
@@ -342,3 +345,165 @@ func mIBFT(h *HeapTable, i interface{}) error {
 	Debug("mIBFT done, head is %d bytes, heap is %d bytes", h.Head.Len(), h.Heap.Len())
 	return nil
 }
+
+func init() {
+	addUnMarshaler("iBFT", unmarshalIBFT)
+}
+
+// unmarshalIBFT unmarshals an iBFT as firmware writes it. Heap offsets count
+// from the beginning of the table, as in the spec.
+func unmarshalIBFT(t Tabler) (Tabler, error) {
+	b := t.AllData()
+	ibft := &IBFT{Generic: Generic{Header: *GetHeader(t), data: b}}
+	c, err := ibftStruct(b, ibftHeaderLen, ibftControl, ibftControlLen)
+	if err != nil {
+		return nil, err
+	}
+	ibft.Multi = rflag(c[5], 0)
+
+	if off := binary.LittleEndian.Uint16(c[8:]); off != 0 {
+		s, err := ibftStruct(b, off, ibftInitiator, ibftInitiatorLen)
+		if err != nil {
+			return nil, err
+		}
+		if ibft.Initiator.Name, err = rsheap(b, s[70:]); err != nil {
+			return nil, err
+		}
+		ibft.Initiator.Valid = rflag(s[5], 0)
+		ibft.Initiator.Boot = rflag(s[5], 1)
+		ibft.Initiator.SNSServer = ripaddr(s[6:22])
+		ibft.Initiator.SLPServer = ripaddr(s[22:38])
+		ibft.Initiator.PrimaryRadiusServer = ripaddr(s[38:54])
+		ibft.Initiator.SecondaryRadiusServer = ripaddr(s[54:70])
+	}
+	for _, n := range []struct {
+		off int
+		nic *IBFTNIC
+	}{
+		{10, &ibft.NIC0},
+		{14, &ibft.NIC1},
+	} {
+		if err := unmarshalIBFTNIC(b, binary.LittleEndian.Uint16(c[n.off:]), n.nic); err != nil {
+			return nil, err
+		}
+	}
+	for _, tg := range []struct {
+		off    int
+		target *IBFTTarget
+	}{
+		{12, &ibft.Target0},
+		{16, &ibft.Target1},
+	} {
+		if err := unmarshalIBFTTarget(b, binary.LittleEndian.Uint16(c[tg.off:]), tg.target); err != nil {
+			return nil, err
+		}
+	}
+	return ibft, nil
+}
+
+func unmarshalIBFTNIC(b []byte, off uint16, n *IBFTNIC) error {
+	if off == 0 {
+		return nil
+	}
+	s, err := ibftStruct(b, off, ibftNIC, ibftNICLen)
+	if err != nil {
+		return err
+	}
+	if n.HostName, err = rsheap(b, s[98:]); err != nil {
+		return err
+	}
+	n.Valid = rflag(s[5], 0)
+	n.Boot = rflag(s[5], 1)
+	n.Global = rflag(s[5], 2)
+	n.Index = rflag(s[4], 0)
+	n.IPAddress = ripaddr(s[6:22])
+	n.SubNet = u8(strconv.Itoa(int(s[22])))
+	n.Origin = u8(strconv.Itoa(int(s[23])))
+	n.Gateway = ripaddr(s[24:40])
+	n.PrimaryDNS = ripaddr(s[40:56])
+	n.SecondaryDNS = ripaddr(s[56:72])
+	n.DHCP = ripaddr(s[72:88])
+	n.VLAN = u16(strconv.Itoa(int(binary.LittleEndian.Uint16(s[88:]))))
+	n.MACAddress = mac(net.HardwareAddr(s[90:96]).String())
+	n.PCIBDF = bdf(fmt.Sprintf("%#x", binary.LittleEndian.Uint16(s[96:])))
+	return nil
+}
+
+func unmarshalIBFTTarget(b []byte, off uint16, t *IBFTTarget) error {
+	if off == 0 {
+		return nil
+	}
+	s, err := ibftStruct(b, off, ibftTarget, ibftTargetLen)
+	if err != nil {
+		return err
+	}
+	for _, h := range []struct {
+		off int
+		s   *sheap
+	}{
+		{34, &t.TargetName},
+		{38, &t.CHAPName},
+		{42, &t.CHAPSecret},
+		{46, &t.ReverseCHAPName},
+		{50, &t.ReverseCHAPSecret},
+	} {
+		if *h.s, err = rsheap(b, s[h.off:]); err != nil {
+			return err
+		}
+	}
+	t.Valid = rflag(s[5], 0)
+	t.Boot = rflag(s[5], 1)
+	t.CHAP = rflag(s[5], 2)
+	t.RCHAP = rflag(s[5], 3)
+	t.Index = rflag(s[4], 0)
+	if ip := ripaddr(s[6:22]); ip != "" {
+		t.TargetIP = sockaddr(net.JoinHostPort(string(ip), strconv.Itoa(int(binary.LittleEndian.Uint16(s[22:])))))
+	}
+	t.BootLUN = u64(strconv.FormatUint(binary.LittleEndian.Uint64(s[24:]), 10))
+	t.ChapType = u8(strconv.Itoa(int(s[32])))
+	t.Association = u8(strconv.Itoa(int(s[33])))
+	return nil
+}
+
+// ibftStruct returns the iBFT structure with the given id and minimum
+// length at off in b.
+func ibftStruct(b []byte, off uint16, id uint8, length uint16) ([]byte, error) {
+	if int(off)+int(length) > len(b) {
+		return nil, fmt.Errorf("iBFT structure %d at %d runs past the end of the table (%d bytes)", id, off, len(b))
+	}
+	s := b[off:]
+	if s[0] != id {
+		return nil, fmt.Errorf("iBFT structure at %d has ID %d, want %d", off, s[0], id)
+	}
+	return s[:length], nil
+}
+
+// rflag returns bit of f as a flag.
+func rflag(f uint8, bit uint) flag {
+	if f&(1<<bit) != 0 {
+		return "1"
+	}
+	return "0"
+}
+
+// ripaddr returns the 16 byte address b, or "" if it is unset.
+func ripaddr(b []byte) ipaddr {
+	ip := net.IP(b)
+	if ip.Equal(net.IPv6zero) || ip.Equal(net.IPv4zero) {
+		return ""
+	}
+	return ipaddr(ip.String())
+}
+
+// rsheap returns the heap string whose length and offset are at the start
+// of h.
+func rsheap(b []byte, h []byte) (sheap, error) {
+	l, off := int(binary.LittleEndian.Uint16(h)), int(binary.LittleEndian.Uint16(h[2:]))
+	if l == 0 {
+		return "", nil
+	}
+	if off+l > len(b) {
+		return "", fmt.Errorf("iBFT heap entry at %d runs past the end of the table (%d bytes)", off, len(b))
+	}
+	return sheap(b[off : off+l]), nil
+}
@@ -5,10 +5,14 @@
 package acpi
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"io/ioutil"
+	"net"
 	"reflect"
 	"testing"
+
+	"github.com/u-root/u-root/pkg/ibft"
 )
 
 /*
@@ -146,3 +150,111 @@ func TestIBFTMarshal(t *testing.T) {
 	}
 	t.Logf("Wrote %d bytes to %q", n, f.Name())
 }
+
+func TestIBFTUnmarshal(t *testing.T) {
+	i := &ibft.IBFT{
+		Initiator: ibft.Initiator{
+			Name:  "iqn.2019-01.org.u-root:initiator",
+			Valid: true,
+			Boot:  true,
+		},
+		NIC0: ibft.NIC{
+			Valid:      true,
+			Boot:       true,
+			Global:     true,
+			IPNet:      &net.IPNet{IP: net.IP{192, 168, 1, 5}, Mask: net.CIDRMask(24, 32)},
+			Gateway:    net.IP{192, 168, 1, 1},
+			PrimaryDNS: net.IP{8, 8, 8, 8},
+			VLAN:       10,
+			MACAddress: net.HardwareAddr{0, 0x0c, 0x29, 0x12, 0xa4, 0x2e},
+			PCIBDF:     ibft.BDF{Bus: 0, Device: 3, Function: 0},
+			HostName:   "somehost",
+		},
+		Target0: ibft.Target{
+			Valid:      true,
+			Boot:       true,
+			Target:     &net.TCPAddr{IP: net.IP{192, 168, 1, 2}, Port: 3260},
+			BootLUN:    1,
+			TargetName: "iqn.2019-01.org.u-root:target",
+		},
+	}
+	r, err := NewRaw(i.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tab, err := unmarshalIBFT(r)
+	if err != nil {
+		t.Fatalf("unmarshalIBFT: got %v, want nil", err)
+	}
+	got := tab.(*IBFT)
+
+	wantInitiator := IBFTInitiator{
+		Valid: "1",
+		Boot:  "1",
+		Name:  "iqn.2019-01.org.u-root:initiator",
+	}
+	if !reflect.DeepEqual(got.Initiator, wantInitiator) {
+		t.Errorf("Initiator: got %+v, want %+v", got.Initiator, wantInitiator)
+	}
+	wantNIC := IBFTNIC{
+		Valid:      "1",
+		Boot:       "1",
+		Global:     "1",
+		Index:      "0",
+		IPAddress:  "192.168.1.5",
+		SubNet:     "24",
+		Origin:     "0",
+		Gateway:    "192.168.1.1",
+		PrimaryDNS: "8.8.8.8",
+		VLAN:       "10",
+		MACAddress: "00:0c:29:12:a4:2e",
+		PCIBDF:     "0x18",
+		HostName:   "somehost",
+	}
+	if !reflect.DeepEqual(got.NIC0, wantNIC) {
+		t.Errorf("NIC0: got %+v, want %+v", got.NIC0, wantNIC)
+	}
+	wantTarget := IBFTTarget{
+		Valid:       "1",
+		Boot:        "1",
+		CHAP:        "0",
+		RCHAP:       "0",
+		Index:       "0",
+		TargetIP:    "192.168.1.2:3260",
+		BootLUN:     "1",
+		ChapType:    "0",
+		Association: "0",
+		TargetName:  "iqn.2019-01.org.u-root:target",
+	}
+	if !reflect.DeepEqual(got.Target0, wantTarget) {
+		t.Errorf("Target0: got %+v, want %+v", got.Target0, wantTarget)
+	}
+	if got.NIC1 != (IBFTNIC{}) {
+		t.Errorf("NIC1: got %+v, want none", got.NIC1)
+	}
+}
+
+func TestIBFTUnmarshalErrors(t *testing.T) {
+	good := (&ibft.IBFT{NIC0: ibft.NIC{Valid: true, HostName: "somehost"}}).Marshal()
+	for _, tt := range []struct {
+		name string
+		b    []byte
+	}{
+		{"short", good[:60]},
+		{"no heap", good[:0x138]},
+		{"bad control", append(append(append([]byte{}, good[:48]...), 9), good[49:]...)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// Fix the length, so that NewRaw does not cut the table.
+			b := append([]byte{}, tt.b...)
+			binary.LittleEndian.PutUint32(b[LengthOffset:], uint32(len(b)))
+			r, err := NewRaw(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := unmarshalIBFT(r); err == nil {
+				t.Errorf("unmarshalIBFT: got nil, want error")
+			}
+		})
+	}
+}
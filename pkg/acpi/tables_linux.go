@@ -28,3 +28,20 @@ func RawTables() ([]Tabler, error) {
 	}
 	return tabs, nil
 }
+
+// ibftPath is where Linux shows the iBFT, if firmware put it in the ACPI
+// tables.
+const ibftPath = "/sys/firmware/acpi/tables/iBFT"
+
+// ReadIBFT reads the iSCSI Boot Firmware Table from /sys.
+func ReadIBFT() (*IBFT, error) {
+	r, err := RawFromFile(ibftPath)
+	if err != nil {
+		return nil, err
+	}
+	t, err := unmarshalIBFT(r)
+	if err != nil {
+		return nil, err
+	}
+	return t.(*IBFT), nil
+}
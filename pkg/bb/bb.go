@@ -36,9 +36,11 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/tools/go/ast/astutil"
@@ -83,6 +85,39 @@ func getBBLock(bblock string) (*lockfile.Lockfile, error) {
 // pkgs is a list of Go import paths. If nil is returned, binaryPath will hold
 // the busybox-style binary.
 func BuildBusybox(env golang.Environ, pkgs []string, binaryPath string) error {
+	return BuildBusyboxCached(env, pkgs, binaryPath, nil, golang.BuildOpts{})
+}
+
+// The import paths of the bb implementation in the u-root repository.
+const (
+	bbImportPath    = "github.com/u-root/u-root/pkg/bb/bbmain"
+	bbCmdImportPath = "github.com/u-root/u-root/pkg/bb/bbmain/cmd"
+)
+
+// BuildBusyboxCached builds a busybox of the given Go packages like
+// BuildBusybox does.
+//
+// If cache is not nil, rewritten packages and the compiled busybox are taken
+// from the cache when possible, and added to it otherwise.
+//
+// opts are used to compile the busybox.
+//
+// pkgs must either all be in GOPATH or all be part of Go modules. Commands
+// from several different modules may be combined into one busybox.
+func BuildBusyboxCached(env golang.Environ, pkgs []string, binaryPath string, cache *Cache, opts golang.BuildOpts) error {
+	buildPkgs, err := commandPackages(env, pkgs)
+	if err != nil {
+		return err
+	}
+
+	modules, err := inModules(env, buildPkgs)
+	if err != nil {
+		return err
+	}
+	if modules {
+		return buildModuleBusybox(env, buildPkgs, binaryPath, cache, opts)
+	}
+
 	urootPkg, err := env.Package("github.com/u-root/u-root")
 	if err != nil {
 		return err
@@ -115,9 +150,37 @@ func BuildBusybox(env golang.Environ, pkgs []string, binaryPath string) error {
 		return err
 	}
 
-	var bbPackages []string
-	// Move and rewrite package files.
-	importer := importer.For("source", nil)
+	var bbPkgs []*build.Package
+	for _, imp := range []string{bbImportPath, bbCmdImportPath} {
+		p, err := env.Package(imp)
+		if err != nil {
+			return err
+		}
+		bbPkgs = append(bbPkgs, p)
+	}
+
+	// TODO: use bbDir to derive import path below or vice versa.
+	b := &busybox{
+		env:          env,
+		pkgs:         buildPkgs,
+		bbPkgs:       bbPkgs,
+		bbImportPath: bbImportPath,
+		mainDir:      bbDir,
+		pkgDir: func(p *build.Package) string {
+			return filepath.Join(p.Dir, ".bb")
+		},
+		pkgImportPath: gopathImportPath,
+		compile: func(opts golang.BuildOpts) error {
+			return env.Build("github.com/u-root/u-root/bb", binaryPath, opts)
+		},
+	}
+	return b.build(binaryPath, cache, opts)
+}
+
+// commandPackages returns the packages of the commands pkgs to include in a
+// busybox.
+func commandPackages(env golang.Environ, pkgs []string) ([]*build.Package, error) {
+	var buildPkgs []*build.Package
 	seenPackages := map[string]bool{}
 	for _, pkg := range pkgs {
 		basePkg := path.Base(pkg)
@@ -125,35 +188,191 @@ func BuildBusybox(env golang.Environ, pkgs []string, binaryPath string) error {
 			continue
 		}
 		if _, ok := seenPackages[path.Base(pkg)]; ok {
-			return fmt.Errorf("Failed to build with bb: found duplicate pkgs %s", basePkg)
+			return nil, fmt.Errorf("Failed to build with bb: found duplicate pkgs %s", basePkg)
 		}
 		seenPackages[basePkg] = true
 
-		// TODO: use bbDir to derive import path below or vice versa.
-		if err := RewritePackage(env, pkg, "github.com/u-root/u-root/pkg/bb/bbmain", importer); err != nil {
+		p, err := env.Package(pkg)
+		if err != nil {
+			return nil, err
+		}
+		buildPkgs = append(buildPkgs, p)
+	}
+	return buildPkgs, nil
+}
+
+// gopathImportPath returns the import path of the rewritten package of the
+// GOPATH command p.
+func gopathImportPath(p *build.Package) string {
+	return path.Join(p.ImportPath, ".bb")
+}
+
+// busybox describes where the rewritten packages and the main package of a
+// busybox go, and how to compile it.
+type busybox struct {
+	env  golang.Environ
+	pkgs []*build.Package
+
+	// bbPkgs are the bbmain package and the bb main.go template.
+	bbPkgs []*build.Package
+
+	// bbImportPath is the import path of bbmain that rewritten packages
+	// register with.
+	bbImportPath string
+
+	// mainDir is the directory of the bb main package.
+	mainDir string
+
+	// pkgDir and pkgImportPath return the directory and import path of
+	// the rewritten package of p.
+	pkgDir        func(p *build.Package) string
+	pkgImportPath func(p *build.Package) string
+
+	// importer returns the importer used to type check p. If nil, the
+	// source importer is used.
+	importer func(p *build.Package) (types.Importer, error)
+
+	// extraKey is additional input to the busybox cache key.
+	extraKey string
+
+	// compile compiles the bb main package.
+	compile func(opts golang.BuildOpts) error
+}
+
+func (b *busybox) build(binaryPath string, cache *Cache, opts golang.BuildOpts) error {
+	var pkgKeys []string
+	var binKey string
+	if cache != nil {
+		h, err := newHasher(b.env)
+		if err != nil {
 			return err
 		}
+		for _, p := range b.pkgs {
+			key, err := h.packageKey(p, b.bbImportPath)
+			if err != nil {
+				return err
+			}
+			pkgKeys = append(pkgKeys, key)
+		}
+		keys := append(pkgKeys[:len(pkgKeys):len(pkgKeys)], fmt.Sprintf("buildopts %q %t", opts.ExtraArgs, opts.Reproducible))
+		if len(b.extraKey) > 0 {
+			keys = append(keys, b.extraKey)
+		}
+		if binKey, err = h.binaryKey(keys, b.bbPkgs); err != nil {
+			return err
+		}
+		if ok, err := cache.getBinary(binKey, binaryPath); err != nil || ok {
+			return err
+		}
+	}
 
-		bbPackages = append(bbPackages, path.Join(pkg, ".bb"))
+	// Move and rewrite package files.
+	if err := b.rewritePackages(pkgKeys, cache); err != nil {
+		return err
 	}
 
-	bb, err := NewPackageFromEnv(env, "github.com/u-root/u-root/pkg/bb/bbmain/cmd", importer)
+	var bbPackages []string
+	for _, p := range b.pkgs {
+		bbPackages = append(bbPackages, b.pkgImportPath(p))
+	}
+
+	fset, astp, err := ParseAST(SrcFiles(b.bbPkgs[1]))
 	if err != nil {
 		return err
 	}
-	if bb == nil {
-		return fmt.Errorf("bb cmd template missing")
-	}
-	if len(bb.ast.Files) != 1 {
+	if len(astp.Files) != 1 {
 		return fmt.Errorf("bb cmd template is supposed to only have one file")
 	}
+	for _, f := range astp.Files {
+		astutil.RewriteImport(fset, f, bbImportPath, b.bbImportPath)
+	}
 	// Create bb main.go.
-	if err := CreateBBMainSource(bb.fset, bb.ast, bbPackages, bbDir); err != nil {
+	if err := CreateBBMainSource(fset, astp, bbPackages, b.mainDir); err != nil {
+		return err
+	}
+
+	// Compile bb. When caching, let the go tool reuse the packages that
+	// did not change, too.
+	opts.NoForceRebuild = cache != nil
+	if err := b.compile(opts); err != nil {
 		return err
 	}
+	if cache != nil {
+		return cache.putBinary(binKey, binaryPath)
+	}
+	return nil
+}
+
+// rewritePackages rewrites the busybox's packages concurrently.
+//
+// If cache is not nil, pkgKeys are the cache keys of the packages.
+func (b *busybox) rewritePackages(pkgKeys []string, cache *Cache) error {
+	work := make(chan int)
+	errs := make(chan error, len(b.pkgs))
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// The source importer is not safe for concurrent use,
+			// so every worker gets its own.
+			importer := importer.For("source", nil)
+			for i := range work {
+				var key string
+				if cache != nil {
+					key = pkgKeys[i]
+				}
+				p := b.pkgs[i]
+				imp := importer
+				if b.importer != nil {
+					var err error
+					if imp, err = b.importer(p); err != nil {
+						errs <- err
+						continue
+					}
+				}
+				errs <- rewriteCachedPackage(p, b.pkgDir(p), key, b.bbImportPath, imp, cache)
+			}
+		}()
+	}
+	for i := range b.pkgs {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	close(errs)
 
-	// Compile bb.
-	return env.Build("github.com/u-root/u-root/bb", binaryPath, golang.BuildOpts{})
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteCachedPackage rewrites p into dest. If cache is not nil, the
+// rewritten package is looked up and stored under key.
+func rewriteCachedPackage(p *build.Package, dest, key, bbImportPath string, importer types.Importer, cache *Cache) error {
+	if cache != nil {
+		if err := os.RemoveAll(dest); err != nil {
+			return fmt.Errorf("error removing stale directory %q: %v", dest, err)
+		}
+		if ok, err := cache.getPackage(key, dest); err != nil || ok {
+			return err
+		}
+	}
+	if err := rewritePackage(p, dest, bbImportPath, importer); err != nil {
+		return fmt.Errorf("rewriting %s: %v", p.ImportPath, err)
+	}
+	if cache == nil {
+		return nil
+	}
+	// Packages without a main function are not rewritten.
+	if _, err := os.Stat(dest); os.IsNotExist(err) {
+		return nil
+	}
+	return cache.putPackage(key, dest)
 }
 
 // CreateBBMainSource creates a bb Go command that imports all given pkgs.
@@ -277,14 +496,17 @@ func RewritePackage(env golang.Environ, pkgPath, bbImportPath string, importer t
 	if err != nil {
 		return err
 	}
+	return rewritePackage(buildp, filepath.Join(buildp.Dir, ".bb"), bbImportPath, importer)
+}
 
+// rewritePackage rewrites buildp into dest.
+func rewritePackage(buildp *build.Package, dest, bbImportPath string, importer types.Importer) error {
 	p, err := NewPackage(filepath.Base(buildp.Dir), buildp.ImportPath, SrcFiles(buildp), importer)
 	if err != nil {
 		return err
 	}
-	dest := filepath.Join(buildp.Dir, ".bb")
-	// If .bb directory already exists, delete it. This will prevent stale
-	// files from being included in the build.
+	// If the destination directory already exists, delete it. This will
+	// prevent stale files from being included in the build.
 	if err := os.RemoveAll(dest); err != nil {
 		return fmt.Errorf("error removing stale directory %q: %v", dest, err)
 	}
@@ -32,3 +32,95 @@ func TestPackageRewriteFile(t *testing.T) {
 		t.Fatalf("foo failed: %v %v", string(o), err)
 	}
 }
+
+func TestBuildBusyboxCached(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache := &Cache{Dir: filepath.Join(dir, "cache")}
+	pkgs := []string{"github.com/u-root/u-root/pkg/uroot/test/foo"}
+	// The second build comes from the cache. The binary must be named
+	// foo for bb to run the foo command.
+	for _, name := range []string{"build", "cached"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		bin := filepath.Join(dir, name, "foo")
+		if err := BuildBusyboxCached(golang.Default(), pkgs, bin, cache, golang.BuildOpts{}); err != nil {
+			t.Fatal(err)
+		}
+		if o, err := exec.Command(bin).CombinedOutput(); err != nil {
+			t.Fatalf("%s foo failed: %v %v", name, string(o), err)
+		}
+	}
+
+	for _, kind := range []string{"pkg", "bin"} {
+		entries, err := ioutil.ReadDir(filepath.Join(cache.Dir, kind))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("cache has %d %s entries, want 1", len(entries), kind)
+		}
+	}
+}
+
+func TestPackageKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(pkg, content string) {
+		if err := os.MkdirAll(filepath.Join(dir, "src", pkg), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "src", pkg, pkg+".go"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env := golang.Default()
+	env.GOPATH = dir
+	key := func() string {
+		h, err := newHasher(env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := env.Package("cmd1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := h.packageKey(p, "bbmain")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	write("cmd1", "package main\n\nimport \"lib\"\n\nvar x = lib.X\n\nfunc main() {}\n")
+	write("lib", "package lib\n\nvar X = 1\n")
+	k1 := key()
+	if k := key(); k != k1 {
+		t.Errorf("key of unchanged package changed from %s to %s", k1, k)
+	}
+
+	write("lib", "package lib\n\nvar X = \"one\"\n")
+	k2 := key()
+	if k2 == k1 {
+		t.Errorf("key did not change with dependency")
+	}
+
+	write("cmd1", "package main\n\nimport \"lib\"\n\nvar x = lib.X\n\nfunc main() { println(x) }\n")
+	if k := key(); k == k2 {
+		t.Errorf("key did not change with package source")
+	}
+
+	env.GOARCH = "arm64"
+	if k := key(); k == k2 {
+		t.Errorf("key did not change with GOARCH")
+	}
+}
@@ -11,7 +11,6 @@ import (
 	"path/filepath"
 
 	"github.com/u-root/u-root/pkg/bb/bbmain"
-	"github.com/u-root/u-root/pkg/uroot/util"
 )
 
 func run() {
@@ -22,7 +21,7 @@ func run() {
 }
 
 func main() {
-	os.Args[0] = util.ResolveUntilLastSymlink(os.Args[0])
+	os.Args[0] = bbmain.ResolveUntilLastSymlink(os.Args[0])
 
 	run()
 }
@@ -0,0 +1,52 @@
+// Copyright 2014-2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bbmain
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// The functions in this file mirror those in pkg/uroot/util. bbmain must
+// only depend on the standard library, so that it can be copied into
+// busyboxes built in module mode.
+
+// absSymlink returns an absolute path for the link from a file to a target.
+func absSymlink(originalFile, target string) string {
+	if !filepath.IsAbs(originalFile) {
+		if abs, err := filepath.Abs(originalFile); err == nil {
+			originalFile = abs
+		}
+	}
+	// Relative symlinks are resolved relative to the original file's
+	// parent directory.
+	//
+	// E.g. /bin/defaultsh -> ../bbin/elvish
+	if !filepath.IsAbs(target) {
+		return filepath.Join(filepath.Dir(originalFile), target)
+	}
+	return target
+}
+
+// isTargetSymlink returns true if a target of a symlink is also a symlink.
+func isTargetSymlink(originalFile, target string) bool {
+	s, err := os.Lstat(absSymlink(originalFile, target))
+	if err != nil {
+		return false
+	}
+	return (s.Mode() & os.ModeSymlink) == os.ModeSymlink
+}
+
+// ResolveUntilLastSymlink resolves until the last symlink.
+//
+// This is needed when we have a chain of symlinks and want the last
+// symlink, not the file pointed to (which is why we don't use
+// filepath.EvalSymlinks).
+func ResolveUntilLastSymlink(p string) string {
+	for target, err := os.Readlink(p); err == nil && isTargetSymlink(p, target); target, err = os.Readlink(p) {
+		p = absSymlink(p, target)
+	}
+	return p
+}
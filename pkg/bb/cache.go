@@ -0,0 +1,340 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/u-root/u-root/pkg/golang"
+)
+
+// cacheVersion is part of every cache key. Bump it whenever the rewrite
+// changes, so that stale rewritten packages are not used.
+const cacheVersion = "bb-cache-1"
+
+// Cache is a content-addressed cache of rewritten bb packages and compiled
+// busybox binaries.
+//
+// A rewritten package is keyed on the Go environment, the source files of
+// the package, and the source files of all of its non-standard library
+// dependencies, since the rewrite depends on their types. A busybox binary is
+// keyed on the keys of all of its packages.
+//
+// Cache directories may be shared between processes.
+type Cache struct {
+	// Dir is the cache directory.
+	Dir string
+}
+
+func (c *Cache) pkgDir(key string) string {
+	return filepath.Join(c.Dir, "pkg", key)
+}
+
+func (c *Cache) binPath(key string) string {
+	return filepath.Join(c.Dir, "bin", key)
+}
+
+// getPackage copies the rewritten package with the given key to destDir.
+//
+// It returns false if the package is not cached.
+func (c *Cache) getPackage(key, destDir string) (bool, error) {
+	src := c.pkgDir(key)
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return false, nil
+	}
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return false, err
+	}
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return false, err
+	}
+	for _, fi := range files {
+		if err := copyFile(filepath.Join(src, fi.Name()), filepath.Join(destDir, fi.Name()), 0644); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// putPackage adds the rewritten package in srcDir to the cache.
+func (c *Cache) putPackage(key, srcDir string) error {
+	files, err := ioutil.ReadDir(srcDir)
+	if err != nil {
+		return err
+	}
+	return c.put(c.pkgDir(key), func(tmp string) error {
+		if err := os.Mkdir(tmp, 0755); err != nil {
+			return err
+		}
+		for _, fi := range files {
+			if err := copyFile(filepath.Join(srcDir, fi.Name()), filepath.Join(tmp, fi.Name()), 0644); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// getBinary copies the busybox binary with the given key to binaryPath.
+//
+// It returns false if the binary is not cached.
+func (c *Cache) getBinary(key, binaryPath string) (bool, error) {
+	src := c.binPath(key)
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return false, nil
+	}
+	if err := copyFile(src, binaryPath, 0755); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// putBinary adds the busybox binary at binaryPath to the cache.
+func (c *Cache) putBinary(key, binaryPath string) error {
+	return c.put(c.binPath(key), func(tmp string) error {
+		return copyFile(binaryPath, tmp, 0755)
+	})
+}
+
+// put atomically creates the cache entry at path by having create fill in a
+// temporary path and renaming it.
+func (c *Cache) put(path string, create func(tmp string) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmpDir, err := ioutil.TempDir(filepath.Dir(path), ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmp := filepath.Join(tmpDir, "entry")
+	if err := create(tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		// Another process may have added the same entry in the
+		// meantime. Since entries are content-addressed, that's
+		// fine.
+		if _, serr := os.Stat(path); serr == nil {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// hasher computes cache keys for packages in a Go environment.
+type hasher struct {
+	env golang.Environ
+
+	// envKey identifies env and the Go tool chain.
+	envKey string
+
+	mu sync.Mutex
+	// files maps a package directory to the hash of its source files.
+	files map[string]string
+	// deps maps a package directory to its non-standard library
+	// dependencies, including itself.
+	deps map[string][]*build.Package
+}
+
+func newHasher(env golang.Environ) (*hasher, error) {
+	v, err := env.Version()
+	if err != nil {
+		return nil, fmt.Errorf("could not determine Go version: %v", err)
+	}
+	root, err := env.Root()
+	if err != nil {
+		return nil, fmt.Errorf("could not determine GOROOT: %v", err)
+	}
+	// The rewrite also depends on the Go version u-root was built with,
+	// whose go/types type-checks the packages.
+	return &hasher{
+		env:    env,
+		envKey: fmt.Sprintf("%s %s %s goroot=%s tags=%s", v, runtime.Version(), env, root, strings.Join(env.BuildTags, ",")),
+		files:  make(map[string]string),
+		deps:   make(map[string][]*build.Package),
+	}, nil
+}
+
+// fileHash returns the hash of the source files of p.
+func (h *hasher) fileHash(p *build.Package) (string, error) {
+	h.mu.Lock()
+	sum, ok := h.files[p.Dir]
+	h.mu.Unlock()
+	if ok {
+		return sum, nil
+	}
+
+	var names []string
+	for _, files := range [][]string{p.GoFiles, p.CgoFiles, p.SFiles, p.HFiles, p.CFiles, p.CXXFiles, p.MFiles, p.FFiles, p.SwigFiles, p.SwigCXXFiles, p.SysoFiles} {
+		names = append(names, files...)
+	}
+	sort.Strings(names)
+
+	s := sha256.New()
+	for _, name := range names {
+		b, err := ioutil.ReadFile(filepath.Join(p.Dir, name))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(s, "%s %d\n", name, len(b))
+		s.Write(b)
+	}
+	sum = hex.EncodeToString(s.Sum(nil))
+
+	h.mu.Lock()
+	h.files[p.Dir] = sum
+	h.mu.Unlock()
+	return sum, nil
+}
+
+// dependencies returns p and all of its transitive non-standard library
+// dependencies, sorted by import path.
+func (h *hasher) dependencies(p *build.Package) ([]*build.Package, error) {
+	h.mu.Lock()
+	deps, ok := h.deps[p.Dir]
+	h.mu.Unlock()
+	if ok {
+		return deps, nil
+	}
+
+	if h.env.UseModules(p.Dir) {
+		return h.moduleDependencies(p)
+	}
+
+	seen := map[string]*build.Package{p.Dir: p}
+	queue := []*build.Package{p}
+	for len(queue) > 0 {
+		q := queue[0]
+		queue = queue[1:]
+		for _, imp := range q.Imports {
+			if imp == "C" || imp == "unsafe" {
+				continue
+			}
+			// Resolve relative to q.Dir to honor vendor directories.
+			dep, err := h.env.Context.Import(imp, q.Dir, 0)
+			if err != nil {
+				return nil, err
+			}
+			if dep.Goroot {
+				continue
+			}
+			if _, ok := seen[dep.Dir]; !ok {
+				seen[dep.Dir] = dep
+				queue = append(queue, dep)
+			}
+		}
+	}
+	for _, dep := range seen {
+		deps = append(deps, dep)
+	}
+	sort.Slice(deps, func(i, j int) bool {
+		return deps[i].ImportPath < deps[j].ImportPath
+	})
+
+	h.mu.Lock()
+	h.deps[p.Dir] = deps
+	h.mu.Unlock()
+	return deps, nil
+}
+
+// moduleDependencies is dependencies for packages in modules, whose imports
+// go/build cannot resolve.
+func (h *hasher) moduleDependencies(p *build.Package) ([]*build.Package, error) {
+	lps, err := h.env.ListDeps(p.Dir, false)
+	if err != nil {
+		return nil, err
+	}
+	var deps []*build.Package
+	for _, lp := range lps {
+		if lp.Goroot {
+			continue
+		}
+		if lp.Dir == p.Dir {
+			deps = append(deps, p)
+		} else {
+			deps = append(deps, lp.BuildPackage())
+		}
+	}
+	sort.Slice(deps, func(i, j int) bool {
+		return deps[i].ImportPath < deps[j].ImportPath
+	})
+
+	h.mu.Lock()
+	h.deps[p.Dir] = deps
+	h.mu.Unlock()
+	return deps, nil
+}
+
+// packageKey returns the cache key of the bb rewrite of p, registering with
+// bbImportPath.
+func (h *hasher) packageKey(p *build.Package, bbImportPath string) (string, error) {
+	deps, err := h.dependencies(p)
+	if err != nil {
+		return "", err
+	}
+
+	s := sha256.New()
+	fmt.Fprintf(s, "%s\n%s\n%s\n%s\n", cacheVersion, h.envKey, bbImportPath, p.ImportPath)
+	for _, dep := range deps {
+		sum, err := h.fileHash(dep)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(s, "%s %s\n", dep.ImportPath, sum)
+	}
+	return hex.EncodeToString(s.Sum(nil)), nil
+}
+
+// binaryKey returns the cache key of a busybox built from the packages with
+// the given keys and the bb implementation in bbPkgs.
+func (h *hasher) binaryKey(pkgKeys []string, bbPkgs []*build.Package) (string, error) {
+	s := sha256.New()
+	fmt.Fprintf(s, "%s\n%s\n", cacheVersion, h.envKey)
+	for _, key := range pkgKeys {
+		fmt.Fprintf(s, "%s\n", key)
+	}
+	// The bb packages only depend on each other and the standard
+	// library.
+	for _, p := range bbPkgs {
+		sum, err := h.fileHash(p)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(s, "%s %s\n", p.ImportPath, sum)
+	}
+	return hex.EncodeToString(s.Sum(nil)), nil
+}
@@ -0,0 +1,427 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bb
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/golang"
+)
+
+// bbModulePath is the module path of the synthetic module that busyboxes of
+// commands in Go modules are built in.
+const bbModulePath = "bb.u-root.com/bb"
+
+// localVersion is the version required of modules that are replaced by a
+// local directory.
+const localVersion = "v0.0.0-00010101000000-000000000000"
+
+// moduleImportPath returns the import path of the rewritten package of the
+// module command p.
+func moduleImportPath(p *build.Package) string {
+	return path.Join(bbModulePath, "cmds", p.ImportPath)
+}
+
+// inModules returns true if all pkgs are part of Go modules, and false if
+// none of them are.
+func inModules(env golang.Environ, pkgs []*build.Package) (bool, error) {
+	var modules, gopath []string
+	for _, p := range pkgs {
+		if env.UseModules(p.Dir) {
+			modules = append(modules, p.ImportPath)
+		} else {
+			gopath = append(gopath, p.ImportPath)
+		}
+	}
+	if len(modules) > 0 && len(gopath) > 0 {
+		return false, fmt.Errorf("cannot build a busybox of both Go module packages %v and GOPATH packages %v", modules, gopath)
+	}
+	return len(modules) > 0, nil
+}
+
+// bbSourcePackage finds the source of one of the u-root bb packages.
+//
+// The u-root bb packages are either in the module of the current directory's
+// build list or in GOPATH.
+func bbSourcePackage(env golang.Environ, importPath string) (*build.Package, error) {
+	if p, err := env.Package(importPath); err == nil {
+		return p, nil
+	}
+	// go/build resolves import paths in module mode, too, so look in
+	// GOPATH explicitly.
+	for _, gopath := range filepath.SplitList(env.GOPATH) {
+		p, err := env.Context.ImportDir(filepath.Join(gopath, "src", filepath.FromSlash(importPath)), 0)
+		if err == nil {
+			p.ImportPath = importPath
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("could not find %q in the current module or GOPATH", importPath)
+}
+
+// buildModuleBusybox builds a busybox of commands in one or more Go modules.
+//
+// The commands are rewritten into a temporary synthetic module, whose go.mod
+// requires the union of the requirements of all the commands' modules. Each
+// command's module is replaced by its directory.
+func buildModuleBusybox(env golang.Environ, pkgs []*build.Package, binaryPath string, cache *Cache, opts golang.BuildOpts) error {
+	bbmainPkg, err := bbSourcePackage(env, bbImportPath)
+	if err != nil {
+		return err
+	}
+	templatePkg, err := bbSourcePackage(env, bbCmdImportPath)
+	if err != nil {
+		return err
+	}
+
+	var gm goMod
+	seen := make(map[string]bool)
+	for _, p := range pkgs {
+		lp, err := env.Deps(p.Dir)
+		if err != nil {
+			return err
+		}
+		if lp.Module == nil {
+			return fmt.Errorf("package %s in %q is not part of a module", p.ImportPath, p.Dir)
+		}
+		if seen[lp.Module.Dir] {
+			continue
+		}
+		seen[lp.Module.Dir] = true
+		if err := gm.addMainModule(env, lp.Module); err != nil {
+			return err
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "bb-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	goModFile := gm.goMod()
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), goModFile, 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.sum"), gm.goSum(), 0644); err != nil {
+		return err
+	}
+
+	// bbmain only depends on the standard library, so it can be copied
+	// as is.
+	bbmainDir := filepath.Join(dir, "pkg", "bbmain")
+	if err := os.MkdirAll(bbmainDir, 0755); err != nil {
+		return err
+	}
+	for _, name := range bbmainPkg.GoFiles {
+		if err := copyFile(filepath.Join(bbmainPkg.Dir, name), filepath.Join(bbmainDir, name), 0644); err != nil {
+			return err
+		}
+	}
+
+	b := &busybox{
+		env:          env,
+		pkgs:         pkgs,
+		bbPkgs:       []*build.Package{bbmainPkg, templatePkg},
+		bbImportPath: path.Join(bbModulePath, "pkg", "bbmain"),
+		mainDir:      dir,
+		pkgDir: func(p *build.Package) string {
+			return filepath.Join(dir, "cmds", filepath.FromSlash(p.ImportPath))
+		},
+		pkgImportPath: moduleImportPath,
+		// go/build resolves imports relative to the current
+		// directory's module, so type check with export data
+		// from each command's own module instead.
+		importer: exportImporter(env),
+		extraKey: string(goModFile),
+		compile: func(opts golang.BuildOpts) error {
+			menv := env
+			menv.GO111MODULE = "on"
+			// The go tool adds missing indirect requirements.
+			// Building from the temporary directory must not
+			// leak into the binary.
+			opts.ExtraArgs = append(opts.ExtraArgs[:len(opts.ExtraArgs):len(opts.ExtraArgs)], "-mod=mod", "-trimpath")
+			return menv.BuildDir(dir, binaryPath, opts)
+		},
+	}
+	return b.build(binaryPath, cache, opts)
+}
+
+// exportImporter returns importers that type check packages in modules with
+// the export data of their dependencies.
+//
+// go/build resolves imports relative to the module of the current directory,
+// not of the importing package, so the source importer cannot be used.
+func exportImporter(env golang.Environ) func(p *build.Package) (types.Importer, error) {
+	return func(p *build.Package) (types.Importer, error) {
+		deps, err := env.ListDeps(p.Dir, true)
+		if err != nil {
+			return nil, err
+		}
+		exports := make(map[string]string)
+		for _, dep := range deps {
+			exports[dep.ImportPath] = dep.Export
+		}
+		// Map import paths as written in the source, e.g. of
+		// vendored packages.
+		for _, dep := range deps {
+			for from, to := range dep.ImportMap {
+				if _, ok := exports[from]; !ok {
+					exports[from] = exports[to]
+				}
+			}
+		}
+		return importer.ForCompiler(token.NewFileSet(), "gc", func(path string) (io.ReadCloser, error) {
+			if f, ok := exports[path]; ok && len(f) > 0 {
+				return os.Open(f)
+			}
+			return nil, fmt.Errorf("no export data for %q", path)
+		}), nil
+	}
+}
+
+// goMod collects the requirements of several main modules into one go.mod.
+type goMod struct {
+	goVersion string
+
+	// require maps module paths to their required version.
+	require map[string]string
+
+	// replace maps module paths to their replacement, either a directory
+	// or a "path version" pair.
+	replace map[string]string
+
+	// sums are the lines of the main modules' go.sum files.
+	sums map[string]struct{}
+}
+
+// addMainModule adds the requirements of mod's build list.
+//
+// If two modules require different versions of the same module, the higher
+// version is used, as the go tool's minimal version selection would.
+func (g *goMod) addMainModule(env golang.Environ, mod *golang.Module) error {
+	if g.require == nil {
+		g.require = make(map[string]string)
+		g.replace = make(map[string]string)
+		g.sums = make(map[string]struct{})
+	}
+
+	mods, err := env.ListModules(mod.Dir)
+	if err != nil {
+		return err
+	}
+	for _, m := range mods {
+		var replace string
+		switch {
+		case m.Main:
+			replace = m.Dir
+		case m.Replace == nil:
+		case len(m.Replace.Version) == 0:
+			replace = m.Replace.Dir
+		default:
+			replace = m.Replace.Path + " " + m.Replace.Version
+		}
+		if len(replace) > 0 {
+			if r, ok := g.replace[m.Path]; ok && r != replace {
+				return fmt.Errorf("module %s is replaced by both %s and %s", m.Path, r, replace)
+			}
+			g.replace[m.Path] = replace
+		}
+
+		version := m.Version
+		if len(version) == 0 {
+			version = localVersion
+		}
+		if v, ok := g.require[m.Path]; !ok || semverLess(v, version) {
+			g.require[m.Path] = version
+		}
+
+		if m.Main && semverLess("v"+g.goVersion, "v"+m.GoVersion) {
+			g.goVersion = m.GoVersion
+		}
+	}
+
+	sum, err := ioutil.ReadFile(filepath.Join(mod.Dir, "go.sum"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(sum), "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			g.sums[line] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// goMod returns the contents of the go.mod file.
+func (g *goMod) goMod() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "module %s\n", bbModulePath)
+	if len(g.goVersion) > 0 {
+		fmt.Fprintf(&b, "\ngo %s\n", g.goVersion)
+	}
+	if len(g.require) > 0 {
+		fmt.Fprintf(&b, "\nrequire (\n")
+		for _, p := range sortedKeys(g.require) {
+			fmt.Fprintf(&b, "\t%s %s\n", p, g.require[p])
+		}
+		fmt.Fprintf(&b, ")\n")
+	}
+	if len(g.replace) > 0 {
+		fmt.Fprintf(&b, "\nreplace (\n")
+		for _, p := range sortedKeys(g.replace) {
+			r := g.replace[p]
+			if filepath.IsAbs(r) {
+				r = strconv.Quote(r)
+			}
+			fmt.Fprintf(&b, "\t%s => %s\n", p, r)
+		}
+		fmt.Fprintf(&b, ")\n")
+	}
+	return b.Bytes()
+}
+
+// goSum returns the contents of the go.sum file.
+func (g *goMod) goSum() []byte {
+	var lines []string
+	for line := range g.sums {
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	var b bytes.Buffer
+	for _, line := range lines {
+		fmt.Fprintln(&b, line)
+	}
+	return b.Bytes()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// semverLess returns true if semantic version a is lower than b.
+//
+// Build metadata is ignored. Invalid versions sort before valid ones.
+func semverLess(a, b string) bool {
+	return semverCompare(a, b) < 0
+}
+
+func semverCompare(a, b string) int {
+	va, oka := parseSemver(a)
+	vb, okb := parseSemver(b)
+	switch {
+	case !oka && !okb:
+		return strings.Compare(a, b)
+	case !oka:
+		return -1
+	case !okb:
+		return 1
+	}
+	for i := range va.release {
+		if va.release[i] != vb.release[i] {
+			if va.release[i] < vb.release[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	// A version without pre-release is higher than one with.
+	switch {
+	case len(va.pre) == 0 && len(vb.pre) == 0:
+		return 0
+	case len(va.pre) == 0:
+		return 1
+	case len(vb.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(va.pre) && i < len(vb.pre); i++ {
+		if c := comparePrerelease(va.pre[i], vb.pre[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(va.pre) < len(vb.pre):
+		return -1
+	case len(va.pre) > len(vb.pre):
+		return 1
+	}
+	return 0
+}
+
+type semver struct {
+	release [3]uint64
+	pre     []string
+}
+
+// parseSemver parses "vMAJOR[.MINOR[.PATCH]][-PRERELEASE][+BUILD]".
+func parseSemver(v string) (semver, bool) {
+	var s semver
+	if !strings.HasPrefix(v, "v") {
+		return s, false
+	}
+	v = v[1:]
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		v = v[:i]
+	}
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		s.pre = strings.Split(v[i+1:], ".")
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) > 3 {
+		return s, false
+	}
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return s, false
+		}
+		s.release[i] = n
+	}
+	return s, true
+}
+
+// comparePrerelease compares pre-release identifiers. Numeric identifiers
+// are lower than alphanumeric ones.
+func comparePrerelease(a, b string) int {
+	na, erra := strconv.ParseUint(a, 10, 64)
+	nb, errb := strconv.ParseUint(b, 10, 64)
+	switch {
+	case erra == nil && errb == nil:
+		switch {
+		case na < nb:
+			return -1
+		case na > nb:
+			return 1
+		}
+		return 0
+	case erra == nil:
+		return -1
+	case errb == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
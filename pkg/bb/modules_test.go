@@ -0,0 +1,192 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bb
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/golang"
+)
+
+// writeTree creates files relative to dir.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// setenv sets an environment variable for the duration of a test.
+func setenv(t *testing.T, key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestBuildBusyboxModules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Module a has a command using a package from module b, which is
+	// replaced by a local directory. Module b has a command, too.
+	writeTree(t, dir, map[string]string{
+		"a/go.mod": "module example.com/a\n\ngo 1.13\n\nrequire example.com/b v1.0.0\n\nreplace example.com/b => ../b\n",
+		"a/cmds/hello/hello.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/b/greet"
+)
+
+var greeting = greet.Greeting()
+
+func main() {
+	fmt.Println(greeting)
+}
+`,
+		"b/go.mod": "module example.com/b\n\ngo 1.12\n",
+		"b/greet/greet.go": `package greet
+
+func Greeting() string {
+	return "hello from b"
+}
+`,
+		"b/cmds/bye/bye.go": `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("bye")
+}
+`,
+	})
+
+	defer setenv(t, "GO111MODULE", "on")()
+	defer setenv(t, "GOFLAGS", "-mod=mod")()
+	defer setenv(t, "GOPROXY", "off")()
+
+	env := golang.Default()
+	pkgs := []string{
+		filepath.Join(dir, "a", "cmds", "hello"),
+		filepath.Join(dir, "b", "cmds", "bye"),
+	}
+	bin := filepath.Join(dir, "bb")
+	cache := &Cache{Dir: filepath.Join(dir, "cache")}
+	if err := BuildBusyboxCached(env, pkgs, bin, cache, golang.BuildOpts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for cmd, want := range map[string]string{
+		"hello": "hello from b",
+		"bye":   "bye",
+	} {
+		o, err := exec.Command(bin, cmd).CombinedOutput()
+		if err != nil {
+			t.Fatalf("bb %s failed: %v %v", cmd, string(o), err)
+		}
+		if got := strings.TrimSpace(string(o)); got != want {
+			t.Errorf("bb %s = %q, want %q", cmd, got, want)
+		}
+	}
+}
+
+func TestBuildBusyboxMixed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTree(t, dir, map[string]string{
+		"a/go.mod":              "module example.com/a\n",
+		"a/cmds/hello/hello.go": "package main\n\nfunc main() {}\n",
+	})
+
+	defer setenv(t, "GO111MODULE", "auto")()
+
+	pkgs := []string{
+		filepath.Join(dir, "a", "cmds", "hello"),
+		"github.com/u-root/u-root/pkg/uroot/test/foo",
+	}
+	err = BuildBusybox(golang.Default(), pkgs, filepath.Join(dir, "bb"))
+	if err == nil || !strings.Contains(err.Error(), "both Go module packages") {
+		t.Errorf("BuildBusybox(%v) = %v, want mixed modules error", pkgs, err)
+	}
+}
+
+func TestGoMod(t *testing.T) {
+	g := goMod{
+		goVersion: "1.13",
+		require: map[string]string{
+			"example.com/b": "v1.2.0",
+			"example.com/a": localVersion,
+		},
+		replace: map[string]string{
+			"example.com/a": "/src/a",
+			"example.com/c": "example.com/d v1.0.0",
+		},
+	}
+	want := `module bb.u-root.com/bb
+
+go 1.13
+
+require (
+	example.com/a v0.0.0-00010101000000-000000000000
+	example.com/b v1.2.0
+)
+
+replace (
+	example.com/a => "/src/a"
+	example.com/c => example.com/d v1.0.0
+)
+`
+	if got := string(g.goMod()); got != want {
+		t.Errorf("goMod() = \n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSemverLess(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want bool
+	}{
+		{"v1.0.0", "v1.0.1", true},
+		{"v1.0.1", "v1.0.0", false},
+		{"v1.2.0", "v1.10.0", true},
+		{"v1.0.0", "v1.0.0", false},
+		{"v1.0.0-rc.1", "v1.0.0", true},
+		{"v1.0.0", "v1.0.0-rc.1", false},
+		{"v1.0.0-rc.2", "v1.0.0-rc.10", true},
+		{"v1.0.0-1", "v1.0.0-alpha", true},
+		{"v0.0.0-20190101000000-abcdef", "v0.0.0-20190201000000-abcdef", true},
+		{"v1.0.0+meta", "v1.0.0", false},
+		{"v1.13", "v1.12", false},
+		{"", "v1.0.0", true},
+	} {
+		if got := semverLess(tt.a, tt.b); got != tt.want {
+			t.Errorf("semverLess(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
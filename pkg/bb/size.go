@@ -0,0 +1,287 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bb
+
+import (
+	"debug/elf"
+	"debug/gosym"
+	"fmt"
+	"go/build"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/u-root/u-root/pkg/golang"
+)
+
+// PackageSizes returns the size of the machine code of each Go package in the
+// ELF binary at binaryPath, by import path.
+//
+// Sizes are read from the Go symbol table in the .gopclntab section, which
+// stripped binaries keep. Data such as strings and type information is not
+// attributed to packages. Code that belongs to no package, such as assembly
+// entry points and generated type equality functions, is attributed to "".
+func PackageSizes(binaryPath string) (map[string]uint64, error) {
+	f, err := elf.Open(binaryPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pclntab := f.Section(".gopclntab")
+	text := f.Section(".text")
+	if pclntab == nil || text == nil {
+		return nil, fmt.Errorf("%s has no Go symbol table", binaryPath)
+	}
+	data, err := pclntab.Data()
+	if err != nil {
+		return nil, fmt.Errorf("reading Go symbol table of %s: %v", binaryPath, err)
+	}
+	table, err := gosym.NewTable(nil, gosym.NewLineTable(data, text.Addr))
+	if err != nil {
+		return nil, fmt.Errorf("parsing Go symbol table of %s: %v", binaryPath, err)
+	}
+
+	sizes := make(map[string]uint64)
+	for _, fn := range table.Funcs {
+		var pkg string
+		// Assembly and C functions such as _rt0_amd64_linux
+		// have no package.
+		if strings.Contains(fn.Name, ".") {
+			pkg = symbolPackage(fn.PackageName())
+		}
+		sizes[pkg] += fn.End - fn.Entry
+	}
+	return sizes, nil
+}
+
+// symbolPackage returns the import path of the package name of a symbol.
+//
+// The linker escapes dots in the last element of import paths, such as in
+// the ".bb" directory of rewritten packages, sometimes twice.
+func symbolPackage(name string) string {
+	for strings.Contains(name, "%") {
+		u, err := url.PathUnescape(name)
+		if err != nil || u == name {
+			break
+		}
+		name = u
+	}
+	return name
+}
+
+// CommandSize is the code size attributed to one command of a busybox.
+type CommandSize struct {
+	Name       string `json:"name"`
+	ImportPath string `json:"import_path"`
+
+	// Size is the size of the command's own package.
+	Size uint64 `json:"size"`
+
+	// Exclusive is Size plus the size of the dependencies that no other
+	// command in the busybox uses. It is roughly what removing the
+	// command from the busybox saves.
+	Exclusive uint64 `json:"exclusive"`
+
+	// Total is Size plus the size of all of the command's dependencies.
+	Total uint64 `json:"total"`
+}
+
+// PackageSize is the code size of one package of a busybox.
+type PackageSize struct {
+	ImportPath string `json:"import_path"`
+	Size       uint64 `json:"size"`
+
+	// Commands are the names of the commands that depend on the package.
+	// It is empty for packages that only the busybox itself uses, and
+	// for code that belongs to no package.
+	Commands []string `json:"commands,omitempty"`
+}
+
+// SizeReport attributes the code size of a busybox to its commands and their
+// dependencies.
+type SizeReport struct {
+	// FileSize is the size of the busybox binary, and TextSize the size
+	// of all of its code.
+	FileSize uint64 `json:"file_size"`
+	TextSize uint64 `json:"text_size"`
+
+	// Commands are sorted by Exclusive size, largest first.
+	Commands []CommandSize `json:"commands"`
+
+	// Packages are sorted by size, largest first.
+	Packages []PackageSize `json:"packages"`
+}
+
+// NewSizeReport attributes the code of the busybox at binaryPath, built from
+// the commands pkgs with BuildBusybox in env, to the commands and their
+// dependencies.
+func NewSizeReport(env golang.Environ, pkgs []string, binaryPath string) (*SizeReport, error) {
+	buildPkgs, err := commandPackages(env, pkgs)
+	if err != nil {
+		return nil, err
+	}
+	deps, err := commandDeps(env, buildPkgs)
+	if err != nil {
+		return nil, err
+	}
+	sizes, err := PackageSizes(binaryPath)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(binaryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &SizeReport{
+		FileSize: uint64(fi.Size()),
+		Commands: []CommandSize{},
+		Packages: []PackageSize{},
+	}
+	for _, size := range sizes {
+		r.TextSize += size
+	}
+
+	// The rewritten package of a command is the command's own package.
+	rewritten := make(map[string]string)
+	users := make(map[string][]string)
+	for _, p := range buildPkgs {
+		name := path.Base(p.ImportPath)
+		rewritten[gopathImportPath(p)] = p.ImportPath
+		rewritten[moduleImportPath(p)] = p.ImportPath
+		users[p.ImportPath] = append(users[p.ImportPath], name)
+		for _, dep := range deps[p.ImportPath] {
+			users[dep] = append(users[dep], name)
+		}
+	}
+	pkgSizes := make(map[string]uint64)
+	for pkg, size := range sizes {
+		if orig, ok := rewritten[pkg]; ok {
+			pkg = orig
+		}
+		pkgSizes[pkg] += size
+	}
+
+	for pkg, size := range pkgSizes {
+		r.Packages = append(r.Packages, PackageSize{
+			ImportPath: pkg,
+			Size:       size,
+			Commands:   users[pkg],
+		})
+	}
+	for _, p := range buildPkgs {
+		c := CommandSize{
+			Name:       path.Base(p.ImportPath),
+			ImportPath: p.ImportPath,
+			Size:       pkgSizes[p.ImportPath],
+		}
+		c.Exclusive, c.Total = c.Size, c.Size
+		for _, dep := range deps[p.ImportPath] {
+			c.Total += pkgSizes[dep]
+			if len(users[dep]) == 1 {
+				c.Exclusive += pkgSizes[dep]
+			}
+		}
+		r.Commands = append(r.Commands, c)
+	}
+
+	sort.Slice(r.Packages, func(i, j int) bool {
+		if r.Packages[i].Size != r.Packages[j].Size {
+			return r.Packages[i].Size > r.Packages[j].Size
+		}
+		return r.Packages[i].ImportPath < r.Packages[j].ImportPath
+	})
+	sort.Slice(r.Commands, func(i, j int) bool {
+		if r.Commands[i].Exclusive != r.Commands[j].Exclusive {
+			return r.Commands[i].Exclusive > r.Commands[j].Exclusive
+		}
+		return r.Commands[i].Name < r.Commands[j].Name
+	})
+	return r, nil
+}
+
+// commandDeps returns the transitive dependencies of each of pkgs, by import
+// path.
+func commandDeps(env golang.Environ, pkgs []*build.Package) (map[string][]string, error) {
+	modules, err := inModules(env, pkgs)
+	if err != nil {
+		return nil, err
+	}
+
+	var lps []*golang.ListPackage
+	if modules {
+		// Commands may be part of different modules.
+		for _, p := range pkgs {
+			lp, err := env.ListPackages(p.Dir, ".")
+			if err != nil {
+				return nil, err
+			}
+			lps = append(lps, lp...)
+		}
+	} else {
+		var importPaths []string
+		for _, p := range pkgs {
+			importPaths = append(importPaths, p.ImportPath)
+		}
+		if lps, err = env.ListPackages("", importPaths...); err != nil {
+			return nil, err
+		}
+	}
+
+	deps := make(map[string][]string)
+	for _, lp := range lps {
+		deps[lp.ImportPath] = lp.Deps
+	}
+	return deps, nil
+}
+
+func kib(size uint64) string {
+	return fmt.Sprintf("%.1f", float64(size)/1024)
+}
+
+// WriteTo writes r as two tables to w: the size of each command, and the
+// size of each package. Sizes are in KiB.
+func (r *SizeReport) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "busybox: %s KiB, %s KiB of code\n\n", kib(r.FileSize), kib(r.TextSize))
+
+	tw := tabwriter.NewWriter(&b, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "command\town\texclusive\ttotal\t\n")
+	for _, c := range r.Commands {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t\n", c.Name, kib(c.Size), kib(c.Exclusive), kib(c.Total))
+	}
+	tw.Flush()
+	b.WriteString("\n")
+
+	tw = tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "size\tpackage\tused by\n")
+	for _, p := range r.Packages {
+		name := p.ImportPath
+		if name == "" {
+			name = "(no package)"
+		}
+		var usedBy string
+		switch n := len(p.Commands); {
+		case n == 0:
+			usedBy = "bb"
+		case n == len(r.Commands) && n > 1:
+			usedBy = "all commands"
+		case n <= 3:
+			usedBy = strings.Join(p.Commands, ", ")
+		default:
+			usedBy = fmt.Sprintf("%d commands", n)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", kib(p.Size), name, usedBy)
+	}
+	tw.Flush()
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
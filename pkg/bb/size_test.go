@@ -0,0 +1,108 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/golang"
+)
+
+func TestSymbolPackage(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		want string
+	}{
+		{"fmt", "fmt"},
+		{"github.com/u-root/u-root/cmds/core/ls/%2ebb", "github.com/u-root/u-root/cmds/core/ls/.bb"},
+		{"github.com/u-root/u-root/cmds/core/ls/%252ebb", "github.com/u-root/u-root/cmds/core/ls/.bb"},
+		{"gopkg.in/yaml%2ev2", "gopkg.in/yaml.v2"},
+		{"", ""},
+	} {
+		if got := symbolPackage(tt.name); got != tt.want {
+			t.Errorf("symbolPackage(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewSizeReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	env := golang.Default()
+	pkgs := []string{
+		"github.com/u-root/u-root/cmds/core/ls",
+		"github.com/u-root/u-root/pkg/uroot/test/foo",
+	}
+	bin := filepath.Join(dir, "bb")
+	if err := BuildBusybox(env, pkgs, bin); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewSizeReport(env, pkgs, bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.TextSize == 0 || r.TextSize > r.FileSize {
+		t.Errorf("text size = %d, file size = %d, want 0 < text size <= file size", r.TextSize, r.FileSize)
+	}
+
+	commands := make(map[string]CommandSize)
+	for _, c := range r.Commands {
+		commands[c.Name] = c
+	}
+	ls, ok := commands["ls"]
+	if !ok || len(commands) != 2 {
+		t.Fatalf("commands = %v, want ls and foo", r.Commands)
+	}
+	if ls.Size == 0 || ls.Exclusive <= ls.Size || ls.Total <= ls.Exclusive {
+		t.Errorf("ls = %+v, want 0 < size < exclusive < total", ls)
+	}
+
+	packages := make(map[string]PackageSize)
+	for _, p := range r.Packages {
+		packages[p.ImportPath] = p
+	}
+	for pkg, want := range map[string][]string{
+		"github.com/u-root/u-root/cmds/core/ls":                  {"ls"},
+		"github.com/u-root/u-root/pkg/ls":                        {"ls"},
+		"github.com/u-root/u-root/vendor/github.com/spf13/pflag": {"ls"},
+		"fmt":                                    {"ls", "foo"},
+		"github.com/u-root/u-root/pkg/bb/bbmain": nil,
+	} {
+		p, ok := packages[pkg]
+		if !ok || p.Size == 0 {
+			t.Errorf("report has no code for package %q", pkg)
+			continue
+		}
+		if !reflect.DeepEqual(p.Commands, want) {
+			t.Errorf("package %q is used by %v, want %v", pkg, p.Commands, want)
+		}
+	}
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(b.String(), "\n") {
+		if f := strings.Fields(line); len(f) > 1 {
+			lines[strings.Join(f[1:], " ")] = true
+		}
+	}
+	for _, s := range []string{"own exclusive total", "github.com/u-root/u-root/pkg/ls ls", "fmt all commands"} {
+		if !lines[s] {
+			t.Errorf("report does not contain a line with %q:\n%s", s, b.String())
+		}
+	}
+}
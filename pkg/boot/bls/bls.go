@@ -0,0 +1,292 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package bls finds BootLoaderSpec boot entries.
+//
+// See https://systemd.io/BOOT_LOADER_SPECIFICATION. Type #1 entries are
+// the loader/entries/*.conf files Fedora, RHEL and systemd-boot use. Type #2
+// entries are unified kernel images, EFI programs in EFI/Linux that contain
+// the kernel, initramfs and command line in PE sections.
+package bls
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/boot/grub"
+	"github.com/u-root/u-root/pkg/boot/vboot"
+	"github.com/u-root/u-root/pkg/storage"
+	"github.com/u-root/u-root/pkg/uio"
+)
+
+// Entry is a BootLoaderSpec boot entry.
+type Entry struct {
+	// ID is the file name of the entry without .conf or .efi.
+	ID string
+
+	Title     string
+	Version   string
+	MachineID string
+	SortKey   string
+
+	// Linux is the path of the kernel, or of the unified kernel image.
+	Linux string
+
+	// Initrds are the paths of the initramfs images, which are
+	// concatenated.
+	Initrds []string
+
+	// Options is the kernel command line.
+	Options string
+
+	// Devicetree is the path of the device tree, if any.
+	Devicetree string
+
+	// Unified is set if Linux is a unified kernel image.
+	Unified bool
+
+	// kernel and initrd are the sections of the unified kernel image.
+	kernel, initrd section
+}
+
+// section is a part of a file.
+type section struct {
+	offset, size int64
+}
+
+func (e *Entry) String() string {
+	if e.Title == "" {
+		return e.ID
+	}
+	return e.Title
+}
+
+// OSImage returns the image that boots e. Unless it is a unified kernel
+// image, its signatures are the detached signatures next to the files, see
+// package vboot; several initrds are not signed.
+func (e *Entry) OSImage() *boot.LinuxImage {
+	if e.Unified {
+		f := uio.NewLazyFile(e.Linux)
+		img := &boot.LinuxImage{
+			Kernel:  io.NewSectionReader(f, e.kernel.offset, e.kernel.size),
+			Cmdline: e.Options,
+		}
+		if e.initrd.size > 0 {
+			img.Initrd = io.NewSectionReader(f, e.initrd.offset, e.initrd.size)
+		}
+		return img
+	}
+	img := &boot.LinuxImage{
+		Kernel:     uio.NewLazyFile(e.Linux),
+		Initrd:     initrd(e.Initrds),
+		Cmdline:    e.Options,
+		KernelSig:  vboot.Detached(e.Linux),
+		CmdlineSig: vboot.DetachedCmdline(e.Linux),
+	}
+	if len(e.Initrds) == 1 {
+		img.InitrdSig = vboot.Detached(e.Initrds[0])
+	}
+	return img
+}
+
+// initrd returns the concatenation of the files in paths.
+func initrd(paths []string) io.ReaderAt {
+	switch len(paths) {
+	case 0:
+		return nil
+	case 1:
+		return uio.NewLazyFile(paths[0])
+	}
+	return uio.NewLazyOpenerAt(func() (io.ReaderAt, error) {
+		var b bytes.Buffer
+		for _, p := range paths {
+			d, err := ioutil.ReadFile(p)
+			if err != nil {
+				return nil, err
+			}
+			b.Write(d)
+		}
+		return bytes.NewReader(b.Bytes()), nil
+	})
+}
+
+// architectures maps GOARCH to the names of the architecture key.
+var architectures = map[string]string{
+	"386":     "ia32",
+	"amd64":   "x64",
+	"arm":     "arm",
+	"arm64":   "aa64",
+	"riscv64": "riscv64",
+}
+
+// grubenvLocations are where Fedora and RHEL keep the GRUB environment
+// block, whose variables, like $kernelopts, the options of their entries
+// refer to.
+var grubenvLocations = []string{
+	"grub2/grubenv",
+	"boot/grub2/grubenv",
+	"grub/grubenv",
+	"boot/grub/grubenv",
+}
+
+// ScanMountpoints returns the entries on the file systems mountpoints,
+// e.g. the partitions from storage.GetBlockStats mounted with
+// storage.Mount, in the order of the specification: newest first.
+func ScanMountpoints(mountpoints []storage.Mountpoint) []*Entry {
+	var entries []*Entry
+	for _, mp := range mountpoints {
+		es, err := ScanDir(mp.Path)
+		if err != nil {
+			log.Printf("bls: %s: %v", mp.Path, err)
+			continue
+		}
+		entries = append(entries, es...)
+	}
+	Sort(entries)
+	return entries
+}
+
+// ScanDir returns the entries of the boot partition mounted at dir, that
+// is the ESP, an XBOOTLDR partition or a root file system with /boot.
+func ScanDir(dir string) ([]*Entry, error) {
+	var env map[string]string
+	for _, l := range grubenvLocations {
+		var err error
+		if env, err = grub.ParseEnvFile(filepath.Join(dir, l)); err == nil {
+			break
+		}
+	}
+
+	var entries []*Entry
+	for _, root := range []string{dir, filepath.Join(dir, "boot")} {
+		confs, err := filepath.Glob(filepath.Join(root, "loader/entries/*.conf"))
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range confs {
+			e, err := parseEntry(root, p, env)
+			if err != nil {
+				log.Printf("bls: %v", err)
+				continue
+			}
+			if e != nil {
+				entries = append(entries, e)
+			}
+		}
+
+		ukis, err := filepath.Glob(filepath.Join(root, "EFI/Linux/*.efi"))
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range ukis {
+			e, err := parseUnified(p)
+			if err != nil {
+				log.Printf("bls: %v", err)
+				continue
+			}
+			entries = append(entries, e)
+		}
+	}
+	Sort(entries)
+	return entries, nil
+}
+
+// parseEntry parses the Type #1 entry at path. Paths in the entry are
+// relative to root. Variables in the options are expanded from the GRUB
+// environment env, like GRUB's blscfg command does.
+//
+// parseEntry returns nil if the entry does not boot Linux on this
+// architecture.
+func parseEntry(root, path string, env map[string]string) (*Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	e := &Entry{ID: strings.TrimSuffix(filepath.Base(path), ".conf")}
+	var options []string
+	var arch string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			continue
+		}
+		key, value := line[:i], strings.TrimSpace(line[i:])
+		switch key {
+		case "title":
+			e.Title = value
+		case "version":
+			e.Version = value
+		case "machine-id":
+			e.MachineID = value
+		case "sort-key":
+			e.SortKey = value
+		case "linux":
+			e.Linux = filepath.Join(root, filepath.Clean("/"+value))
+		case "initrd":
+			e.Initrds = append(e.Initrds, filepath.Join(root, filepath.Clean("/"+value)))
+		case "devicetree":
+			e.Devicetree = filepath.Join(root, filepath.Clean("/"+value))
+		case "options":
+			options = append(options, value)
+		case "architecture":
+			arch = value
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if arch != "" && !strings.EqualFold(arch, architectures[runtime.GOARCH]) {
+		return nil, nil
+	}
+	if e.Linux == "" {
+		// E.g. an efi entry for an EFI program.
+		return nil, nil
+	}
+	e.Options = strings.Join(strings.Fields(os.Expand(strings.Join(options, " "), func(name string) string {
+		return env[name]
+	})), " ")
+	return e, nil
+}
+
+// Sort sorts entries in the order of the specification: entries with a
+// sort-key come first, ordered by sort-key and machine-id, and then by
+// version, newest first. Entries without a sort-key follow, ordered by ID,
+// newest first.
+func Sort(entries []*Entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if (a.SortKey == "") != (b.SortKey == "") {
+			return a.SortKey != ""
+		}
+		if a.SortKey != "" {
+			if a.SortKey != b.SortKey {
+				return a.SortKey < b.SortKey
+			}
+			if a.MachineID != b.MachineID {
+				return a.MachineID < b.MachineID
+			}
+			if c := CompareVersions(a.Version, b.Version); c != 0 {
+				return c > 0
+			}
+		}
+		return CompareVersions(a.ID, b.ID) > 0
+	})
+}
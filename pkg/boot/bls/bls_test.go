@@ -0,0 +1,141 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bls
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/u-root/u-root/pkg/storage"
+	"github.com/u-root/u-root/pkg/uio"
+)
+
+const machineID = "2f6a2c1bb1ce4a5f8d8f3f2b4e0a9c71"
+
+var (
+	fedoraEntries = []*Entry{
+		{
+			ID:      machineID + "-5.0.16-300.fc30.x86_64",
+			Title:   "Fedora (5.0.16-300.fc30.x86_64) 30 (Workstation Edition)",
+			Version: "5.0.16-300.fc30.x86_64",
+			Linux:   "testdata/fedora-30/vmlinuz-5.0.16-300.fc30.x86_64",
+			Initrds: []string{"testdata/fedora-30/initramfs-5.0.16-300.fc30.x86_64.img"},
+			Options: "root=/dev/mapper/fedora-root ro resume=/dev/mapper/fedora-swap rd.lvm.lv=fedora/root rd.lvm.lv=fedora/swap rhgb quiet",
+		},
+		{
+			ID:      machineID + "-5.0.9-301.fc30.x86_64",
+			Title:   "Fedora (5.0.9-301.fc30.x86_64) 30 (Workstation Edition)",
+			Version: "5.0.9-301.fc30.x86_64",
+			Linux:   "testdata/fedora-30/vmlinuz-5.0.9-301.fc30.x86_64",
+			Initrds: []string{"testdata/fedora-30/initramfs-5.0.9-301.fc30.x86_64.img"},
+			Options: "root=/dev/mapper/fedora-root ro resume=/dev/mapper/fedora-swap rd.lvm.lv=fedora/root rd.lvm.lv=fedora/swap rhgb quiet",
+		},
+		{
+			ID:      machineID + "-0-rescue",
+			Title:   "Fedora (0-rescue-" + machineID + ") 30 (Workstation Edition)",
+			Version: "0-rescue-" + machineID,
+			Linux:   "testdata/fedora-30/vmlinuz-0-rescue-" + machineID,
+			Initrds: []string{"testdata/fedora-30/initramfs-0-rescue-" + machineID + ".img"},
+			Options: "root=/dev/mapper/fedora-root ro resume=/dev/mapper/fedora-swap rd.lvm.lv=fedora/root rd.lvm.lv=fedora/swap rhgb quiet",
+		},
+	}
+
+	espEntries = []*Entry{
+		{
+			ID:      "arch",
+			Title:   "Arch Linux",
+			Version: "5.1.2-arch1-1",
+			SortKey: "arch",
+			Linux:   "testdata/esp/vmlinuz-linux",
+			Initrds: []string{"testdata/esp/intel-ucode.img", "testdata/esp/initramfs-linux.img"},
+			Options: "root=PARTUUID=6f2a7d3e-9c41-4e8b-a0d5-1b7c3e9f2a64 rw quiet",
+		},
+		{
+			ID:      "arch-fallback",
+			Title:   "Arch Linux (fallback initramfs)",
+			Version: "5.1.2-arch1-1~fallback",
+			SortKey: "arch",
+			Linux:   "testdata/esp/vmlinuz-linux",
+			Initrds: []string{"testdata/esp/initramfs-linux-fallback.img"},
+			Options: "root=PARTUUID=6f2a7d3e-9c41-4e8b-a0d5-1b7c3e9f2a64 rw",
+		},
+		{
+			ID:      "linux-5.1.3-arch1-1",
+			Title:   "Arch Linux",
+			Version: "5.1.3-arch1-1",
+			Linux:   "testdata/esp/EFI/Linux/linux-5.1.3-arch1-1.efi",
+			Options: "root=PARTUUID=6f2a7d3e-9c41-4e8b-a0d5-1b7c3e9f2a64 rw quiet",
+			Unified: true,
+			kernel:  section{offset: 0x800, size: 60},
+			initrd:  section{offset: 0xa00, size: 30},
+		},
+	}
+)
+
+func TestScanDir(t *testing.T) {
+	for _, tt := range []struct {
+		dir  string
+		want []*Entry
+	}{
+		{"testdata/fedora-30", fedoraEntries},
+		{"testdata/esp", espEntries},
+	} {
+		t.Run(tt.dir, func(t *testing.T) {
+			got, err := ScanDir(tt.dir)
+			if err != nil {
+				t.Fatalf("ScanDir(%s) = %v", tt.dir, err)
+			}
+			if diff := deep.Equal(got, tt.want); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestScanMountpoints(t *testing.T) {
+	got := ScanMountpoints([]storage.Mountpoint{
+		{DeviceName: "/dev/sda2", Path: "testdata/fedora-30"},
+		{DeviceName: "/dev/sda1", Path: "testdata/esp"},
+		{DeviceName: "/dev/sda3", Path: "testdata/nonexistent"},
+	})
+	// Entries with a sort-key first, then by ID, newest first.
+	var want []string
+	for _, e := range append(espEntries[:2:2], fedoraEntries[0], fedoraEntries[1], fedoraEntries[2], espEntries[2]) {
+		want = append(want, e.ID)
+	}
+	var ids []string
+	for _, e := range got {
+		ids = append(ids, e.ID)
+	}
+	if diff := deep.Equal(ids, want); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestUnifiedOSImage(t *testing.T) {
+	img := espEntries[2].OSImage()
+	for _, tt := range []struct {
+		name string
+		r    io.ReaderAt
+		want string
+	}{
+		{"kernel", img.Kernel, strings.Repeat("KERNEL", 10)},
+		{"initrd", img.Initrd, strings.Repeat("INITRD", 5)},
+	} {
+		b, err := ioutil.ReadAll(uio.Reader(tt.r))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, b, tt.want)
+		}
+	}
+	if img.Cmdline != espEntries[2].Options {
+		t.Errorf("Cmdline = %q, want %q", img.Cmdline, espEntries[2].Options)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bls
+
+import (
+	"bufio"
+	"bytes"
+	"debug/pe"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// parseUnified parses the unified kernel image at path. The kernel,
+// initramfs and command line are in its .linux, .initrd and .cmdline
+// sections; its title and version come from the os-release file in the
+// .osrel section and the kernel version in the .uname section.
+func parseUnified(path string) (*Entry, error) {
+	f, err := pe.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	defer f.Close()
+
+	e := &Entry{
+		ID:      strings.TrimSuffix(filepath.Base(path), ".efi"),
+		Linux:   path,
+		Unified: true,
+	}
+	linux := f.Section(".linux")
+	if linux == nil {
+		return nil, fmt.Errorf("%s: no .linux section", path)
+	}
+	e.kernel = sectionOf(linux)
+	if s := f.Section(".initrd"); s != nil {
+		e.initrd = sectionOf(s)
+	}
+	if s := f.Section(".cmdline"); s != nil {
+		b, err := sectionData(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		e.Options = strings.Join(strings.Fields(string(b)), " ")
+	}
+	if s := f.Section(".osrel"); s != nil {
+		b, err := sectionData(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		osrel := parseOSRelease(b)
+		e.Title = osrel["PRETTY_NAME"]
+		if e.Title == "" {
+			e.Title = osrel["NAME"]
+		}
+		e.Version = osrel["VERSION_ID"]
+		if e.Version == "" {
+			e.Version = osrel["BUILD_ID"]
+		}
+	}
+	if s := f.Section(".uname"); s != nil {
+		b, err := sectionData(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		e.Version = strings.TrimSpace(string(b))
+	}
+	return e, nil
+}
+
+// sectionOf returns where the contents of s are in the file. The raw size
+// of s is rounded up to the file alignment; the virtual size is not.
+func sectionOf(s *pe.Section) section {
+	size := s.Size
+	if s.VirtualSize != 0 && s.VirtualSize < size {
+		size = s.VirtualSize
+	}
+	return section{offset: int64(s.Offset), size: int64(size)}
+}
+
+func sectionData(s *pe.Section) ([]byte, error) {
+	b, err := s.Data()
+	if err != nil {
+		return nil, err
+	}
+	b = b[:sectionOf(s).size]
+	return bytes.TrimRight(b, "\x00"), nil
+}
+
+// parseOSRelease parses an os-release(5) file.
+func parseOSRelease(b []byte) map[string]string {
+	vars := make(map[string]string)
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := kv[1]
+		if v, err := strconv.Unquote(value); err == nil {
+			value = v
+		} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		}
+		vars[kv[0]] = value
+	}
+	return vars
+}
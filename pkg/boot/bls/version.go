@@ -0,0 +1,111 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bls
+
+import (
+	"strings"
+)
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isVersionChar(c byte) bool {
+	return isDigit(c) || isAlpha(c) || strings.IndexByte("~-^.", c) >= 0
+}
+
+// rank orders the characters that separate version segments. A version
+// that ends is newer than one that continues with ~ or -, and older than
+// one that continues with ^, ., or another segment.
+func rank(s string) int {
+	if s == "" {
+		return 2
+	}
+	switch s[0] {
+	case '~':
+		return 0
+	case '-':
+		return 1
+	case '^':
+		return 3
+	case '.':
+		return 4
+	}
+	return 5
+}
+
+// CompareVersions compares the versions a and b like systemd-boot does,
+// see https://uapi-group.org/specifications/specs/version_format_specification.
+// It returns -1 if a is older than b, 1 if a is newer and 0 if they are the
+// same version.
+//
+// Versions are split into numeric and alphabetic segments. Numbers compare
+// numerically and are newer than letters, which compare alphabetically.
+// 1.0~rc1 is older than 1.0, which is older than 1.0^1 and 1.0.1.
+func CompareVersions(a, b string) int {
+	for {
+		a = strings.TrimLeftFunc(a, func(r rune) bool { return r > 0x7f || !isVersionChar(byte(r)) })
+		b = strings.TrimLeftFunc(b, func(r rune) bool { return r > 0x7f || !isVersionChar(byte(r)) })
+
+		ra, rb := rank(a), rank(b)
+		if ra != rb {
+			return cmp(ra, rb)
+		}
+		if a == "" {
+			return 0
+		}
+		if ra < 5 {
+			// The same separator.
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		// Two segments.
+		var sa, sb string
+		switch {
+		case isDigit(a[0]) && isDigit(b[0]):
+			sa, a = span(a, isDigit)
+			sb, b = span(b, isDigit)
+			sa = strings.TrimLeft(sa, "0")
+			sb = strings.TrimLeft(sb, "0")
+			if len(sa) != len(sb) {
+				return cmp(len(sa), len(sb))
+			}
+		case isDigit(a[0]):
+			return 1
+		case isDigit(b[0]):
+			return -1
+		default:
+			sa, a = span(a, isAlpha)
+			sb, b = span(b, isAlpha)
+		}
+		if c := strings.Compare(sa, sb); c != 0 {
+			return c
+		}
+	}
+}
+
+// span splits s after its prefix of characters that match f.
+func span(s string, f func(byte) bool) (string, string) {
+	i := 0
+	for i < len(s) && f(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func cmp(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
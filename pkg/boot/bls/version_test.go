@@ -0,0 +1,42 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bls
+
+import (
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"1", "1", 0},
+		{"1.0", "1.0", 0},
+		{"1", "2", -1},
+		{"2", "10", -1},
+		{"010", "10", 0},
+		{"1.0", "1.0.1", -1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0", "1.0^1", -1},
+		{"1.0^1", "1.0.1", -1},
+		{"1.0-1", "1.0", -1},
+		{"1a", "1b", -1},
+		{"1a", "1", 1},
+		{"5.0.16-300.fc30.x86_64", "5.0.9-301.fc30.x86_64", 1},
+		{"4.19.0-rc1", "4.19.0", -1},
+		{"abc", "abd", -1},
+		{"1+0", "10", -1},
+	} {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := CompareVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package bootstate persists boot-once and boot counting state across
+// reboots, for A/B updates.
+//
+// Before booting an entry, the boot commands count the attempt. The booted
+// OS confirms that it came up, which resets the count; an entry that was
+// booted too often without confirmation is skipped. An entry can also be
+// booted once, on the next boot only, falling back to the usual order
+// after that.
+//
+// The state is JSON in a file, in a directory on a persistent partition
+// that both the boot commands and the booted OS can reach, such as the ESP.
+// The BootState variable of the read-write VPD is also loaded, so firmware
+// tools can set it, but Linux cannot write the VPD.
+package bootstate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/u-root/u-root/pkg/vpd"
+	"golang.org/x/sys/unix"
+)
+
+// Key is the name of the state in a Backend.
+const Key = "BootState"
+
+// Backend stores variables.
+type Backend interface {
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte) error
+}
+
+// VPDBackend stores variables in the read-write VPD. The sysfs VPD interface
+// is read-only, so Set always fails.
+type VPDBackend struct{}
+
+// Get implements Backend.
+func (VPDBackend) Get(key string) ([]byte, error) {
+	return vpd.Get(key, false)
+}
+
+// Set implements Backend.
+func (VPDBackend) Set(key string, value []byte) error {
+	return vpd.Set(key, value, false)
+}
+
+// FileBackend stores variables in files in a directory.
+type FileBackend struct {
+	Dir string
+}
+
+// Get implements Backend.
+func (f FileBackend) Get(key string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(f.Dir, key))
+}
+
+// Set implements Backend. The file is replaced atomically, so a power
+// loss leaves either the old or the new value.
+func (f FileBackend) Set(key string, value []byte) error {
+	if err := os.MkdirAll(f.Dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(f.Dir, key)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(f.Dir, key))
+}
+
+// State is the persistent boot state.
+type State struct {
+	// Generation increases with every save. The backend with the highest
+	// generation holds the current state.
+	Generation uint64 `json:"generation"`
+
+	// NextBoot is the entry to boot on the next boot only.
+	NextBoot string `json:"next_boot,omitempty"`
+
+	// Booting is the entry that was booted last.
+	Booting string `json:"booting,omitempty"`
+
+	// Attempts counts the boots of each entry since the booted OS last
+	// confirmed that it came up.
+	Attempts map[string]int `json:"attempts,omitempty"`
+}
+
+// TakeNextBoot returns the entry to boot once and clears it.
+func (s *State) TakeNextBoot() (string, bool) {
+	next := s.NextBoot
+	s.NextBoot = ""
+	return next, next != ""
+}
+
+// StartBoot counts a boot of entry and remembers it as the one booting.
+func (s *State) StartBoot(entry string) {
+	if s.Attempts == nil {
+		s.Attempts = make(map[string]int)
+	}
+	s.Attempts[entry]++
+	s.Booting = entry
+}
+
+// Exhausted returns whether entry was booted max times without
+// confirmation. Entries are never exhausted if max is 0.
+func (s *State) Exhausted(entry string, max int) bool {
+	return max > 0 && s.Attempts[entry] >= max
+}
+
+// Confirm resets the count of entry, or of the entry booted last if entry
+// is empty.
+func (s *State) Confirm(entry string) {
+	if entry == "" {
+		entry = s.Booting
+	}
+	delete(s.Attempts, entry)
+}
+
+// Store loads and saves the state in backends.
+type Store struct {
+	// Backends are tried in order when saving.
+	Backends []Backend
+}
+
+// New returns a store that saves the state in files in dir, and also loads
+// it from the VPD.
+//
+// dir must be on a persistent file system, or the state would be lost at
+// reboot: New fails if dir is empty or on a tmpfs or ramfs, such as the
+// initramfs.
+func New(dir string) (*Store, error) {
+	if dir == "" {
+		return nil, errors.New("no boot state directory given; it must be on a persistent partition, such as the ESP")
+	}
+	if err := persistent(dir); err != nil {
+		return nil, err
+	}
+	return &Store{Backends: []Backend{FileBackend{Dir: dir}, VPDBackend{}}}, nil
+}
+
+// persistent returns an error if dir, or the closest of its parents that
+// exists, is not on a persistent file system.
+func persistent(dir string) error {
+	p, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	for {
+		var st unix.Statfs_t
+		err := unix.Statfs(p, &st)
+		if os.IsNotExist(err) && p != filepath.Dir(p) {
+			p = filepath.Dir(p)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("boot state directory %s: %v", dir, err)
+		}
+		switch uint32(st.Type) {
+		case unix.TMPFS_MAGIC, unix.RAMFS_MAGIC:
+			return fmt.Errorf("boot state directory %s is not on a persistent file system; the state would be lost at reboot", dir)
+		}
+		return nil
+	}
+}
+
+// Load returns the state with the highest generation of all backends, or
+// an empty state if there is none.
+func (s *Store) Load() *State {
+	state := &State{}
+	for _, b := range s.Backends {
+		v, err := b.Get(Key)
+		if err != nil {
+			continue
+		}
+		var st State
+		if err := json.Unmarshal(v, &st); err != nil {
+			log.Printf("Ignoring invalid boot state in %T: %v", b, err)
+			continue
+		}
+		// Saved states have generation 1 or later.
+		if st.Generation > state.Generation {
+			state = &st
+		}
+	}
+	return state
+}
+
+// Save saves the state in the first backend that can store it, with the next
+// generation.
+func (s *Store) Save(state *State) error {
+	state.Generation++
+	v, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, b := range s.Backends {
+		err := b.Set(Key, v)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return fmt.Errorf("could not save boot state: %v", errs)
+}
+
+// Update loads the state, calls f with it and saves it.
+func (s *Store) Update(f func(*State)) error {
+	state := s.Load()
+	f(state)
+	return s.Save(state)
+}
@@ -0,0 +1,174 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bootstate
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+	"golang.org/x/sys/unix"
+)
+
+// readOnly is a backend that cannot be written, like the VPD from Linux.
+type readOnly map[string]string
+
+func (r readOnly) Get(key string) ([]byte, error) {
+	v, ok := r[key]
+	if !ok {
+		return nil, errors.New("no such key")
+	}
+	return []byte(v), nil
+}
+
+func (r readOnly) Set(key string, value []byte) error {
+	return errors.New("permission denied")
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "bootstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestFileBackend(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	f := FileBackend{Dir: filepath.Join(dir, "state")}
+
+	if _, err := f.Get(Key); !os.IsNotExist(err) {
+		t.Errorf("Get() = %v, want not exist", err)
+	}
+	for _, v := range []string{"first", "second"} {
+		if err := f.Set(Key, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := f.Get(Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != v {
+			t.Errorf("Get() = %q, want %q", got, v)
+		}
+	}
+	// No temporary files are left.
+	files, err := ioutil.ReadDir(f.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("%d files in %s, want 1", len(files), f.Dir)
+	}
+}
+
+func TestStoreFallback(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	vpd := readOnly{Key: `{"generation": 3, "next_boot": "B", "attempts": {"A": 1}}`}
+	s := &Store{Backends: []Backend{vpd, FileBackend{Dir: dir}}}
+
+	// The VPD state is the only one.
+	want := &State{Generation: 3, NextBoot: "B", Attempts: map[string]int{"A": 1}}
+	if diff := deep.Equal(s.Load(), want); diff != nil {
+		t.Fatal(diff)
+	}
+
+	// The VPD cannot be written, so the state goes to the file, which
+	// now has the highest generation.
+	if err := s.Update(func(st *State) {
+		next, _ := st.TakeNextBoot()
+		st.StartBoot(next)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want = &State{Generation: 4, Booting: "B", Attempts: map[string]int{"A": 1, "B": 1}}
+	if diff := deep.Equal(s.Load(), want); diff != nil {
+		t.Error(diff)
+	}
+
+	// An older state in the file is ignored.
+	vpd[Key] = `{"generation": 7}`
+	if diff := deep.Equal(s.Load(), &State{Generation: 7}); diff != nil {
+		t.Error(diff)
+	}
+
+	// So is an invalid one.
+	vpd[Key] = `{"generation": "many"}`
+	if diff := deep.Equal(s.Load(), want); diff != nil {
+		t.Error(diff)
+	}
+
+	if err := (&Store{Backends: []Backend{vpd}}).Save(&State{}); err == nil {
+		t.Error("Save() to a read-only backend succeeded")
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(""); err == nil {
+		t.Error("New(\"\") succeeded")
+	}
+
+	var st unix.Statfs_t
+	if err := unix.Statfs("/dev/shm", &st); err != nil || uint32(st.Type) != unix.TMPFS_MAGIC {
+		t.Skip("/dev/shm is not a tmpfs")
+	}
+	// The state directory does not need to exist yet.
+	for _, dir := range []string{"/dev/shm", "/dev/shm/no/such/bootstate"} {
+		if _, err := New(dir); err == nil || !strings.Contains(err.Error(), "not on a persistent file system") {
+			t.Errorf("New(%q) = %v, want not persistent", dir, err)
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	var s State
+	if _, ok := s.TakeNextBoot(); ok {
+		t.Error("TakeNextBoot() of an empty state returned an entry")
+	}
+	s.NextBoot = "B"
+	if next, ok := s.TakeNextBoot(); !ok || next != "B" {
+		t.Errorf("TakeNextBoot() = %q, %t, want B, true", next, ok)
+	}
+	if _, ok := s.TakeNextBoot(); ok {
+		t.Error("TakeNextBoot() returned an entry twice")
+	}
+
+	s.StartBoot("A")
+	s.StartBoot("A")
+	s.StartBoot("B")
+	for _, tt := range []struct {
+		entry string
+		max   int
+		want  bool
+	}{
+		{"A", 0, false},
+		{"A", 2, true},
+		{"A", 3, false},
+		{"B", 1, true},
+		{"C", 1, false},
+	} {
+		if got := s.Exhausted(tt.entry, tt.max); got != tt.want {
+			t.Errorf("Exhausted(%q, %d) = %t, want %t", tt.entry, tt.max, got, tt.want)
+		}
+	}
+
+	// B booted last.
+	s.Confirm("")
+	s.Confirm("C")
+	if diff := deep.Equal(s.Attempts, map[string]int{"A": 2}); diff != nil {
+		t.Error(diff)
+	}
+	s.Confirm("A")
+	if s.Exhausted("A", 1) {
+		t.Error("A is exhausted after Confirm")
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/boot/vboot"
+)
+
+// arches are U-Boot's names of the Go architectures.
+var arches = map[string]string{
+	"386":     "x86",
+	"amd64":   "x86_64",
+	"arm":     "arm",
+	"arm64":   "arm64",
+	"riscv64": "riscv",
+}
+
+// BootImage boots a configuration of a FIT image. It implements
+// boot.OSImage.
+type BootImage struct {
+	FIT *FIT
+
+	// Config is the name of the configuration to boot. If it is empty,
+	// the default configuration boots.
+	Config string
+
+	Cmdline string
+
+	// Verify, if not nil, says whether the configuration and the hashes
+	// of its images must be signed, by the signatures of the
+	// configuration in the FIT image, and the command line by
+	// CmdlineSig.
+	Verify     *vboot.Policy
+	CmdlineSig io.ReaderAt
+}
+
+var _ boot.Verifiable = &BootImage{}
+
+// String implements fmt.Stringer.
+func (bi *BootImage) String() string {
+	c, err := bi.FIT.Config(bi.Config)
+	if err != nil {
+		return fmt.Sprintf("FIT(%s: %v)", bi.Config, err)
+	}
+	return fmt.Sprintf("FIT(%s, cmdline: %s)", c, bi.Cmdline)
+}
+
+// SetVerifyPolicy implements boot.Verifiable.
+func (bi *BootImage) SetVerifyPolicy(p *vboot.Policy) {
+	bi.Verify = p
+}
+
+// verifying says whether bi verifies signatures.
+func (bi *BootImage) verifying() bool {
+	return bi.Verify != nil && bi.Verify.Mode != vboot.Off
+}
+
+// image returns the data of the image called name of type typ, after
+// verifying its hashes. An image without hashes is unverified, which Verify
+// decides about.
+func (bi *BootImage) image(name, typ string) ([]byte, error) {
+	img, err := bi.FIT.Image(name)
+	if err != nil {
+		return nil, err
+	}
+	if img.Type != typ && !(typ == "kernel" && img.Type == "kernel_noload") {
+		return nil, fmt.Errorf("image %s is a %s, want %s", name, img.Type, typ)
+	}
+	if arch, ok := arches[runtime.GOARCH]; ok && img.Arch != "" && img.Arch != arch {
+		return nil, fmt.Errorf("image %s is for %s, not %s", name, img.Arch, arch)
+	}
+	if err := img.VerifyHashes(); err == ErrNoHashes {
+		if bi.verifying() {
+			if err := bi.Verify.Decide("image "+name, err); err != nil {
+				return nil, err
+			}
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return img.Data()
+}
+
+// LinuxImage returns the kernel, ramdisk and device tree of the
+// configuration to boot, after verifying them.
+func (bi *BootImage) LinuxImage() (*boot.LinuxImage, error) {
+	c, err := bi.FIT.Config(bi.Config)
+	if err != nil {
+		return nil, err
+	}
+	if c.Kernel == "" {
+		return nil, fmt.Errorf("configuration %s has no kernel", c.Name)
+	}
+	if bi.verifying() {
+		if err := bi.Verify.Decide("configuration "+c.Name, bi.FIT.VerifyConfig(c, bi.Verify.Keys)); err != nil {
+			return nil, err
+		}
+	}
+	li := &boot.LinuxImage{Cmdline: bi.Cmdline}
+	kernel, err := bi.image(c.Kernel, "kernel")
+	if err != nil {
+		return nil, err
+	}
+	li.Kernel = bytes.NewReader(kernel)
+	if c.Ramdisk != "" {
+		ramdisk, err := bi.image(c.Ramdisk, "ramdisk")
+		if err != nil {
+			return nil, err
+		}
+		li.Initrd = bytes.NewReader(ramdisk)
+	}
+	if c.FDT != "" {
+		fdt, err := bi.image(c.FDT, "flat_dt")
+		if err != nil {
+			return nil, err
+		}
+		li.DTB = bytes.NewReader(fdt)
+	}
+	if err := bi.Verify.CheckString("command line", bi.Cmdline, bi.CmdlineSig); err != nil {
+		return nil, err
+	}
+	return li, nil
+}
+
+// Load implements boot.OSImage.Load. It verifies the hashes of the images
+// of the configuration, and its signature if Verify says so, before loading
+// them.
+func (bi *BootImage) Load(verbose bool) error {
+	li, err := bi.LinuxImage()
+	if err != nil {
+		return err
+	}
+	return li.Load(verbose)
+}
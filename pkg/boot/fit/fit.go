@@ -0,0 +1,342 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package fit reads U-Boot Flattened Image Tree (FIT) images.
+//
+// A FIT image (.itb) is a device tree whose /images node holds the kernels,
+// ramdisks and device trees, each with hashes of its data, and whose
+// /configurations node combines them into bootable configurations, signed
+// together with the hashes of their images:
+//
+//	/ {
+//		images {
+//			kernel-1 {
+//				data = /incbin/("Image.gz");
+//				type = "kernel";
+//				arch = "arm64";
+//				os = "linux";
+//				compression = "gzip";
+//				hash-1 { algo = "sha256"; value = <...>; };
+//			};
+//			fdt-1 { ... };
+//		};
+//		configurations {
+//			default = "conf-1";
+//			conf-1 {
+//				kernel = "kernel-1";
+//				fdt = "fdt-1";
+//				signature-1 {
+//					algo = "sha256,rsa2048";
+//					hashed-nodes = "/", "/configurations/conf-1",
+//						"/images/kernel-1", "/images/kernel-1/hash-1", ...;
+//					hashed-strings = <0 ...>;
+//					value = <...>;
+//				};
+//			};
+//		};
+//	};
+//
+// See doc/uImage.FIT in the U-Boot source. Image data may be embedded or
+// external, following the tree. A configuration signature signs the nodes
+// of the tree it lists, but not the image data, so an image is only as
+// trustworthy as its signed hashes. Signatures of single images are not
+// supported: they would let images of different configurations be booted
+// together.
+package fit
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/boot/vboot"
+	"github.com/u-root/u-root/pkg/dt"
+	"github.com/u-root/u-root/pkg/uio"
+)
+
+// FIT is a parsed FIT image.
+type FIT struct {
+	Description string
+
+	// DefaultConfig is the name of the configuration to boot when none
+	// is chosen. It may be empty.
+	DefaultConfig string
+
+	Images  []*Image
+	Configs []*Config
+
+	// fdt is the device tree, which configuration signatures sign parts
+	// of.
+	fdt []byte
+}
+
+// Config combines images into a bootable configuration.
+type Config struct {
+	Name        string
+	Description string
+
+	// Kernel, Ramdisk and FDT name the images of the configuration.
+	// Ramdisk and FDT may be empty.
+	Kernel  string
+	Ramdisk string
+	FDT     string
+
+	// Signatures sign the configuration and its images, see
+	// VerifyConfig.
+	Signatures []Signature
+}
+
+// Hash is the hash of the data of an image.
+type Hash struct {
+	// Name is the name of the hash node, e.g. hash-1.
+	Name string
+
+	// Algo is sha1, sha256, sha384, sha512, md5 or crc32.
+	Algo  string
+	Value []byte
+}
+
+// Signature is a signature of a configuration.
+type Signature struct {
+	// Algo is the hash and the key algorithm, e.g. sha256,rsa2048 or
+	// sha256,ecdsa256.
+	Algo string
+
+	// Padding is the RSA padding, pkcs-1.5 or pss. Empty means
+	// pkcs-1.5.
+	Padding string
+
+	// KeyNameHint names the key that made the signature.
+	KeyNameHint string
+
+	// HashedNodes are the paths of the nodes that the signature signs,
+	// and HashedStrings the number of bytes of the strings block of the
+	// device tree it signs.
+	HashedNodes   []string
+	HashedStrings uint32
+
+	Value []byte
+}
+
+// Parse reads the FIT image r.
+func Parse(r io.ReaderAt) (*FIT, error) {
+	b, err := uio.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	fdt, err := dt.ReadFDT(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("not a FIT image: %v", err)
+	}
+	root := fdt.RootNode
+	images, ok := root.LookChild("images")
+	if !ok {
+		return nil, fmt.Errorf("not a FIT image: no /images node")
+	}
+
+	if int(fdt.Header.TotalSize) > len(b) {
+		return nil, fmt.Errorf("device tree is %d bytes, but the file only %d", fdt.Header.TotalSize, len(b))
+	}
+
+	// External data follows the tree, which is padded to 4 bytes.
+	var external []byte
+	if end := align4(int(fdt.Header.TotalSize)); end <= len(b) {
+		external = b[end:]
+	}
+
+	f := &FIT{
+		Description: stringProp(root, "description"),
+		fdt:         b[:fdt.Header.TotalSize],
+	}
+	for _, n := range images.Children {
+		img, err := parseImage(n, b, external)
+		if err != nil {
+			return nil, err
+		}
+		f.Images = append(f.Images, img)
+	}
+	if configs, ok := root.LookChild("configurations"); ok {
+		f.DefaultConfig = stringProp(configs, "default")
+		for _, n := range configs.Children {
+			f.Configs = append(f.Configs, parseConfig(n))
+		}
+	}
+	return f, nil
+}
+
+func parseConfig(n *dt.Node) *Config {
+	c := &Config{
+		Name:        n.Name,
+		Description: stringProp(n, "description"),
+		Kernel:      stringProp(n, "kernel"),
+		Ramdisk:     stringProp(n, "ramdisk"),
+		Signatures:  signatures(n),
+	}
+	// fdt may list overlays after the base device tree.
+	if p, ok := n.LookProperty("fdt"); ok {
+		if fdts, err := p.AsStringList(); err == nil && len(fdts) > 0 {
+			c.FDT = fdts[0]
+		}
+	}
+	return c
+}
+
+// Image returns the image called name.
+func (f *FIT) Image(name string) (*Image, error) {
+	for _, img := range f.Images {
+		if img.Name == name {
+			return img, nil
+		}
+	}
+	return nil, fmt.Errorf("no image %q", name)
+}
+
+// Config returns the configuration called name or, if name is empty, the
+// default configuration.
+func (f *FIT) Config(name string) (*Config, error) {
+	if name == "" {
+		name = f.DefaultConfig
+	}
+	if name == "" && len(f.Configs) == 1 {
+		return f.Configs[0], nil
+	}
+	for _, c := range f.Configs {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("no default configuration")
+	}
+	return nil, fmt.Errorf("no configuration %q", name)
+}
+
+// VerifyConfig returns nil if one of the signatures of c is a valid
+// signature by one of keys of the configuration, its images and their SHA
+// hashes. It returns vboot.ErrUnsigned if c has no signatures.
+//
+// The data of the images is not signed; VerifyHashes verifies it.
+func (f *FIT) VerifyConfig(c *Config, keys vboot.Keyring) error {
+	if len(c.Signatures) == 0 {
+		return vboot.ErrUnsigned
+	}
+	err := errors.New("no trusted keys")
+	for _, sig := range c.Signatures {
+		data, serr := f.signedConfig(c, &sig)
+		if serr != nil {
+			err = serr
+			continue
+		}
+		for _, key := range keys {
+			if err = sig.verify(key, data); err == nil {
+				return nil
+			}
+		}
+	}
+	return err
+}
+
+// signedConfig returns the data that sig signs, after checking that it
+// signs c and a SHA hash of each image of c.
+func (f *FIT) signedConfig(c *Config, sig *Signature) ([]byte, error) {
+	hashed := make(map[string]bool)
+	for _, n := range sig.HashedNodes {
+		hashed[n] = true
+	}
+	if n := "/configurations/" + c.Name; !hashed[n] {
+		return nil, fmt.Errorf("signature does not sign %s", n)
+	}
+	for _, name := range []string{c.Kernel, c.Ramdisk, c.FDT} {
+		if name == "" {
+			continue
+		}
+		img, err := f.Image(name)
+		if err != nil {
+			return nil, err
+		}
+		n := "/images/" + name
+		if !hashed[n] {
+			return nil, fmt.Errorf("signature does not sign %s", n)
+		}
+		signed := false
+		for _, h := range img.Hashes {
+			if _, algo, _ := newHash(h.Algo); algo != 0 && algo != crypto.MD5 && hashed[n+"/"+h.Name] {
+				signed = true
+			}
+		}
+		if !signed {
+			return nil, fmt.Errorf("signature signs no SHA hash of image %s", name)
+		}
+	}
+	return signedData(f.fdt, sig.HashedNodes, sig.HashedStrings)
+}
+
+func (c *Config) String() string {
+	var parts []string
+	for _, p := range []struct{ what, name string }{
+		{"kernel", c.Kernel},
+		{"ramdisk", c.Ramdisk},
+		{"fdt", c.FDT},
+	} {
+		if p.name != "" {
+			parts = append(parts, fmt.Sprintf("%s %s", p.what, p.name))
+		}
+	}
+	s := fmt.Sprintf("%s: %s", c.Name, strings.Join(parts, ", "))
+	if c.Description != "" {
+		s += fmt.Sprintf(" (%s)", c.Description)
+	}
+	return s
+}
+
+func signatures(n *dt.Node) []Signature {
+	var sigs []Signature
+	for _, c := range n.Children {
+		if !strings.HasPrefix(c.Name, "signature") {
+			continue
+		}
+		sig := Signature{
+			Algo:        stringProp(c, "algo"),
+			Padding:     stringProp(c, "padding"),
+			KeyNameHint: stringProp(c, "key-name-hint"),
+			Value:       bytesProp(c, "value"),
+		}
+		if p, ok := c.LookProperty("hashed-nodes"); ok {
+			sig.HashedNodes, _ = p.AsStringList()
+		}
+		// hashed-strings is the offset, always 0, and the size.
+		if b := bytesProp(c, "hashed-strings"); len(b) == 8 {
+			sig.HashedStrings = binary.BigEndian.Uint32(b[4:])
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs
+}
+
+func stringProp(n *dt.Node, name string) string {
+	p, ok := n.LookProperty(name)
+	if !ok {
+		return ""
+	}
+	s, err := p.AsString()
+	if err != nil {
+		return ""
+	}
+	return s
+}
+
+func bytesProp(n *dt.Node, name string) []byte {
+	if p, ok := n.LookProperty(name); ok {
+		return p.Value
+	}
+	return nil
+}
+
+func align4(x int) int {
+	return (x + 3) &^ 3
+}
@@ -0,0 +1,559 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/boot/vboot"
+	"github.com/u-root/u-root/pkg/dt"
+	"github.com/u-root/u-root/pkg/uio"
+)
+
+func str(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func node(name string, props map[string][]byte, children ...*dt.Node) *dt.Node {
+	n := &dt.Node{Name: name, Children: children}
+	for k, v := range props {
+		n.UpdateProperty(k, v)
+	}
+	return n
+}
+
+func gz(t *testing.T, b []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func write(t *testing.T, root *dt.Node, external []byte) []byte {
+	fdt := &dt.FDT{
+		Header:   dt.Header{Magic: dt.Magic, Version: 17, LastCompVersion: 16},
+		RootNode: root,
+	}
+	var b bytes.Buffer
+	if _, err := fdt.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	for b.Len()%4 != 0 {
+		b.WriteByte(0)
+	}
+	b.Write(external)
+	return b.Bytes()
+}
+
+var (
+	kernel  = []byte("arm64 Image kernel")
+	ramdisk = []byte("initramfs")
+	fdtData = []byte("device tree blob")
+)
+
+func sha256Hash(name string, data []byte) *dt.Node {
+	sum := sha256.Sum256(data)
+	return node(name, map[string][]byte{"algo": str("sha256"), "value": sum[:]})
+}
+
+// testFIT returns a FIT image with a gzipped kernel embedded, the ramdisk
+// at an offset into the external data, the device tree at a position in
+// the file, and two configurations.
+func testFIT(t *testing.T, kernelNode *dt.Node) []byte {
+	return signedFIT(t, kernelNode, nil, nil)
+}
+
+// signedFIT returns testFIT with the second configuration signed by key
+// over the hashed nodes. If key is nil, it is not signed.
+func signedFIT(t *testing.T, kernelNode *dt.Node, key *rsa.PrivateKey, hashed []string) []byte {
+	ramdiskNode := node("ramdisk-1", map[string][]byte{
+		"type":        str("ramdisk"),
+		"compression": str("none"),
+		"data-offset": u32(0),
+		"data-size":   u32(uint32(len(ramdisk))),
+	}, sha256Hash("hash-1", ramdisk))
+	fdtNode := node("fdt-1", map[string][]byte{
+		"type":          str("flat_dt"),
+		"data-position": u32(0),
+		"data-size":     u32(uint32(len(fdtData))),
+	}, sha256Hash("hash-1", fdtData))
+	conf2 := node("conf-2", map[string][]byte{
+		"description": str("with ramdisk"),
+		"kernel":      str("kernel-1"),
+		"ramdisk":     str("ramdisk-1"),
+		"fdt":         append(str("fdt-1"), str("overlay-1")...),
+	})
+	var sig *dt.Node
+	if key != nil {
+		var nodes []byte
+		for _, n := range hashed {
+			nodes = append(nodes, str(n)...)
+		}
+		// The value and the size of the strings are filled in once the
+		// layout of the tree is final.
+		sig = node("signature-1", map[string][]byte{
+			"algo":           str("sha256,rsa2048"),
+			"key-name-hint":  str("dev"),
+			"hashed-nodes":   nodes,
+			"hashed-strings": append(u32(0), u32(0)...),
+			"value":          make([]byte, key.Size()),
+		})
+		conf2.Children = append(conf2.Children, sig)
+	}
+	root := node("", map[string][]byte{"description": str("test FIT")},
+		node("images", nil, kernelNode, ramdiskNode, fdtNode),
+		node("configurations", map[string][]byte{"default": str("conf-2")},
+			node("conf-1", map[string][]byte{"kernel": str("kernel-1")}),
+			conf2))
+
+	// The device tree follows the ramdisk in the external data; its
+	// position depends on the size of the tree.
+	external := append(append([]byte{}, ramdisk...), fdtData...)
+	b := write(t, root, external)
+	fdtNode.UpdateProperty("data-position", u32(uint32(len(b)-len(fdtData))))
+	b = write(t, root, external)
+	if key == nil {
+		return b
+	}
+
+	strs := binary.BigEndian.Uint32(b[32:])
+	sig.UpdateProperty("hashed-strings", append(u32(0), u32(strs)...))
+	b = write(t, root, external)
+	data, err := signedData(b, hashed, strs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(data)
+	value, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig.UpdateProperty("value", value)
+	return write(t, root, external)
+}
+
+func kernelNode(t *testing.T, arch string) *dt.Node {
+	data := gz(t, kernel)
+	crc := crc32.ChecksumIEEE(data)
+	return node("kernel-1", map[string][]byte{
+		"description": str("Linux"),
+		"data":        data,
+		"type":        str("kernel"),
+		"arch":        str(arch),
+		"os":          str("linux"),
+		"compression": str("gzip"),
+	},
+		node("hash-1", map[string][]byte{"algo": str("crc32"), "value": u32(crc)}),
+		sha256Hash("hash-2", data))
+}
+
+// signedNodes are the nodes that U-Boot's mkimage signs for conf-2.
+var signedNodes = []string{
+	"/",
+	"/configurations/conf-2",
+	"/images/kernel-1",
+	"/images/kernel-1/hash-1",
+	"/images/kernel-1/hash-2",
+	"/images/ramdisk-1",
+	"/images/ramdisk-1/hash-1",
+	"/images/fdt-1",
+	"/images/fdt-1/hash-1",
+}
+
+func TestParse(t *testing.T) {
+	f, err := Parse(bytes.NewReader(testFIT(t, kernelNode(t, "arm64"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Description != "test FIT" || f.DefaultConfig != "conf-2" || len(f.Images) != 3 || len(f.Configs) != 2 {
+		t.Fatalf("Parse() = %+v", f)
+	}
+
+	c, err := f.Config("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "conf-2: kernel kernel-1, ramdisk ramdisk-1, fdt fdt-1 (with ramdisk)"; c.String() != want {
+		t.Errorf("Config() = %s, want %s", c, want)
+	}
+	if _, err := f.Config("conf-3"); err == nil {
+		t.Error("Config(conf-3) succeeded")
+	}
+
+	for name, want := range map[string][]byte{
+		"kernel-1":  kernel,
+		"ramdisk-1": ramdisk,
+		"fdt-1":     fdtData,
+	} {
+		img, err := f.Image(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := img.VerifyHashes(); err != nil {
+			t.Errorf("VerifyHashes() = %v", err)
+		}
+		got, err := img.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("Data() of %s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestVerifyHashes(t *testing.T) {
+	n := kernelNode(t, "arm64")
+	hash, _ := n.Children[0].LookProperty("value")
+	hash.Value = u32(0xdeadbeef)
+	f, err := Parse(bytes.NewReader(testFIT(t, n)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := f.Image("kernel-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := img.VerifyHashes(); err == nil {
+		t.Error("VerifyHashes() of a corrupt image succeeded")
+	}
+	if _, err := (&BootImage{FIT: f}).LinuxImage(); err == nil {
+		t.Error("LinuxImage() with a corrupt kernel succeeded")
+	}
+
+	img.Hashes = nil
+	if err := img.VerifyHashes(); err != ErrNoHashes {
+		t.Errorf("VerifyHashes() without hashes = %v, want %v", err, ErrNoHashes)
+	}
+}
+
+func TestData(t *testing.T) {
+	for _, tt := range []struct {
+		compression string
+		data        []byte
+		want        []byte
+		wantErr     bool
+	}{
+		{"", kernel, kernel, false},
+		{"none", kernel, kernel, false},
+		{"gzip", gz(t, kernel), kernel, false},
+		{"gzip", kernel, nil, true},
+		{"lzma", kernel, nil, true},
+	} {
+		img := &Image{Name: "kernel-1", Compression: tt.compression, data: tt.data}
+		got, err := img.Data()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Data() with %q = %v, want error %t", tt.compression, err, tt.wantErr)
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("Data() with %q = %q, want %q", tt.compression, got, tt.want)
+		}
+	}
+}
+
+// pad returns b with leading zeros to n bytes.
+func pad(b []byte, n int) []byte {
+	return append(make([]byte, n-len(b)), b...)
+}
+
+func TestVerifySignature(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("configuration")
+	digest := sha256.Sum256(data)
+
+	pkcs, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pss, err := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest[:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSig := append(pad(r.Bytes(), 32), pad(s.Bytes(), 32)...)
+
+	for _, tt := range []struct {
+		name    string
+		sig     Signature
+		key     crypto.PublicKey
+		wantErr bool
+	}{
+		{"pkcs-1.5", Signature{Algo: "sha256,rsa2048", Value: pkcs}, rsaKey.Public(), false},
+		{"pss", Signature{Algo: "sha256,rsa2048", Padding: "pss", Value: pss}, rsaKey.Public(), false},
+		{"ecdsa", Signature{Algo: "sha256,ecdsa256", Value: ecSig}, ecKey.Public(), false},
+		{"untrusted", Signature{Algo: "sha256,rsa2048", Value: pkcs}, otherKey.Public(), true},
+		{"wrong key type", Signature{Algo: "sha256,ecdsa256", Value: ecSig}, rsaKey.Public(), true},
+		{"wrong hash", Signature{Algo: "sha1,rsa2048", Value: pkcs}, rsaKey.Public(), true},
+		{"unsupported", Signature{Algo: "crc32,rsa2048", Value: pkcs}, rsaKey.Public(), true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.sig.verify(tt.key, data); (err != nil) != tt.wantErr {
+				t.Errorf("verify() = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignedData(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := signedFIT(t, kernelNode(t, "arm64"), key, signedNodes)
+	strs := binary.BigEndian.Uint32(b[32:])
+	data, err := signedData(b, signedNodes, strs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(ramdisk)
+	if !bytes.Contains(data, sum[:]) || !bytes.Contains(data, str("with ramdisk")) {
+		t.Error("signedData() does not contain the hashes and the configuration")
+	}
+	if bytes.Contains(data, gz(t, kernel)[10:]) {
+		t.Error("signedData() contains the kernel data")
+	}
+
+	// Nodes that are not hashed do not matter.
+	f, err := dt.ReadFDT(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs, _ := f.RootNode.LookChild("configurations")
+	conf1, _ := configs.LookChild("conf-1")
+	conf1.UpdateProperty("kernel", str("kernel-2"))
+	other, err := signedData(write(t, f.RootNode, nil), signedNodes, strs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, other) {
+		t.Error("signedData() changed with a node that is not hashed")
+	}
+
+	conf2, _ := configs.LookChild("conf-2")
+	conf2.UpdateProperty("kernel", str("kernel-2"))
+	if other, err = signedData(write(t, f.RootNode, nil), signedNodes, strs); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(data, other) {
+		t.Error("signedData() did not change with a hashed node")
+	}
+}
+
+func TestVerifyConfig(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	without := func(name string) []string {
+		var nodes []string
+		for _, n := range signedNodes {
+			if n != name {
+				nodes = append(nodes, n)
+			}
+		}
+		return nodes
+	}
+	for _, tt := range []struct {
+		name    string
+		hashed  []string
+		keys    vboot.Keyring
+		modify  func(b []byte) []byte
+		wantErr bool
+	}{
+		{name: "ok", hashed: signedNodes, keys: vboot.Keyring{key.Public()}},
+		{name: "untrusted", hashed: signedNodes, keys: vboot.Keyring{otherKey.Public()}, wantErr: true},
+		{name: "no keys", hashed: signedNodes, wantErr: true},
+		{name: "configuration not signed", hashed: without("/configurations/conf-2"), keys: vboot.Keyring{key.Public()}, wantErr: true},
+		{name: "image not signed", hashed: without("/images/fdt-1"), keys: vboot.Keyring{key.Public()}, wantErr: true},
+		// The CRC of the kernel is signed, but can be forged.
+		{name: "no SHA hash signed", hashed: without("/images/kernel-1/hash-2"), keys: vboot.Keyring{key.Public()}, wantErr: true},
+		{
+			name:   "changed configuration",
+			hashed: signedNodes,
+			keys:   vboot.Keyring{key.Public()},
+			modify: func(b []byte) []byte {
+				return bytes.Replace(b, str("with ramdisk"), str("with ramdis!"), 1)
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := signedFIT(t, kernelNode(t, "arm64"), key, tt.hashed)
+			if tt.modify != nil {
+				b = tt.modify(b)
+			}
+			f, err := Parse(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := f.Config("conf-2")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := f.VerifyConfig(c, tt.keys); (err != nil) != tt.wantErr {
+				t.Errorf("VerifyConfig() = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+
+	f, err := Parse(bytes.NewReader(testFIT(t, kernelNode(t, "arm64"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := f.Config("conf-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.VerifyConfig(c, vboot.Keyring{key.Public()}); err != vboot.ErrUnsigned {
+		t.Errorf("VerifyConfig() of an unsigned configuration = %v, want %v", err, vboot.ErrUnsigned)
+	}
+}
+
+func TestLinuxImage(t *testing.T) {
+	arch, ok := arches[runtime.GOARCH]
+	if !ok {
+		t.Skipf("no FIT architecture for %s", runtime.GOARCH)
+	}
+	f, err := Parse(bytes.NewReader(testFIT(t, kernelNode(t, arch))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bi := &BootImage{FIT: f, Cmdline: "console=ttyAMA0"}
+	li, err := bi.LinuxImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		what string
+		got  []byte
+		want []byte
+	}{
+		{"kernel", read(t, li.Kernel), kernel},
+		{"initrd", read(t, li.Initrd), ramdisk},
+		{"device tree", read(t, li.DTB), fdtData},
+	} {
+		if !bytes.Equal(tt.got, tt.want) {
+			t.Errorf("%s = %q, want %q", tt.what, tt.got, tt.want)
+		}
+	}
+	if li.Cmdline != bi.Cmdline {
+		t.Errorf("Cmdline = %q, want %q", li.Cmdline, bi.Cmdline)
+	}
+
+	bi.Config = "conf-1"
+	if li, err = bi.LinuxImage(); err != nil {
+		t.Fatal(err)
+	}
+	if li.Initrd != nil || li.DTB != nil {
+		t.Errorf("conf-1 = %v, want only a kernel", li)
+	}
+
+	// The configurations are not signed.
+	bi.Verify = &vboot.Policy{Mode: vboot.Warn}
+	if _, err := bi.LinuxImage(); err != nil {
+		t.Errorf("LinuxImage() with warnings = %v, want nil", err)
+	}
+	bi.Verify.Mode = vboot.Enforce
+	if _, err := bi.LinuxImage(); err == nil {
+		t.Error("LinuxImage() of an unsigned configuration succeeded")
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bi.FIT, err = Parse(bytes.NewReader(signedFIT(t, kernelNode(t, arch), key, signedNodes))); err != nil {
+		t.Fatal(err)
+	}
+	bi.Config = ""
+	bi.Verify = &vboot.Policy{Mode: vboot.Enforce, Keys: vboot.Keyring{key.Public()}}
+	// Only the command line is not signed.
+	if _, err := bi.LinuxImage(); err == nil || !strings.HasPrefix(err.Error(), "command line") {
+		t.Errorf("LinuxImage() of a signed configuration = %v, want a command line error", err)
+	}
+
+	// An image without hashes is not verified.
+	n := kernelNode(t, arch)
+	n.Children = nil
+	if bi.FIT, err = Parse(bytes.NewReader(testFIT(t, n))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bi.LinuxImage(); err == nil {
+		t.Error("LinuxImage() of a kernel without hashes succeeded")
+	}
+	bi.Verify.Mode = vboot.Warn
+	if _, err := bi.LinuxImage(); err != nil {
+		t.Errorf("LinuxImage() of a kernel without hashes with warnings = %v, want nil", err)
+	}
+	bi.Verify = nil
+	if _, err := bi.LinuxImage(); err != nil {
+		t.Errorf("LinuxImage() of a kernel without hashes = %v, want nil", err)
+	}
+}
+
+func TestLinuxImageArch(t *testing.T) {
+	f, err := Parse(bytes.NewReader(testFIT(t, kernelNode(t, "mips"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&BootImage{FIT: f}).LinuxImage(); err == nil {
+		t.Error("LinuxImage() of a mips kernel succeeded")
+	}
+}
+
+func read(t *testing.T, r interface {
+	ReadAt([]byte, int64) (int, error)
+}) []byte {
+	if r == nil {
+		return nil
+	}
+	b, err := uio.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
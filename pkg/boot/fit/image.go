@@ -0,0 +1,219 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fit
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/dt"
+)
+
+// Image is a kernel, ramdisk, device tree or other image in a FIT image.
+type Image struct {
+	Name        string
+	Description string
+
+	// Type is e.g. kernel, ramdisk or flat_dt.
+	Type string
+
+	// Arch and OS are U-Boot's names, e.g. arm64 and linux.
+	Arch string
+	OS   string
+
+	// Compression is none, gzip or bzip2. Other compressions cannot be
+	// decompressed.
+	Compression string
+
+	Hashes []Hash
+
+	// data is the data as stored, possibly compressed.
+	data []byte
+}
+
+func parseImage(n *dt.Node, fit, external []byte) (*Image, error) {
+	img := &Image{
+		Name:        n.Name,
+		Description: stringProp(n, "description"),
+		Type:        stringProp(n, "type"),
+		Arch:        stringProp(n, "arch"),
+		OS:          stringProp(n, "os"),
+		Compression: stringProp(n, "compression"),
+	}
+	for _, c := range n.Children {
+		if strings.HasPrefix(c.Name, "hash") {
+			img.Hashes = append(img.Hashes, Hash{
+				Name:  c.Name,
+				Algo:  stringProp(c, "algo"),
+				Value: bytesProp(c, "value"),
+			})
+		}
+	}
+
+	// The data is embedded, at an offset into the external data, or at
+	// a position in the file.
+	if p, ok := n.LookProperty("data"); ok {
+		img.data = p.Value
+		return img, nil
+	}
+	size, err := u32Prop(n, "data-size")
+	if err != nil {
+		return nil, fmt.Errorf("image %s has no data: %v", n.Name, err)
+	}
+	data, start := external, uint32(0)
+	if off, err := u32Prop(n, "data-offset"); err == nil {
+		start = off
+	} else if pos, err := u32Prop(n, "data-position"); err == nil {
+		data, start = fit, pos
+	} else {
+		return nil, fmt.Errorf("image %s has neither data, data-offset nor data-position", n.Name)
+	}
+	if uint64(start)+uint64(size) > uint64(len(data)) {
+		return nil, fmt.Errorf("data of image %s (%d bytes at %#x) is beyond the end of the file", n.Name, size, start)
+	}
+	img.data = data[start : start+size]
+	return img, nil
+}
+
+func u32Prop(n *dt.Node, name string) (uint32, error) {
+	p, ok := n.LookProperty(name)
+	if !ok {
+		return 0, fmt.Errorf("no property %q", name)
+	}
+	return p.AsU32()
+}
+
+func (img *Image) String() string {
+	s := fmt.Sprintf("%s: %s", img.Name, img.Type)
+	if img.Description != "" {
+		s += fmt.Sprintf(" (%s)", img.Description)
+	}
+	return s
+}
+
+// newHash returns the hash called algo, if FIT supports it, and its
+// crypto.Hash for signatures, if it has one.
+func newHash(algo string) (hash.Hash, crypto.Hash, error) {
+	switch algo {
+	case "md5":
+		return md5.New(), crypto.MD5, nil
+	case "sha1":
+		return sha1.New(), crypto.SHA1, nil
+	case "sha256":
+		return sha256.New(), crypto.SHA256, nil
+	case "sha384":
+		return sha512.New384(), crypto.SHA384, nil
+	case "sha512":
+		return sha512.New(), crypto.SHA512, nil
+	case "crc32":
+		// U-Boot stores the CRC big endian, which is how hash/crc32
+		// returns it.
+		return crc32.NewIEEE(), 0, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported hash algorithm %q", algo)
+}
+
+// ErrNoHashes is returned by VerifyHashes for an image without hashes, whose
+// data cannot be verified.
+var ErrNoHashes = errors.New("no hashes")
+
+// VerifyHashes returns an error unless all hashes of the image match its
+// data. It returns ErrNoHashes if the image has no hashes.
+func (img *Image) VerifyHashes() error {
+	if len(img.Hashes) == 0 {
+		return ErrNoHashes
+	}
+	for _, h := range img.Hashes {
+		hh, _, err := newHash(h.Algo)
+		if err != nil {
+			return fmt.Errorf("image %s: %v", img.Name, err)
+		}
+		hh.Write(img.data)
+		if sum := hh.Sum(nil); !bytes.Equal(sum, h.Value) {
+			return fmt.Errorf("image %s: %s hash is %x, want %x", img.Name, h.Algo, sum, h.Value)
+		}
+	}
+	return nil
+}
+
+// verify returns nil if s is a signature of data by key.
+func (s *Signature) verify(key crypto.PublicKey, data []byte) error {
+	algos := strings.SplitN(s.Algo, ",", 2)
+	if len(algos) != 2 {
+		return fmt.Errorf("invalid signature algorithm %q", s.Algo)
+	}
+	h, hashAlgo, err := newHash(algos[0])
+	if err != nil || hashAlgo == 0 {
+		return fmt.Errorf("unsupported signature algorithm %q", s.Algo)
+	}
+	h.Write(data)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(algos[1], "rsa") {
+			return fmt.Errorf("%s signature cannot be verified with an RSA key", s.Algo)
+		}
+		if s.Padding == "pss" {
+			return rsa.VerifyPSS(k, hashAlgo, digest, s.Value, nil)
+		}
+		return rsa.VerifyPKCS1v15(k, hashAlgo, digest, s.Value)
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(algos[1], "ecdsa") {
+			return fmt.Errorf("%s signature cannot be verified with an ECDSA key", s.Algo)
+		}
+		// The signature is r and s, each as long as the key.
+		n := len(s.Value) / 2
+		if n == 0 || len(s.Value)%2 != 0 {
+			return errors.New("invalid ECDSA signature")
+		}
+		r, ss := new(big.Int).SetBytes(s.Value[:n]), new(big.Int).SetBytes(s.Value[n:])
+		if !ecdsa.Verify(k, digest, r, ss) {
+			return errors.New("ECDSA verification failed")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported public key type %T", key)
+}
+
+// Data returns the decompressed data of the image.
+func (img *Image) Data() ([]byte, error) {
+	var r io.Reader
+	switch img.Compression {
+	case "", "none":
+		return img.data, nil
+	case "gzip":
+		z, err := gzip.NewReader(bytes.NewReader(img.data))
+		if err != nil {
+			return nil, fmt.Errorf("image %s: %v", img.Name, err)
+		}
+		r = z
+	case "bzip2":
+		r = bzip2.NewReader(bytes.NewReader(img.data))
+	default:
+		return nil, fmt.Errorf("image %s: unsupported compression %q", img.Name, img.Compression)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("image %s: %v", img.Name, err)
+	}
+	return b, nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Structure block tokens of a flattened device tree.
+const (
+	fdtBeginNode = 1
+	fdtEndNode   = 2
+	fdtProp      = 3
+	fdtNop       = 4
+	fdtEnd       = 9
+)
+
+// excludedProps are the properties that configuration signatures do not
+// cover: the image data, which the signed hashes cover instead.
+var excludedProps = []string{"data", "data-size", "data-position", "data-offset"}
+
+// signedData returns the parts of the flattened device tree fdt that a
+// configuration signature over the nodes hashed and the first size bytes
+// of the strings block signs.
+//
+// This is U-Boot's fdt_find_regions: the properties of the hashed nodes,
+// except for excludedProps, the begin and end tokens of their parents and
+// direct children, and the end token of the structure block, in the order
+// of the tree.
+func signedData(fdt []byte, hashed []string, size uint32) ([]byte, error) {
+	if len(fdt) < 40 {
+		return nil, fmt.Errorf("device tree header is truncated")
+	}
+	u32 := binary.BigEndian.Uint32
+	offStruct, offStrings := u32(fdt[8:]), u32(fdt[12:])
+	sizeStrings, sizeStruct := u32(fdt[32:]), u32(fdt[36:])
+	if uint64(offStruct)+uint64(sizeStruct) > uint64(len(fdt)) ||
+		uint64(offStrings)+uint64(sizeStrings) > uint64(len(fdt)) || size > sizeStrings {
+		return nil, fmt.Errorf("device tree blocks are beyond the end of the tree")
+	}
+	st := fdt[offStruct : offStruct+sizeStruct]
+	strs := fdt[offStrings : offStrings+sizeStrings]
+
+	include := make(map[string]bool)
+	for _, n := range hashed {
+		include[n] = true
+	}
+	excluded := make(map[string]bool)
+	for _, p := range excludedProps {
+		excluded[p] = true
+	}
+
+	var (
+		data  bytes.Buffer
+		path  []string
+		stack []int
+		want  int
+		start = -1
+	)
+	for off := 0; ; {
+		if off+4 > len(st) {
+			return nil, fmt.Errorf("device tree structure block is truncated")
+		}
+		tag := u32(st[off:])
+		next := off + 4
+		stopAt := next
+		in := false
+		switch tag {
+		case fdtProp:
+			if next+8 > len(st) {
+				return nil, fmt.Errorf("device tree structure block is truncated")
+			}
+			n, nameOff := u32(st[next:]), u32(st[next+4:])
+			next += 8 + align4(int(n))
+			name, err := cstring(strs, int(nameOff))
+			if err != nil {
+				return nil, err
+			}
+			in = want >= 2 && !excluded[name]
+			stopAt = off
+		case fdtNop:
+			in = want >= 2
+			stopAt = off
+		case fdtBeginNode:
+			name, err := cstring(st, next)
+			if err != nil {
+				return nil, err
+			}
+			next += align4(len(name) + 1)
+			path = append(path, name)
+			stack = append(stack, want)
+			if want == 1 {
+				stopAt = off
+			}
+			switch {
+			case include[nodePath(path)]:
+				want = 2
+			case want > 0:
+				want--
+			default:
+				stopAt = off
+			}
+			in = want > 0
+		case fdtEndNode:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("device tree structure block has an unmatched end of node")
+			}
+			in = want > 0
+			want = stack[len(stack)-1]
+			stack, path = stack[:len(stack)-1], path[:len(path)-1]
+		case fdtEnd:
+			in = true
+		default:
+			return nil, fmt.Errorf("invalid device tree token %#x at %#x", tag, off)
+		}
+		if next > len(st) {
+			return nil, fmt.Errorf("device tree structure block is truncated")
+		}
+
+		if in && start == -1 {
+			start = off
+		}
+		if !in && start != -1 {
+			data.Write(st[start:stopAt])
+			start = -1
+		}
+		if tag == fdtEnd {
+			data.Write(st[start:next])
+			break
+		}
+		off = next
+	}
+	data.Write(strs[:size])
+	return data.Bytes(), nil
+}
+
+// nodePath returns the path of the node with the names path from the root
+// node, whose name is empty.
+func nodePath(path []string) string {
+	if len(path) <= 1 {
+		return "/"
+	}
+	p := ""
+	for _, name := range path[1:] {
+		p += "/" + name
+	}
+	return p
+}
+
+// cstring returns the NUL-terminated string at off in b.
+func cstring(b []byte, off int) (string, error) {
+	if off < 0 || off > len(b) {
+		return "", fmt.Errorf("device tree string at %#x is beyond the end of its block", off)
+	}
+	n := bytes.IndexByte(b[off:], 0)
+	if n < 0 {
+		return "", fmt.Errorf("device tree string at %#x is not terminated", off)
+	}
+	return string(b[off : off+n]), nil
+}
@@ -0,0 +1,235 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package grub
+
+import (
+	"fmt"
+	"strings"
+)
+
+// noops are commands that only matter to the real GRUB, like graphics and
+// terminal setup. They always succeed.
+var noops = map[string]bool{
+	"background_color": true,
+	"background_image": true,
+	"boot":             true,
+	"clear":            true,
+	"echo":             true,
+	"insmod":           true,
+	"loadfont":         true,
+	"password":         true,
+	"password_pbkdf2":  true,
+	"play":             true,
+	"rmmod":            true,
+	"save_env":         true,
+	"serial":           true,
+	"sleep":            true,
+	"terminal_input":   true,
+	"terminal_output":  true,
+}
+
+// builtin runs the GRUB command name. Commands that do not make sense
+// outside of GRUB, like hwmatch or chainloader, return errUnknownCommand.
+func (in *interp) builtin(name string, args []string) error {
+	if noops[name] {
+		return nil
+	}
+	switch name {
+	case "true", ":":
+		return nil
+
+	case "false":
+		return errFalse
+
+	case "set":
+		for _, arg := range args {
+			if kv := strings.SplitN(arg, "=", 2); len(kv) == 2 {
+				in.set(kv[0], kv[1])
+			}
+		}
+		return nil
+
+	case "unset":
+		for _, arg := range args {
+			delete(in.vars, arg)
+			delete(in.exported, arg)
+		}
+		return nil
+
+	case "export":
+		for _, arg := range args {
+			in.export(arg)
+		}
+		return nil
+
+	case "[":
+		if len(args) == 0 || args[len(args)-1] != "]" {
+			return fmt.Errorf("missing ]")
+		}
+		return in.test(args[:len(args)-1])
+
+	case "test":
+		return in.test(args)
+
+	case "source", ".":
+		if len(args) < 1 {
+			return fmt.Errorf("file name expected")
+		}
+		return in.source(args[0])
+
+	case "configfile":
+		if len(args) < 1 {
+			return fmt.Errorf("file name expected")
+		}
+		return in.configfile(args[0])
+
+	case "load_env":
+		return in.loadEnvCommand(args)
+
+	case "search":
+		return in.searchCommand(args)
+
+	case "search.file", "search.fs_uuid", "search.fs_label":
+		// search.fs_uuid UUID [VARIABLE] [HINTS...]
+		if len(args) < 1 {
+			return fmt.Errorf("one argument expected")
+		}
+		v := "root"
+		if len(args) > 1 {
+			v = args[1]
+		}
+		return in.searchSet(strings.TrimPrefix(name, "search."), args[0], v)
+
+	case "linux", "linux16", "linuxefi":
+		if in.boot == nil {
+			return errNoEntry
+		}
+		if len(args) < 1 {
+			return fmt.Errorf("kernel file name expected")
+		}
+		in.boot.kernel = in.resolve(args[0])
+		// Like GRUB, tell the kernel where it was loaded from.
+		in.boot.cmdline = strings.Join(append([]string{"BOOT_IMAGE=" + args[0]}, args[1:]...), " ")
+		in.boot.multiboot = false
+		in.boot.modules = nil
+		return nil
+
+	case "initrd", "initrd16", "initrdefi":
+		if in.boot == nil {
+			return errNoEntry
+		}
+		if len(args) < 1 {
+			return fmt.Errorf("initrd file name expected")
+		}
+		in.boot.initrds = nil
+		for _, arg := range args {
+			in.boot.initrds = append(in.boot.initrds, in.resolve(arg))
+		}
+		return nil
+
+	case "multiboot", "multiboot2":
+		if in.boot == nil {
+			return errNoEntry
+		}
+		if len(args) < 1 {
+			return fmt.Errorf("kernel file name expected")
+		}
+		in.boot.kernel = in.resolve(args[0])
+		in.boot.cmdline = strings.Join(args[1:], " ")
+		in.boot.multiboot = true
+		in.boot.initrds = nil
+		in.boot.modules = nil
+		return nil
+
+	case "module", "module2":
+		if in.boot == nil {
+			return errNoEntry
+		}
+		if len(args) > 0 && args[0] == "--nounzip" {
+			args = args[1:]
+		}
+		if len(args) < 1 {
+			return fmt.Errorf("module file name expected")
+		}
+		if !in.boot.multiboot {
+			return fmt.Errorf("you need to load the multiboot kernel first")
+		}
+		in.boot.modules = append(in.boot.modules, strings.Join(append([]string{in.resolve(args[0])}, args[1:]...), " "))
+		return nil
+	}
+	return errUnknownCommand
+}
+
+// loadEnvCommand runs load_env [-f FILE] [--skip-sig] [VARIABLES...].
+func (in *interp) loadEnvCommand(args []string) error {
+	file := in.get("prefix") + "/grubenv"
+	var names []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-f" || arg == "--file":
+			if i+1 == len(args) {
+				return fmt.Errorf("%s needs an argument", arg)
+			}
+			i++
+			file = args[i]
+		case strings.HasPrefix(arg, "--file="):
+			file = strings.TrimPrefix(arg, "--file=")
+		case arg == "-s" || arg == "--skip-sig":
+		default:
+			names = append(names, arg)
+		}
+	}
+	return in.loadEnv(file, names)
+}
+
+// searchCommand runs
+//
+//   search [--file|--label|--fs-uuid] [--set [VARIABLE]] [--no-floppy]
+//     [--hint HINT...] NAME
+func (in *interp) searchCommand(args []string) error {
+	kind := "file"
+	var v, key string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-f" || arg == "--file":
+			kind = "file"
+		case arg == "-u" || arg == "--fs-uuid":
+			kind = "fs_uuid"
+		case arg == "-l" || arg == "--label":
+			kind = "fs_label"
+		case arg == "-s" || arg == "--set":
+			v = "root"
+		case strings.HasPrefix(arg, "--set="):
+			v = strings.TrimPrefix(arg, "--set=")
+		case arg == "-h" || arg == "--hint" || strings.HasPrefix(arg, "--hint-") && !strings.Contains(arg, "="):
+			// Hints only speed the search up.
+			i++
+		case strings.HasPrefix(arg, "-"):
+			// --no-floppy, --hint=..., and so on.
+		default:
+			key = arg
+		}
+	}
+	if key == "" {
+		return fmt.Errorf("one argument expected")
+	}
+	if v == "" {
+		if _, ok := in.search(kind, key); !ok {
+			return fmt.Errorf("no such device: %s", key)
+		}
+		return nil
+	}
+	return in.searchSet(kind, key, v)
+}
+
+// searchSet sets the variable v to the device search finds.
+func (in *interp) searchSet(kind, key, v string) error {
+	dev, ok := in.search(kind, key)
+	if !ok {
+		return fmt.Errorf("no such device: %s", key)
+	}
+	in.set(v, dev)
+	return nil
+}
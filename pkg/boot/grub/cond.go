@@ -0,0 +1,187 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package grub
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// test evaluates the expression of a test or [ command.
+func (in *interp) test(args []string) error {
+	c := &cond{in: in, args: args}
+	ok, err := c.or()
+	if err != nil {
+		return err
+	}
+	if c.pos < len(args) {
+		return fmt.Errorf("unexpected %q", args[c.pos])
+	}
+	if !ok {
+		return errFalse
+	}
+	return nil
+}
+
+// cond parses and evaluates test expressions:
+//
+//   expr := and { -o and }
+//   and := not { -a not }
+//   not := ! not | ( expr ) | UNARY-OP ARG | ARG BINARY-OP ARG | ARG
+type cond struct {
+	in   *interp
+	args []string
+	pos  int
+}
+
+func (c *cond) peek(n int) (string, bool) {
+	if c.pos+n >= len(c.args) {
+		return "", false
+	}
+	return c.args[c.pos+n], true
+}
+
+func (c *cond) or() (bool, error) {
+	ok, err := c.and()
+	if err != nil {
+		return false, err
+	}
+	for {
+		if op, _ := c.peek(0); op != "-o" {
+			return ok, nil
+		}
+		c.pos++
+		r, err := c.and()
+		if err != nil {
+			return false, err
+		}
+		ok = ok || r
+	}
+}
+
+func (c *cond) and() (bool, error) {
+	ok, err := c.not()
+	if err != nil {
+		return false, err
+	}
+	for {
+		if op, _ := c.peek(0); op != "-a" {
+			return ok, nil
+		}
+		c.pos++
+		r, err := c.not()
+		if err != nil {
+			return false, err
+		}
+		ok = ok && r
+	}
+}
+
+var binaryOps = map[string]bool{
+	"=": true, "==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true,
+	"-eq": true, "-ne": true, "-lt": true, "-le": true, "-gt": true, "-ge": true,
+}
+
+var unaryOps = map[string]bool{
+	"-z": true, "-n": true, "-e": true, "-f": true, "-d": true, "-s": true,
+}
+
+func (c *cond) not() (bool, error) {
+	arg, ok := c.peek(0)
+	if !ok {
+		// An empty test is false.
+		return false, nil
+	}
+
+	// Binary operators take precedence, so that [ "$x" = -n ] works.
+	if op, ok := c.peek(1); ok && binaryOps[op] {
+		if rhs, ok := c.peek(2); ok {
+			c.pos += 3
+			return compare(arg, op, rhs), nil
+		}
+	}
+
+	switch {
+	case arg == "!":
+		c.pos++
+		r, err := c.not()
+		return !r, err
+
+	case arg == "(":
+		c.pos++
+		r, err := c.or()
+		if err != nil {
+			return false, err
+		}
+		if p, _ := c.peek(0); p != ")" {
+			return false, fmt.Errorf("missing )")
+		}
+		c.pos++
+		return r, nil
+
+	case unaryOps[arg]:
+		if operand, ok := c.peek(1); ok {
+			c.pos += 2
+			return c.unary(arg, operand), nil
+		}
+	}
+	c.pos++
+	return arg != "", nil
+}
+
+func (c *cond) unary(op, arg string) bool {
+	switch op {
+	case "-z":
+		return arg == ""
+	case "-n":
+		return arg != ""
+	}
+	fi, err := os.Stat(c.in.resolve(arg))
+	if err != nil {
+		return false
+	}
+	switch op {
+	case "-f":
+		return fi.Mode().IsRegular()
+	case "-d":
+		return fi.IsDir()
+	case "-s":
+		return fi.Size() > 0
+	}
+	return true
+}
+
+func compare(a, op, b string) bool {
+	switch op {
+	case "=", "==":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	x, _ := strconv.ParseInt(a, 0, 64)
+	y, _ := strconv.ParseInt(b, 0, 64)
+	switch op {
+	case "-eq":
+		return x == y
+	case "-ne":
+		return x != y
+	case "-lt":
+		return x < y
+	case "-le":
+		return x <= y
+	case "-gt":
+		return x > y
+	}
+	return x >= y
+}
@@ -0,0 +1,515 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package grub
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxDepth limits nested source, configfile, function calls and
+	// submenus.
+	maxDepth = 32
+
+	// maxIterations limits while and until loops, whose conditions may
+	// depend on state only the real GRUB has.
+	maxIterations = 1000
+)
+
+var (
+	errUnknownCommand = errors.New("unknown command")
+	errFalse          = errors.New("false")
+	errNoEntry        = errors.New("can only be used in a menu entry")
+
+	assignment = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+)
+
+// menuItem is a menuentry or submenu that was defined.
+type menuItem struct {
+	title   string
+	id      string
+	args    []string
+	body    []command
+	submenu bool
+}
+
+// bootState is what the menu entry being run loads.
+type bootState struct {
+	kernel    string
+	cmdline   string
+	initrds   []string
+	multiboot bool
+	modules   []string
+}
+
+// interp runs GRUB scripts.
+//
+// Every menu entry and submenu runs in a new interp, which, as in GRUB, only
+// sees the variables exported by its parent.
+type interp struct {
+	vars     map[string]string
+	exported map[string]bool
+	funcs    map[string]*functionCommand
+	args     []string
+	status   int
+	depth    int
+
+	mounts []Mount
+	home   *Mount
+
+	menu []*menuItem
+	boot *bootState
+}
+
+func newInterp(mounts []Mount, home *Mount) *interp {
+	return &interp{
+		vars:     make(map[string]string),
+		exported: make(map[string]bool),
+		funcs:    make(map[string]*functionCommand),
+		mounts:   mounts,
+		home:     home,
+	}
+}
+
+// child returns an interp for running a menu entry or submenu.
+func (in *interp) child() *interp {
+	c := newInterp(in.mounts, in.home)
+	for name := range in.exported {
+		c.vars[name] = in.vars[name]
+		c.exported[name] = true
+	}
+	// Functions are global in GRUB.
+	c.funcs = in.funcs
+	c.depth = in.depth + 1
+	return c
+}
+
+func (in *interp) get(name string) string {
+	switch name {
+	case "?":
+		return strconv.Itoa(in.status)
+	case "#":
+		return strconv.Itoa(len(in.args))
+	case "@", "*":
+		return strings.Join(in.args, " ")
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		if n >= 1 && n <= len(in.args) {
+			return in.args[n-1]
+		}
+		return ""
+	}
+	return in.vars[name]
+}
+
+func (in *interp) set(name, value string) {
+	in.vars[name] = value
+}
+
+func (in *interp) export(name string) {
+	if _, ok := in.vars[name]; !ok {
+		in.vars[name] = ""
+	}
+	in.exported[name] = true
+}
+
+// expand expands the variables in w. Unquoted variables are split into
+// several arguments at blanks, and disappear if they are empty.
+func (in *interp) expand(w word) []string {
+	var fields []string
+	var cur strings.Builder
+	inField := false
+	flush := func() {
+		if inField {
+			fields = append(fields, cur.String())
+			cur.Reset()
+			inField = false
+		}
+	}
+	for _, p := range w {
+		if !p.variable || p.quoted {
+			s := p.text
+			if p.variable {
+				s = in.get(p.text)
+			}
+			cur.WriteString(s)
+			inField = true
+			continue
+		}
+
+		v := in.get(p.text)
+		fs := strings.Fields(v)
+		if len(v) > 0 && isBlank(v[0]) {
+			flush()
+		}
+		for i, f := range fs {
+			if i > 0 {
+				flush()
+			}
+			cur.WriteString(f)
+			inField = true
+		}
+		if len(fs) > 0 && isBlank(v[len(v)-1]) {
+			flush()
+		}
+	}
+	flush()
+	return fields
+}
+
+func isBlank(c byte) bool {
+	return isSpace(c) || c == '\n'
+}
+
+func (in *interp) expandAll(words []word) []string {
+	var args []string
+	for _, w := range words {
+		args = append(args, in.expand(w)...)
+	}
+	return args
+}
+
+// run runs cmds. Like GRUB, it carries on after failing commands; it only
+// returns errors that stop the whole script.
+func (in *interp) run(cmds []command) error {
+	for _, cmd := range cmds {
+		if err := in.runCommand(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (in *interp) runCommand(cmd command) error {
+	switch c := cmd.(type) {
+	case *simpleCommand:
+		return in.runSimple(c)
+
+	case *ifCommand:
+		for _, clause := range c.clauses {
+			if err := in.run(clause.cond); err != nil {
+				return err
+			}
+			if in.status == 0 {
+				return in.run(clause.body)
+			}
+		}
+		in.status = 0
+		return in.run(c.els)
+
+	case *forCommand:
+		for _, item := range in.expandAll(c.items) {
+			in.set(c.name, item)
+			if err := in.run(c.body); err != nil {
+				return err
+			}
+		}
+
+	case *whileCommand:
+		for i := 0; i < maxIterations; i++ {
+			if err := in.run(c.cond); err != nil {
+				return err
+			}
+			if (in.status == 0) == c.until {
+				return nil
+			}
+			if err := in.run(c.body); err != nil {
+				return err
+			}
+		}
+		return fmt.Errorf("loop did not finish after %d iterations", maxIterations)
+
+	case *functionCommand:
+		in.funcs[c.name] = c
+		in.status = 0
+
+	case *menuCommand:
+		in.addMenuItem(c)
+	}
+	return nil
+}
+
+func (in *interp) runSimple(c *simpleCommand) error {
+	args := in.expandAll(c.args)
+	if len(args) == 0 {
+		return nil
+	}
+	// Like GRUB, only the first field of an assignment counts, so a=$b
+	// sets a to the first word of $b.
+	if assignment.MatchString(args[0]) {
+		kv := strings.SplitN(args[0], "=", 2)
+		in.set(kv[0], kv[1])
+		in.status = 0
+		return nil
+	}
+	if f, ok := in.funcs[args[0]]; ok {
+		return in.call(f, args[1:])
+	}
+
+	err := in.builtin(args[0], args[1:])
+	switch err.(type) {
+	case nil:
+		in.status = 0
+	case *fatalError:
+		return err
+	default:
+		in.status = 1
+		if err != errUnknownCommand && err != errFalse {
+			log.Printf("grub: line %d: %s: %v", c.line, args[0], err)
+		}
+	}
+	return nil
+}
+
+// fatalError stops the script, like exceeding maxDepth.
+type fatalError struct {
+	err error
+}
+
+func (f *fatalError) Error() string {
+	return f.err.Error()
+}
+
+func (in *interp) enter() error {
+	if in.depth >= maxDepth {
+		return &fatalError{fmt.Errorf("nesting is deeper than %d", maxDepth)}
+	}
+	in.depth++
+	return nil
+}
+
+func (in *interp) call(f *functionCommand, args []string) error {
+	if err := in.enter(); err != nil {
+		return err
+	}
+	defer func() { in.depth-- }()
+
+	saved := in.args
+	in.args = args
+	defer func() { in.args = saved }()
+	return in.run(f.body)
+}
+
+// menuOptions are the menuentry and submenu options with an argument.
+var menuOptions = map[string]bool{
+	"--class":  true,
+	"--users":  true,
+	"--hotkey": true,
+	"--id":     true,
+}
+
+func (in *interp) addMenuItem(c *menuCommand) {
+	item := &menuItem{body: c.body, submenu: c.submenu}
+	args := in.expandAll(c.args)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "--") {
+			item.args = append(item.args, arg)
+			continue
+		}
+		kv := strings.SplitN(arg, "=", 2)
+		if !menuOptions[kv[0]] {
+			continue
+		}
+		var value string
+		if len(kv) == 2 {
+			value = kv[1]
+		} else if i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		if kv[0] == "--id" {
+			item.id = value
+		}
+	}
+	if len(item.args) == 0 {
+		log.Printf("grub: line %d: menu entry has no title", c.line)
+		in.status = 1
+		return
+	}
+	item.title = item.args[0]
+	in.menu = append(in.menu, item)
+	in.status = 0
+}
+
+// resolve returns the path on the host of the GRUB file name p, which is
+// either (device)/path or a path on $root.
+func (in *interp) resolve(p string) string {
+	dev := in.get("root")
+	if strings.HasPrefix(p, "(") {
+		if i := strings.IndexByte(p, ')'); i > 0 {
+			dev, p = p[1:i], p[i+1:]
+		}
+	}
+	return filepath.Join(in.mount(dev).Path, filepath.Clean("/"+p))
+}
+
+// mount returns the mount of the GRUB device named dev. Device names that
+// are not known, like hd0,gpt1, are assumed to refer to the device of the
+// configuration file.
+func (in *interp) mount(dev string) *Mount {
+	for i := range in.mounts {
+		if in.mounts[i].Name == dev {
+			return &in.mounts[i]
+		}
+	}
+	return in.home
+}
+
+// parseFile reads and parses the script at the GRUB file name p.
+func (in *interp) parseFile(p string) ([]command, error) {
+	b, err := ioutil.ReadFile(in.resolve(p))
+	if err != nil {
+		return nil, err
+	}
+	cmds, err := parse(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", p, err)
+	}
+	return cmds, nil
+}
+
+// source runs the script at the GRUB file name p in the current context.
+func (in *interp) source(p string) error {
+	cmds, err := in.parseFile(p)
+	if err != nil {
+		return err
+	}
+	if err := in.enter(); err != nil {
+		return err
+	}
+	defer func() { in.depth-- }()
+	return in.run(cmds)
+}
+
+// configfile runs the script at the GRUB file name p, replacing the menu
+// with the one it defines.
+func (in *interp) configfile(p string) error {
+	saved := in.menu
+	in.menu = nil
+	in.set("config_file", p)
+	in.set("config_directory", dir(p))
+	if err := in.source(p); err != nil {
+		in.menu = saved
+		return err
+	}
+	if len(in.menu) == 0 {
+		in.menu = saved
+	}
+	return nil
+}
+
+// dir returns all but the last element of the GRUB file name p.
+func dir(p string) string {
+	if i := strings.LastIndexByte(p, '/'); i >= 0 {
+		return p[:i]
+	}
+	return ""
+}
+
+// search returns the name of the first device that has the file, file
+// system UUID or label key.
+func (in *interp) search(kind, key string) (string, bool) {
+	for _, m := range in.mounts {
+		switch kind {
+		case "file":
+			if _, err := os.Stat(filepath.Join(m.Path, filepath.Clean("/"+key))); err == nil {
+				return m.Name, true
+			}
+		case "fs_uuid":
+			if m.UUID != "" && strings.EqualFold(m.UUID, key) {
+				return m.Name, true
+			}
+		case "fs_label":
+			if m.Label != "" && m.Label == key {
+				return m.Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// loadEnv sets the variables stored in the GRUB environment block at the
+// GRUB file name p. If names is not empty, only those variables are set.
+func (in *interp) loadEnv(p string, names []string) error {
+	vars, err := ParseEnvFile(in.resolve(p))
+	if err != nil {
+		return err
+	}
+	for name, value := range vars {
+		if len(names) > 0 && !contains(names, name) {
+			continue
+		}
+		in.set(name, value)
+	}
+	return nil
+}
+
+const envHeader = "# GRUB Environment Block\n"
+
+// ParseEnvFile returns the variables stored in the GRUB environment block
+// at path, such as /boot/grub/grubenv.
+func ParseEnvFile(path string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	vars, err := parseEnv(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return vars, nil
+}
+
+// parseEnv parses a GRUB environment block, which holds one name=value per
+// line, padded with #s to 1024 bytes. Backslashes escape newlines and
+// backslashes in values.
+func parseEnv(env string) (map[string]string, error) {
+	if !strings.HasPrefix(env, envHeader) {
+		return nil, fmt.Errorf("not a GRUB environment block")
+	}
+	vars := make(map[string]string)
+	env = env[len(envHeader):]
+	for len(env) > 0 {
+		var line strings.Builder
+		i := 0
+		for ; i < len(env) && env[i] != '\n'; i++ {
+			if env[i] == '\\' && i+1 < len(env) {
+				i++
+			}
+			line.WriteByte(env[i])
+		}
+		if i < len(env) {
+			i++
+		}
+		env = env[i:]
+
+		l := line.String()
+		if strings.HasPrefix(l, "#") {
+			continue
+		}
+		if kv := strings.SplitN(l, "=", 2); len(kv) == 2 {
+			vars[kv[0]] = kv[1]
+		}
+	}
+	return vars, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
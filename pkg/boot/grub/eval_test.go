@@ -0,0 +1,224 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package grub
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScripts(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		script string
+		want   map[string]string
+	}{
+		{
+			name:   "quoting",
+			script: `a='x  y'; b="$a z"; c=$a; d='$a'"\$a"pre"${a}"post`,
+			want:   map[string]string{"a": "x  y", "b": "x  y z", "c": "x", "d": "$a$aprex  ypost"},
+		},
+		{
+			name:   "comments and continuation lines",
+			script: "a=1 # a=2\nb=\\\n2\nc=#3",
+			want:   map[string]string{"a": "1", "b": "2", "c": "#3"},
+		},
+		{
+			name:   "field splitting",
+			script: `a="1 2  3"; n=0; for i in $a; do n="$n$i"; done; m=0; for i in "$a"; do m="$m,$i"; done`,
+			want:   map[string]string{"n": "0123", "m": "0,1 2  3"},
+		},
+		{
+			name: "if elif else",
+			script: `
+x=2
+if [ $x = 1 ]; then r=one; elif [ $x = 2 ]; then r=two; else r=other; fi
+if false; then s=yes; else s=no; fi
+if [ -z "$unset" -a -n "$x" ]; then t=yes; fi
+if [ ! "$x" -gt 10 ]; then u=small; fi
+if [ -e /boot/grub/grub.cfg ]; then v=found; fi
+if [ -d (sdb1)/boot/grub ]; then w=found; fi
+`,
+			want: map[string]string{"r": "two", "s": "no", "t": "yes", "u": "small", "v": "", "w": "found"},
+		},
+		{
+			name: "functions",
+			script: `
+function f {
+	a="$1-$2-$#"
+	return_ignored=$3
+}
+f x "y z"
+status=$?
+`,
+			want: map[string]string{"a": "x-y z-2", "status": "0"},
+		},
+		{
+			name:   "status",
+			script: `false; a=$?; true; b=$?; nosuchcommand; c=$?`,
+			want:   map[string]string{"a": "1", "b": "0", "c": "1"},
+		},
+		{
+			name:   "while and until",
+			script: `i=; while [ "$i" != xxx ]; do i="${i}x"; done; until [ "$j" = yy ]; do j="${j}y"; done`,
+			want:   map[string]string{"i": "xxx", "j": "yy"},
+		},
+		{
+			name:   "set and unset",
+			script: `set a=1 b=2=3; c=4; unset c`,
+			want:   map[string]string{"a": "1", "b": "2=3", "c": ""},
+		},
+		{
+			name:   "search",
+			script: `search --no-floppy --fs-uuid --set=dev --hint-bios=hd0,msdos1 --hint hd0,gpt1 ABCD-1234; search.file /boot/grub/grub.cfg part`,
+			want:   map[string]string{"dev": "sdb1", "part": "sdb1"},
+		},
+		{
+			name:   "search by label",
+			script: `search --label --set=dev rootfs; search.fs_label boot part`,
+			want:   map[string]string{"dev": "sdb1", "part": "sda1"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mounts := []Mount{
+				{Name: "sda1", Path: "testdata/nonexistent", Label: "boot"},
+				{Name: "sdb1", Path: "testdata/ubuntu-18.04/root", UUID: "abcd-1234", Label: "rootfs"},
+			}
+			in := newInterp(mounts, &mounts[0])
+			cmds, err := parse(tt.script)
+			if err != nil {
+				t.Fatalf("parse(%q) = %v", tt.script, err)
+			}
+			if err := in.run(cmds); err != nil {
+				t.Fatalf("run(%q) = %v", tt.script, err)
+			}
+			for name, want := range tt.want {
+				if got := in.get(name); got != want {
+					t.Errorf("$%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestScriptErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		script  string
+		wantErr string
+	}{
+		{"unterminated quote", `a="b`, "unterminated"},
+		{"unterminated single quote", `a='b`, "unterminated"},
+		{"missing fi", `if true; then a=1`, "unexpected end of file"},
+		{"missing done", `for i in 1 2; do a=1`, "unexpected end of file"},
+		{"unexpected brace", `a=1; }`, "unexpected }"},
+		{"unexpected fi", `fi`, `unexpected "fi"`},
+		{"pipe", `a | b`, "not supported"},
+		{"endless loop", `while true; do a=1; done`, "did not finish"},
+		{"recursion", `function f { f; }; f`, "nesting is deeper than"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cmds, err := parse(tt.script)
+			if err == nil {
+				err = newInterp(nil, &Mount{}).run(cmds)
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("running %q = %v, want error containing %q", tt.script, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMenu(t *testing.T) {
+	script := `
+menuentry 'Linux' --class gnu --id linux 'extra arg' {
+	linux /vmlinuz root=/dev/sda1 "$2"
+	initrd /initrd.img
+}
+submenu --id sub 'More' {
+	menuentry Xen {
+		multiboot /xen.gz dom0_mem=1G
+		module --nounzip /vmlinuz console=hvc0
+	}
+	menuentry 'No kernel' {
+		true
+	}
+}
+`
+	mounts := []Mount{{Name: "sda1", Path: "/mnt"}}
+	in := newInterp(mounts, &mounts[0])
+	in.set("root", "sda1")
+	in.export("root")
+	cmds, err := parse(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := in.run(cmds); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Config{}
+	menu := c.walk(in, in.menu, "")
+	want := []*Entry{
+		{
+			Title:   "Linux",
+			ID:      "linux",
+			Kernel:  "/mnt/vmlinuz",
+			Cmdline: "BOOT_IMAGE=/vmlinuz root=/dev/sda1 extra arg",
+			Initrds: []string{"/mnt/initrd.img"},
+		},
+		{
+			Title:     "More>Xen",
+			Kernel:    "/mnt/xen.gz",
+			Cmdline:   "dom0_mem=1G",
+			Multiboot: true,
+			Modules:   []string{"/mnt/vmlinuz console=hvc0"},
+		},
+	}
+	if !reflect.DeepEqual(c.Entries, want) {
+		t.Errorf("entries = %+v, want %+v", c.Entries, want)
+	}
+
+	for _, tt := range []struct {
+		def  string
+		want int
+	}{
+		{"0", 0},
+		{"Linux", 0},
+		{"linux", 0},
+		{"1>0", 1},
+		{"sub>Xen", 1},
+		{"More>No kernel", -1},
+		{"More>2", -2},
+		{"2", -2},
+		{"", -2},
+	} {
+		n := findEntry(menu, tt.def)
+		got := -2
+		if n != nil {
+			got = n.entry
+		}
+		if got != tt.want {
+			t.Errorf("findEntry(%q) = %d, want %d", tt.def, got, tt.want)
+		}
+	}
+}
+
+func TestParseEnv(t *testing.T) {
+	env := envHeader + "saved_entry=a\\\\b\\\nc\nnext_entry=\n#" + strings.Repeat("#", 100)
+	got, err := parseEnv(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"saved_entry": "a\\b\nc", "next_entry": ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseEnv = %q, want %q", got, want)
+	}
+
+	if _, err := parseEnv("saved_entry=a\n"); err == nil {
+		t.Errorf("parseEnv without header succeeded, want error")
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package grub evaluates GRUB2 configuration files.
+//
+// grub.cfg files are scripts, see
+// https://www.gnu.org/software/grub/manual/grub/grub.html#Shell_002dlike-scripting.
+// The script is run the way GRUB runs it, with variables, if, for, while,
+// functions, source, configfile, search and load_env, to find the menu
+// entries. Each menu entry, including the ones in submenus, is then run to
+// find the kernel it loads.
+//
+// Commands that only matter to GRUB itself, like insmod or terminal_output,
+// succeed and do nothing. Commands that cannot work outside of GRUB, like
+// chainloader or hwmatch, fail.
+package grub
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/boot/vboot"
+	"github.com/u-root/u-root/pkg/uio"
+)
+
+// Mount is a file system GRUB can load files from.
+type Mount struct {
+	// Name is the device name $root is set to for this file system, e.g.
+	// sda1.
+	Name string
+
+	// Path is where the file system is mounted.
+	Path string
+
+	// UUID is the file system UUID, which search --fs-uuid looks for.
+	UUID string
+
+	// Label is the file system label, which search --label looks for.
+	Label string
+}
+
+// Config is an evaluated GRUB configuration.
+type Config struct {
+	// Entries are the menu entries that boot a kernel, in menu order.
+	// Entries in submenus follow the entries before the submenu.
+	Entries []*Entry
+
+	// DefaultEntry is the index in Entries of the entry GRUB boots by
+	// default, or -1 if that entry does not boot a kernel.
+	DefaultEntry int
+}
+
+// Entry is a menu entry that boots a kernel.
+type Entry struct {
+	// Title is the title of the menu entry. The titles of the submenus it
+	// is in come first, separated by >, as GRUB's default variable
+	// refers to entries in submenus.
+	Title string
+
+	// ID is the --id of the menu entry.
+	ID string
+
+	// Kernel is the path of the Linux or Multiboot kernel and Cmdline its
+	// command line.
+	Kernel  string
+	Cmdline string
+
+	// Initrds are the paths of the initramfs images of a Linux kernel,
+	// which are concatenated.
+	Initrds []string
+
+	// Multiboot is set if Kernel is a Multiboot kernel. Modules then are
+	// the paths of its modules, each followed by the module's command
+	// line.
+	Multiboot bool
+	Modules   []string
+}
+
+// OSImage returns the image that boots e. Its signatures are the detached
+// signatures next to the files, see package vboot; several initrds are not
+// signed.
+func (e *Entry) OSImage() boot.OSImage {
+	if e.Multiboot {
+		img := &boot.MultibootImage{
+			Path:       e.Kernel,
+			Cmdline:    e.Cmdline,
+			Modules:    e.Modules,
+			KernelSig:  vboot.Detached(e.Kernel),
+			CmdlineSig: vboot.DetachedCmdline(e.Kernel),
+		}
+		for _, m := range e.Modules {
+			// A module without a file name fails to load; it has
+			// no signature.
+			var sig io.ReaderAt
+			if f := strings.Fields(m); len(f) > 0 {
+				sig = vboot.Detached(f[0])
+			}
+			img.ModuleSigs = append(img.ModuleSigs, sig)
+		}
+		return img
+	}
+	img := &boot.LinuxImage{
+		Kernel:     uio.NewLazyFile(e.Kernel),
+		Initrd:     initrd(e.Initrds),
+		Cmdline:    e.Cmdline,
+		KernelSig:  vboot.Detached(e.Kernel),
+		CmdlineSig: vboot.DetachedCmdline(e.Kernel),
+	}
+	if len(e.Initrds) == 1 {
+		img.InitrdSig = vboot.Detached(e.Initrds[0])
+	}
+	return img
+}
+
+// initrd returns the concatenation of the files in paths. Like GRUB, each
+// file is padded to 4 bytes.
+func initrd(paths []string) io.ReaderAt {
+	switch len(paths) {
+	case 0:
+		return nil
+	case 1:
+		return uio.NewLazyFile(paths[0])
+	}
+	return uio.NewLazyOpenerAt(func() (io.ReaderAt, error) {
+		var b bytes.Buffer
+		for _, p := range paths {
+			d, err := ioutil.ReadFile(p)
+			if err != nil {
+				return nil, err
+			}
+			b.Write(d)
+			b.Write(make([]byte, (4-len(d)%4)%4))
+		}
+		return bytes.NewReader(b.Bytes()), nil
+	})
+}
+
+// features are the GRUB features grub-mkconfig scripts check for.
+var features = []string{
+	"feature_200_final",
+	"feature_all_video_module",
+	"feature_chainloader_bpb",
+	"feature_default_font_path",
+	"feature_menuentry_id",
+	"feature_menuentry_options",
+	"feature_nativedisk_cmd",
+	"feature_ntldr",
+	"feature_platform_search_hint",
+	"feature_timeout_style",
+}
+
+var efiPath = "/sys/firmware/efi"
+
+// platform returns $grub_cpu and $grub_platform.
+func platform() (string, string) {
+	cpu := runtime.GOARCH
+	switch cpu {
+	case "amd64":
+		cpu = "x86_64"
+	case "386":
+		cpu = "i386"
+	}
+	if _, err := os.Stat(efiPath); err == nil {
+		return cpu, "efi"
+	}
+	return cpu, "pc"
+}
+
+// ParseConfigFile evaluates the GRUB configuration file at path, which
+// must be in one of mounts.
+//
+// The file system of the configuration file is the initial $root, and
+// $prefix is its directory. Files on devices that are not in mounts are
+// looked up on the file system of the configuration file.
+func ParseConfigFile(path string, mounts []Mount) (*Config, error) {
+	var home *Mount
+	var rel string
+	for i, m := range mounts {
+		r, err := filepath.Rel(m.Path, path)
+		if err != nil || strings.HasPrefix(r, "..") {
+			continue
+		}
+		// Prefer the innermost mount.
+		if home == nil || len(m.Path) > len(home.Path) {
+			home, rel = &mounts[i], r
+		}
+	}
+	if home == nil {
+		return nil, fmt.Errorf("%s is not on any of the mounts", path)
+	}
+
+	in := newInterp(mounts, home)
+	cpu, plat := platform()
+	in.set("grub_cpu", cpu)
+	in.set("grub_platform", plat)
+	for _, f := range features {
+		in.set(f, "y")
+	}
+	file := fmt.Sprintf("(%s)/%s", home.Name, filepath.ToSlash(rel))
+	in.set("root", home.Name)
+	in.set("prefix", dir(file))
+	for name := range in.vars {
+		in.export(name)
+	}
+
+	if err := in.configfile(file); err != nil {
+		return nil, err
+	}
+
+	c := &Config{DefaultEntry: -1}
+	menu := c.walk(in, in.menu, "")
+	if n := findEntry(menu, in.get("default")); n != nil {
+		c.DefaultEntry = n.entry
+	} else if len(menu) > 0 {
+		// Like GRUB, fall back to the first entry.
+		c.DefaultEntry = menu[0].entry
+	}
+	return c, nil
+}
+
+// menuNode is a menuItem and, for submenus, the menu it opens.
+type menuNode struct {
+	item     *menuItem
+	children []*menuNode
+
+	// entry is the index of the entry in Config.Entries, or -1.
+	entry int
+}
+
+// walk runs the menu entries and submenus in items, adding the entries that
+// boot a kernel to c.
+func (c *Config) walk(parent *interp, items []*menuItem, prefix string) []*menuNode {
+	var nodes []*menuNode
+	for _, item := range items {
+		n := &menuNode{item: item, entry: -1}
+		nodes = append(nodes, n)
+
+		in := parent.child()
+		in.args = item.args
+		in.set("chosen", prefix+item.title)
+		if !item.submenu {
+			in.boot = &bootState{}
+		}
+		if err := in.run(item.body); err != nil {
+			log.Printf("grub: menu entry %q: %v", item.title, err)
+			continue
+		}
+		if item.submenu {
+			n.children = c.walk(in, in.menu, prefix+item.title+">")
+			continue
+		}
+
+		b := in.boot
+		if b.kernel == "" {
+			continue
+		}
+		n.entry = len(c.Entries)
+		c.Entries = append(c.Entries, &Entry{
+			Title:     prefix + item.title,
+			ID:        item.id,
+			Kernel:    b.kernel,
+			Cmdline:   b.cmdline,
+			Initrds:   b.initrds,
+			Multiboot: b.multiboot,
+			Modules:   b.modules,
+		})
+	}
+	return nodes
+}
+
+// findEntry returns the menu entry that def, the value of GRUB's default
+// variable, refers to. def is a >-separated path of menu entry numbers,
+// titles or IDs.
+func findEntry(menu []*menuNode, def string) *menuNode {
+	if def == "" {
+		return nil
+	}
+	var n *menuNode
+	for _, name := range strings.Split(def, ">") {
+		n = nil
+		if i, err := strconv.Atoi(name); err == nil {
+			if i >= 0 && i < len(menu) {
+				n = menu[i]
+			}
+		} else {
+			for _, m := range menu {
+				if m.item.title == name || m.item.id == name {
+					n = m
+					break
+				}
+			}
+		}
+		if n == nil {
+			return nil
+		}
+		menu = n.children
+	}
+	return n
+}
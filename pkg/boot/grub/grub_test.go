@@ -0,0 +1,139 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package grub
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/u-root/u-root/pkg/boot"
+)
+
+const diskbootTestdata = "../../diskboot/testdata"
+
+func TestParseConfigFile(t *testing.T) {
+	// $grub_platform is pc in all tests.
+	efiPath = "testdata/nonexistent"
+
+	for _, tt := range []struct {
+		name   string
+		config string
+		mounts []Mount
+	}{
+		{
+			name:   "ubuntu-16.04",
+			config: diskbootTestdata + "/testdata/ubuntu-16.04-boot/grub/grub.cfg",
+			mounts: []Mount{{Name: "sda1", Path: diskbootTestdata + "/testdata/ubuntu-16.04-boot"}},
+		},
+		{
+			// A stub grub.cfg on the ESP, the default entry in grubenv,
+			// and a custom.cfg.
+			name:   "ubuntu-18.04",
+			config: "testdata/ubuntu-18.04/esp/EFI/ubuntu/grub.cfg",
+			mounts: []Mount{
+				{Name: "sda1", Path: "testdata/ubuntu-18.04/esp"},
+				{Name: "sda2", Path: "testdata/ubuntu-18.04/root", UUID: "0B5F1E3C-4A8D-4B9E-9F3B-2C6D8E1A7F40"},
+			},
+		},
+		{
+			name:   "centos-7",
+			config: "testdata/centos-7/esp/EFI/centos/grub.cfg",
+			mounts: []Mount{
+				{Name: "sda1", Path: "testdata/centos-7/esp"},
+				{Name: "sda2", Path: "testdata/centos-7/boot", UUID: "5c2f4b8e-31a7-4d0c-a6e2-9b1d7f3e8c52"},
+			},
+		},
+		{
+			name:   "qubes-3.2",
+			config: diskbootTestdata + "/testdata/qubes-3.2-boot/grub2/grub.cfg",
+			mounts: []Mount{{Name: "sda1", Path: diskbootTestdata + "/testdata/qubes-3.2-boot"}},
+		},
+		{
+			name:   "debian-9-install",
+			config: diskbootTestdata + "/debian-9-install/boot/grub/grub.cfg",
+			mounts: []Mount{{Name: "sr0", Path: diskbootTestdata + "/debian-9-install"}},
+		},
+		{
+			name:   "fedora-27-install",
+			config: diskbootTestdata + "/fedora-27-install/EFI/BOOT/grub.cfg",
+			mounts: []Mount{{Name: "sr0", Path: diskbootTestdata + "/fedora-27-install"}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			golden := filepath.Join("testdata", tt.name+".json")
+			b, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want Config
+			if err := json.Unmarshal(b, &want); err != nil {
+				t.Fatalf("Failed to unmarshal %s: %v", golden, err)
+			}
+
+			got, err := ParseConfigFile(tt.config, tt.mounts)
+			if err != nil {
+				t.Fatalf("ParseConfigFile(%s) = %v", tt.config, err)
+			}
+			gotJSON, _ := json.MarshalIndent(got, "", "\t")
+			t.Logf("Config for %s\n%s", tt.config, gotJSON)
+
+			if diff := deep.Equal(got, &want); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestParseConfigFileNotOnMount(t *testing.T) {
+	mounts := []Mount{{Name: "sda1", Path: "testdata/centos-7"}}
+	if _, err := ParseConfigFile("testdata/ubuntu-18.04/esp/EFI/ubuntu/grub.cfg", mounts); err == nil {
+		t.Errorf("ParseConfigFile on no mount succeeded, want error")
+	}
+}
+
+func TestEntryOSImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var initrds []string
+	for _, s := range []string{"abc", "defgh", "ijkl"} {
+		p := filepath.Join(dir, s)
+		if err := ioutil.WriteFile(p, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+		initrds = append(initrds, p)
+	}
+
+	e := &Entry{Kernel: filepath.Join(dir, "vmlinuz"), Cmdline: "console=ttyS0", Initrds: initrds}
+	img, ok := e.OSImage().(*boot.LinuxImage)
+	if !ok {
+		t.Fatalf("OSImage() = %T, want *boot.LinuxImage", e.OSImage())
+	}
+	if img.Cmdline != e.Cmdline {
+		t.Errorf("Cmdline = %q, want %q", img.Cmdline, e.Cmdline)
+	}
+	b := make([]byte, 16)
+	n, _ := img.Initrd.ReadAt(b, 0)
+	if got, want := string(b[:n]), "abc\x00defgh\x00\x00\x00ijkl"; got != want {
+		t.Errorf("initrd = %q, want %q", got, want)
+	}
+
+	e = &Entry{Kernel: "xen.gz", Cmdline: "dom0_mem=1024M", Multiboot: true, Modules: []string{"vmlinuz root=/dev/sda1"}}
+	mb, ok := e.OSImage().(*boot.MultibootImage)
+	if !ok {
+		t.Fatalf("OSImage() = %T, want *boot.MultibootImage", e.OSImage())
+	}
+	if mb.Path != e.Kernel || mb.Cmdline != e.Cmdline || strings.Join(mb.Modules, ",") != "vmlinuz root=/dev/sda1" {
+		t.Errorf("OSImage() = %+v, want kernel %q, cmdline %q, modules %q", mb, e.Kernel, e.Cmdline, e.Modules)
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package grub
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenType int
+
+const (
+	tokWord tokenType = iota
+	// tokSep is a newline or a semicolon.
+	tokSep
+	tokLBrace
+	tokRBrace
+	tokEOF
+)
+
+// part is a piece of a word: either literal text or a variable reference,
+// which is expanded when the command runs.
+type part struct {
+	text     string
+	variable bool
+	quoted   bool
+}
+
+// word is a command argument made up of adjacent literal and variable
+// parts, e.g. x"$foo"'bar'.
+type word []part
+
+// literal returns the text of w if it is a single unquoted literal, as
+// keywords are.
+func (w word) literal() (string, bool) {
+	if len(w) != 1 || w[0].variable || w[0].quoted {
+		return "", false
+	}
+	return w[0].text, true
+}
+
+type token struct {
+	typ  tokenType
+	word word
+	line int
+}
+
+// lexer splits a GRUB script into tokens as described in
+// https://www.gnu.org/software/grub/manual/grub/grub.html#Shell_002dlike-scripting
+type lexer struct {
+	src  string
+	pos  int
+	line int
+}
+
+func lex(src string) ([]token, error) {
+	l := &lexer{src: src, line: 1}
+	var toks []token
+	for {
+		t, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		toks = append(toks, t)
+		if t.typ == tokEOF {
+			return toks, nil
+		}
+	}
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", l.line, fmt.Sprintf(format, args...))
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r'
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// skip skips blanks, line continuations and comments.
+func (l *lexer) skip() {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case isSpace(c):
+			l.pos++
+		case c == '\\' && l.pos+1 < len(l.src) && l.src[l.pos+1] == '\n':
+			l.pos += 2
+			l.line++
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	l.skip()
+	if l.pos == len(l.src) {
+		return token{typ: tokEOF, line: l.line}, nil
+	}
+
+	line := l.line
+	switch c := l.src[l.pos]; c {
+	case '\n', ';':
+		l.pos++
+		if c == '\n' {
+			l.line++
+		}
+		return token{typ: tokSep, line: line}, nil
+	case '{':
+		l.pos++
+		return token{typ: tokLBrace, line: line}, nil
+	case '}':
+		l.pos++
+		return token{typ: tokRBrace, line: line}, nil
+	case '|', '&', '<', '>':
+		return token{}, l.errorf("%q is not supported", c)
+	}
+	w, err := l.word()
+	if err != nil {
+		return token{}, err
+	}
+	return token{typ: tokWord, word: w, line: line}, nil
+}
+
+// word reads a word up to the next unquoted separator.
+func (l *lexer) word() (word, error) {
+	var w word
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			w = append(w, part{text: lit.String()})
+			lit.Reset()
+		}
+	}
+
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case isSpace(c), c == '\n', c == ';', c == '{', c == '}', c == '|', c == '&', c == '<', c == '>':
+			flush()
+			return w, nil
+
+		case c == '\\':
+			l.pos++
+			if l.pos == len(l.src) {
+				lit.WriteByte(c)
+			} else if l.src[l.pos] == '\n' {
+				l.pos++
+				l.line++
+			} else {
+				lit.WriteByte(l.src[l.pos])
+				l.pos++
+			}
+
+		case c == '\'':
+			end := strings.IndexByte(l.src[l.pos+1:], '\'')
+			if end < 0 {
+				return nil, l.errorf("unterminated single quote")
+			}
+			s := l.src[l.pos+1 : l.pos+1+end]
+			l.line += strings.Count(s, "\n")
+			l.pos += end + 2
+			flush()
+			// Keep empty strings, so that '' is an argument.
+			w = append(w, part{text: s, quoted: true})
+
+		case c == '"':
+			l.pos++
+			flush()
+			if err := l.doubleQuoted(&w); err != nil {
+				return nil, err
+			}
+
+		case c == '$':
+			if name, ok := l.variable(); ok {
+				flush()
+				w = append(w, part{text: name, variable: true})
+			} else {
+				lit.WriteByte(c)
+			}
+
+		default:
+			lit.WriteByte(c)
+			l.pos++
+		}
+	}
+	flush()
+	return w, nil
+}
+
+// doubleQuoted reads the rest of a double-quoted string into w. Variables
+// are expanded in double quotes, and backslash only escapes $, ", \ and
+// newlines.
+func (l *lexer) doubleQuoted(w *word) error {
+	var lit strings.Builder
+	empty := true
+	flush := func() {
+		if lit.Len() > 0 {
+			*w = append(*w, part{text: lit.String(), quoted: true})
+			lit.Reset()
+			empty = false
+		}
+	}
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			flush()
+			if empty {
+				*w = append(*w, part{quoted: true})
+			}
+			return nil
+
+		case c == '\\' && l.pos+1 < len(l.src):
+			switch n := l.src[l.pos+1]; n {
+			case '$', '"', '\\':
+				lit.WriteByte(n)
+			case '\n':
+				l.line++
+			default:
+				lit.WriteByte(c)
+				lit.WriteByte(n)
+			}
+			l.pos += 2
+
+		case c == '$':
+			if name, ok := l.variable(); ok {
+				flush()
+				*w = append(*w, part{text: name, variable: true, quoted: true})
+				empty = false
+			} else {
+				lit.WriteByte(c)
+			}
+
+		default:
+			if c == '\n' {
+				l.line++
+			}
+			lit.WriteByte(c)
+			l.pos++
+		}
+	}
+	return l.errorf("unterminated double quote")
+}
+
+// variable reads a $name, ${name} or special variable reference like $? or
+// $1. It returns false, consuming only the $, if no name follows.
+func (l *lexer) variable() (string, bool) {
+	l.pos++
+	if l.pos == len(l.src) {
+		return "", false
+	}
+	if l.src[l.pos] == '{' {
+		end := strings.IndexByte(l.src[l.pos:], '}')
+		if end < 2 {
+			return "", false
+		}
+		name := l.src[l.pos+1 : l.pos+end]
+		l.pos += end + 1
+		return name, true
+	}
+	switch c := l.src[l.pos]; c {
+	case '?', '#', '@', '*':
+		l.pos++
+		return string(c), true
+	}
+	start := l.pos
+	for l.pos < len(l.src) && isNameChar(l.src[l.pos]) {
+		l.pos++
+	}
+	return l.src[start:l.pos], l.pos > start
+}
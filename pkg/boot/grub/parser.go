@@ -0,0 +1,311 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package grub
+
+import (
+	"fmt"
+)
+
+// command is one of the command types below.
+type command interface{}
+
+// simpleCommand runs a builtin command or function, or sets a variable.
+type simpleCommand struct {
+	args []word
+	line int
+}
+
+type ifClause struct {
+	cond []command
+	body []command
+}
+
+// ifCommand is an if; then; elif; then; else; fi statement.
+type ifCommand struct {
+	clauses []ifClause
+	els     []command
+}
+
+// forCommand is a for name in words; do; done loop.
+type forCommand struct {
+	name  string
+	items []word
+	body  []command
+}
+
+// whileCommand is a while or until loop.
+type whileCommand struct {
+	cond  []command
+	body  []command
+	until bool
+}
+
+// functionCommand defines a function.
+type functionCommand struct {
+	name string
+	body []command
+}
+
+// menuCommand is a menuentry or submenu. Its body runs when it is
+// selected.
+type menuCommand struct {
+	args    []word
+	body    []command
+	submenu bool
+	line    int
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+// parse parses a GRUB script.
+func parse(src string) ([]command, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	cmds, err := p.commands()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != tokEOF {
+		return nil, p.unexpected(t)
+	}
+	return cmds, nil
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.typ != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// keyword returns the keyword the next token is, if any.
+func (p *parser) keyword() string {
+	t := p.peek()
+	if t.typ != tokWord {
+		return ""
+	}
+	s, _ := t.word.literal()
+	return s
+}
+
+func (p *parser) unexpected(t token) error {
+	switch t.typ {
+	case tokEOF:
+		return fmt.Errorf("line %d: unexpected end of file", t.line)
+	case tokLBrace:
+		return fmt.Errorf("line %d: unexpected {", t.line)
+	case tokRBrace:
+		return fmt.Errorf("line %d: unexpected }", t.line)
+	case tokSep:
+		return fmt.Errorf("line %d: unexpected end of line", t.line)
+	}
+	s, _ := t.word.literal()
+	return fmt.Errorf("line %d: unexpected %q", t.line, s)
+}
+
+func (p *parser) expect(keyword string) error {
+	if p.keyword() != keyword {
+		return p.unexpected(p.peek())
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) expectType(typ tokenType) error {
+	if t := p.peek(); t.typ != typ {
+		return p.unexpected(t)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) skipSeps() {
+	for p.peek().typ == tokSep {
+		p.next()
+	}
+}
+
+// commands parses commands up to the end of the file, a closing brace or
+// one of the keywords in end.
+func (p *parser) commands(end ...string) ([]command, error) {
+	var cmds []command
+	for {
+		p.skipSeps()
+		if t := p.peek(); t.typ == tokEOF || t.typ == tokRBrace {
+			return cmds, nil
+		}
+		kw := p.keyword()
+		for _, e := range end {
+			if kw == e {
+				return cmds, nil
+			}
+		}
+		cmd, err := p.command()
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+	}
+}
+
+func (p *parser) command() (command, error) {
+	switch kw := p.keyword(); kw {
+	case "if":
+		return p.ifCommand()
+	case "for":
+		return p.forCommand()
+	case "while", "until":
+		return p.whileCommand()
+	case "function":
+		return p.functionCommand()
+	case "menuentry", "submenu":
+		return p.menuCommand()
+	case "then", "elif", "else", "fi", "do", "done":
+		return nil, p.unexpected(p.peek())
+	}
+
+	t := p.peek()
+	if t.typ != tokWord {
+		return nil, p.unexpected(t)
+	}
+	cmd := &simpleCommand{line: t.line}
+	for p.peek().typ == tokWord {
+		cmd.args = append(cmd.args, p.next().word)
+	}
+	if t := p.peek(); t.typ == tokLBrace {
+		return nil, p.unexpected(t)
+	}
+	return cmd, nil
+}
+
+// block parses a { command; ... } block.
+func (p *parser) block() ([]command, error) {
+	if err := p.expectType(tokLBrace); err != nil {
+		return nil, err
+	}
+	body, err := p.commands()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectType(tokRBrace); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func (p *parser) ifCommand() (command, error) {
+	cmd := &ifCommand{}
+	// The first clause starts with if, the others with elif.
+	for kw := p.keyword(); kw == "if" || kw == "elif"; kw = p.keyword() {
+		p.next()
+		cond, err := p.commands("then")
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("then"); err != nil {
+			return nil, err
+		}
+		body, err := p.commands("elif", "else", "fi")
+		if err != nil {
+			return nil, err
+		}
+		cmd.clauses = append(cmd.clauses, ifClause{cond: cond, body: body})
+	}
+	if p.keyword() == "else" {
+		p.next()
+		els, err := p.commands("fi")
+		if err != nil {
+			return nil, err
+		}
+		cmd.els = els
+	}
+	if err := p.expect("fi"); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+func (p *parser) forCommand() (command, error) {
+	p.next()
+	name := p.keyword()
+	if name == "" {
+		return nil, p.unexpected(p.peek())
+	}
+	p.next()
+	if err := p.expect("in"); err != nil {
+		return nil, err
+	}
+	cmd := &forCommand{name: name}
+	for p.peek().typ == tokWord {
+		cmd.items = append(cmd.items, p.next().word)
+	}
+	p.skipSeps()
+	if err := p.expect("do"); err != nil {
+		return nil, err
+	}
+	body, err := p.commands("done")
+	if err != nil {
+		return nil, err
+	}
+	cmd.body = body
+	return cmd, p.expect("done")
+}
+
+func (p *parser) whileCommand() (command, error) {
+	cmd := &whileCommand{until: p.keyword() == "until"}
+	p.next()
+	cond, err := p.commands("do")
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("do"); err != nil {
+		return nil, err
+	}
+	body, err := p.commands("done")
+	if err != nil {
+		return nil, err
+	}
+	cmd.cond, cmd.body = cond, body
+	return cmd, p.expect("done")
+}
+
+func (p *parser) functionCommand() (command, error) {
+	p.next()
+	name := p.keyword()
+	if name == "" {
+		return nil, p.unexpected(p.peek())
+	}
+	p.next()
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	return &functionCommand{name: name, body: body}, nil
+}
+
+func (p *parser) menuCommand() (command, error) {
+	submenu := p.keyword() == "submenu"
+	cmd := &menuCommand{submenu: submenu, line: p.next().line}
+	for p.peek().typ == tokWord {
+		cmd.args = append(cmd.args, p.next().word)
+	}
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	cmd.body = body
+	return cmd, nil
+}
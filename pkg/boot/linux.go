@@ -11,7 +11,10 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"syscall"
 
+	"github.com/u-root/u-root/pkg/boot/linux"
+	"github.com/u-root/u-root/pkg/boot/vboot"
 	"github.com/u-root/u-root/pkg/kexec"
 	"github.com/u-root/u-root/pkg/uio"
 )
@@ -21,6 +24,32 @@ type LinuxImage struct {
 	Kernel  io.ReaderAt
 	Initrd  io.ReaderAt
 	Cmdline string
+
+	// KexecLoad loads the kernel with the kexec_load system call instead
+	// of kexec_file_load, which is not available on all architectures
+	// and may require signed kernels. See package linux.
+	//
+	// kexec_load is also used if kexec_file_load is not available, as
+	// on arm.
+	KexecLoad bool
+
+	// DTB, if not nil, is the device tree the kernel boots with, instead
+	// of the running kernel's. It implies KexecLoad, as kexec_file_load
+	// cannot pass a device tree.
+	DTB io.ReaderAt
+
+	// Verify, if not nil, says whether the kernel, initrd and command line
+	// must be signed. They are verified before they are loaded. See
+	// package vboot.
+	Verify *vboot.Policy
+
+	// KernelSig, InitrdSig, DTBSig and CmdlineSig are the detached
+	// signatures of Kernel, Initrd, DTB and Cmdline. A nil signature means
+	// that part is not signed.
+	KernelSig  io.ReaderAt
+	InitrdSig  io.ReaderAt
+	DTBSig     io.ReaderAt
+	CmdlineSig io.ReaderAt
 }
 
 var _ OSImage = &LinuxImage{}
@@ -30,6 +59,11 @@ func (li *LinuxImage) String() string {
 	return fmt.Sprintf("LinuxImage(\n  Kernel: %s\n  Initrd: %s\n  Cmdline: %s\n)\n", li.Kernel, li.Initrd, li.Cmdline)
 }
 
+// SetVerifyPolicy implements Verifiable.
+func (li *LinuxImage) SetVerifyPolicy(p *vboot.Policy) {
+	li.Verify = p
+}
+
 func copyToFile(r io.Reader) (*os.File, error) {
 	f, err := ioutil.TempFile("", "nerf-netboot")
 	if err != nil {
@@ -87,10 +121,48 @@ func (li *LinuxImage) Load(verbose bool) error {
 		defer i.Close()
 	}
 
+	var dtb *os.File
+	if li.DTB != nil {
+		dtb, err = copyToFile(uio.Reader(li.DTB))
+		if err != nil {
+			return err
+		}
+		defer dtb.Close()
+	}
+
+	// Verify the copies, which cannot change anymore.
+	if err := li.Verify.Check("kernel", k, li.KernelSig); err != nil {
+		return err
+	}
+	if i != nil {
+		if err := li.Verify.Check("initrd", i, li.InitrdSig); err != nil {
+			return err
+		}
+	}
+	if dtb != nil {
+		if err := li.Verify.Check("device tree", dtb, li.DTBSig); err != nil {
+			return err
+		}
+	}
+	if err := li.Verify.CheckString("command line", li.Cmdline, li.CmdlineSig); err != nil {
+		return err
+	}
+
 	log.Printf("Kernel: %s", k.Name())
 	if i != nil {
 		log.Printf("Initrd: %s", i.Name())
 	}
+	if dtb != nil {
+		log.Printf("Device tree: %s", dtb.Name())
+	}
 	log.Printf("Command line: %s", li.Cmdline)
-	return kexec.FileLoad(k, i, li.Cmdline)
+	if li.KexecLoad || dtb != nil {
+		return linux.KexecLoad(k, i, dtb, li.Cmdline)
+	}
+	err = kexec.FileLoad(k, i, li.Cmdline)
+	if err == syscall.ENOSYS {
+		log.Printf("kexec_file_load is not available, using kexec_load")
+		return linux.KexecLoad(k, i, nil, li.Cmdline)
+	}
+	return err
 }
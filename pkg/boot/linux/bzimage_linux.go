@@ -0,0 +1,271 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package linux loads Linux kernels with the kexec_load system call.
+//
+// Unlike kexec_file_load, kexec_load is available on all architectures and
+// is not subject to the kernel's signature checks, but the loader has to lay
+// out the new kernel, its boot parameters and a purgatory that jumps to it in
+// memory itself. Package linux does this for x86-64 bzImages, arm64 Images
+// and arm zImages. arm and arm64 kernels get the running kernel's device tree,
+// with its /chosen node fixed up for the new kernel.
+package linux
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/bzimage"
+	"github.com/u-root/u-root/pkg/kexec"
+)
+
+// Offsets of the boot_params fields that are not part of the setup header.
+const (
+	bootParamsSize = 0x1000
+
+	acpiRSDPAddr    = 0x070
+	extRamdiskImage = 0x0c0
+	extRamdiskSize  = 0x0c4
+	extCmdLinePtr   = 0x0c8
+
+	setupHeader = 0x1f1
+
+	typeOfLoader = 0x210
+	code32Start  = 0x214
+	ramdiskImage = 0x218
+	ramdiskSize  = 0x21c
+	cmdLinePtr   = 0x228
+)
+
+// minProtocol is the boot protocol version that introduced XLoadFlags, and
+// thereby the 64-bit entry point.
+const minProtocol = 0x020c
+
+// pageSize is the page size of the running kernel, which kexec segments must
+// be aligned to.
+var pageSize = uint(os.Getpagesize())
+
+// loadRange is where everything is loaded: above 1M and below 4G.
+var loadRange = func() kexec.Range {
+	end := uint64(1 << 32)
+	if uint64(kexec.MaxAddr) < end {
+		end = uint64(kexec.MaxAddr)
+	}
+	return kexec.RangeFromInterval(0x100000, uintptr(end))
+}()
+
+func alignUp(v, align uint) uint {
+	return (v + align - 1) &^ (align - 1)
+}
+
+// addSegment adds a kexec segment with d of at least size bytes, aligned to
+// align, in limit.
+func addSegment(mem *kexec.Memory, d []byte, size, align uint, limit kexec.Range) (kexec.Range, error) {
+	if uint(len(d)) > size {
+		size = uint(len(d))
+	}
+	r, err := mem.AvailableRAM().FindAlignedSpaceIn(alignUp(size, pageSize), align, limit)
+	if err != nil {
+		return kexec.Range{}, err
+	}
+	mem.Segments.Insert(kexec.NewSegment(d, r))
+	return r, nil
+}
+
+// e820Entry returns the E820 entry of r.
+func e820Entry(r kexec.TypedRange) bzimage.E820Entry {
+	e := bzimage.E820Entry{
+		Addr:    uint64(r.Start),
+		Size:    uint64(r.Size),
+		MemType: bzimage.Reserved,
+	}
+	switch r.Type {
+	case kexec.RangeRAM:
+		e.MemType = bzimage.Ram
+	case kexec.RangeACPI:
+		e.MemType = bzimage.ACPI
+	case kexec.RangeNVS:
+		e.MemType = bzimage.NVS
+	}
+	return e
+}
+
+// bzImageOpts are the parameters of a bzImage to load.
+type bzImageOpts struct {
+	kernel  []byte
+	initrd  []byte
+	cmdline string
+
+	// rsdp is the physical address of the ACPI RSDP, or 0 to let the
+	// kernel search for it.
+	rsdp uint64
+}
+
+// loadBzImage lays out the bzImage in o, its boot_params, and the purgatory
+// in mem, and returns the entry point to kexec to.
+//
+// mem.Phys must be the memory map of the machine. It is passed to the new
+// kernel as its E820 table.
+func loadBzImage(mem *kexec.Memory, o bzImageOpts) (uintptr, error) {
+	h, err := bzimage.ParseHeader(o.kernel)
+	if err != nil {
+		return 0, err
+	}
+	if h.Protocolversion < minProtocol {
+		return 0, fmt.Errorf("boot protocol version %#x is too old, need %#x", h.Protocolversion, minProtocol)
+	}
+	if h.XLoadFlags&bzimage.XLFKernel64 == 0 {
+		return 0, fmt.Errorf("kernel has no 64-bit entry point")
+	}
+	if uint32(len(o.cmdline)) >= h.CmdLineSize {
+		return 0, fmt.Errorf("command line is %d bytes, kernel accepts at most %d", len(o.cmdline), h.CmdLineSize-1)
+	}
+
+	setupSects := uint(h.SetupSects)
+	if setupSects == 0 {
+		setupSects = 4
+	}
+	codeOffset := (setupSects + 1) * 512
+	if uint(len(o.kernel)) <= codeOffset {
+		return 0, fmt.Errorf("bzImage is %d bytes, too short for %d setup sectors", len(o.kernel), setupSects)
+	}
+	code := o.kernel[codeOffset:]
+
+	// The kernel decompresses itself within InitSize bytes of where it
+	// is loaded.
+	var kernel kexec.Range
+	if h.RelocatableKernel != 0 {
+		align := uint(h.Kernelalignment)
+		if align < pageSize {
+			align = pageSize
+		}
+		kernel, err = addSegment(mem, code, uint(h.InitSize), align, loadRange)
+	} else {
+		start := uintptr(h.PrefAddress)
+		kernel, err = addSegment(mem, code, uint(h.InitSize), pageSize, kexec.RangeFromInterval(start, loadRange.End()))
+		if err == nil && kernel.Start != start {
+			err = fmt.Errorf("%#x is not free", start)
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cannot load kernel: %v", err)
+	}
+	log.Printf("Kernel: %s", kernel)
+
+	var initrd kexec.Range
+	if len(o.initrd) > 0 {
+		// InitrdAddrMax is the highest address the initrd may use.
+		end := uint64(h.InitrdAddrMax) + 1
+		if end > uint64(loadRange.End()) {
+			end = uint64(loadRange.End())
+		}
+		limit := kexec.RangeFromInterval(loadRange.Start, uintptr(end))
+		if initrd, err = addSegment(mem, o.initrd, 0, pageSize, limit); err != nil {
+			return 0, fmt.Errorf("cannot load initrd: %v", err)
+		}
+		log.Printf("Initrd: %s", initrd)
+	}
+
+	cmdline, err := addSegment(mem, append([]byte(o.cmdline), 0), 0, pageSize, loadRange)
+	if err != nil {
+		return 0, fmt.Errorf("cannot load command line: %v", err)
+	}
+
+	bp, err := bootParams(o, h, mem.Phys, kernel.Start, initrd.Start, cmdline.Start)
+	if err != nil {
+		return 0, err
+	}
+	params, err := addSegment(mem, bp, 0, pageSize, loadRange)
+	if err != nil {
+		return 0, fmt.Errorf("cannot load boot_params: %v", err)
+	}
+
+	// The 64-bit entry point is 0x200 past the start of the kernel.
+	entry := kernel.Start + 0x200
+	r, err := mem.AvailableRAM().FindAlignedSpaceIn(purgatorySize, pageSize, loadRange)
+	if err != nil {
+		return 0, fmt.Errorf("cannot load purgatory: %v", err)
+	}
+	mem.Segments.Insert(kexec.NewSegment(purgatory(r.Start, entry, params.Start), r))
+	log.Printf("Purgatory: %s, boot_params: %s, kernel entry point: %#x", r, params, entry)
+	return r.Start, nil
+}
+
+// bootParams returns the boot_params of the bzImage in o with header h, loaded
+// at kernel, with its initrd at initrd, its command line at cmdline, and the
+// memory map phys.
+func bootParams(o bzImageOpts, h *bzimage.LinuxHeader, phys kexec.MemoryMap, kernel, initrd, cmdline uintptr) ([]byte, error) {
+	// boot_params starts out as the kernel's setup header, which ends
+	// at 0x202 plus the offset in the jump instruction at 0x201.
+	bp := make([]byte, bootParamsSize)
+	headerEnd := 0x202 + int(o.kernel[0x201])
+	if headerEnd > len(o.kernel) || headerEnd > bzimage.E820Map {
+		return nil, fmt.Errorf("invalid setup header end %#x", headerEnd)
+	}
+	copy(bp[setupHeader:headerEnd], o.kernel[setupHeader:headerEnd])
+
+	le := binary.LittleEndian
+	bp[typeOfLoader] = 0xff
+	le.PutUint32(bp[code32Start:], uint32(kernel))
+	le.PutUint32(bp[ramdiskImage:], uint32(initrd))
+	le.PutUint32(bp[extRamdiskImage:], uint32(uint64(initrd)>>32))
+	le.PutUint32(bp[ramdiskSize:], uint32(len(o.initrd)))
+	le.PutUint32(bp[extRamdiskSize:], uint32(uint64(len(o.initrd))>>32))
+	le.PutUint32(bp[cmdLinePtr:], uint32(cmdline))
+	le.PutUint32(bp[extCmdLinePtr:], uint32(uint64(cmdline)>>32))
+	// acpi_rsdp_addr was added in 2.14.
+	if o.rsdp != 0 && h.Protocolversion >= 0x020e {
+		le.PutUint64(bp[acpiRSDPAddr:], o.rsdp)
+	}
+
+	if len(phys) > bzimage.E820Max {
+		return nil, fmt.Errorf("memory map has %d entries, boot_params only fit %d", len(phys), bzimage.E820Max)
+	}
+	var e820 bytes.Buffer
+	for _, r := range phys {
+		if err := binary.Write(&e820, le, e820Entry(r)); err != nil {
+			return nil, err
+		}
+	}
+	copy(bp[bzimage.E820Map:], e820.Bytes())
+	bp[bzimage.E820NR] = uint8(len(phys))
+	return bp, nil
+}
+
+var efiSystab = "/sys/firmware/efi/systab"
+
+// acpiRSDP returns the address of the ACPI RSDP if the system was booted by
+// EFI firmware, which Linux only finds with the EFI system table. It returns
+// 0 if the address is unknown.
+func acpiRSDP() uint64 {
+	f, err := os.Open(efiSystab)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	var rsdp uint64
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		kv := strings.SplitN(s.Text(), "=", 2)
+		if len(kv) != 2 || (kv[0] != "ACPI20" && kv[0] != "ACPI") {
+			continue
+		}
+		addr, err := strconv.ParseUint(kv[1], 0, 64)
+		if err != nil {
+			continue
+		}
+		// Prefer the ACPI 2.0 table.
+		if rsdp == 0 || kv[0] == "ACPI20" {
+			rsdp = addr
+		}
+	}
+	return rsdp
+}
@@ -0,0 +1,252 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/bzimage"
+	"github.com/u-root/u-root/pkg/kexec"
+)
+
+// testKernel is a non-relocatable kernel with a 64-bit entry point that is
+// loaded at 16M.
+const testKernel = "../../bzimage/testdata/bzImage"
+
+var testPhys = kexec.MemoryMap{
+	{Range: kexec.RangeFromInterval(0, 0x9f000), Type: kexec.RangeRAM},
+	{Range: kexec.RangeFromInterval(0xf0000, 0x100000), Type: kexec.RangeReserved},
+	{Range: kexec.RangeFromInterval(0x100000, 0x20000000), Type: kexec.RangeRAM},
+	{Range: kexec.RangeFromInterval(0x20000000, 0x20010000), Type: kexec.RangeACPI},
+}
+
+func readKernel(t *testing.T) []byte {
+	k, err := ioutil.ReadFile(testKernel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// segmentAt returns the segment that starts at p.
+func segmentAt(segs kexec.Segments, p uintptr) *kexec.Segment {
+	for i := range segs {
+		if segs[i].Phys.Start == p {
+			return &segs[i]
+		}
+	}
+	return nil
+}
+
+func TestLoadBzImage(t *testing.T) {
+	kernel := readKernel(t)
+	h, err := bzimage.ParseHeader(kernel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mem := &kexec.Memory{Phys: testPhys}
+	o := bzImageOpts{
+		kernel:  kernel,
+		initrd:  []byte("070701 initramfs"),
+		cmdline: "console=ttyS0",
+	}
+	entry, err := loadBzImage(mem, o)
+	if err != nil {
+		t.Fatalf("loadBzImage() = %v", err)
+	}
+
+	// Kernel, initrd, command line, boot_params, and purgatory.
+	if len(mem.Segments) != 5 {
+		t.Fatalf("loadBzImage() added segments %v, want 5", mem.Segments)
+	}
+	ram := kexec.RangeFromInterval(loadRange.Start, 0x20000000)
+	for i, s := range mem.Segments {
+		if !ram.IsSupersetOf(s.Phys) || s.Phys.Start%uintptr(pageSize) != 0 {
+			t.Errorf("segment %s is not a page-aligned segment of RAM above 1M", s)
+		}
+		if i > 0 && mem.Segments[i-1].Phys.Overlaps(s.Phys) {
+			t.Errorf("segments %s and %s overlap", mem.Segments[i-1], s)
+		}
+	}
+
+	k := segmentAt(mem.Segments, uintptr(h.PrefAddress))
+	if k == nil {
+		t.Fatalf("no segment at the kernel's preferred address %#x: %v", h.PrefAddress, mem.Segments)
+	}
+	codeSize := uint(len(kernel)) - (uint(h.SetupSects)+1)*512
+	if k.Buf.Size != codeSize || k.Phys.Size < uint(h.InitSize) {
+		t.Errorf("kernel segment is %s, want %#x bytes of code in %#x bytes", k, codeSize, h.InitSize)
+	}
+	p := segmentAt(mem.Segments, entry)
+	if p == nil || p.Phys.Size != purgatorySize {
+		t.Errorf("entry point %#x is not the start of the purgatory: %v", entry, mem.Segments)
+	}
+}
+
+func TestBootParams(t *testing.T) {
+	kernel := readKernel(t)
+	h, err := bzimage.ParseHeader(kernel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := bzImageOpts{
+		kernel:  kernel,
+		initrd:  make([]byte, 0x1234),
+		cmdline: "console=ttyS0",
+		rsdp:    0xf5000,
+	}
+	bp, err := bootParams(o, h, testPhys, 0x1000000, 0x2000000, 0x3000000)
+	if err != nil {
+		t.Fatalf("bootParams() = %v", err)
+	}
+
+	le := binary.LittleEndian
+	if got := string(bp[0x202:0x206]); got != "HdrS" {
+		t.Errorf("setup header magic = %q, want HdrS", got)
+	}
+	for _, tt := range []struct {
+		name string
+		off  int
+		want uint32
+	}{
+		{"code32_start", code32Start, 0x1000000},
+		{"ramdisk_image", ramdiskImage, 0x2000000},
+		{"ramdisk_size", ramdiskSize, 0x1234},
+		{"cmd_line_ptr", cmdLinePtr, 0x3000000},
+		{"ext_cmd_line_ptr", extCmdLinePtr, 0},
+		{"kernel_alignment", 0x230, h.Kernelalignment},
+	} {
+		if got := le.Uint32(bp[tt.off:]); got != tt.want {
+			t.Errorf("%s = %#x, want %#x", tt.name, got, tt.want)
+		}
+	}
+	if bp[typeOfLoader] != 0xff {
+		t.Errorf("type_of_loader = %#x, want 0xff", bp[typeOfLoader])
+	}
+	// The test kernel implements boot protocol 2.13, which does not
+	// have acpi_rsdp_addr.
+	if got := le.Uint64(bp[acpiRSDPAddr:]); got != 0 {
+		t.Errorf("acpi_rsdp_addr = %#x, want 0", got)
+	}
+	// The boot sector code is not part of boot_params.
+	for i := 0; i < setupHeader; i++ {
+		if bp[i] != 0 && i != bzimage.E820NR {
+			t.Fatalf("boot_params[%#x] = %#x, want 0", i, bp[i])
+		}
+	}
+
+	if bp[bzimage.E820NR] != uint8(len(testPhys)) {
+		t.Fatalf("e820_entries = %d, want %d", bp[bzimage.E820NR], len(testPhys))
+	}
+	for i, want := range []struct {
+		addr, size uint64
+		typ        uint32
+	}{
+		{0, 0x9f000, 1},
+		{0xf0000, 0x10000, 2},
+		{0x100000, 0x1ff00000, 1},
+		{0x20000000, 0x10000, 3},
+	} {
+		e := bp[bzimage.E820Map+20*i:]
+		if addr, size, typ := le.Uint64(e), le.Uint64(e[8:]), le.Uint32(e[16:]); addr != want.addr || size != want.size || typ != want.typ {
+			t.Errorf("e820 entry %d = (%#x, %#x, %d), want (%#x, %#x, %d)", i, addr, size, typ, want.addr, want.size, want.typ)
+		}
+	}
+}
+
+func TestLoadBzImageErrors(t *testing.T) {
+	kernel := readKernel(t)
+	noKernel64 := append([]byte{}, kernel...)
+	binary.LittleEndian.PutUint16(noKernel64[0x236:], 0)
+
+	for _, tt := range []struct {
+		name    string
+		phys    kexec.MemoryMap
+		o       bzImageOpts
+		wantErr string
+	}{
+		{
+			name:    "not a bzImage",
+			phys:    testPhys,
+			o:       bzImageOpts{kernel: make([]byte, 0x1000)},
+			wantErr: "Not a bzImage",
+		},
+		{
+			name:    "no 64-bit entry point",
+			phys:    testPhys,
+			o:       bzImageOpts{kernel: noKernel64},
+			wantErr: "no 64-bit entry point",
+		},
+		{
+			name:    "command line too long",
+			phys:    testPhys,
+			o:       bzImageOpts{kernel: kernel, cmdline: strings.Repeat("a", 2048)},
+			wantErr: "command line is 2048 bytes",
+		},
+		{
+			name: "preferred address is not RAM",
+			phys: kexec.MemoryMap{
+				{Range: kexec.RangeFromInterval(0x100000, 0x800000), Type: kexec.RangeRAM},
+				{Range: kexec.RangeFromInterval(0x2000000, 0x20000000), Type: kexec.RangeRAM},
+			},
+			o:       bzImageOpts{kernel: kernel},
+			wantErr: "0x1000000 is not free",
+		},
+		{
+			name: "initrd too large",
+			phys: testPhys,
+			o: bzImageOpts{
+				kernel: kernel,
+				initrd: make([]byte, 0x20000000),
+			},
+			wantErr: "cannot load initrd",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := &kexec.Memory{Phys: tt.phys}
+			if _, err := loadBzImage(mem, tt.o); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadBzImage() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestACPIRSDP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "linux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(old string) { efiSystab = old }(efiSystab)
+
+	for _, tt := range []struct {
+		systab string
+		want   uint64
+	}{
+		{"ACPI=0xe0000\nSMBIOS=0xf0000\n", 0xe0000},
+		{"ACPI20=0x7fb7e014\nACPI=0x7fb7e000\nSMBIOS=0xf0000\n", 0x7fb7e014},
+		{"ACPI=0x7fb7e000\nACPI20=0x7fb7e014\n", 0x7fb7e014},
+		{"SMBIOS=0xf0000\n", 0},
+	} {
+		efiSystab = filepath.Join(dir, "systab")
+		if err := ioutil.WriteFile(efiSystab, []byte(tt.systab), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := acpiRSDP(); got != tt.want {
+			t.Errorf("acpiRSDP(%q) = %#x, want %#x", tt.systab, got, tt.want)
+		}
+	}
+
+	efiSystab = filepath.Join(dir, "nonexistent")
+	if got := acpiRSDP(); got != 0 {
+		t.Errorf("acpiRSDP() without EFI = %#x, want 0", got)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/u-root/u-root/pkg/dt"
+	"github.com/u-root/u-root/pkg/kexec"
+)
+
+// fdtPath is the device tree the running kernel was booted with.
+var fdtPath = "/sys/firmware/fdt"
+
+// dtOpts are the parameters of an arm or arm64 kernel to load.
+type dtOpts struct {
+	kernel  []byte
+	initrd  []byte
+	cmdline string
+
+	// fdt is the device tree of the machine. Its /chosen node is fixed
+	// up for the new kernel.
+	fdt *dt.FDT
+
+	// kaslrSeed randomizes the new kernel's address space, if it is not
+	// 0 and the kernel supports it.
+	kaslrSeed uint64
+}
+
+// readDTOpts reads the kernel, initrd and device tree to load. If dtb is
+// nil, the device tree is the running kernel's.
+func readDTOpts(kernel, ramfs, dtb *os.File, cmdline string) (dtOpts, error) {
+	o := dtOpts{cmdline: cmdline}
+	var err error
+	if o.kernel, err = ioutil.ReadAll(kernel); err != nil {
+		return o, err
+	}
+	if ramfs != nil {
+		if o.initrd, err = ioutil.ReadAll(ramfs); err != nil {
+			return o, err
+		}
+	}
+	if dtb == nil {
+		f, err := os.Open(fdtPath)
+		if err != nil {
+			return o, err
+		}
+		defer f.Close()
+		dtb = f
+	}
+	if o.fdt, err = dt.ReadFDT(dtb); err != nil {
+		return o, fmt.Errorf("error reading %s: %v", dtb.Name(), err)
+	}
+	return o, nil
+}
+
+// reserveFDT excludes the memory reservations of fdt from the RAM in mem.
+func reserveFDT(mem *kexec.Memory, fdt *dt.FDT) {
+	for _, r := range fdt.ReserveEntries {
+		mem.Phys.Insert(kexec.TypedRange{
+			Range: kexec.Range{Start: uintptr(r.Address), Size: uint(r.Size)},
+			Type:  kexec.RangeReserved,
+		})
+	}
+}
+
+func u64Prop(v uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return b[:]
+}
+
+// fixupChosen points the /chosen node of fdt at the command line and initrd
+// of the new kernel.
+func fixupChosen(fdt *dt.FDT, cmdline string, initrd kexec.Range, kaslrSeed uint64) {
+	chosen, ok := fdt.RootNode.LookChild("chosen")
+	if !ok {
+		chosen = &dt.Node{Name: "chosen"}
+		fdt.RootNode.Children = append(fdt.RootNode.Children, chosen)
+	}
+
+	chosen.UpdateProperty("bootargs", append([]byte(cmdline), 0))
+	if initrd.Size > 0 {
+		chosen.UpdateProperty("linux,initrd-start", u64Prop(uint64(initrd.Start)))
+		chosen.UpdateProperty("linux,initrd-end", u64Prop(uint64(initrd.End())))
+	} else {
+		chosen.RemoveProperty("linux,initrd-start")
+		chosen.RemoveProperty("linux,initrd-end")
+	}
+	// The running kernel zeroes the seed it used.
+	if kaslrSeed != 0 {
+		chosen.UpdateProperty("kaslr-seed", u64Prop(kaslrSeed))
+	} else {
+		chosen.RemoveProperty("kaslr-seed")
+	}
+	// kdump kernels are told about the crashed kernel's memory here.
+	chosen.RemoveProperty("linux,elfcorehdr")
+	chosen.RemoveProperty("linux,usable-memory-range")
+}
+
+// addFDT adds the device tree in o, fixed up for the new kernel with its
+// initrd at initrd, as a kexec segment of at most maxSize bytes in limit.
+func addFDT(mem *kexec.Memory, o dtOpts, initrd kexec.Range, maxSize uint, limit kexec.Range) (kexec.Range, error) {
+	fixupChosen(o.fdt, o.cmdline, initrd, o.kaslrSeed)
+	var b bytes.Buffer
+	if _, err := o.fdt.Write(&b); err != nil {
+		return kexec.Range{}, err
+	}
+	if uint(b.Len()) > maxSize {
+		return kexec.Range{}, fmt.Errorf("device tree is %d bytes, kernel accepts at most %d", b.Len(), maxSize)
+	}
+	r, err := addSegment(mem, b.Bytes(), 0, pageSize, limit)
+	if err != nil {
+		return kexec.Range{}, fmt.Errorf("cannot load device tree: %v", err)
+	}
+	return r, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/dt"
+	"github.com/u-root/u-root/pkg/kexec"
+)
+
+// testFDT is the device tree of an arm64 QEMU virt machine with RAM at 1G.
+const testFDT = "../../dt/testdata/fdt.dtb"
+
+func readFDT(t *testing.T) *dt.FDT {
+	f, err := os.Open(testFDT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fdt, err := dt.ReadFDT(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fdt
+}
+
+func TestFixupChosen(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		initrd    kexec.Range
+		kaslrSeed uint64
+		want      []dt.Property
+	}{
+		{
+			name:      "initrd",
+			initrd:    kexec.Range{Start: 0x48000000, Size: 0x1234},
+			kaslrSeed: 0x0123456789abcdef,
+			want: []dt.Property{
+				{Name: "linux,initrd-end", Value: []byte{0, 0, 0, 0, 0x48, 0, 0x12, 0x34}},
+				{Name: "linux,initrd-start", Value: []byte{0, 0, 0, 0, 0x48, 0, 0, 0}},
+				{Name: "stdout-path", Value: []byte("/pl011@9000000\x00")},
+				{Name: "bootargs", Value: []byte("console=ttyAMA0\x00")},
+				{Name: "kaslr-seed", Value: []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}},
+			},
+		},
+		{
+			name: "no initrd",
+			want: []dt.Property{
+				{Name: "stdout-path", Value: []byte("/pl011@9000000\x00")},
+				{Name: "bootargs", Value: []byte("console=ttyAMA0\x00")},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fdt := readFDT(t)
+			fixupChosen(fdt, "console=ttyAMA0", tt.initrd, tt.kaslrSeed)
+
+			// The fixed up device tree must survive a round trip.
+			var b bytes.Buffer
+			if _, err := fdt.Write(&b); err != nil {
+				t.Fatal(err)
+			}
+			fdt, err := dt.ReadFDT(bytes.NewReader(b.Bytes()))
+			if err != nil {
+				t.Fatalf("ReadFDT(fixed up FDT) = %v", err)
+			}
+			chosen, ok := fdt.RootNode.LookChild("chosen")
+			if !ok {
+				t.Fatalf("fixed up FDT has no /chosen")
+			}
+			if !reflect.DeepEqual(chosen.Properties, tt.want) {
+				t.Errorf("/chosen = %q, want %q", chosen.Properties, tt.want)
+			}
+		})
+	}
+}
+
+func TestFixupChosenMissing(t *testing.T) {
+	fdt := &dt.FDT{RootNode: &dt.Node{}}
+	fixupChosen(fdt, "quiet", kexec.Range{}, 0)
+	chosen, ok := fdt.RootNode.LookChild("chosen")
+	if !ok {
+		t.Fatalf("fixupChosen() did not add /chosen")
+	}
+	if p, ok := chosen.LookProperty("bootargs"); !ok || string(p.Value) != "quiet\x00" {
+		t.Errorf("/chosen/bootargs = %v, want quiet", p)
+	}
+}
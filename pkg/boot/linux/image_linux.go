@@ -0,0 +1,147 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+
+	"github.com/u-root/u-root/pkg/kexec"
+)
+
+// arm64Header is the header of an arm64 Image.
+//
+// The layout is defined in Linux:
+//     Documentation/arm64/booting.txt
+type arm64Header struct {
+	Code0      uint32
+	Code1      uint32
+	TextOffset uint64
+	ImageSize  uint64
+	Flags      uint64
+	Res2       uint64
+	Res3       uint64
+	Res4       uint64
+	Magic      uint32
+	Res5       uint32
+}
+
+// arm64Magic is "ARM\x64".
+const arm64Magic = 0x644d5241
+
+const (
+	// arm64KernelAlign is the alignment of the base the Image is loaded
+	// text_offset bytes above.
+	arm64KernelAlign = 2 << 20
+
+	// arm64MaxFDTSize is the largest device tree arm64 kernels accept.
+	arm64MaxFDTSize = 2 << 20
+
+	// The initrd and device tree must be within a 1G aligned window of
+	// up to 32G that also covers the kernel.
+	arm64WindowAlign = 1 << 30
+	arm64WindowSize  = 32 << 30
+)
+
+// arm64Purgatory returns the arm64 purgatory, which jumps to the kernel entry
+// point with the device tree at fdt in x0, as the boot protocol requires:
+//
+//     ldr  x0, fdt
+//     ldr  x4, entry
+//     mov  x1, xzr
+//     mov  x2, xzr
+//     mov  x3, xzr
+//     br   x4
+//   fdt:
+//     .quad fdt
+//   entry:
+//     .quad entry
+func arm64Purgatory(entry, fdt uintptr) []byte {
+	p := make([]byte, 0x28)
+	le := binary.LittleEndian
+	for i, insn := range []uint32{
+		0x580000c0,
+		0x580000e4,
+		0xaa1f03e1,
+		0xaa1f03e2,
+		0xaa1f03e3,
+		0xd61f0080,
+	} {
+		le.PutUint32(p[4*i:], insn)
+	}
+	le.PutUint64(p[0x18:], uint64(fdt))
+	le.PutUint64(p[0x20:], uint64(entry))
+	return p
+}
+
+// loadImage lays out the arm64 Image in o, its initrd, device tree and the
+// purgatory in mem, and returns the entry point to kexec to.
+func loadImage(mem *kexec.Memory, o dtOpts) (uintptr, error) {
+	var h arm64Header
+	if err := binary.Read(bytes.NewReader(o.kernel), binary.LittleEndian, &h); err != nil {
+		return 0, fmt.Errorf("not an arm64 Image: %v", err)
+	}
+	if h.Magic != arm64Magic {
+		return 0, fmt.Errorf("not an arm64 Image: magic is %#x, want %#x", h.Magic, arm64Magic)
+	}
+	// Kernels before Linux 3.17 do not say how much memory they need.
+	if h.ImageSize == 0 {
+		return 0, fmt.Errorf("arm64 Image has no image size, kernel is too old")
+	}
+	reserveFDT(mem, o.fdt)
+
+	// The kernel is loaded text_offset bytes above a 2M aligned base,
+	// and uses image_size bytes from there.
+	size := uint(h.ImageSize)
+	if uint(len(o.kernel)) > size {
+		size = uint(len(o.kernel))
+	}
+	size = alignUp(size, pageSize)
+	textOffset := uint(h.TextOffset)
+	base, err := mem.AvailableRAM().FindAlignedSpaceIn(textOffset+size, arm64KernelAlign, kexec.RangeFromInterval(0, kexec.MaxAddr))
+	if err != nil {
+		return 0, fmt.Errorf("cannot load kernel: %v", err)
+	}
+	kernel := kexec.Range{Start: base.Start + uintptr(textOffset), Size: size}
+	mem.Segments.Insert(kexec.NewSegment(o.kernel, kernel))
+	if textOffset > 0 {
+		mem.Phys.Insert(kexec.TypedRange{
+			Range: kexec.Range{Start: base.Start, Size: textOffset},
+			Type:  kexec.RangeReserved,
+		})
+	}
+	log.Printf("Kernel: %s", kernel)
+
+	start := uint64(kernel.Start) &^ (arm64WindowAlign - 1)
+	end := start + arm64WindowSize
+	if end > uint64(kexec.MaxAddr) {
+		end = uint64(kexec.MaxAddr)
+	}
+	window := kexec.RangeFromInterval(uintptr(start), uintptr(end))
+
+	var initrd kexec.Range
+	if len(o.initrd) > 0 {
+		if initrd, err = addSegment(mem, o.initrd, 0, pageSize, window); err != nil {
+			return 0, fmt.Errorf("cannot load initrd: %v", err)
+		}
+		// The kernel only uses the initrd's bytes, not the padding.
+		initrd.Size = uint(len(o.initrd))
+		log.Printf("Initrd: %s", initrd)
+	}
+
+	fdt, err := addFDT(mem, o, initrd, arm64MaxFDTSize, window)
+	if err != nil {
+		return 0, err
+	}
+
+	p, err := addSegment(mem, arm64Purgatory(kernel.Start, fdt.Start), 0, pageSize, window)
+	if err != nil {
+		return 0, fmt.Errorf("cannot load purgatory: %v", err)
+	}
+	log.Printf("Purgatory: %s, device tree: %s", p, fdt)
+	return p.Start, nil
+}
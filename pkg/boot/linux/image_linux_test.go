@@ -0,0 +1,142 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/dt"
+	"github.com/u-root/u-root/pkg/kexec"
+)
+
+var testARMPhys = kexec.MemoryMap{
+	{Range: kexec.RangeFromInterval(0x40000000, 0x40080000), Type: kexec.RangeReserved},
+	{Range: kexec.RangeFromInterval(0x40080000, 0x80000000), Type: kexec.RangeRAM},
+}
+
+// arm64Image returns an arm64 Image with the given header fields.
+func arm64Image(textOffset, imageSize uint64) []byte {
+	h := arm64Header{
+		Code0:      0x91005a4d, // add x13, x18, #0x16
+		Code1:      0x14000000,
+		TextOffset: textOffset,
+		ImageSize:  imageSize,
+		Flags:      0xa,
+		Magic:      arm64Magic,
+	}
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, h)
+	b.Write(make([]byte, 0x10000))
+	return b.Bytes()
+}
+
+func TestLoadImage(t *testing.T) {
+	mem := &kexec.Memory{Phys: append(kexec.MemoryMap{}, testARMPhys...)}
+	o := dtOpts{
+		kernel:  arm64Image(0x80000, 0x1000000),
+		initrd:  []byte("070701 initramfs"),
+		cmdline: "console=ttyAMA0",
+		fdt:     readFDT(t),
+	}
+	entry, err := loadImage(mem, o)
+	if err != nil {
+		t.Fatalf("loadImage() = %v", err)
+	}
+
+	// Kernel, initrd, device tree, and purgatory.
+	if len(mem.Segments) != 4 {
+		t.Fatalf("loadImage() added segments %v, want 4", mem.Segments)
+	}
+	for i, s := range mem.Segments {
+		if !testARMPhys[1].IsSupersetOf(s.Phys) || s.Phys.Start%uintptr(pageSize) != 0 {
+			t.Errorf("segment %s is not a page-aligned segment of RAM", s)
+		}
+		if i > 0 && mem.Segments[i-1].Phys.Overlaps(s.Phys) {
+			t.Errorf("segments %s and %s overlap", mem.Segments[i-1], s)
+		}
+	}
+
+	// The first 2M aligned base is 0x40200000, as 0x40000000 is
+	// reserved.
+	kernel := segmentAt(mem.Segments, 0x40280000)
+	if kernel == nil || kernel.Phys.Size != 0x1000000 {
+		t.Fatalf("kernel is not loaded at 0x40280000 with 16M: %v", mem.Segments)
+	}
+	if mem.Segments.PhysContains(0x40200000) {
+		t.Errorf("segments %v use the memory below text_offset", mem.Segments)
+	}
+
+	p := segmentAt(mem.Segments, entry)
+	if p == nil || p.Buf.Size != 0x28 {
+		t.Errorf("entry point %#x is not the start of the purgatory: %v", entry, mem.Segments)
+	}
+}
+
+func TestArm64Purgatory(t *testing.T) {
+	p := arm64Purgatory(0x40280000, 0x48000000)
+	le := binary.LittleEndian
+	// The literals are where the ldr instructions load them from.
+	for _, tt := range []struct {
+		insn int
+		want uint64
+	}{
+		{0, 0x48000000},
+		{1, 0x40280000},
+	} {
+		insn := le.Uint32(p[4*tt.insn:])
+		literal := 4*tt.insn + 4*int(insn>>5&0x7ffff)
+		if got := le.Uint64(p[literal:]); got != tt.want {
+			t.Errorf("ldr %d loads %#x, want %#x", tt.insn, got, tt.want)
+		}
+	}
+}
+
+func TestLoadImageErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		phys    kexec.MemoryMap
+		o       dtOpts
+		wantErr string
+	}{
+		{
+			name:    "not an Image",
+			phys:    testARMPhys,
+			o:       dtOpts{kernel: make([]byte, 0x1000), fdt: &dt.FDT{RootNode: &dt.Node{}}},
+			wantErr: "not an arm64 Image",
+		},
+		{
+			name:    "no image size",
+			phys:    testARMPhys,
+			o:       dtOpts{kernel: arm64Image(0x80000, 0), fdt: &dt.FDT{RootNode: &dt.Node{}}},
+			wantErr: "kernel is too old",
+		},
+		{
+			name:    "kernel too large",
+			phys:    testARMPhys,
+			o:       dtOpts{kernel: arm64Image(0x80000, 0x80000000), fdt: &dt.FDT{RootNode: &dt.Node{}}},
+			wantErr: "cannot load kernel",
+		},
+		{
+			name: "initrd too large",
+			phys: testARMPhys,
+			o: dtOpts{
+				kernel: arm64Image(0x80000, 0x1000000),
+				initrd: make([]byte, 0x40000000),
+				fdt:    &dt.FDT{RootNode: &dt.Node{}},
+			},
+			wantErr: "cannot load initrd",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := &kexec.Memory{Phys: append(kexec.MemoryMap{}, tt.phys...)}
+			if _, err := loadImage(mem, tt.o); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadImage() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
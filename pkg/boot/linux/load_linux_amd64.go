@@ -0,0 +1,49 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/u-root/u-root/pkg/kexec"
+)
+
+// KexecLoad loads the bzImage kernel as the new kernel with the given ramfs
+// and cmdline, using the kexec_load system call.
+//
+// ramfs may be nil. dtb must be nil, device trees are not supported on
+// amd64. After KexecLoad is called, kexec.Reboot() is ready to be called any
+// time to stop Linux and execute the loaded kernel.
+func KexecLoad(kernel, ramfs, dtb *os.File, cmdline string) error {
+	if dtb != nil {
+		return errors.New("device trees are not supported on amd64")
+	}
+	o := bzImageOpts{
+		cmdline: cmdline,
+		rsdp:    acpiRSDP(),
+	}
+	var err error
+	if o.kernel, err = ioutil.ReadAll(kernel); err != nil {
+		return err
+	}
+	if ramfs != nil {
+		if o.initrd, err = ioutil.ReadAll(ramfs); err != nil {
+			return err
+		}
+	}
+
+	var mem kexec.Memory
+	if err := mem.ParseMemoryMap(); err != nil {
+		return fmt.Errorf("error parsing memory map: %v", err)
+	}
+	entry, err := loadBzImage(&mem, o)
+	if err != nil {
+		return err
+	}
+	return kexec.Load(entry, mem.Segments, 0)
+}
@@ -0,0 +1,43 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"github.com/u-root/u-root/pkg/kexec"
+)
+
+// KexecLoad loads the arm64 Image kernel as the new kernel with the given
+// ramfs and cmdline, using the kexec_load system call.
+//
+// The new kernel gets the device tree dtb or, if dtb is nil, the device tree
+// of the running kernel, with /chosen pointing to its cmdline and ramfs and a
+// new KASLR seed.
+//
+// ramfs may be nil. After KexecLoad is called, kexec.Reboot() is ready to be
+// called any time to stop Linux and execute the loaded kernel.
+func KexecLoad(kernel, ramfs, dtb *os.File, cmdline string) error {
+	o, err := readDTOpts(kernel, ramfs, dtb, cmdline)
+	if err != nil {
+		return err
+	}
+	if err := binary.Read(rand.Reader, binary.LittleEndian, &o.kaslrSeed); err != nil {
+		return fmt.Errorf("error generating KASLR seed: %v", err)
+	}
+
+	var mem kexec.Memory
+	if mem.Phys, err = kexec.ParseIOMem(); err != nil {
+		return fmt.Errorf("error parsing memory map: %v", err)
+	}
+	entry, err := loadImage(&mem, o)
+	if err != nil {
+		return err
+	}
+	return kexec.Load(entry, mem.Segments, 0)
+}
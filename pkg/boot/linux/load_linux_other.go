@@ -0,0 +1,20 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux,!amd64,!arm64,!arm
+
+package linux
+
+import (
+	"os"
+	"syscall"
+)
+
+// KexecLoad loads the kernel as the new kernel with the given ramfs, device
+// tree and cmdline, using the kexec_load system call.
+//
+// KexecLoad is not implemented on this architecture.
+func KexecLoad(kernel, ramfs, dtb *os.File, cmdline string) error {
+	return syscall.ENOSYS
+}
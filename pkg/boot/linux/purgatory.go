@@ -0,0 +1,105 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// The x86-64 purgatory is a page of code and data that kexec jumps to in
+// 64-bit mode with all memory identity-mapped. It sets up the state the
+// 64-bit boot protocol requires and jumps to the kernel:
+//
+//     cli
+//     mov   rsp, base + purgatorySize  // stack at the end of the page
+//     lgdt  [rip + gdtr]
+//     mov   eax, 0x18                  // __BOOT_DS
+//     mov   ds, eax
+//     mov   es, eax
+//     mov   ss, eax
+//     mov   fs, eax
+//     mov   gs, eax
+//     push  0x10                       // __BOOT_CS
+//     mov   rax, base + next
+//     push  rax
+//     retfq
+//   next:
+//     mov   rsi, bootParams
+//     mov   rax, entry
+//     xor   ebp, ebp
+//     xor   edi, edi
+//     xor   ebx, ebx
+//     jmp   rax
+//
+// See Documentation/x86/boot.txt in the Linux source.
+const (
+	purgatorySize = 0x1000
+
+	// Offsets of the GDT and its descriptor in the purgatory.
+	purgatoryGDT  = 0x80
+	purgatoryGDTR = 0xa0
+)
+
+// gdt has the flat segments the 64-bit boot protocol requires.
+var gdt = [4]uint64{
+	0,
+	0,
+	0x00af9a000000ffff, // 0x10: 64-bit code
+	0x00cf92000000ffff, // 0x18: data
+}
+
+// purgatory returns the x86-64 purgatory to be loaded at base. It jumps to
+// the kernel entry point with the boot_params at bootParams.
+func purgatory(base, entry, bootParams uintptr) []byte {
+	p := make([]byte, 0, purgatorySize)
+	emit := func(b ...byte) {
+		p = append(p, b...)
+	}
+	imm64 := func(v uint64) {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], v)
+		emit(b[:]...)
+	}
+
+	emit(0xfa)       // cli
+	emit(0x48, 0xbc) // mov rsp, imm64
+	imm64(uint64(base) + purgatorySize)
+	emit(0x0f, 0x01, 0x15) // lgdt [rip + rel32]
+	var rel [4]byte
+	binary.LittleEndian.PutUint32(rel[:], uint32(purgatoryGDTR-(len(p)+len(rel))))
+	emit(rel[:]...)
+	emit(0xb8, 0x18, 0x00, 0x00, 0x00) // mov eax, 0x18
+	emit(0x8e, 0xd8)                   // mov ds, eax
+	emit(0x8e, 0xc0)                   // mov es, eax
+	emit(0x8e, 0xd0)                   // mov ss, eax
+	emit(0x8e, 0xe0)                   // mov fs, eax
+	emit(0x8e, 0xe8)                   // mov gs, eax
+	emit(0x6a, 0x10)                   // push 0x10
+	emit(0x48, 0xb8)                   // mov rax, imm64
+	// The immediate is followed by push rax and retfq.
+	imm64(uint64(base) + uint64(len(p)+8+3))
+	emit(0x50)       // push rax
+	emit(0x48, 0xcb) // retfq
+	emit(0x48, 0xbe) // mov rsi, imm64
+	imm64(uint64(bootParams))
+	emit(0x48, 0xb8) // mov rax, imm64
+	imm64(uint64(entry))
+	emit(0x31, 0xed) // xor ebp, ebp
+	emit(0x31, 0xff) // xor edi, edi
+	emit(0x31, 0xdb) // xor ebx, ebx
+	emit(0xff, 0xe0) // jmp rax
+
+	if len(p) > purgatoryGDT {
+		panic(fmt.Sprintf("purgatory code is %d bytes, overlaps GDT at %#x", len(p), purgatoryGDT))
+	}
+	p = p[:purgatorySize]
+	for i, d := range gdt {
+		binary.LittleEndian.PutUint64(p[purgatoryGDT+8*i:], d)
+	}
+	binary.LittleEndian.PutUint16(p[purgatoryGDTR:], uint16(len(gdt)*8-1))
+	binary.LittleEndian.PutUint64(p[purgatoryGDTR+2:], uint64(base)+purgatoryGDT)
+	return p
+}
@@ -0,0 +1,69 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestPurgatory(t *testing.T) {
+	const (
+		base       = 0x7000000
+		entry      = 0x1000200
+		bootParams = 0x6000000
+	)
+	p := purgatory(base, entry, bootParams)
+	if len(p) != purgatorySize {
+		t.Fatalf("purgatory is %d bytes, want %d", len(p), purgatorySize)
+	}
+	le := binary.LittleEndian
+
+	// mov rsp, imm64
+	if got := le.Uint64(p[3:]); got != base+purgatorySize {
+		t.Errorf("stack pointer = %#x, want %#x", got, base+purgatorySize)
+	}
+	// lgdt [rip + rel32]
+	if !bytes.Equal(p[11:14], []byte{0x0f, 0x01, 0x15}) {
+		t.Fatalf("instruction at 11 = %#x, want lgdt", p[11:14])
+	}
+	if got := 18 + int32(le.Uint32(p[14:])); got != purgatoryGDTR {
+		t.Errorf("lgdt loads GDT descriptor at %#x, want %#x", got, purgatoryGDTR)
+	}
+	if got, want := le.Uint16(p[purgatoryGDTR:]), uint16(len(gdt)*8-1); got != want {
+		t.Errorf("GDT limit = %#x, want %#x", got, want)
+	}
+	if got := le.Uint64(p[purgatoryGDTR+2:]); got != base+purgatoryGDT {
+		t.Errorf("GDT base = %#x, want %#x", got, base+purgatoryGDT)
+	}
+	for i, want := range gdt {
+		if got := le.Uint64(p[purgatoryGDT+8*i:]); got != want {
+			t.Errorf("GDT entry %d = %#x, want %#x", i, got, want)
+		}
+	}
+
+	// The far return jumps to mov rsi, bootParams.
+	i := bytes.Index(p, []byte{0x6a, 0x10, 0x48, 0xb8})
+	if i == -1 {
+		t.Fatalf("purgatory does not push the code segment")
+	}
+	next := le.Uint64(p[i+4:]) - base
+	if next >= purgatoryGDT || !bytes.Equal(p[next:next+2], []byte{0x48, 0xbe}) {
+		t.Fatalf("far return target %#x is not mov rsi", next)
+	}
+	if got := le.Uint64(p[next+2:]); got != bootParams {
+		t.Errorf("boot_params = %#x, want %#x", got, bootParams)
+	}
+	if !bytes.Equal(p[next+10:next+12], []byte{0x48, 0xb8}) {
+		t.Fatalf("instruction at %#x = %#x, want mov rax", next+10, p[next+10:next+12])
+	}
+	if got := le.Uint64(p[next+12:]); got != entry {
+		t.Errorf("entry point = %#x, want %#x", got, entry)
+	}
+	if !bytes.Equal(p[next+20:next+28], []byte{0x31, 0xed, 0x31, 0xff, 0x31, 0xdb, 0xff, 0xe0}) {
+		t.Errorf("purgatory does not end with jmp rax: %#x", p[next+20:next+28])
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+
+	"github.com/u-root/u-root/pkg/kexec"
+	"github.com/u-root/u-root/pkg/zimage"
+)
+
+const (
+	// The zImage decompresses the kernel to zImageTextOffset bytes above
+	// the 128M aligned base of the RAM it runs from. The kernel's page
+	// tables are just below that.
+	zImageRAMAlign   = 128 << 20
+	zImageTextOffset = 0x8000
+
+	// zImageSlack is room for the decompressor's stack and heap, which
+	// follow the zImage.
+	zImageSlack = 1 << 20
+
+	// zImageLowmem is how much memory above the base the kernel maps
+	// for certain. The initrd and device tree must be in it.
+	zImageLowmem = 768 << 20
+
+	// arm kernels accept device trees of any size.
+	armMaxFDTSize = ^uint(0)
+)
+
+// decompressedSize returns the memory the kernel in the zImage z, with the
+// contents kernel, needs once it is decompressed.
+func decompressedSize(z *zimage.ZImage, kernel []byte) (uint, error) {
+	piggySizeAddr, bssSize, err := z.GetKernelSizes()
+	if err != nil {
+		// Older zImages do not say; assume the usual compression
+		// ratio.
+		return 4 * uint(len(kernel)), nil
+	}
+	if uint(piggySizeAddr)+4 > uint(len(kernel)) {
+		return 0, fmt.Errorf("zImage kernel size at %#x is out of bounds", piggySizeAddr)
+	}
+	return uint(binary.LittleEndian.Uint32(kernel[piggySizeAddr:])) + uint(bssSize), nil
+}
+
+// loadZImage lays out the arm zImage in o, its initrd and device tree in mem,
+// and returns the entry point to kexec to.
+//
+// The arm kernel finds the device tree segment by its magic and passes its
+// address to the new kernel, so no purgatory is needed.
+func loadZImage(mem *kexec.Memory, o dtOpts) (uintptr, error) {
+	z, err := zimage.Parse(bytes.NewReader(o.kernel))
+	if err != nil {
+		return 0, err
+	}
+	size, err := decompressedSize(z, o.kernel)
+	if err != nil {
+		return 0, err
+	}
+	reserveFDT(mem, o.fdt)
+
+	ram := mem.Phys.FilterByType(kexec.RangeRAM)
+	if len(ram) == 0 {
+		return 0, fmt.Errorf("memory map has no RAM")
+	}
+	ram.Sort()
+	base := ram[0].Start &^ (zImageRAMAlign - 1)
+
+	// Keep the memory the kernel is decompressed to free, and load the
+	// zImage above it so it does not have to move itself out of the way.
+	decompressed := kexec.Range{Start: base, Size: alignUp(zImageTextOffset+size, pageSize)}
+	if r, err := mem.AvailableRAM().FindAlignedSpaceIn(decompressed.Size, pageSize, decompressed); err != nil || r.Start != base {
+		return 0, fmt.Errorf("cannot decompress kernel: %s is not free", decompressed)
+	}
+	mem.Phys.Insert(kexec.TypedRange{Range: decompressed, Type: kexec.RangeReserved})
+
+	kernel, err := addSegment(mem, o.kernel, uint(len(o.kernel))+zImageSlack, pageSize, kexec.RangeFromInterval(decompressed.End(), base+zImageRAMAlign))
+	if err != nil {
+		return 0, fmt.Errorf("cannot load kernel: %v", err)
+	}
+	log.Printf("Kernel: %s, decompressed to %s", kernel, decompressed)
+
+	end := uint64(base) + zImageLowmem
+	if end > uint64(kexec.MaxAddr) {
+		end = uint64(kexec.MaxAddr)
+	}
+	lowmem := kexec.RangeFromInterval(kernel.End(), uintptr(end))
+
+	var initrd kexec.Range
+	if len(o.initrd) > 0 {
+		if initrd, err = addSegment(mem, o.initrd, 0, pageSize, lowmem); err != nil {
+			return 0, fmt.Errorf("cannot load initrd: %v", err)
+		}
+		initrd.Size = uint(len(o.initrd))
+		log.Printf("Initrd: %s", initrd)
+	}
+
+	fdt, err := addFDT(mem, o, initrd, armMaxFDTSize, lowmem)
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("Device tree: %s", fdt)
+	return kernel.Start, nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/dt"
+	"github.com/u-root/u-root/pkg/kexec"
+)
+
+// testZImage has 0x2b83c bytes of BSS. The decompressed size at 0xd55f5 has
+// been zeroed out with the rest of the kernel.
+const testZImage = "../../zimage/testdata/zImage"
+
+func TestLoadZImage(t *testing.T) {
+	kernel, err := ioutil.ReadFile(testZImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	phys := kexec.MemoryMap{
+		{Range: kexec.RangeFromInterval(0x40000000, 0x80000000), Type: kexec.RangeRAM},
+	}
+	mem := &kexec.Memory{Phys: phys}
+	o := dtOpts{
+		kernel:  kernel,
+		initrd:  []byte("070701 initramfs"),
+		cmdline: "console=ttyAMA0",
+		fdt:     readFDT(t),
+	}
+	entry, err := loadZImage(mem, o)
+	if err != nil {
+		t.Fatalf("loadZImage() = %v", err)
+	}
+
+	// Kernel, initrd, and device tree.
+	if len(mem.Segments) != 3 {
+		t.Fatalf("loadZImage() added segments %v, want 3", mem.Segments)
+	}
+	lowmem := kexec.RangeFromInterval(0x40000000, 0x40000000+zImageLowmem)
+	for i, s := range mem.Segments {
+		if !lowmem.IsSupersetOf(s.Phys) || s.Phys.Start%uintptr(pageSize) != 0 {
+			t.Errorf("segment %s is not a page-aligned segment of lowmem", s)
+		}
+		if i > 0 && mem.Segments[i-1].Phys.Overlaps(s.Phys) {
+			t.Errorf("segments %s and %s overlap", mem.Segments[i-1], s)
+		}
+	}
+
+	// The kernel is decompressed to 0x40008000, and the zImage is loaded
+	// above it.
+	decompressed := kexec.RangeFromInterval(0x40000000, 0x40008000+0x2b83c)
+	for _, s := range mem.Segments {
+		if s.Phys.Overlaps(decompressed) {
+			t.Errorf("segment %s overlaps the decompressed kernel at %s", s, decompressed)
+		}
+	}
+	k := segmentAt(mem.Segments, entry)
+	if k == nil || k.Buf.Size != uint(len(kernel)) || entry >= 0x48000000 {
+		t.Errorf("entry point %#x is not the start of the zImage in the first 128M: %v", entry, mem.Segments)
+	}
+}
+
+func TestLoadZImageErrors(t *testing.T) {
+	kernel, err := ioutil.ReadFile(testZImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		phys    kexec.MemoryMap
+		kernel  []byte
+		wantErr string
+	}{
+		{
+			name: "not a zImage",
+			phys: kexec.MemoryMap{
+				{Range: kexec.RangeFromInterval(0x40000000, 0x80000000), Type: kexec.RangeRAM},
+			},
+			kernel:  make([]byte, 0x1000),
+			wantErr: "invalid zImage magic",
+		},
+		{
+			name: "decompressed kernel is reserved",
+			phys: kexec.MemoryMap{
+				{Range: kexec.RangeFromInterval(0x40000000, 0x40100000), Type: kexec.RangeReserved},
+				{Range: kexec.RangeFromInterval(0x40100000, 0x80000000), Type: kexec.RangeRAM},
+			},
+			kernel:  kernel,
+			wantErr: "cannot decompress kernel",
+		},
+		{
+			name:    "no RAM",
+			kernel:  kernel,
+			wantErr: "no RAM",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := &kexec.Memory{Phys: tt.phys}
+			o := dtOpts{kernel: tt.kernel, fdt: &dt.FDT{RootNode: &dt.Node{}}}
+			if _, err := loadZImage(mem, o); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadZImage() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
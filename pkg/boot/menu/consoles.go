@@ -0,0 +1,57 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package menu
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/cmdline"
+)
+
+type stdio struct {
+	io.Reader
+	io.Writer
+}
+
+// consoleDevices returns the devices named by the console= parameters of
+// the kernel command line, except the last one, which is /dev/console.
+func consoleDevices(cmdline string) []string {
+	var devs []string
+	for _, f := range strings.Fields(cmdline) {
+		if !strings.HasPrefix(f, "console=") {
+			continue
+		}
+		name := strings.TrimPrefix(f, "console=")
+		// console=ttyS0,115200n8
+		if i := strings.IndexByte(name, ','); i >= 0 {
+			name = name[:i]
+		}
+		devs = append(devs, filepath.Join("/dev", name))
+	}
+	if len(devs) <= 1 {
+		return nil
+	}
+	return devs[:len(devs)-1]
+}
+
+// Consoles returns the terminals to show a menu on: standard input and
+// output, which are usually /dev/console, and the other consoles named on
+// the kernel command line, e.g. serial lines.
+func Consoles() []io.ReadWriter {
+	terms := []io.ReadWriter{stdio{os.Stdin, os.Stdout}}
+	for _, dev := range consoleDevices(cmdline.FullCmdLine()) {
+		f, err := os.OpenFile(dev, os.O_RDWR, 0)
+		if err != nil {
+			log.Printf("Not showing the menu on %s: %v", dev, err)
+			continue
+		}
+		terms = append(terms, f)
+	}
+	return terms
+}
@@ -0,0 +1,306 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package menu shows a boot menu on the console and serial lines.
+//
+// The menu lists the entries and counts down to booting the default entry.
+// Input on any of the terminals stops the countdown; the menu then reads
+// the choice from that terminal. Choices are entry numbers, or e and an
+// entry number to edit the entry's kernel command line.
+//
+// Without terminals or a timeout, the menu does not wait for input and
+// chooses the default entry.
+package menu
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/u-root/u-root/pkg/boot"
+)
+
+// Entry is a menu entry.
+type Entry struct {
+	// Label is the text shown for the entry. If it is empty, the
+	// entry's Image is shown.
+	Label string
+
+	Image boot.OSImage
+}
+
+func (e *Entry) String() string {
+	if e.Label != "" {
+		return e.Label
+	}
+	return e.Image.String()
+}
+
+// Menu is a boot menu.
+type Menu struct {
+	Entries []*Entry
+
+	// Default is the index in Entries of the entry that is chosen when
+	// nobody chooses one.
+	Default int
+
+	// Timeout is how long the menu waits for input before choosing the
+	// default entry. If it is zero, the menu does not wait.
+	Timeout time.Duration
+
+	// Terminals are where the menu is shown and choices are read, e.g.
+	// the ones Consoles returns.
+	Terminals []io.ReadWriter
+
+	once   sync.Once
+	inputs chan input
+	// eof is the number of terminals that have no more input.
+	eof int
+}
+
+// New returns a menu of images. The default is the first image.
+func New(timeout time.Duration, terminals []io.ReadWriter, images ...boot.OSImage) *Menu {
+	m := &Menu{Timeout: timeout, Terminals: terminals}
+	for _, img := range images {
+		m.Entries = append(m.Entries, &Entry{Image: img})
+	}
+	return m
+}
+
+// input is a line read from one of the terminals.
+type input struct {
+	term io.ReadWriter
+	line string
+	eof  bool
+}
+
+// read starts reading lines from the terminals, once for the life of the
+// menu, so that lines are not lost between calls to Choose.
+func (m *Menu) read() <-chan input {
+	m.once.Do(func() {
+		m.inputs = make(chan input)
+		for _, t := range m.Terminals {
+			go func(t io.ReadWriter) {
+				r := bufio.NewReader(t)
+				for {
+					line, err := r.ReadString('\n')
+					line = strings.TrimSpace(line)
+					if err != nil && line == "" {
+						m.inputs <- input{term: t, eof: true}
+						return
+					}
+					m.inputs <- input{term: t, line: line}
+				}
+			}(t)
+		}
+	})
+	return m.inputs
+}
+
+// Choose shows the menu and returns the entry that was chosen.
+func (m *Menu) Choose() (*Entry, error) {
+	if len(m.Entries) == 0 {
+		return nil, errors.New("no entries to choose from")
+	}
+	if m.Default < 0 || m.Default >= len(m.Entries) {
+		m.Default = 0
+	}
+	def := m.Entries[m.Default]
+	if m.Timeout <= 0 || len(m.Terminals) == 0 {
+		return def, nil
+	}
+
+	inputs := m.read()
+	m.printAll(m.list())
+	deadline := time.Now().Add(m.Timeout)
+	timeout := time.NewTimer(m.Timeout)
+	defer timeout.Stop()
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		left := (time.Until(deadline) + time.Second - 1) / time.Second
+		m.printAll(fmt.Sprintf("\rBooting %s in %d seconds, press Enter to stop. ", def, left))
+		select {
+		case <-timeout.C:
+			m.printAll("\n")
+			return def, nil
+
+		case <-tick.C:
+
+		case in := <-inputs:
+			if in.eof {
+				m.eof++
+				if m.eof == len(m.Terminals) {
+					// Nobody is left to choose.
+					m.printAll("\n")
+					return def, nil
+				}
+				continue
+			}
+			m.printAll("\n")
+			return m.interact(in), nil
+		}
+	}
+}
+
+// interact reads choices from the terminal that in came from, starting
+// with in, until one is made.
+func (m *Menu) interact(in input) *Entry {
+	t := in.term
+	for {
+		if e, ok := m.choose(t, in.line); ok {
+			return e
+		}
+		fmt.Fprint(t, "Enter an entry number, e and a number to edit an entry, or nothing for the default: ")
+		var ok bool
+		if in, ok = m.next(t); !ok {
+			return m.Entries[m.Default]
+		}
+	}
+}
+
+// next returns the next line from the terminal t. It returns false if t has
+// no more input.
+func (m *Menu) next(t io.ReadWriter) (input, bool) {
+	for in := range m.read() {
+		if in.term != t {
+			continue
+		}
+		if in.eof {
+			m.eof++
+			return in, false
+		}
+		return in, true
+	}
+	return input{}, false
+}
+
+// choose handles the command line. It returns false if no entry was
+// chosen.
+func (m *Menu) choose(t io.ReadWriter, line string) (*Entry, bool) {
+	if line == "" {
+		return m.Entries[m.Default], true
+	}
+	f := strings.Fields(line)
+	if f[0] == "e" && len(f) == 2 {
+		e, err := m.entry(f[1])
+		if err != nil {
+			fmt.Fprintln(t, err)
+			return nil, false
+		}
+		m.edit(t, e)
+		fmt.Fprint(t, m.list())
+		return nil, false
+	}
+	e, err := m.entry(line)
+	if err != nil {
+		fmt.Fprintln(t, err)
+		return nil, false
+	}
+	return e, true
+}
+
+func (m *Menu) entry(s string) (*Entry, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n >= len(m.Entries) {
+		return nil, fmt.Errorf("%q is not an entry number", s)
+	}
+	return m.Entries[n], nil
+}
+
+// edit replaces the command line of e with one read from t.
+func (m *Menu) edit(t io.ReadWriter, e *Entry) {
+	cmdline, ok := Cmdline(e.Image)
+	if !ok {
+		fmt.Fprintf(t, "The command line of %s cannot be edited\n", e)
+		return
+	}
+	fmt.Fprintf(t, "Command line: %s\nNew command line (nothing keeps it): ", cmdline)
+	in, ok := m.next(t)
+	if !ok || in.line == "" {
+		return
+	}
+	SetCmdline(e.Image, in.line)
+}
+
+// list returns the menu's entries as text.
+func (m *Menu) list() string {
+	var b strings.Builder
+	b.WriteString("\n")
+	for i, e := range m.Entries {
+		fmt.Fprintf(&b, "  %d. %s\n", i, e)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+func (m *Menu) printAll(s string) {
+	for _, t := range m.Terminals {
+		io.WriteString(t, s)
+	}
+}
+
+// Cmdline returns the kernel command line of img, if it has one.
+func Cmdline(img boot.OSImage) (string, bool) {
+	switch i := img.(type) {
+	case *boot.LinuxImage:
+		return i.Cmdline, true
+	case *boot.MultibootImage:
+		return i.Cmdline, true
+	}
+	return "", false
+}
+
+// SetCmdline sets the kernel command line of img, if it has one.
+func SetCmdline(img boot.OSImage, cmdline string) {
+	switch i := img.(type) {
+	case *boot.LinuxImage:
+		i.Cmdline = cmdline
+	case *boot.MultibootImage:
+		i.Cmdline = cmdline
+	}
+}
+
+// Boot shows the menu, then loads and executes the entry that was chosen.
+// If loading an entry fails, it is removed and the menu is shown again,
+// with the next entry as the default.
+func (m *Menu) Boot(verbose bool) error {
+	for len(m.Entries) > 0 {
+		e, err := m.Choose()
+		if err != nil {
+			return err
+		}
+		if err := e.Image.Load(verbose); err != nil {
+			log.Printf("Failed to load %s: %v", e, err)
+			m.Remove(e)
+			continue
+		}
+		return boot.Execute()
+	}
+	return errors.New("nothing left to boot")
+}
+
+// Remove removes the entry e from the menu. If e is the default, the entry
+// after it becomes the default.
+func (m *Menu) Remove(e *Entry) {
+	for i := range m.Entries {
+		if m.Entries[i] != e {
+			continue
+		}
+		m.Entries = append(m.Entries[:i], m.Entries[i+1:]...)
+		if i < m.Default {
+			m.Default--
+		}
+		if m.Default >= len(m.Entries) {
+			m.Default = 0
+		}
+		return
+	}
+}
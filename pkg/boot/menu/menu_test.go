@@ -0,0 +1,199 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package menu
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/u-root/u-root/pkg/boot"
+)
+
+type terminal struct {
+	in  io.Reader
+	out bytes.Buffer
+}
+
+func newTerminal(input string) *terminal {
+	return &terminal{in: strings.NewReader(input)}
+}
+
+// silent is a terminal nobody types on.
+func silent() *terminal {
+	r, _ := io.Pipe()
+	return &terminal{in: r}
+}
+
+func (t *terminal) Read(b []byte) (int, error)  { return t.in.Read(b) }
+func (t *terminal) Write(b []byte) (int, error) { return t.out.Write(b) }
+func (t *terminal) String() string              { return t.out.String() }
+
+type image string
+
+func (i image) String() string          { return string(i) }
+func (i image) Load(verbose bool) error { return nil }
+
+func entries() []*Entry {
+	return []*Entry{
+		{Label: "zero", Image: &boot.LinuxImage{Cmdline: "console=ttyS0"}},
+		{Image: image("one")},
+		{Label: "two", Image: &boot.MultibootImage{Cmdline: "xen"}},
+	}
+}
+
+func TestChoose(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		input   string
+		def     int
+		timeout time.Duration
+		want    string
+		output  string
+	}{
+		{name: "no timeout", input: "1\n", def: 2, want: "two"},
+		{name: "nothing typed", input: "", def: 1, timeout: time.Minute, want: "one"},
+		{name: "enter", input: "\n", def: 2, timeout: time.Minute, want: "two", output: "Booting two in 60 seconds"},
+		{name: "number", input: "1\n", timeout: time.Minute, want: "one", output: "  1. one\n"},
+		{name: "retry", input: "x\n3\n2\n", timeout: time.Minute, want: "two", output: `"3" is not an entry number`},
+		{name: "no more input", input: "9", def: 1, timeout: time.Minute, want: "one"},
+		{name: "default out of range", def: 5, want: "zero"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			term := newTerminal(tt.input)
+			m := &Menu{
+				Entries:   entries(),
+				Default:   tt.def,
+				Timeout:   tt.timeout,
+				Terminals: []io.ReadWriter{term},
+			}
+			e, err := m.Choose()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.String() != tt.want {
+				t.Errorf("Choose() = %s, want %s", e, tt.want)
+			}
+			if !strings.Contains(term.String(), tt.output) {
+				t.Errorf("output %q does not contain %q", term.String(), tt.output)
+			}
+		})
+	}
+}
+
+func TestChooseTimeout(t *testing.T) {
+	term := silent()
+	m := &Menu{
+		Entries:   entries(),
+		Default:   1,
+		Timeout:   100 * time.Millisecond,
+		Terminals: []io.ReadWriter{term},
+	}
+	e, err := m.Choose()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.String() != "one" {
+		t.Errorf("Choose() = %s, want one", e)
+	}
+	if !strings.Contains(term.String(), "Booting one in 1 seconds") {
+		t.Errorf("output %q has no countdown", term.String())
+	}
+}
+
+func TestChooseSerial(t *testing.T) {
+	console, serial := silent(), newTerminal("2\n")
+	m := &Menu{
+		Entries:   entries(),
+		Timeout:   time.Minute,
+		Terminals: []io.ReadWriter{console, serial},
+	}
+	e, err := m.Choose()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.String() != "two" {
+		t.Errorf("Choose() = %s, want two", e)
+	}
+	for _, term := range []*terminal{console, serial} {
+		if !strings.Contains(term.String(), "  0. zero\n  1. one\n  2. two\n") {
+			t.Errorf("output %q does not list the entries", term.String())
+		}
+	}
+}
+
+func TestEdit(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"linux", "e 0\nconsole=tty0 quiet\n\n", []string{"console=tty0 quiet", "xen"}},
+		{"multiboot", "e 2\ndom0_mem=1G\n2\n", []string{"console=ttyS0", "dom0_mem=1G"}},
+		{"keep", "e 0\n\n0\n", []string{"console=ttyS0", "xen"}},
+		{"cannot edit", "e 1\n0\n", []string{"console=ttyS0", "xen"}},
+		{"bad entry", "e 7\n\n", []string{"console=ttyS0", "xen"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			term := newTerminal(tt.input)
+			m := &Menu{
+				Entries:   entries(),
+				Timeout:   time.Minute,
+				Terminals: []io.ReadWriter{term},
+			}
+			if _, err := m.Choose(); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, i := range []int{0, 2} {
+				cmdline, _ := Cmdline(m.Entries[i].Image)
+				got = append(got, cmdline)
+			}
+			if diff := deep.Equal(got, tt.want); diff != nil {
+				t.Errorf("%v\noutput: %s", diff, term.String())
+			}
+		})
+	}
+}
+
+func TestRemove(t *testing.T) {
+	for _, tt := range []struct {
+		def, remove int
+		want        string
+	}{
+		{def: 0, remove: 0, want: "one"},
+		{def: 1, remove: 0, want: "one"},
+		{def: 1, remove: 2, want: "one"},
+		{def: 2, remove: 2, want: "zero"},
+	} {
+		m := &Menu{Entries: entries(), Default: tt.def}
+		m.Remove(m.Entries[tt.remove])
+		if len(m.Entries) != 2 {
+			t.Fatalf("%d entries after remove, want 2", len(m.Entries))
+		}
+		if got := m.Entries[m.Default].String(); got != tt.want {
+			t.Errorf("default %d, remove %d: default = %s, want %s", tt.def, tt.remove, got, tt.want)
+		}
+	}
+}
+
+func TestConsoleDevices(t *testing.T) {
+	for _, tt := range []struct {
+		cmdline string
+		want    []string
+	}{
+		{"root=/dev/sda1 quiet", nil},
+		{"console=ttyS0,115200n8", nil},
+		{"console=ttyS0,115200n8 root=/dev/sda1 console=tty0", []string{"/dev/ttyS0"}},
+		{"console=ttyS1 console=ttyAMA0,115200 console=tty0", []string{"/dev/ttyS1", "/dev/ttyAMA0"}},
+	} {
+		if diff := deep.Equal(consoleDevices(tt.cmdline), tt.want); diff != nil {
+			t.Errorf("consoleDevices(%q): %v", tt.cmdline, diff)
+		}
+	}
+}
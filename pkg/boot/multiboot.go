@@ -5,27 +5,98 @@
 package boot
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"strings"
 
+	"github.com/u-root/u-root/pkg/boot/vboot"
 	"github.com/u-root/u-root/pkg/ibft"
 	"github.com/u-root/u-root/pkg/multiboot"
 )
 
 // MultibootImage is a multiboot-formated OSImage, such as ESXi, Xen, Akaros,
 // tboot.
+//
+// The kernel may have a Multiboot or a Multiboot2 header; Load boots it with
+// the one it has.
 type MultibootImage struct {
 	Path    string
 	Cmdline string
 	Modules []string
 	IBFT    *ibft.IBFT
+
+	// Verify, if not nil, says whether the kernel, command line and
+	// modules must be signed. They are verified before they are loaded.
+	// See package vboot.
+	Verify *vboot.Policy
+
+	// KernelSig is the detached signature of the kernel at Path.
+	KernelSig io.ReaderAt
+
+	// CmdlineSig is the detached signature of the command lines of the
+	// kernel and the modules, see vboot.MultibootCmdlines.
+	CmdlineSig io.ReaderAt
+
+	// ModuleSigs are the detached signatures of the module files, in the
+	// order of Modules.
+	ModuleSigs []io.ReaderAt
 }
 
 var _ OSImage = &MultibootImage{}
 
 // Load implements OSImage.Load.
 func (mi *MultibootImage) Load(verbose bool) error {
-	return multiboot.Load(verbose, mi.Path, mi.Cmdline, mi.Modules, mi.IBFT)
+	files, err := mi.readVerified()
+	if err != nil {
+		return err
+	}
+	return multiboot.LoadFiles(verbose, mi.Path, mi.Cmdline, mi.Modules, mi.IBFT, files)
+}
+
+// readVerified reads the kernel and module files once and verifies their
+// contents, which are then loaded and cannot change anymore. It returns the
+// contents by path.
+func (mi *MultibootImage) readVerified() (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	read := func(path string, sig io.ReaderAt) error {
+		b, ok := files[path]
+		if !ok {
+			var err error
+			if b, err = ioutil.ReadFile(path); err != nil {
+				return err
+			}
+			files[path] = b
+		}
+		return mi.Verify.Check(path, bytes.NewReader(b), sig)
+	}
+
+	if err := read(mi.Path, mi.KernelSig); err != nil {
+		return nil, err
+	}
+	if err := mi.Verify.CheckString("command line", vboot.MultibootCmdlines(mi.Cmdline, mi.Modules), mi.CmdlineSig); err != nil {
+		return nil, err
+	}
+	for i, m := range mi.Modules {
+		var sig io.ReaderAt
+		if i < len(mi.ModuleSigs) {
+			sig = mi.ModuleSigs[i]
+		}
+		path, err := multiboot.ModulePath(m)
+		if err != nil {
+			return nil, err
+		}
+		if err := read(path, sig); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// SetVerifyPolicy implements Verifiable.
+func (mi *MultibootImage) SetVerifyPolicy(p *vboot.Policy) {
+	mi.Verify = p
 }
 
 // String implements fmt.Stringer.
@@ -0,0 +1,204 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/boot/bootstate"
+	"github.com/u-root/u-root/pkg/boot/vboot"
+	"github.com/u-root/u-root/pkg/recovery"
+)
+
+// Attempt records one try to find candidates or to boot one.
+type Attempt struct {
+	Source string
+	// Candidate is empty if finding the source's candidates was tried.
+	Candidate string
+	// Try counts the attempts for the same candidate, from 1.
+	Try  int
+	Time time.Time
+	Err  error
+}
+
+func (a Attempt) String() string {
+	what := a.Source
+	if a.Candidate != "" {
+		what = fmt.Sprintf("%s %q", a.Source, a.Candidate)
+	}
+	result := "succeeded"
+	if a.Err != nil {
+		result = fmt.Sprintf("failed: %v", a.Err)
+	}
+	return fmt.Sprintf("%s: %s, try %d, %s", a.Time.Format(time.RFC3339), what, a.Try, result)
+}
+
+// Orchestrator boots the candidates of sources in the order of a policy.
+type Orchestrator struct {
+	Policy  *Policy
+	Sources map[string]Source
+
+	// Recoverer is called when no candidate booted. It may be nil.
+	Recoverer recovery.Recoverer
+
+	// Verbose is passed to the images' Load.
+	Verbose bool
+
+	// Attempts are all attempts so far, in order.
+	Attempts []Attempt
+
+	// Record is called with every attempt. It may be nil.
+	Record func(Attempt)
+
+	// State, if not nil, holds the entry to boot once and counts boots.
+	State *bootstate.Store
+
+	// Verify, if not nil, says whether candidates must be signed. See
+	// boot.SetVerifyPolicy.
+	Verify *vboot.Policy
+
+	// sleep and execute are replaced in tests.
+	sleep   func(time.Duration)
+	execute func() error
+}
+
+// New returns an orchestrator that boots the candidates of sources in the
+// order of p, and calls r when none booted.
+func New(p *Policy, sources map[string]Source, r recovery.Recoverer) *Orchestrator {
+	return &Orchestrator{
+		Policy:    p,
+		Sources:   sources,
+		Recoverer: r,
+		sleep:     time.Sleep,
+		execute:   boot.Execute,
+	}
+}
+
+// Boot tries the candidates of the sources in order until one boots. If
+// none does, it calls the Recoverer and returns an error.
+//
+// With a State, the entry to boot once is tried first, and candidates that
+// were booted Policy.MaxBoots times without confirmation are skipped.
+func (o *Orchestrator) Boot() error {
+	if err := o.Policy.Validate(); err != nil {
+		return err
+	}
+	state := &bootstate.State{}
+	if o.State != nil {
+		state = o.State.Load()
+	}
+
+	// Sources are only asked for candidates once.
+	found := make(map[string][]Candidate)
+	candidates := func(name string) []Candidate {
+		if cs, ok := found[name]; ok {
+			return cs
+		}
+		src, ok := o.Sources[name]
+		if !ok {
+			log.Printf("Skipping unknown boot source %q", name)
+			return nil
+		}
+		var cs []Candidate
+		if err := o.try(name, "", func() error {
+			var err error
+			cs, err = src.Candidates()
+			return err
+		}); err != nil {
+			cs = nil
+		}
+		found[name] = cs
+		return cs
+	}
+
+	if next, ok := state.TakeNextBoot(); ok {
+		log.Printf("Booting %q once", next)
+		// Boot it only once, even if it does not come up.
+		o.save(state)
+		for _, name := range o.Policy.Order {
+			for _, c := range candidates(name) {
+				if c.Name == next && o.boot(state, name, c) == nil {
+					return nil
+				}
+			}
+		}
+		log.Printf("Could not boot %q once, trying the boot order", next)
+	}
+
+	for _, name := range o.Policy.Order {
+		for _, c := range candidates(name) {
+			if state.Exhausted(c.Name, o.Policy.MaxBoots) {
+				log.Printf("Skipping %q: booted %d times without confirmation", c.Name, state.Attempts[c.Name])
+				continue
+			}
+			if o.boot(state, name, c) == nil {
+				return nil
+			}
+		}
+	}
+
+	msg := fmt.Sprintf("no boot candidate succeeded after %d attempts", len(o.Attempts))
+	if o.Recoverer != nil {
+		if err := o.Recoverer.Recover(msg); err != nil {
+			return fmt.Errorf("%s, and recovery failed: %v", msg, err)
+		}
+	}
+	return errors.New(msg)
+}
+
+// boot loads the candidate c of the source name and executes it, trying up
+// to Policy.Attempts times. The boot is counted just before executing.
+func (o *Orchestrator) boot(state *bootstate.State, name string, c Candidate) error {
+	return o.try(name, c.Name, func() error {
+		if err := boot.SetVerifyPolicy(c.Image, o.Verify); err != nil {
+			return err
+		}
+		if err := c.Image.Load(o.Verbose); err != nil {
+			return err
+		}
+		state.StartBoot(c.Name)
+		o.save(state)
+		// Execute only returns on failure.
+		return o.execute()
+	})
+}
+
+func (o *Orchestrator) save(state *bootstate.State) {
+	if o.State == nil {
+		return
+	}
+	if err := o.State.Save(state); err != nil {
+		log.Printf("Failed to save boot state: %v", err)
+	}
+}
+
+// try calls f up to Policy.Attempts times until it succeeds, with
+// exponential backoff, and records each attempt.
+func (o *Orchestrator) try(source, candidate string, f func() error) error {
+	delay := time.Duration(o.Policy.Backoff)
+	var err error
+	for i := 1; i <= o.Policy.Attempts; i++ {
+		if i > 1 {
+			log.Printf("Trying again in %v", delay)
+			o.sleep(delay)
+			delay *= 2
+		}
+		err = f()
+		a := Attempt{Source: source, Candidate: candidate, Try: i, Time: time.Now(), Err: err}
+		log.Print(a)
+		o.Attempts = append(o.Attempts, a)
+		if o.Record != nil {
+			o.Record(a)
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,293 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/u-root/u-root/pkg/boot/bootstate"
+	"github.com/u-root/u-root/pkg/boot/vboot"
+	"github.com/u-root/u-root/pkg/bootconfig"
+)
+
+// image fails to load the first failures times.
+type image struct {
+	name     string
+	failures int
+	loads    int
+}
+
+func (i *image) String() string { return i.name }
+
+func (i *image) Load(verbose bool) error {
+	i.loads++
+	if i.loads <= i.failures {
+		return fmt.Errorf("%s: load %d failed", i.name, i.loads)
+	}
+	return nil
+}
+
+// signedImage is an image that can verify its signatures.
+type signedImage struct {
+	image
+	verify *vboot.Policy
+}
+
+func (i *signedImage) SetVerifyPolicy(p *vboot.Policy) { i.verify = p }
+
+// source fails to find candidates the first failures times.
+type source struct {
+	failures   int
+	calls      int
+	candidates []Candidate
+}
+
+func (s *source) Candidates() ([]Candidate, error) {
+	s.calls++
+	if s.calls <= s.failures {
+		return nil, errors.New("no lease")
+	}
+	return s.candidates, nil
+}
+
+type recoverer struct {
+	messages []string
+}
+
+func (r *recoverer) Recover(message string) error {
+	r.messages = append(r.messages, message)
+	return nil
+}
+
+// attempt is an Attempt without its time.
+type attempt struct {
+	source, candidate string
+	try               int
+	failed            bool
+}
+
+func testOrchestrator(p *Policy, sources map[string]Source) (*Orchestrator, *recoverer, *[]time.Duration) {
+	r := &recoverer{}
+	o := New(p, sources, r)
+	var sleeps []time.Duration
+	o.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	o.execute = func() error { return nil }
+	return o, r, &sleeps
+}
+
+func attempts(o *Orchestrator) []attempt {
+	var as []attempt
+	for _, a := range o.Attempts {
+		as = append(as, attempt{a.Source, a.Candidate, a.Try, a.Err != nil})
+	}
+	return as
+}
+
+func TestBootFallback(t *testing.T) {
+	broken := &image{name: "broken", failures: 100}
+	flaky := &image{name: "flaky", failures: 2}
+	net := &source{failures: 1, candidates: []Candidate{{"pxe", flaky}}}
+	o, r, sleeps := testOrchestrator(
+		&Policy{Order: []string{"disk", "usb", "net"}, Attempts: 3, Backoff: Duration(time.Second)},
+		map[string]Source{
+			"disk": &source{candidates: []Candidate{{"ubuntu", broken}}},
+			"net":  net,
+		})
+
+	if err := o.Boot(); err != nil {
+		t.Fatalf("Boot() = %v", err)
+	}
+	want := []attempt{
+		{"disk", "", 1, false},
+		{"disk", "ubuntu", 1, true},
+		{"disk", "ubuntu", 2, true},
+		{"disk", "ubuntu", 3, true},
+		// usb is unknown.
+		{"net", "", 1, true},
+		{"net", "", 2, false},
+		{"net", "pxe", 1, true},
+		{"net", "pxe", 2, true},
+		{"net", "pxe", 3, false},
+	}
+	if diff := deep.Equal(attempts(o), want); diff != nil {
+		t.Error(diff)
+	}
+	wantSleeps := []time.Duration{time.Second, 2 * time.Second, time.Second, time.Second, 2 * time.Second}
+	if diff := deep.Equal(*sleeps, wantSleeps); diff != nil {
+		t.Errorf("sleeps: %v", diff)
+	}
+	if len(r.messages) != 0 {
+		t.Errorf("recovered after booting: %v", r.messages)
+	}
+}
+
+func TestBootRecovery(t *testing.T) {
+	var recorded []string
+	o, r, _ := testOrchestrator(
+		&Policy{Order: []string{"disk", "net"}, Attempts: 2},
+		map[string]Source{
+			"disk": &source{candidates: []Candidate{{"a", &image{name: "a", failures: 2}}, {"b", &image{name: "b", failures: 2}}}},
+			"net":  &source{failures: 2},
+		})
+	o.Record = func(a Attempt) { recorded = append(recorded, a.Candidate) }
+
+	err := o.Boot()
+	if err == nil {
+		t.Fatal("Boot() succeeded, want error")
+	}
+	wantMsg := "no boot candidate succeeded after 7 attempts"
+	if err.Error() != wantMsg {
+		t.Errorf("Boot() = %v, want %s", err, wantMsg)
+	}
+	if diff := deep.Equal(r.messages, []string{wantMsg}); diff != nil {
+		t.Errorf("recovery: %v", diff)
+	}
+	if diff := deep.Equal(recorded, []string{"", "a", "a", "b", "b", "", ""}); diff != nil {
+		t.Errorf("recorded: %v", diff)
+	}
+}
+
+func TestBootExecuteFails(t *testing.T) {
+	o, _, _ := testOrchestrator(
+		&Policy{Order: []string{"disk"}, Attempts: 1},
+		map[string]Source{"disk": &source{candidates: []Candidate{{"a", &image{name: "a"}}, {"b", &image{name: "b"}}}}})
+	executed := 0
+	o.execute = func() error {
+		if executed++; executed == 1 {
+			return errors.New("kexec failed")
+		}
+		return nil
+	}
+	if err := o.Boot(); err != nil {
+		t.Fatalf("Boot() = %v", err)
+	}
+	want := []attempt{{"disk", "", 1, false}, {"disk", "a", 1, true}, {"disk", "b", 1, false}}
+	if diff := deep.Equal(attempts(o), want); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestBootVerify(t *testing.T) {
+	unsigned := &image{name: "unsigned"}
+	signed := &signedImage{image: image{name: "signed"}}
+	o, _, _ := testOrchestrator(
+		&Policy{Order: []string{"disk"}, Attempts: 1},
+		map[string]Source{
+			"disk": &source{candidates: []Candidate{{"unsigned", unsigned}, {"signed", signed}}},
+		})
+	o.Verify = &vboot.Policy{Mode: vboot.Enforce}
+
+	if err := o.Boot(); err != nil {
+		t.Fatalf("Boot() = %v", err)
+	}
+	want := []attempt{
+		{"disk", "", 1, false},
+		{"disk", "unsigned", 1, true},
+		{"disk", "signed", 1, false},
+	}
+	if diff := deep.Equal(attempts(o), want); diff != nil {
+		t.Error(diff)
+	}
+	if unsigned.loads != 0 {
+		t.Errorf("unverifiable image was loaded %d times, want 0", unsigned.loads)
+	}
+	if signed.verify != o.Verify {
+		t.Errorf("signed image has policy %v, want %v", signed.verify, o.Verify)
+	}
+}
+
+func TestAttemptString(t *testing.T) {
+	tm := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		a    Attempt
+		want string
+	}{
+		{Attempt{Source: "net", Try: 2, Time: tm, Err: errors.New("no lease")}, "2019-06-01T12:00:00Z: net, try 2, failed: no lease"},
+		{Attempt{Source: "disk", Candidate: "Ubuntu", Try: 1, Time: tm}, `2019-06-01T12:00:00Z: disk "Ubuntu", try 1, succeeded`},
+	} {
+		if got := tt.a.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestBootConfigSource(t *testing.T) {
+	candidates, err := BootConfigSource{Path: "../../bootconfig/testdata/bootconfig.zip"}.Candidates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 {
+		t.Fatalf("got %d candidates, want 1", len(candidates))
+	}
+	if candidates[0].Name != "first boot entry" {
+		t.Errorf("Name = %q, want %q", candidates[0].Name, "first boot entry")
+	}
+	// Absolute paths are not in the unpacked file.
+	if cfg := candidates[0].Image.(*bootconfig.BootConfig); cfg.Kernel != "/path/to/kernel" {
+		t.Errorf("Kernel = %q, want /path/to/kernel", cfg.Kernel)
+	}
+
+	if _, err := (BootConfigSource{Path: "../../bootconfig/testdata/bootconfig.zip", PubKey: "../../bootconfig/testdata/pubkey"}).Candidates(); err == nil {
+		t.Error("Candidates() of an unsigned file with a public key succeeded")
+	}
+	if _, err := (BootConfigSource{Path: "nonexistent.zip"}).Candidates(); !os.IsNotExist(err) {
+		t.Errorf("Candidates() of a nonexistent file = %v, want not exist", err)
+	}
+}
+
+func TestBootState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bootstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := &bootstate.Store{Backends: []bootstate.Backend{bootstate.FileBackend{Dir: dir}}}
+
+	// a boots, but never comes up; b is the new version to try once.
+	a, b := &image{name: "a"}, &image{name: "b", failures: 1}
+	boot := func() []attempt {
+		o, _, _ := testOrchestrator(
+			&Policy{Order: []string{"disk"}, Attempts: 1, MaxBoots: 2},
+			map[string]Source{"disk": &source{candidates: []Candidate{{"a", a}, {"b", b}}}})
+		o.State = store
+		if err := o.Boot(); err != nil {
+			t.Fatalf("Boot() = %v", err)
+		}
+		return attempts(o)
+	}
+
+	if err := store.Update(func(s *bootstate.State) { s.NextBoot = "b" }); err != nil {
+		t.Fatal(err)
+	}
+	// b fails to load, so the boot order is tried.
+	want := []attempt{{"disk", "", 1, false}, {"disk", "b", 1, true}, {"disk", "a", 1, false}}
+	if diff := deep.Equal(boot(), want); diff != nil {
+		t.Errorf("boot once: %v", diff)
+	}
+	// b is not booted again.
+	want = []attempt{{"disk", "", 1, false}, {"disk", "a", 1, false}}
+	if diff := deep.Equal(boot(), want); diff != nil {
+		t.Errorf("second boot: %v", diff)
+	}
+	// a was booted twice without confirmation.
+	want = []attempt{{"disk", "", 1, false}, {"disk", "b", 1, false}}
+	if diff := deep.Equal(boot(), want); diff != nil {
+		t.Errorf("third boot: %v", diff)
+	}
+
+	s := store.Load()
+	if s.NextBoot != "" || s.Booting != "b" {
+		t.Errorf("state = %+v, want no next boot and b booting", s)
+	}
+	if diff := deep.Equal(s.Attempts, map[string]int{"a": 2, "b": 1}); diff != nil {
+		t.Errorf("attempts: %v", diff)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package policy boots the first of an ordered list of boot candidates that
+// works.
+//
+// Candidates come from sources: local disks, ESXi boot banks, the network
+// and bootconfig manifests. A Policy orders the sources and says how often
+// to try each candidate. The Orchestrator tries the candidates in that
+// order, records every attempt and, when all of them failed, hands off to a
+// recovery.Recoverer.
+//
+// The policy is JSON, e.g.
+//
+//	{"order": ["disk", "net"], "attempts": 3, "backoff": "2s"}
+//
+// stored in the BootPolicy VPD variable or in a file. The kernel command
+// line overrides it with uroot.bootorder=disk,net, uroot.bootattempts=3,
+// uroot.bootbackoff=2s and uroot.maxboots=3.
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/u-root/u-root/pkg/cmdline"
+	"github.com/u-root/u-root/pkg/vpd"
+)
+
+// Names of the sources.
+const (
+	BootConfig = "bootconfig"
+	Disk       = "disk"
+	ESXi       = "esxi"
+	Net        = "net"
+)
+
+// VPDKey is the VPD variable that holds the policy.
+const VPDKey = "BootPolicy"
+
+// vpdGet and cmdlineFlag are variables so tests can override them.
+var (
+	vpdGet      = vpd.Get
+	cmdlineFlag = cmdline.Flag
+)
+
+// Duration is a time.Duration that is a string like "1m30s" in JSON.
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Policy orders the boot sources and says how often to try booting.
+type Policy struct {
+	// Order are the names of the sources whose candidates are tried, in
+	// order.
+	Order []string `json:"order"`
+
+	// Attempts is how often each candidate is tried, and how often each
+	// source is asked for candidates, before moving on.
+	Attempts int `json:"attempts"`
+
+	// Backoff is how long to wait before trying again. It doubles with
+	// each further attempt.
+	Backoff Duration `json:"backoff"`
+
+	// MaxBoots is how often a candidate is booted without the booted OS
+	// confirming that it came up, see package bootstate, before it is
+	// skipped. If it is 0, candidates are never skipped.
+	MaxBoots int `json:"max_boots,omitempty"`
+}
+
+// Default returns the policy that is used where nothing else is set: the
+// bootconfig manifest, then local disks and ESXi, then the network, each
+// tried twice.
+func Default() *Policy {
+	return &Policy{
+		Order:    []string{BootConfig, Disk, ESXi, Net},
+		Attempts: 2,
+		Backoff:  Duration(time.Second),
+	}
+}
+
+// Validate returns an error if p cannot be used to boot.
+func (p *Policy) Validate() error {
+	if len(p.Order) == 0 {
+		return errors.New("policy has no boot sources")
+	}
+	if p.Attempts < 1 {
+		return fmt.Errorf("policy has %d attempts, need at least 1", p.Attempts)
+	}
+	if p.Backoff < 0 {
+		return fmt.Errorf("policy has negative backoff %v", time.Duration(p.Backoff))
+	}
+	if p.MaxBoots < 0 {
+		return fmt.Errorf("policy has negative max boots %d", p.MaxBoots)
+	}
+	return nil
+}
+
+// Parse overrides the fields of p that the JSON policy b sets.
+func (p *Policy) Parse(b []byte) error {
+	return json.Unmarshal(b, p)
+}
+
+// Load returns the default policy, overridden by the BootPolicy VPD
+// variable (read-write before read-only), then by the file at path, if it
+// exists, then by the kernel command line.
+func Load(path string) (*Policy, error) {
+	p := Default()
+	b, err := vpdGet(VPDKey, false)
+	if err != nil {
+		b, err = vpdGet(VPDKey, true)
+	}
+	if err == nil {
+		if err := p.Parse(b); err != nil {
+			return nil, fmt.Errorf("invalid %s VPD variable: %v", VPDKey, err)
+		}
+	}
+
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			if err := p.Parse(b); err != nil {
+				return nil, fmt.Errorf("invalid policy file %s: %v", path, err)
+			}
+		}
+	}
+
+	if order, ok := cmdlineFlag("uroot.bootorder"); ok {
+		p.Order = strings.Split(order, ",")
+	}
+	if attempts, ok := cmdlineFlag("uroot.bootattempts"); ok {
+		n, err := strconv.Atoi(attempts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uroot.bootattempts: %v", err)
+		}
+		p.Attempts = n
+	}
+	if maxBoots, ok := cmdlineFlag("uroot.maxboots"); ok {
+		n, err := strconv.Atoi(maxBoots)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uroot.maxboots: %v", err)
+		}
+		p.MaxBoots = n
+	}
+	if backoff, ok := cmdlineFlag("uroot.bootbackoff"); ok {
+		d, err := time.ParseDuration(backoff)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uroot.bootbackoff: %v", err)
+		}
+		p.Backoff = Duration(d)
+	}
+	return p, p.Validate()
+}
@@ -0,0 +1,128 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+)
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "policy.json")
+	if err := ioutil.WriteFile(file, []byte(`{"order": ["net", "disk"], "backoff": "5s"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(get func(string, bool) ([]byte, error), flag func(string) (string, bool)) {
+		vpdGet, cmdlineFlag = get, flag
+	}(vpdGet, cmdlineFlag)
+
+	for _, tt := range []struct {
+		name    string
+		vpd     map[bool]string
+		path    string
+		cmdline map[string]string
+		want    *Policy
+		wantErr bool
+	}{
+		{
+			name: "default",
+			path: filepath.Join(dir, "nonexistent"),
+			want: Default(),
+		},
+		{
+			name: "read-only VPD",
+			vpd:  map[bool]string{true: `{"order": ["esxi"], "attempts": 1}`},
+			want: &Policy{Order: []string{"esxi"}, Attempts: 1, Backoff: Duration(time.Second)},
+		},
+		{
+			name: "read-write VPD first",
+			vpd:  map[bool]string{true: `{"attempts": 1}`, false: `{"attempts": 4}`},
+			want: &Policy{Order: Default().Order, Attempts: 4, Backoff: Duration(time.Second)},
+		},
+		{
+			name: "file overrides VPD",
+			vpd:  map[bool]string{false: `{"order": ["esxi"], "attempts": 3}`},
+			path: file,
+			want: &Policy{Order: []string{"net", "disk"}, Attempts: 3, Backoff: Duration(5 * time.Second)},
+		},
+		{
+			name:    "command line overrides all",
+			vpd:     map[bool]string{false: `{"attempts": 3}`},
+			path:    file,
+			cmdline: map[string]string{"uroot.bootorder": "disk,bootconfig", "uroot.bootattempts": "5", "uroot.bootbackoff": "100ms", "uroot.maxboots": "2"},
+			want:    &Policy{Order: []string{"disk", "bootconfig"}, Attempts: 5, Backoff: Duration(100 * time.Millisecond), MaxBoots: 2},
+		},
+		{
+			name:    "invalid VPD",
+			vpd:     map[bool]string{false: `{"order": "disk"}`},
+			wantErr: true,
+		},
+		{
+			name:    "invalid attempts",
+			cmdline: map[string]string{"uroot.bootattempts": "0"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid backoff",
+			cmdline: map[string]string{"uroot.bootbackoff": "soon"},
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			vpdGet = func(key string, readOnly bool) ([]byte, error) {
+				if v, ok := tt.vpd[readOnly]; ok && key == VPDKey {
+					return []byte(v), nil
+				}
+				return nil, errors.New("no such key")
+			}
+			cmdlineFlag = func(flag string) (string, bool) {
+				v, ok := tt.cmdline[flag]
+				return v, ok
+			}
+			got, err := Load(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() = %v, want error %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if diff := deep.Equal(got, tt.want); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestDurationJSON(t *testing.T) {
+	b, err := json.Marshal(Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"order":["bootconfig","disk","esxi","net"],"attempts":2,"backoff":"1s"}`; string(b) != want {
+		t.Errorf("Marshal(Default()) = %s, want %s", b, want)
+	}
+	var p Policy
+	if err := json.Unmarshal(b, &p); err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(&p, Default()); diff != nil {
+		t.Error(diff)
+	}
+	if err := json.Unmarshal([]byte(`{"backoff": 5}`), &p); err == nil {
+		t.Error("Unmarshal of a numeric backoff succeeded")
+	}
+}
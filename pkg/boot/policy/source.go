@@ -0,0 +1,205 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/bootconfig"
+	"github.com/u-root/u-root/pkg/cmdline"
+	"github.com/u-root/u-root/pkg/dhclient"
+	"github.com/u-root/u-root/pkg/diskboot"
+	"github.com/u-root/u-root/pkg/esxi"
+	"github.com/u-root/u-root/pkg/netboot"
+	"github.com/u-root/u-root/pkg/urlfetch"
+)
+
+// Candidate is something that may boot.
+type Candidate struct {
+	Name  string
+	Image boot.OSImage
+}
+
+// Source finds boot candidates.
+type Source interface {
+	// Candidates returns the candidates in the order they should be
+	// tried. An error means the source may have candidates later, e.g.
+	// when the network is up.
+	Candidates() ([]Candidate, error)
+}
+
+// DiskSource finds the GRUB and syslinux entries on local disks. The
+// default entry of each configuration comes first.
+type DiskSource struct {
+	// Glob matches the block devices in /sys, e.g. /sys/class/block/*.
+	Glob string
+
+	// Filter updates the kernel command lines. It may be nil.
+	Filter cmdline.Filter
+}
+
+// Candidates implements Source.
+func (s DiskSource) Candidates() ([]Candidate, error) {
+	var candidates []Candidate
+	for _, dev := range diskboot.FindDevices(s.Glob) {
+		for _, config := range dev.Configs {
+			order := make([]int, 0, len(config.Entries))
+			if config.DefaultEntry >= 0 && config.DefaultEntry < len(config.Entries) {
+				order = append(order, config.DefaultEntry)
+			}
+			for i := range config.Entries {
+				if i != config.DefaultEntry {
+					order = append(order, i)
+				}
+			}
+			for _, i := range order {
+				entry := &config.Entries[i]
+				img, err := entry.OSImage(config.MountPath, s.Filter)
+				if err != nil {
+					log.Printf("Skipping entry %q of %s: %v", entry.Name, config.ConfigPath, err)
+					continue
+				}
+				candidates = append(candidates, Candidate{
+					Name:  fmt.Sprintf("%s (%s on %s)", entry.Name, config.ConfigPath, dev.DevPath),
+					Image: img,
+				})
+			}
+		}
+	}
+	return candidates, nil
+}
+
+// ESXiSource finds ESXi boot banks, partitions 5 and 6 of disks.
+type ESXiSource struct {
+	// Glob matches the disks in /sys, e.g. /sys/block/*.
+	Glob string
+}
+
+// Candidates implements Source.
+func (s ESXiSource) Candidates() ([]Candidate, error) {
+	disks, err := filepath.Glob(s.Glob)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []Candidate
+	for _, disk := range disks {
+		dev := filepath.Join("/dev", filepath.Base(disk))
+		imgs, err := esxi.LoadDisk(dev)
+		if err != nil {
+			continue
+		}
+		for _, img := range imgs {
+			candidates = append(candidates, Candidate{
+				Name:  fmt.Sprintf("ESXi %s on %s", img.Path, dev),
+				Image: img,
+			})
+		}
+	}
+	return candidates, nil
+}
+
+// NetSource gets a DHCP lease on each interface and the PXE or iPXE
+// configuration it points to.
+type NetSource struct {
+	// Interfaces is a regular expression matching the interface names.
+	Interfaces string
+
+	// Timeout and Retries of each DHCP request.
+	Timeout time.Duration
+	Retries int
+
+	// Verbose logs the DHCP exchanges.
+	Verbose bool
+}
+
+// Candidates implements Source.
+func (s NetSource) Candidates() ([]Candidate, error) {
+	ifs, err := dhclient.Interfaces(s.Interfaces)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), (1<<uint(s.Retries))*s.Timeout)
+	defer cancel()
+
+	c := dhclient.Config{
+		Timeout: s.Timeout,
+		Retries: s.Retries,
+	}
+	if s.Verbose {
+		c.LogLevel = dhclient.LogSummary
+	}
+	var candidates []Candidate
+	for result := range dhclient.SendRequests(ctx, ifs, true, true, c) {
+		if result.Err != nil {
+			continue
+		}
+		if err := result.Lease.Configure(); err != nil {
+			log.Printf("Failed to configure lease %s: %v", result.Lease, err)
+		}
+		img, err := netboot.BootImage(urlfetch.DefaultSchemes, result.Lease)
+		if err != nil {
+			log.Printf("Failed to get a boot image for lease %s: %v", result.Lease, err)
+			continue
+		}
+		candidates = append(candidates, Candidate{
+			Name:  fmt.Sprintf("netboot on %s", result.Interface.Attrs().Name),
+			Image: img,
+		})
+	}
+	if len(candidates) == 0 {
+		return nil, errors.New("no DHCP lease with a boot image")
+	}
+	return candidates, nil
+}
+
+// BootConfigSource finds the boot configurations of a bootconfig manifest
+// in a signed ZIP file.
+type BootConfigSource struct {
+	// Path is the ZIP file.
+	Path string
+
+	// PubKey is the public key the file must be signed with. If it is
+	// empty, the signature is not verified.
+	PubKey string
+}
+
+// Candidates implements Source.
+func (s BootConfigSource) Candidates() ([]Candidate, error) {
+	var pubkey *string
+	if s.PubKey != "" {
+		pubkey = &s.PubKey
+	}
+	manifest, dir, err := bootconfig.FromZip(s.Path, pubkey)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []Candidate
+	for i := range manifest.Configs {
+		cfg := &manifest.Configs[i]
+		if !cfg.IsValid() {
+			log.Printf("Skipping invalid boot configuration %d of %s", i, s.Path)
+			continue
+		}
+		// Files are relative to the unpacked ZIP file.
+		for _, p := range []*string{&cfg.Kernel, &cfg.Initramfs, &cfg.DeviceTree, &cfg.Multiboot} {
+			if *p != "" && !filepath.IsAbs(*p) {
+				*p = filepath.Join(dir, *p)
+			}
+		}
+		for j, m := range cfg.Modules {
+			if !filepath.IsAbs(m) {
+				cfg.Modules[j] = filepath.Join(dir, m)
+			}
+		}
+		candidates = append(candidates, Candidate{Name: cfg.String(), Image: cfg})
+	}
+	return candidates, nil
+}
@@ -0,0 +1,342 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package vboot verifies detached signatures of the parts of boot images
+// before they are loaded.
+//
+// A signature signs the SHA-256 digest of the signed data. It is either an
+// Ed25519 signature of the digest, as made by cmds/exp/vboot's tooling, an
+// RSA PKCS #1 v1.5 signature or an ASN.1 encoded ECDSA signature.
+//
+// The keys are read from a keyring: a file or a directory of files with PEM
+// encoded public keys ("PUBLIC KEY") or X.509 certificates ("CERTIFICATE").
+// Ed25519 keys may also be raw 32 byte keys, as written by pkg/crypto.
+package vboot
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/uio"
+	"golang.org/x/crypto/ed25519"
+)
+
+// SigSuffix is appended to the path of a file to find its detached
+// signature.
+const SigSuffix = ".sig"
+
+// CmdlineSuffix is appended to the path of a kernel to find the detached
+// signature of its command line.
+const CmdlineSuffix = ".cmdline" + SigSuffix
+
+// DefaultKeyring is where the boot commands look for trusted keys.
+const DefaultKeyring = "/etc/vboot"
+
+// Mode says what to do about missing or invalid signatures.
+type Mode int
+
+const (
+	// Off does not verify anything.
+	Off Mode = iota
+
+	// Warn verifies signatures, but only logs a warning if one is missing
+	// or invalid.
+	Warn
+
+	// Enforce refuses to load images with a missing or invalid signature.
+	Enforce
+)
+
+var modes = map[Mode]string{
+	Off:     "off",
+	Warn:    "warn",
+	Enforce: "enforce",
+}
+
+func (m Mode) String() string {
+	if s, ok := modes[m]; ok {
+		return s
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+// ParsePolicy returns the policy with the mode named mode and the keyring at
+// path, as given by the boot commands' -verify and -keyring flags.
+func ParsePolicy(mode, path string) (*Policy, error) {
+	m, err := ParseMode(mode)
+	if err != nil {
+		return nil, err
+	}
+	return New(m, path)
+}
+
+// ParseMode returns the mode named s: off, warn or enforce.
+func ParseMode(s string) (Mode, error) {
+	for m, name := range modes {
+		if name == s {
+			return m, nil
+		}
+	}
+	return Off, fmt.Errorf("unknown verification mode %q, want off, warn or enforce", s)
+}
+
+// ErrUnsigned is returned when data that must be signed has no signature.
+var ErrUnsigned = errors.New("no signature")
+
+// Keyring is a set of public keys that are trusted to sign boot images.
+type Keyring []crypto.PublicKey
+
+// ParseKeys returns the public keys in the PEM encoded data b. If b is not
+// PEM encoded, it must be a raw Ed25519 key.
+func ParseKeys(b []byte) (Keyring, error) {
+	var keys Keyring
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "PUBLIC KEY":
+			if len(block.Bytes) == ed25519.PublicKeySize {
+				keys = append(keys, ed25519.PublicKey(block.Bytes))
+				continue
+			}
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, cert.PublicKey)
+		}
+	}
+	if keys == nil && len(b) == ed25519.PublicKeySize {
+		keys = append(keys, ed25519.PublicKey(b))
+	}
+	if keys == nil {
+		return nil, errors.New("no public keys found")
+	}
+	return keys, nil
+}
+
+// ReadKeyring returns the keys in the file at path or, if path is a
+// directory, in all files in it. Files without keys are ignored in a
+// directory.
+func ReadKeyring(path string) (Keyring, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if fi.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(path, "*")); err != nil {
+			return nil, err
+		}
+	}
+
+	var keys Keyring
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			if fi.IsDir() {
+				continue
+			}
+			return nil, err
+		}
+		k, err := ParseKeys(b)
+		if err != nil {
+			if fi.IsDir() {
+				log.Printf("Ignoring %s: %v", file, err)
+				continue
+			}
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		keys = append(keys, k...)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public keys in %s", path)
+	}
+	return keys, nil
+}
+
+// VerifyDigest returns nil if sig is a signature of the SHA-256 digest by
+// key.
+func VerifyDigest(key crypto.PublicKey, digest, sig []byte) error {
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, digest, sig) {
+			return errors.New("ed25519 verification failed")
+		}
+		return nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, sig)
+	case *ecdsa.PublicKey:
+		var ecdsaSig struct {
+			R, S *big.Int
+		}
+		if rest, err := asn1.Unmarshal(sig, &ecdsaSig); err != nil || len(rest) > 0 {
+			return errors.New("invalid ecdsa signature")
+		}
+		if !ecdsa.Verify(k, digest, ecdsaSig.R, ecdsaSig.S) {
+			return errors.New("ecdsa verification failed")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// Verify returns nil if sig is a signature of data by one of the keys.
+func (k Keyring) Verify(data io.Reader, sig []byte) error {
+	if len(k) == 0 {
+		return errors.New("no trusted keys")
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, data); err != nil {
+		return err
+	}
+	digest := h.Sum(nil)
+	for _, key := range k {
+		if VerifyDigest(key, digest, sig) == nil {
+			return nil
+		}
+	}
+	return errors.New("signature does not match any trusted key")
+}
+
+// Policy says whether the parts of boot images must be signed, and by whom.
+//
+// A nil *Policy verifies nothing.
+type Policy struct {
+	Mode Mode
+	Keys Keyring
+}
+
+// New returns a policy with mode m and the keys of the keyring at path. The
+// keyring is not read if m is Off.
+func New(m Mode, path string) (*Policy, error) {
+	p := &Policy{Mode: m}
+	if m == Off {
+		return p, nil
+	}
+	keys, err := ReadKeyring(path)
+	if err != nil {
+		if m == Enforce {
+			return nil, err
+		}
+		log.Printf("Warning: %v", err)
+	}
+	p.Keys = keys
+	return p, nil
+}
+
+// Check verifies that sig is a signature of data. A nil sig means data is
+// not signed. name describes data in errors and warnings.
+//
+// With Enforce, Check returns an error if the signature is missing or
+// invalid; with Warn, it only logs it.
+func (p *Policy) Check(name string, data io.ReaderAt, sig io.ReaderAt) error {
+	if p == nil || p.Mode == Off {
+		return nil
+	}
+	return p.Decide(name, p.check(data, sig))
+}
+
+// Decide applies p to the result err of verifying the signature of what
+// name describes. If err is not nil, Decide returns it if p enforces
+// signatures, and logs it if p only warns.
+func (p *Policy) Decide(name string, err error) error {
+	if p == nil || p.Mode == Off {
+		return nil
+	}
+	if err == nil {
+		log.Printf("Verified signature of %s", name)
+		return nil
+	}
+	err = fmt.Errorf("%s: %v", name, err)
+	if p.Mode == Enforce {
+		return err
+	}
+	log.Printf("Warning: %v", err)
+	return nil
+}
+
+func (p *Policy) check(data io.ReaderAt, sig io.ReaderAt) error {
+	if sig == nil {
+		return ErrUnsigned
+	}
+	s, err := uio.ReadAll(sig)
+	if os.IsNotExist(err) {
+		return ErrUnsigned
+	}
+	if err != nil {
+		return fmt.Errorf("could not read signature: %v", err)
+	}
+	if data == nil {
+		data = strings.NewReader("")
+	}
+	return p.Keys.Verify(uio.Reader(data), s)
+}
+
+// CheckString is Check for a string, such as a command line.
+func (p *Policy) CheckString(name, data string, sig io.ReaderAt) error {
+	return p.Check(name, strings.NewReader(data), sig)
+}
+
+// CheckFile is Check for the file at path.
+func (p *Policy) CheckFile(path string, sig io.ReaderAt) error {
+	if p == nil || p.Mode == Off {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return p.Check(path, f, sig)
+}
+
+// MultibootCmdlines returns what the command line signature of a multiboot
+// kernel signs: its command line and the module lines, each followed by a
+// newline.
+func MultibootCmdlines(cmdline string, modules []string) string {
+	var b strings.Builder
+	fmt.Fprintln(&b, cmdline)
+	for _, m := range modules {
+		fmt.Fprintln(&b, m)
+	}
+	return b.String()
+}
+
+// Detached returns the detached signature of the file at path, path with
+// SigSuffix appended. It is opened lazily; if it does not exist, the file
+// counts as unsigned.
+func Detached(path string) io.ReaderAt {
+	return uio.NewLazyFile(path + SigSuffix)
+}
+
+// DetachedCmdline returns the detached signature of the command line of the
+// kernel at path, path with CmdlineSuffix appended.
+func DetachedCmdline(path string) io.ReaderAt {
+	return uio.NewLazyFile(path + CmdlineSuffix)
+}
@@ -0,0 +1,213 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vboot
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+type signer struct {
+	name string
+	key  crypto.Signer
+}
+
+func signers(t *testing.T) []signer {
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []signer{{"ed25519", ed}, {"rsa", r}, {"ecdsa", ec}}
+}
+
+// sign signs the SHA-256 digest of data like the signing tools do.
+func sign(t *testing.T, key crypto.Signer, data string) []byte {
+	digest := sha256.Sum256([]byte(data))
+	opts := crypto.SignerOpts(crypto.SHA256)
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		opts = crypto.Hash(0)
+	}
+	sig, err := key.Sign(rand.Reader, digest[:], opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+// writeKeyring writes the public keys of signers in all supported formats:
+// the ed25519 key raw, the RSA key as PKIX and the ECDSA key in a
+// certificate.
+func writeKeyring(t *testing.T, dir string, signers []signer) {
+	for _, s := range signers {
+		var b []byte
+		switch s.name {
+		case "ed25519":
+			b = s.key.Public().(ed25519.PublicKey)
+		case "rsa":
+			der, err := x509.MarshalPKIXPublicKey(s.key.Public())
+			if err != nil {
+				t.Fatal(err)
+			}
+			b = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+		case "ecdsa":
+			tmpl := &x509.Certificate{
+				SerialNumber: big.NewInt(1),
+				Subject:      pkix.Name{CommonName: "vboot"},
+				NotBefore:    time.Now(),
+				NotAfter:     time.Now().Add(time.Hour),
+			}
+			der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, s.key.Public(), s.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, s.name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadKeyring(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vboot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeKeyring(t, dir, signers(t))
+
+	keys, err := ReadKeyring(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Errorf("ReadKeyring(%s) has %d keys, want 3", dir, len(keys))
+	}
+	if _, err := ReadKeyring(filepath.Join(dir, "README")); err == nil {
+		t.Error("ReadKeyring() of a file without keys succeeded")
+	}
+	if _, err := ReadKeyring(filepath.Join(dir, "nonexistent")); err == nil {
+		t.Error("ReadKeyring() of a nonexistent file succeeded")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vboot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	trusted := signers(t)
+	writeKeyring(t, dir, trusted)
+	untrusted := signers(t)
+
+	const data = "kernel"
+	for _, s := range trusted {
+		for _, tt := range []struct {
+			name    string
+			mode    Mode
+			sig     []byte
+			wantErr bool
+		}{
+			{"valid", Enforce, sign(t, s.key, data), false},
+			{"other data", Enforce, sign(t, s.key, "initrd"), true},
+			{"untrusted", Enforce, sign(t, untrusted[0].key, data), true},
+			{"unsigned", Enforce, nil, true},
+			{"warn", Warn, nil, false},
+			{"off", Off, []byte("garbage"), false},
+		} {
+			t.Run(s.name+" "+tt.name, func(t *testing.T) {
+				p, err := New(tt.mode, dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var sig io.ReaderAt
+				if tt.sig != nil {
+					sig = bytes.NewReader(tt.sig)
+				}
+				if err := p.CheckString("kernel", data, sig); (err != nil) != tt.wantErr {
+					t.Errorf("Check() = %v, want error %t", err, tt.wantErr)
+				}
+			})
+		}
+	}
+}
+
+func TestCheckFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vboot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := signers(t)[0]
+	writeKeyring(t, dir, []signer{s})
+	p, err := New(Enforce, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kernel := filepath.Join(dir, "vmlinuz")
+	if err := ioutil.WriteFile(kernel, []byte("kernel"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CheckFile(kernel, Detached(kernel)); err == nil || !strings.Contains(err.Error(), ErrUnsigned.Error()) {
+		t.Errorf("CheckFile() without signature file = %v, want %v", err, ErrUnsigned)
+	}
+	if err := ioutil.WriteFile(kernel+SigSuffix, sign(t, s.key, "kernel"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CheckFile(kernel, Detached(kernel)); err != nil {
+		t.Errorf("CheckFile() = %v, want nil", err)
+	}
+
+	var nilPolicy *Policy
+	if err := nilPolicy.CheckFile(filepath.Join(dir, "nonexistent"), nil); err != nil {
+		t.Errorf("CheckFile() of a nil policy = %v, want nil", err)
+	}
+	if _, err := New(Enforce, filepath.Join(dir, "nonexistent")); err == nil {
+		t.Error("New() enforcing without keys succeeded")
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for _, m := range []Mode{Off, Warn, Enforce} {
+		got, err := ParseMode(m.String())
+		if err != nil || got != m {
+			t.Errorf("ParseMode(%q) = %v, %v, want %v", m.String(), got, err, m)
+		}
+	}
+	if _, err := ParseMode("strict"); err == nil {
+		t.Error("ParseMode(strict) succeeded")
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package boot
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/u-root/u-root/pkg/boot/vboot"
+)
+
+// Verifiable is an OSImage that verifies its signatures before it is
+// loaded.
+type Verifiable interface {
+	OSImage
+
+	// SetVerifyPolicy sets the policy that says which signatures Load
+	// requires.
+	SetVerifyPolicy(p *vboot.Policy)
+}
+
+var (
+	_ Verifiable = &LinuxImage{}
+	_ Verifiable = &MultibootImage{}
+)
+
+// SetVerifyPolicy makes img verify its signatures with p when it is loaded.
+//
+// If img is not Verifiable, SetVerifyPolicy returns an error if p enforces
+// signatures, and logs a warning if p only warns.
+func SetVerifyPolicy(img OSImage, p *vboot.Policy) error {
+	if v, ok := img.(Verifiable); ok {
+		v.SetVerifyPolicy(p)
+		return nil
+	}
+	if p == nil || p.Mode == vboot.Off {
+		return nil
+	}
+	err := fmt.Errorf("cannot verify the signatures of %s", img)
+	if p.Mode == vboot.Enforce {
+		return err
+	}
+	log.Printf("Warning: %v", err)
+	return nil
+}
@@ -8,12 +8,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"os"
 
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/boot/vboot"
 	"github.com/u-root/u-root/pkg/crypto"
 	"github.com/u-root/u-root/pkg/kexec"
 	"github.com/u-root/u-root/pkg/multiboot"
+	"github.com/u-root/u-root/pkg/uio"
 )
 
 // BootConfig is a general-purpose boot configuration. It draws some
@@ -28,6 +29,10 @@ type BootConfig struct {
 	Multiboot     string   `json:"multiboot_kernel,omitempty"`
 	MultibootArgs string   `json:"multiboot_args,omitempty"`
 	Modules       []string `json:"multiboot_modules,omitempty"`
+
+	// Verify, if not nil, says whether the files and command lines must
+	// have detached signatures, see package vboot.
+	Verify *vboot.Policy `json:"-"`
 }
 
 // IsValid returns true if a BootConfig object has valid content, and false
@@ -55,48 +60,88 @@ func (bc *BootConfig) bytestream() []byte {
 	return []byte(b)
 }
 
-// Boot tries to boot the kernel with optional initramfs and command line
-// options. If a device-tree is specified, that will be used too
-func (bc *BootConfig) Boot() error {
-	crypto.TryMeasureData(crypto.BootConfigPCR, bc.bytestream(), "bootconfig")
-	crypto.TryMeasureFiles(bc.fileNames()...)
+// String implements fmt.Stringer.
+func (bc *BootConfig) String() string {
+	if bc.Name != "" {
+		return bc.Name
+	}
 	if bc.Kernel != "" {
-		kernel, err := os.Open(bc.Kernel)
-		if err != nil {
-			return err
+		return fmt.Sprintf("BootConfig(kernel %s)", bc.Kernel)
+	}
+	return fmt.Sprintf("BootConfig(multiboot kernel %s)", bc.Multiboot)
+}
+
+// SetVerifyPolicy implements boot.Verifiable.
+func (bc *BootConfig) SetVerifyPolicy(p *vboot.Policy) {
+	bc.Verify = p
+}
+
+// image returns the image that boots bc, with the detached signatures of
+// its files and command lines.
+func (bc *BootConfig) image() (boot.OSImage, error) {
+	if bc.Kernel != "" {
+		img := &boot.LinuxImage{
+			Kernel:     uio.NewLazyFile(bc.Kernel),
+			Cmdline:    bc.KernelArgs,
+			Verify:     bc.Verify,
+			KernelSig:  vboot.Detached(bc.Kernel),
+			CmdlineSig: vboot.DetachedCmdline(bc.Kernel),
 		}
-		var initramfs *os.File
 		if bc.Initramfs != "" {
-			initramfs, err = os.Open(bc.Initramfs)
-			if err != nil {
-				return err
-			}
-		}
-		defer func() {
-			// clean up
-			if kernel != nil {
-				if err := kernel.Close(); err != nil {
-					log.Printf("Error closing kernel file descriptor: %v", err)
-				}
-			}
-			if initramfs != nil {
-				if err := initramfs.Close(); err != nil {
-					log.Printf("Error closing initramfs file descriptor: %v", err)
-				}
-			}
-		}()
-		if err := kexec.FileLoad(kernel, initramfs, bc.KernelArgs); err != nil {
-			return err
+			img.Initrd = uio.NewLazyFile(bc.Initramfs)
+			img.InitrdSig = vboot.Detached(bc.Initramfs)
 		}
-	} else if bc.Multiboot != "" {
-		// check multiboot header
-		if err := multiboot.Probe(bc.Multiboot); err != nil {
-			log.Printf("Error parsing multiboot header: %v", err)
-			return err
-		}
-		if err := multiboot.Load(true, bc.Multiboot, bc.MultibootArgs, bc.Modules, nil); err != nil {
-			return fmt.Errorf("kexec.Load() error: %v", err)
+		return img, nil
+	}
+	img := &boot.MultibootImage{
+		Path:       bc.Multiboot,
+		Cmdline:    bc.MultibootArgs,
+		Modules:    bc.Modules,
+		Verify:     bc.Verify,
+		KernelSig:  vboot.Detached(bc.Multiboot),
+		CmdlineSig: vboot.DetachedCmdline(bc.Multiboot),
+	}
+	for _, m := range bc.Modules {
+		path, err := multiboot.ModulePath(m)
+		if err != nil {
+			return nil, err
 		}
+		img.ModuleSigs = append(img.ModuleSigs, vboot.Detached(path))
+	}
+	return img, nil
+}
+
+// Load measures the boot configuration and its files, verifies their
+// signatures if Verify says so, and loads the kernel with optional
+// initramfs and command line options, or the multiboot kernel and its
+// modules. It implements boot.OSImage.
+//
+// The files are read once: the contents that are verified are the ones
+// that are loaded.
+func (bc *BootConfig) Load(verbose bool) error {
+	crypto.TryMeasureData(crypto.BootConfigPCR, bc.bytestream(), "bootconfig")
+	crypto.TryMeasureFiles(bc.fileNames()...)
+	if bc.Kernel == "" && bc.Multiboot == "" {
+		return nil
+	}
+	img, err := bc.image()
+	if err != nil {
+		return err
+	}
+	if bc.Kernel != "" {
+		return img.Load(verbose)
+	}
+	if err := img.Load(true); err != nil {
+		return fmt.Errorf("kexec.Load() error: %v", err)
+	}
+	return nil
+}
+
+// Boot tries to boot the kernel with optional initramfs and command line
+// options. If a device-tree is specified, that will be used too
+func (bc *BootConfig) Boot() error {
+	if err := bc.Load(true); err != nil {
+		return err
 	}
 	err := kexec.Reboot()
 	if err == nil {
@@ -301,6 +301,19 @@ func (b *BzImage) AddInitRAMFS(name string) error {
 	return nil
 }
 
+// ParseHeader parses the header of the bzImage in d without unpacking the
+// kernel.
+func ParseHeader(d []byte) (*LinuxHeader, error) {
+	var h LinuxHeader
+	if err := binary.Read(bytes.NewReader(d), binary.LittleEndian, &h); err != nil {
+		return nil, fmt.Errorf("Not a bzImage: %v", err)
+	}
+	if h.HeaderMagic != HeaderMagic {
+		return nil, fmt.Errorf("Not a bzImage: magic should be %02x, and is %02x", HeaderMagic, h.HeaderMagic)
+	}
+	return &h, nil
+}
+
 // MakeLinuxHeader marshals a LinuxHeader into a []byte.
 func MakeLinuxHeader(h *LinuxHeader) ([]byte, error) {
 	buf := new(bytes.Buffer)
@@ -38,6 +38,16 @@ const (
 	E820NR  = 0x1e8
 )
 
+// Flags of LinuxHeader.XLoadFlags (2.12+).
+const (
+	// XLFKernel64 is set if the kernel has the legacy 64-bit entry point
+	// at 0x200 past the start of the protected-mode code.
+	XLFKernel64 = 1 << 0
+	// XLFCanBeLoadedAbove4G is set if the kernel, boot_params, the
+	// command line, and the initrd can be above 4G.
+	XLFCanBeLoadedAbove4G = 1 << 1
+)
+
 // what's an EDD? No idea.
 /*
  * EDD stuff
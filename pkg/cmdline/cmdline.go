@@ -149,6 +149,20 @@ func Flag(flag string) (string, bool) {
 	return value, present
 }
 
+// FlagValues returns all values of a flag that may be given more than once,
+// such as nameserver, in order.
+func FlagValues(flag string) []string {
+	once.Do(cmdLineOpener)
+	canonicalFlag := strings.Replace(flag, "-", "_", -1)
+	var values []string
+	doParse(procCmdLine.Raw, func(flag, key, canonicalKey, value, trimmedValue string) {
+		if canonicalKey == canonicalFlag {
+			values = append(values, trimmedValue)
+		}
+	})
+	return values
+}
+
 // getFlagMap gets specified flags as a map
 func getFlagMap(flagName string) map[string]string {
 	return parseToMap(flagName)
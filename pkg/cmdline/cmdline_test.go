@@ -5,6 +5,7 @@
 package cmdline
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -81,3 +82,22 @@ func TestCmdline(t *testing.T) {
 	}
 
 }
+
+func TestFlagValues(t *testing.T) {
+	once.Do(cmdLineOpener)
+	procCmdLine = parse(strings.NewReader(`ip=dhcp nameserver=8.8.8.8 console=tty0 nameserver="1.1.1.1" name-server=9.9.9.9`))
+
+	for _, tt := range []struct {
+		flag string
+		want []string
+	}{
+		{"nameserver", []string{"8.8.8.8", "1.1.1.1"}},
+		{"name-server", []string{"9.9.9.9"}},
+		{"ip", []string{"dhcp"}},
+		{"root", nil},
+	} {
+		if got := FlagValues(tt.flag); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("FlagValues(%q) = %v, want %v", tt.flag, got, tt.want)
+		}
+	}
+}
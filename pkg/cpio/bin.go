@@ -0,0 +1,240 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/u-root/u-root/pkg/uio"
+)
+
+const (
+	binMagic = 070707
+
+	// binHeaderLen is the length of an old binary header including the
+	// magic.
+	binHeaderLen = 26
+)
+
+var (
+	// Bin is the old binary CPIO record format.
+	//
+	// The writer produces little-endian archives; the reader accepts
+	// either byte order.
+	Bin RecordFormat = bin{}
+)
+
+// bin implements RecordFormat for the old binary format.
+type bin struct{}
+
+// binHeader is the old binary header. Times and file sizes are split into
+// two 16-bit halves, most significant half first.
+type binHeader struct {
+	Magic    uint16
+	Dev      uint16
+	Ino      uint16
+	Mode     uint16
+	UID      uint16
+	GID      uint16
+	NLink    uint16
+	Rdev     uint16
+	MTime    [2]uint16
+	NameSize uint16
+	FileSize [2]uint16
+}
+
+func round2(n int64) int64 {
+	return (n + 1) &^ 1
+}
+
+type binWriter struct {
+	w   io.Writer
+	pos int64
+}
+
+// Writer implements RecordFormat.Writer.
+func (bin) Writer(w io.Writer) RecordWriter {
+	return NewDedupWriter(&binWriter{w: w})
+}
+
+func (w *binWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	if err != nil {
+		return 0, err
+	}
+	w.pos += int64(n)
+	return n, nil
+}
+
+func (w *binWriter) pad() error {
+	if o := round2(w.pos); o != w.pos {
+		if _, err := w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteRecord writes old binary cpio records.
+//
+// Inode and device numbers that do not fit the 16-bit header fields are
+// truncated, like GNU cpio does.
+func (w *binWriter) WriteRecord(f Record) error {
+	size := f.FileSize
+	if f.ReaderAt == nil {
+		size = 0
+	}
+	for _, field := range []struct {
+		v    uint64
+		max  uint64
+		name string
+	}{
+		{f.Mode, 0xffff, "mode"},
+		{f.UID, 0xffff, "uid"},
+		{f.GID, 0xffff, "gid"},
+		{f.NLink, 0xffff, "nlink"},
+		{f.Rmajor<<8 | f.Rminor, 0xffff, "rdev"},
+		{f.MTime, 0xffffffff, "mtime"},
+		{uint64(len(f.Name)) + 1, 0xffff, "name size"},
+		{size, 0xffffffff, "file size"},
+	} {
+		if field.v > field.max {
+			return fmt.Errorf("WriteRecord: %s: %s %#x does not fit in binary header", f.Name, field.name, field.v)
+		}
+	}
+
+	hdr := binHeader{
+		Magic:    binMagic,
+		Dev:      uint16(f.Major<<8 | f.Minor),
+		Ino:      uint16(f.Ino),
+		Mode:     uint16(f.Mode),
+		UID:      uint16(f.UID),
+		GID:      uint16(f.GID),
+		NLink:    uint16(f.NLink),
+		Rdev:     uint16(f.Rmajor<<8 | f.Rminor),
+		MTime:    [2]uint16{uint16(f.MTime >> 16), uint16(f.MTime)},
+		NameSize: uint16(len(f.Name) + 1),
+		FileSize: [2]uint16{uint16(size >> 16), uint16(size)},
+	}
+	if err := binary.Write(w, binary.LittleEndian, hdr); err != nil {
+		return err
+	}
+	if _, err := w.Write(append([]byte(f.Name), 0)); err != nil {
+		return err
+	}
+	if err := w.pad(); err != nil {
+		return err
+	}
+
+	if f.ReaderAt == nil {
+		return nil
+	}
+	m, err := io.Copy(w, uio.Reader(f))
+	if err != nil {
+		return err
+	}
+	if m != int64(size) {
+		return fmt.Errorf("WriteRecord: %s: wrote %d bytes of file instead of %d bytes; archive is now corrupt", f.Name, m, size)
+	}
+	if c, ok := f.ReaderAt.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+	return w.pad()
+}
+
+type binReader struct {
+	r   io.ReaderAt
+	pos int64
+}
+
+// Reader implements RecordFormat.Reader.
+func (bin) Reader(r io.ReaderAt) RecordReader {
+	return EOFReader{&binReader{r: r}}
+}
+
+func (r *binReader) read(p []byte) error {
+	n, err := r.r.ReadAt(p, r.pos)
+	if err == io.EOF && n == 0 {
+		return io.EOF
+	}
+	if n != len(p) {
+		return fmt.Errorf("ReadAt(pos = %d): got %d, want %d bytes; error %v", r.pos, n, len(p), err)
+	}
+	r.pos += int64(n)
+	return nil
+}
+
+// binByteOrder returns the byte order of an old binary header given its
+// first two bytes.
+func binByteOrder(magic []byte) (binary.ByteOrder, bool) {
+	switch {
+	case binary.LittleEndian.Uint16(magic) == binMagic:
+		return binary.LittleEndian, true
+	case binary.BigEndian.Uint16(magic) == binMagic:
+		return binary.BigEndian, true
+	}
+	return nil, false
+}
+
+// ReadRecord implements RecordReader for the old binary cpio format.
+func (r *binReader) ReadRecord() (Record, error) {
+	recPos := r.pos
+	buf := make([]byte, binHeaderLen)
+	if err := r.read(buf); err != nil {
+		return Record{}, err
+	}
+	order, ok := binByteOrder(buf)
+	if !ok {
+		return Record{}, fmt.Errorf("reader: magic got %#x, want %#o in either byte order", buf[:2], binMagic)
+	}
+	var hdr binHeader
+	if err := binary.Read(bytes.NewReader(buf), order, &hdr); err != nil {
+		return Record{}, err
+	}
+	if hdr.NameSize == 0 {
+		return Record{}, fmt.Errorf("reader: binary record at %d has no name", recPos)
+	}
+
+	name := make([]byte, hdr.NameSize)
+	if err := r.read(name); err != nil {
+		return Record{}, err
+	}
+	r.pos = round2(r.pos)
+
+	fileSize := uint64(hdr.FileSize[0])<<16 | uint64(hdr.FileSize[1])
+	recLen := uint64(r.pos - recPos)
+	filePos := r.pos
+	content := io.NewSectionReader(r.r, r.pos, int64(fileSize))
+	r.pos = round2(r.pos + int64(fileSize))
+	return fromSource(Record{
+		Info: Info{
+			Ino:      uint64(hdr.Ino),
+			Mode:     uint64(hdr.Mode),
+			UID:      uint64(hdr.UID),
+			GID:      uint64(hdr.GID),
+			NLink:    uint64(hdr.NLink),
+			MTime:    uint64(hdr.MTime[0])<<16 | uint64(hdr.MTime[1]),
+			FileSize: fileSize,
+			Major:    uint64(hdr.Dev >> 8),
+			Minor:    uint64(hdr.Dev & 0xff),
+			Rmajor:   uint64(hdr.Rdev >> 8),
+			Rminor:   uint64(hdr.Rdev & 0xff),
+			Name:     string(name[:hdr.NameSize-1]),
+		},
+		ReaderAt: content,
+		RecLen:   recLen,
+		RecPos:   recPos,
+		FilePos:  filePos,
+	}, r), nil
+}
+
+func init() {
+	formatMap["bin"] = Bin
+}
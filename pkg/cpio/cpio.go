@@ -4,7 +4,9 @@
 
 // Package cpio implements utilities for reading and writing cpio archives.
 //
-// Currently, only newc-formatted cpio archives are supported through cpio.Newc.
+// The newc format is supported through cpio.Newc. The crc, odc, and old
+// binary formats are supported through cpio.CRC, cpio.ODC, and cpio.Bin.
+// Detect figures out which format an archive is in.
 //
 // Reading from or writing to a file:
 //
@@ -60,6 +62,10 @@ type Record struct {
 	RecPos  int64  // Where in the file this record is
 	RecLen  uint64 // How big the record is.
 	FilePos int64  // Where in the CPIO the file's contents are.
+
+	// link identifies the file of a hardlinked record in the archive
+	// or file system it came from. See DedupWriter.
+	link linkKey
 }
 
 // String implements a fmt.Stringer for Record.
@@ -135,6 +141,31 @@ func Format(name string) (RecordFormat, error) {
 	return op, nil
 }
 
+// Detect returns the RecordFormat of the archive in r, going by the magic
+// number of its first record.
+func Detect(r io.ReaderAt) (RecordFormat, error) {
+	magic := make([]byte, magicLen)
+	n, err := r.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	magic = magic[:n]
+	switch string(magic) {
+	case newcMagic:
+		return Newc, nil
+	case crcMagic:
+		return CRC, nil
+	case odcMagic:
+		return ODC, nil
+	}
+	if len(magic) >= 2 {
+		if _, ok := binByteOrder(magic); ok {
+			return Bin, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown cpio format with magic %q", magic)
+}
+
 func modeFromLinux(mode uint64) os.FileMode {
 	m := os.FileMode(mode & 0777)
 	switch mode & syscall.S_IFMT {
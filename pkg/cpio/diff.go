@@ -0,0 +1,202 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpio
+
+import (
+	"path"
+	"sort"
+
+	"github.com/u-root/u-root/pkg/uio"
+	"golang.org/x/sys/unix"
+)
+
+// RecordSummary is the part of a record's metadata that Diff compares.
+type RecordSummary struct {
+	Mode   uint64 `json:"mode"`
+	UID    uint64 `json:"uid"`
+	GID    uint64 `json:"gid"`
+	Size   uint64 `json:"size"`
+	Rmajor uint64 `json:"rmajor,omitempty"`
+	Rminor uint64 `json:"rminor,omitempty"`
+}
+
+func summarize(r Record) *RecordSummary {
+	return &RecordSummary{
+		Mode:   r.Mode,
+		UID:    r.UID,
+		GID:    r.GID,
+		Size:   r.FileSize,
+		Rmajor: r.Rmajor,
+		Rminor: r.Rminor,
+	}
+}
+
+// RecordDiff describes a record that was added, removed, or changed between
+// two archives.
+type RecordDiff struct {
+	Name string `json:"name"`
+
+	// Old and New summarize the record in the old and new archive. Old is
+	// nil for added records, New is nil for removed records.
+	Old *RecordSummary `json:"old,omitempty"`
+	New *RecordSummary `json:"new,omitempty"`
+
+	// Changes are the names of the fields that differ: "mode", "uid",
+	// "gid", "size", "rdev", and "content".
+	Changes []string `json:"changes,omitempty"`
+}
+
+// DirDiff is the change in the total size of the files in a directory and
+// all of its subdirectories.
+type DirDiff struct {
+	Name    string `json:"name"`
+	OldSize uint64 `json:"old_size"`
+	NewSize uint64 `json:"new_size"`
+	Delta   int64  `json:"delta"`
+}
+
+// ArchiveDiff is the difference between two archives.
+type ArchiveDiff struct {
+	// Added, Removed, and Changed are sorted by name.
+	Added   []RecordDiff `json:"added"`
+	Removed []RecordDiff `json:"removed"`
+	Changed []RecordDiff `json:"changed"`
+
+	// Dirs are the directories whose size changed, largest change first.
+	// The root directory is ".".
+	Dirs []DirDiff `json:"dirs"`
+
+	// OldSize and NewSize are the total size of all files in the old and
+	// new archive.
+	OldSize uint64 `json:"old_size"`
+	NewSize uint64 `json:"new_size"`
+}
+
+// Diff compares the records of the archives old and new.
+//
+// Inode numbers, link counts, and modification times are not compared, since
+// they change between otherwise identical builds.
+func Diff(old, new *Archive) *ArchiveDiff {
+	d := &ArchiveDiff{
+		Added:   []RecordDiff{},
+		Removed: []RecordDiff{},
+		Changed: []RecordDiff{},
+		Dirs:    []DirDiff{},
+	}
+
+	for _, name := range sortedNames(old) {
+		o := old.Files[name]
+		n, ok := new.Files[name]
+		if !ok {
+			d.Removed = append(d.Removed, RecordDiff{Name: name, Old: summarize(o)})
+			continue
+		}
+		if changes := recordChanges(o, n); len(changes) > 0 {
+			d.Changed = append(d.Changed, RecordDiff{
+				Name:    name,
+				Old:     summarize(o),
+				New:     summarize(n),
+				Changes: changes,
+			})
+		}
+	}
+	for _, name := range sortedNames(new) {
+		if _, ok := old.Files[name]; !ok {
+			d.Added = append(d.Added, RecordDiff{Name: name, New: summarize(new.Files[name])})
+		}
+	}
+
+	oldDirs, newDirs := DirSizes(old), DirSizes(new)
+	d.OldSize, d.NewSize = oldDirs["."], newDirs["."]
+	for name, size := range newDirs {
+		if size != oldDirs[name] {
+			d.Dirs = append(d.Dirs, dirDiff(name, oldDirs[name], size))
+		}
+	}
+	for name, size := range oldDirs {
+		if _, ok := newDirs[name]; !ok && size != 0 {
+			d.Dirs = append(d.Dirs, dirDiff(name, size, 0))
+		}
+	}
+	sort.Slice(d.Dirs, func(i, j int) bool {
+		di, dj := abs(d.Dirs[i].Delta), abs(d.Dirs[j].Delta)
+		if di != dj {
+			return di > dj
+		}
+		return d.Dirs[i].Name < d.Dirs[j].Name
+	})
+	return d
+}
+
+func dirDiff(name string, oldSize, newSize uint64) DirDiff {
+	return DirDiff{
+		Name:    name,
+		OldSize: oldSize,
+		NewSize: newSize,
+		Delta:   int64(newSize) - int64(oldSize),
+	}
+}
+
+func abs(i int64) int64 {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+func sortedNames(a *Archive) []string {
+	names := make([]string, 0, len(a.Files))
+	for name := range a.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// recordChanges returns the names of the fields that differ between o and n.
+func recordChanges(o, n Record) []string {
+	var changes []string
+	if o.Mode != n.Mode {
+		changes = append(changes, "mode")
+	}
+	if o.UID != n.UID {
+		changes = append(changes, "uid")
+	}
+	if o.GID != n.GID {
+		changes = append(changes, "gid")
+	}
+	if o.FileSize != n.FileSize {
+		changes = append(changes, "size")
+	}
+	if o.Rmajor != n.Rmajor || o.Rminor != n.Rminor {
+		changes = append(changes, "rdev")
+	}
+	if !uio.ReaderAtEqual(o.ReaderAt, n.ReaderAt) {
+		changes = append(changes, "content")
+	}
+	return changes
+}
+
+// DirSizes returns the total size of the files in every directory of a and
+// all of its subdirectories, by directory name. The root directory is ".".
+//
+// Directories that are only implied by the names of records are included.
+func DirSizes(a *Archive) map[string]uint64 {
+	sizes := map[string]uint64{".": 0}
+	for name, r := range a.Files {
+		if r.Mode&unix.S_IFMT == unix.S_IFDIR {
+			if _, ok := sizes[name]; !ok {
+				sizes[name] = 0
+			}
+		}
+		for dir := path.Dir(name); ; dir = path.Dir(dir) {
+			sizes[dir] += r.FileSize
+			if dir == "." || dir == "/" {
+				break
+			}
+		}
+	}
+	return sizes
+}
@@ -0,0 +1,106 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpio
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	owned := StaticFile("etc/passwd", "root:x:0:0", 0644)
+	owned.UID = 1000
+
+	old := ArchiveFromRecords([]Record{
+		Directory("bin", 0755),
+		StaticFile("bin/ls", "ls", 0755),
+		StaticFile("bin/rm", "rm", 0755),
+		Directory("etc", 0755),
+		StaticFile("etc/passwd", "root:x:0:0", 0644),
+		Symlink("etc/mtab", "/proc/mounts"),
+	})
+	new := ArchiveFromRecords([]Record{
+		Directory("bin", 0755),
+		StaticFile("bin/ls", "ls2", 0755),
+		Directory("etc", 0700),
+		owned,
+		Symlink("etc/mtab", "/proc/self/mounts"),
+		Directory("lib", 0755),
+		StaticFile("lib/libc.so", "libc", 0644),
+	})
+
+	want := &ArchiveDiff{
+		Added: []RecordDiff{
+			{Name: "lib", New: &RecordSummary{Mode: 040755}},
+			{Name: "lib/libc.so", New: &RecordSummary{Mode: 0100644, Size: 4}},
+		},
+		Removed: []RecordDiff{
+			{Name: "bin/rm", Old: &RecordSummary{Mode: 0100755, Size: 2}},
+		},
+		Changed: []RecordDiff{
+			{
+				Name:    "bin/ls",
+				Old:     &RecordSummary{Mode: 0100755, Size: 2},
+				New:     &RecordSummary{Mode: 0100755, Size: 3},
+				Changes: []string{"size", "content"},
+			},
+			{
+				Name:    "etc",
+				Old:     &RecordSummary{Mode: 040755},
+				New:     &RecordSummary{Mode: 040700},
+				Changes: []string{"mode"},
+			},
+			{
+				Name:    "etc/mtab",
+				Old:     &RecordSummary{Mode: 0120777, Size: 12},
+				New:     &RecordSummary{Mode: 0120777, Size: 17},
+				Changes: []string{"size", "content"},
+			},
+			{
+				Name:    "etc/passwd",
+				Old:     &RecordSummary{Mode: 0100644, Size: 10},
+				New:     &RecordSummary{Mode: 0100644, UID: 1000, Size: 10},
+				Changes: []string{"uid"},
+			},
+		},
+		Dirs: []DirDiff{
+			{Name: ".", OldSize: 26, NewSize: 34, Delta: 8},
+			{Name: "etc", OldSize: 22, NewSize: 27, Delta: 5},
+			{Name: "lib", OldSize: 0, NewSize: 4, Delta: 4},
+			{Name: "bin", OldSize: 4, NewSize: 3, Delta: -1},
+		},
+		OldSize: 26,
+		NewSize: 34,
+	}
+
+	got := Diff(old, new)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %+v, want %+v", got, want)
+	}
+
+	empty := Diff(old, old)
+	if len(empty.Added)+len(empty.Removed)+len(empty.Changed)+len(empty.Dirs) != 0 {
+		t.Errorf("Diff(a, a) = %+v, want no differences", empty)
+	}
+}
+
+func TestDirSizes(t *testing.T) {
+	a := ArchiveFromRecords([]Record{
+		Directory("empty", 0755),
+		StaticFile("usr/lib/libc.so", "libc", 0644),
+		StaticFile("usr/bin/ls", "ls", 0755),
+		StaticFile("init", "init", 0755),
+	})
+	want := map[string]uint64{
+		".":       10,
+		"empty":   0,
+		"usr":     6,
+		"usr/bin": 2,
+		"usr/lib": 4,
+	}
+	if got := DirSizes(a); !reflect.DeepEqual(got, want) {
+		t.Errorf("DirSizes() = %v, want %v", got, want)
+	}
+}
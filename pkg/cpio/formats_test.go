@@ -0,0 +1,167 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpio
+
+import (
+	"bytes"
+	"io/ioutil"
+	"syscall"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/uio"
+)
+
+func TestFormatsRoundTrip(t *testing.T) {
+	records := []Record{
+		Directory("etc", 0755),
+		StaticRecord([]byte("LANAAAAAAAAAA"), Info{
+			Ino:    1,
+			Mode:   syscall.S_IFREG | 0644,
+			UID:    3,
+			GID:    4,
+			NLink:  1,
+			MTime:  1548000000,
+			Major:  8,
+			Minor:  9,
+			Rmajor: 10,
+			Rminor: 11,
+			Name:   "etc/foobar",
+		}),
+		StaticFile("etc/odd", "odd", 0600),
+		Symlink("etc/link", "foobar"),
+	}
+
+	for _, name := range []string{"newc", "crc", "odc", "bin"} {
+		t.Run(name, func(t *testing.T) {
+			f, err := Format(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := &bytes.Buffer{}
+			w := f.Writer(buf)
+			if err := WriteRecords(w, records); err != nil {
+				t.Fatal(err)
+			}
+			if err := WriteTrailer(w); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, err := Detect(bytes.NewReader(buf.Bytes())); err != nil || got != f {
+				t.Errorf("Detect = %v, %v; want %v", got, err, f)
+			}
+
+			got, err := ReadAllRecords(f.Reader(bytes.NewReader(buf.Bytes())))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(records) {
+				t.Fatalf("read %d records, want %d", len(got), len(records))
+			}
+			for i := range records {
+				if got[i].Info != records[i].Info {
+					t.Errorf("record %d: got %v, want %v", i, got[i].Info, records[i].Info)
+				}
+				if records[i].ReaderAt == nil {
+					continue
+				}
+				want, _ := ioutil.ReadAll(uio.Reader(records[i]))
+				if gotContent, err := ioutil.ReadAll(uio.Reader(got[i])); err != nil || !bytes.Equal(gotContent, want) {
+					t.Errorf("record %d: content is %q (%v), want %q", i, gotContent, err, want)
+				}
+			}
+		})
+	}
+}
+
+var knownRecord = StaticRecord([]byte("hi"), Info{
+	Ino:   1,
+	Mode:  syscall.S_IFREG | 0644,
+	UID:   2,
+	GID:   3,
+	NLink: 1,
+	MTime: 4,
+	Minor: 5,
+	Name:  "a",
+})
+
+func TestODCKnown(t *testing.T) {
+	want := "070707" + "000005" + "000001" + "100644" + "000002" + "000003" + "000001" + "000000" +
+		"00000000004" + "000002" + "00000000002" + "a\x00" + "hi"
+
+	buf := &bytes.Buffer{}
+	if err := ODC.Writer(buf).WriteRecord(knownRecord); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != want {
+		t.Errorf("odc record is %q, want %q", buf.String(), want)
+	}
+}
+
+func TestBinKnown(t *testing.T) {
+	le := []byte{
+		0xc7, 0x71, // magic
+		0x05, 0x00, // dev
+		0x01, 0x00, // ino
+		0xa4, 0x81, // mode
+		0x02, 0x00, // uid
+		0x03, 0x00, // gid
+		0x01, 0x00, // nlink
+		0x00, 0x00, // rdev
+		0x00, 0x00, 0x04, 0x00, // mtime
+		0x02, 0x00, // name size
+		0x00, 0x00, 0x02, 0x00, // file size
+		'a', 0,
+		'h', 'i',
+	}
+
+	buf := &bytes.Buffer{}
+	if err := Bin.Writer(buf).WriteRecord(knownRecord); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), le) {
+		t.Errorf("bin record is %#x, want %#x", buf.Bytes(), le)
+	}
+
+	// Big-endian archives have every 16-bit word swapped.
+	be := append([]byte{}, le...)
+	for i := 0; i < binHeaderLen; i += 2 {
+		be[i], be[i+1] = be[i+1], be[i]
+	}
+	for _, b := range [][]byte{le, be} {
+		rec, err := Bin.Reader(bytes.NewReader(b)).ReadRecord()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Info != knownRecord.Info {
+			t.Errorf("read %v, want %v", rec.Info, knownRecord.Info)
+		}
+	}
+}
+
+func TestCRCMismatch(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := CRC.Writer(buf).WriteRecord(StaticFile("a", "checksummed", 0644)); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	b[bytes.Index(b, []byte("checksummed"))] ^= 1
+
+	if _, err := CRC.Reader(bytes.NewReader(b)).ReadRecord(); err == nil {
+		t.Errorf("ReadRecord of corrupt crc archive succeeded")
+	}
+	// newc does not care.
+	b[5] = '1'
+	if _, err := Newc.Reader(bytes.NewReader(b)).ReadRecord(); err != nil {
+		t.Errorf("ReadRecord of newc archive: %v", err)
+	}
+}
+
+func TestDetectUnknown(t *testing.T) {
+	for _, b := range []string{"", "0", "070708", "not cpio"} {
+		if f, err := Detect(bytes.NewReader([]byte(b))); err == nil {
+			t.Errorf("Detect(%q) = %v, want error", b, f)
+		}
+	}
+}
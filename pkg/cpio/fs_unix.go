@@ -107,8 +107,24 @@ func CreateFile(f Record) error {
 	return CreateFileInRoot(f, ".", true)
 }
 
+// mkParent creates the parent directory of name if it does not exist.
+func mkParent(name string) error {
+	dir := filepath.Dir(name)
+	// The problem: many cpio archives do not specify the directories and
+	// hence the permissions. They just specify the whole path.  In order
+	// to create files in these directories, we have to make them at least
+	// mode 755.
+	if _, err := os.Stat(dir); os.IsNotExist(err) && len(dir) > 0 {
+		return os.MkdirAll(dir, 0755)
+	}
+	return nil
+}
+
 // CreateFileInRoot creates a local file for f relative to rootDir.
 //
+// CreateFileInRoot creates every record as a file of its own. Use an
+// Extractor to restore hardlinks.
+//
 // It will attempt to set all metadata for the file, including ownership,
 // times, and permissions. If these fail, it only returns an error if
 // forcePriv is true.
@@ -121,15 +137,8 @@ func CreateFileInRoot(f Record, rootDir string, forcePriv bool) error {
 	}
 
 	f.Name = filepath.Clean(filepath.Join(rootDir, f.Name))
-	dir := filepath.Dir(f.Name)
-	// The problem: many cpio archives do not specify the directories and
-	// hence the permissions. They just specify the whole path.  In order
-	// to create files in these directories, we have to make them at least
-	// mode 755.
-	if _, err := os.Stat(dir); os.IsNotExist(err) && len(dir) > 0 {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return fmt.Errorf("CreateFileInRoot %q: %v", f.Name, err)
-		}
+	if err := mkParent(f.Name); err != nil {
+		return fmt.Errorf("CreateFileInRoot %q: %v", f.Name, err)
 	}
 
 	switch m {
@@ -178,6 +187,77 @@ func CreateFileInRoot(f Record, rootDir string, forcePriv bool) error {
 	return nil
 }
 
+// An Extractor creates local files for the records of an archive relative
+// to a root directory, restoring hardlinks between them.
+//
+// The life-time of an Extractor is meant to be the same as the extraction of
+// a single CPIO archive.
+type Extractor struct {
+	root      string
+	forcePriv bool
+
+	// links maps hardlinked files to the first path created for them.
+	links map[linkKey]string
+}
+
+// NewExtractor returns an Extractor creating files relative to rootDir.
+//
+// forcePriv is as in CreateFileInRoot.
+func NewExtractor(rootDir string, forcePriv bool) *Extractor {
+	return &Extractor{
+		root:      rootDir,
+		forcePriv: forcePriv,
+		links:     make(map[linkKey]string),
+	}
+}
+
+// CreateFile creates a local file for f as CreateFileInRoot does.
+//
+// If f is a link to a regular file created before, CreateFile creates a
+// hardlink to that file instead. If f has contents, like the last link GNU
+// cpio writes does, they replace the contents of the linked file.
+func (e *Extractor) CreateFile(f Record) error {
+	key, ok := hardlink(f)
+	if !ok {
+		return CreateFileInRoot(f, e.root, e.forcePriv)
+	}
+	old, ok := e.links[key]
+	if !ok {
+		if err := CreateFileInRoot(f, e.root, e.forcePriv); err != nil {
+			return err
+		}
+		e.links[key] = filepath.Clean(filepath.Join(e.root, f.Name))
+		return nil
+	}
+
+	f.Name = filepath.Clean(filepath.Join(e.root, f.Name))
+	if err := mkParent(f.Name); err != nil {
+		return fmt.Errorf("CreateFile %q: %v", f.Name, err)
+	}
+	if err := os.Remove(f.Name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(old, f.Name); err != nil {
+		return err
+	}
+	if f.ReaderAt == nil || f.FileSize == 0 {
+		return nil
+	}
+
+	nf, err := os.OpenFile(f.Name, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	defer nf.Close()
+	if _, err := io.Copy(nf, uio.Reader(f)); err != nil {
+		return err
+	}
+	if err := setModes(f); err != nil && e.forcePriv {
+		return err
+	}
+	return nil
+}
+
 // Inumber and devnumbers are unique to Unix-like
 // operating systems. You can not uniquely disambiguate a file in a
 // Unix system with just an inumber, you need a device number too.
@@ -207,20 +287,20 @@ type Recorder struct {
 // If not, we get a new inumber for it and save the inode away.
 // This eliminates two of the messier parts of creating reproducible
 // output streams.
-func (r *Recorder) inode(i Info) (Info, bool) {
+func (r *Recorder) inode(i Info) Info {
 	d := devInode{dev: i.Dev, ino: i.Ino}
 	i.Dev = 0
 
 	if d, ok := r.inodeMap[d]; ok {
 		i.Ino = d.Ino
-		return i, true
+		return i
 	}
 
 	i.Ino = r.inumber
 	r.inumber++
 	r.inodeMap[d] = i
 
-	return i, false
+	return i
 }
 
 func newLazyFile(name string) io.ReaderAt {
@@ -240,14 +320,14 @@ func (r *Recorder) GetRecord(path string) (Record, error) {
 	}
 
 	sys := fi.Sys().(*syscall.Stat_t)
-	info, done := r.inode(sysInfo(path, sys))
+	info := r.inode(sysInfo(path, sys))
 
 	switch fi.Mode() & os.ModeType {
 	case 0: // Regular file.
-		if done {
-			return Record{Info: info}, nil
-		}
-		return Record{Info: info, ReaderAt: newLazyFile(path)}, nil
+		// All links of a hardlinked file share info.Ino. Each record
+		// carries the contents; DedupWriter decides which link they
+		// are written with.
+		return fromSource(Record{Info: info, ReaderAt: newLazyFile(path)}, r), nil
 
 	case os.ModeSymlink:
 		linkname, err := os.Readlink(path)
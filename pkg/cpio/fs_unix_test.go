@@ -0,0 +1,101 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpio
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestHardlinkRoundTrip(t *testing.T) {
+	src, err := ioutil.TempDir("", "cpio-hardlink-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	dst, err := ioutil.TempDir("", "cpio-hardlink-dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	content := bytes.Repeat([]byte("a large multi-name binary\n"), 1000)
+	if err := ioutil.WriteFile(filepath.Join(src, "a"), content, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"b", "c"} {
+		if err := os.Link(filepath.Join(src, "a"), filepath.Join(src, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "d"), []byte("single"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	w := Newc.Writer(buf)
+	cr := NewRecorder()
+	for _, name := range []string{"a", "b", "d", "c"} {
+		rec, err := cr.GetRecord(filepath.Join(src, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec.Name = name
+		if err := w.WriteRecord(MakeReproducible(rec)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := WriteTrailer(w); err != nil {
+		t.Fatal(err)
+	}
+
+	// The contents are in the archive only once.
+	if n := bytes.Count(buf.Bytes(), content); n != 1 {
+		t.Errorf("archive contains the hardlinked file %d times, want 1", n)
+	}
+
+	recs, err := ReadAllRecords(Newc.Reader(bytes.NewReader(buf.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewExtractor(dst, false)
+	for _, rec := range recs {
+		if err := e.CreateFile(rec); err != nil {
+			t.Fatalf("CreateFile(%v): %v", rec, err)
+		}
+	}
+
+	var ino uint64
+	for _, name := range []string{"a", "b", "c"} {
+		p := filepath.Join(dst, name)
+		got, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("%s has %d bytes of contents, want %d", name, len(got), len(content))
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		if st.Nlink != 3 {
+			t.Errorf("%s has %d links, want 3", name, st.Nlink)
+		}
+		if ino == 0 {
+			ino = st.Ino
+		} else if st.Ino != ino {
+			t.Errorf("%s is not a hardlink of a", name)
+		}
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(dst, "d")); err != nil || string(got) != "single" {
+		t.Errorf("d = %q, %v; want %q", got, err, "single")
+	}
+}
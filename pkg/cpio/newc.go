@@ -16,12 +16,17 @@ import (
 
 const (
 	newcMagic = "070701"
+	crcMagic  = "070702"
 	magicLen  = 6
 )
 
 var (
 	// Newc is the newc CPIO record format.
 	Newc RecordFormat = newc{magic: newcMagic}
+
+	// CRC is the crc CPIO record format. It is the newc format with a
+	// checksum of the file contents in every header.
+	CRC RecordFormat = newc{magic: crcMagic}
 )
 
 type header struct {
@@ -73,11 +78,36 @@ func (h header) Info() Info {
 	return i
 }
 
-// newc implements RecordFormat for the newc format.
+// newc implements RecordFormat for the newc and crc formats.
 type newc struct {
 	magic string
 }
 
+// hasChecksum returns true for the crc format.
+func (n newc) hasChecksum() bool {
+	return n.magic == crcMagic
+}
+
+// checksum returns the crc format checksum of r's contents, which is the sum
+// of all bytes truncated to 32 bits.
+func checksum(r io.ReaderAt) (uint32, error) {
+	var sum uint32
+	buf := make([]byte, 32*1024)
+	rr := uio.Reader(r)
+	for {
+		n, err := rr.Read(buf)
+		for _, b := range buf[:n] {
+			sum += uint32(b)
+		}
+		if err == io.EOF {
+			return sum, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
 // round4 returns the next multiple of 4 close to n.
 func round4(n int64) int64 {
 	return (n + 3) &^ 0x3
@@ -127,6 +157,13 @@ func (w *writer) WriteRecord(f Record) error {
 		hdr.FileSize = 0
 	}
 	hdr.CRC = 0
+	if w.n.hasChecksum() && f.ReaderAt != nil {
+		sum, err := checksum(f)
+		if err != nil {
+			return err
+		}
+		hdr.CRC = sum
+	}
 	if err := binary.Write(buf, binary.BigEndian, hdr); err != nil {
 		return err
 	}
@@ -246,16 +283,26 @@ func (r *reader) ReadRecord() (Record, error) {
 	recLen := uint64(r.pos - recPos)
 	filePos := r.pos
 	content := io.NewSectionReader(r.r, r.pos, int64(hdr.FileSize))
+	if r.n.hasChecksum() {
+		sum, err := checksum(content)
+		if err != nil {
+			return Record{}, err
+		}
+		if sum != hdr.CRC {
+			return Record{}, fmt.Errorf("reader: %q: checksum is %#x, header says %#x", info.Name, sum, hdr.CRC)
+		}
+	}
 	r.pos = round4(r.pos + int64(hdr.FileSize))
-	return Record{
+	return fromSource(Record{
 		Info:     info,
 		ReaderAt: content,
 		RecLen:   recLen,
 		RecPos:   recPos,
 		FilePos:  filePos,
-	}, nil
+	}, r), nil
 }
 
 func init() {
 	formatMap["newc"] = Newc
+	formatMap["crc"] = CRC
 }
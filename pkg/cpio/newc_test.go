@@ -493,6 +493,9 @@ func TestReproducible(t *testing.T) {
 	if err := WriteRecords(w, rec); err != nil {
 		t.Errorf("Could not write record %q: %v", rec[0].Name, err)
 	}
+	if err := WriteTrailer(w); err != nil {
+		t.Errorf("Could not write trailer: %v", err)
+	}
 	rec[0].ReaderAt = bytes.NewReader(contents)
 	b2 := &bytes.Buffer{}
 	w = Newc.Writer(b2)
@@ -500,6 +503,9 @@ func TestReproducible(t *testing.T) {
 	if err := WriteRecords(w, rec); err != nil {
 		t.Errorf("Could not write record %q: %v", rec[0].Name, err)
 	}
+	if err := WriteTrailer(w); err != nil {
+		t.Errorf("Could not write trailer: %v", err)
+	}
 
 	if reflect.DeepEqual(b1.Bytes()[:], b2.Bytes()[:]) {
 		t.Error("Reproducible: compared as same, wanted different")
@@ -515,6 +521,9 @@ func TestReproducible(t *testing.T) {
 	if err := WriteRecords(w, rec); err != nil {
 		t.Errorf("Could not write record %q: %v", rec[0].Name, err)
 	}
+	if err := WriteTrailer(w); err != nil {
+		t.Errorf("Could not write trailer: %v", err)
+	}
 
 	b2 = &bytes.Buffer{}
 	w = Newc.Writer(b2)
@@ -524,6 +533,9 @@ func TestReproducible(t *testing.T) {
 	if err := WriteRecords(w, rec); err != nil {
 		t.Errorf("Could not write record %q: %v", rec[0].Name, err)
 	}
+	if err := WriteTrailer(w); err != nil {
+		t.Errorf("Could not write trailer: %v", err)
+	}
 
 	if len(b1.Bytes()) != len(b2.Bytes()) {
 		t.Fatalf("Reproducible \n%v,\n%v: len is different, wanted same", b1.Bytes()[:], b2.Bytes()[:])
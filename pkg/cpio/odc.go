@@ -0,0 +1,183 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpio
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/u-root/u-root/pkg/uio"
+)
+
+const (
+	odcMagic = "070707"
+
+	// odcHeaderLen is the length of an odc header including the magic.
+	odcHeaderLen = 76
+)
+
+var (
+	// ODC is the POSIX.1 portable ASCII CPIO record format, also called
+	// the old character format.
+	ODC RecordFormat = odc{}
+)
+
+// odc implements RecordFormat for the odc format.
+type odc struct{}
+
+// odcField describes one octal header field.
+type odcField struct {
+	v     uint64
+	width int
+	name  string
+}
+
+type odcWriter struct {
+	w io.Writer
+}
+
+// Writer implements RecordFormat.Writer.
+func (odc) Writer(w io.Writer) RecordWriter {
+	return NewDedupWriter(&odcWriter{w: w})
+}
+
+// WriteRecord writes odc cpio records.
+//
+// Inode and device numbers that do not fit the 18-bit header fields are
+// truncated, like GNU cpio does.
+func (w *odcWriter) WriteRecord(f Record) error {
+	size := f.FileSize
+	if f.ReaderAt == nil {
+		size = 0
+	}
+	fields := []odcField{
+		{(f.Major<<8 | f.Minor) & 0777777, 6, "device"},
+		{f.Ino & 0777777, 6, "inode"},
+		{f.Mode, 6, "mode"},
+		{f.UID, 6, "uid"},
+		{f.GID, 6, "gid"},
+		{f.NLink, 6, "nlink"},
+		{f.Rmajor<<8 | f.Rminor, 6, "rdev"},
+		{f.MTime, 11, "mtime"},
+		{uint64(len(f.Name)) + 1, 6, "name size"},
+		{size, 11, "file size"},
+	}
+	hdr := make([]byte, 0, odcHeaderLen+len(f.Name)+1)
+	hdr = append(hdr, odcMagic...)
+	for _, field := range fields {
+		s := strconv.FormatUint(field.v, 8)
+		if len(s) > field.width {
+			return fmt.Errorf("WriteRecord: %s: %s %#o does not fit in odc header", f.Name, field.name, field.v)
+		}
+		for i := len(s); i < field.width; i++ {
+			hdr = append(hdr, '0')
+		}
+		hdr = append(hdr, s...)
+	}
+	hdr = append(hdr, f.Name...)
+	hdr = append(hdr, 0)
+	if _, err := w.w.Write(hdr); err != nil {
+		return err
+	}
+
+	if f.ReaderAt == nil {
+		return nil
+	}
+	m, err := io.Copy(w.w, uio.Reader(f))
+	if err != nil {
+		return err
+	}
+	if m != int64(size) {
+		return fmt.Errorf("WriteRecord: %s: wrote %d bytes of file instead of %d bytes; archive is now corrupt", f.Name, m, size)
+	}
+	if c, ok := f.ReaderAt.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+type odcReader struct {
+	r   io.ReaderAt
+	pos int64
+}
+
+// Reader implements RecordFormat.Reader.
+func (odc) Reader(r io.ReaderAt) RecordReader {
+	return EOFReader{&odcReader{r: r}}
+}
+
+func (r *odcReader) read(p []byte) error {
+	n, err := r.r.ReadAt(p, r.pos)
+	if err == io.EOF && n == 0 {
+		return io.EOF
+	}
+	if n != len(p) {
+		return fmt.Errorf("ReadAt(pos = %d): got %d, want %d bytes; error %v", r.pos, n, len(p), err)
+	}
+	r.pos += int64(n)
+	return nil
+}
+
+// ReadRecord implements RecordReader for the odc cpio format.
+func (r *odcReader) ReadRecord() (Record, error) {
+	recPos := r.pos
+	buf := make([]byte, odcHeaderLen)
+	if err := r.read(buf); err != nil {
+		return Record{}, err
+	}
+	if magic := string(buf[:magicLen]); magic != odcMagic {
+		return Record{}, fmt.Errorf("reader: magic got %q, want %q", magic, odcMagic)
+	}
+
+	var v [10]uint64
+	off := magicLen
+	for i, width := range []int{6, 6, 6, 6, 6, 6, 6, 11, 6, 11} {
+		n, err := strconv.ParseUint(string(buf[off:off+width]), 8, 64)
+		if err != nil {
+			return Record{}, fmt.Errorf("reader: invalid odc header field %q: %v", buf[off:off+width], err)
+		}
+		v[i] = n
+		off += width
+	}
+	dev, ino, mode, uid, gid, nlink, rdev, mtime, nameSize, fileSize := v[0], v[1], v[2], v[3], v[4], v[5], v[6], v[7], v[8], v[9]
+	if nameSize == 0 {
+		return Record{}, fmt.Errorf("reader: odc record at %d has no name", recPos)
+	}
+
+	name := make([]byte, nameSize)
+	if err := r.read(name); err != nil {
+		return Record{}, err
+	}
+
+	recLen := uint64(r.pos - recPos)
+	filePos := r.pos
+	content := io.NewSectionReader(r.r, r.pos, int64(fileSize))
+	r.pos += int64(fileSize)
+	return fromSource(Record{
+		Info: Info{
+			Ino:      ino,
+			Mode:     mode,
+			UID:      uid,
+			GID:      gid,
+			NLink:    nlink,
+			MTime:    mtime,
+			FileSize: fileSize,
+			Major:    dev >> 8,
+			Minor:    dev & 0xff,
+			Rmajor:   rdev >> 8,
+			Rminor:   rdev & 0xff,
+			Name:     string(name[:nameSize-1]),
+		},
+		ReaderAt: content,
+		RecLen:   recLen,
+		RecPos:   recPos,
+		FilePos:  filePos,
+	}, r), nil
+}
+
+func init() {
+	formatMap["odc"] = ODC
+}
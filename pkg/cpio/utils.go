@@ -93,16 +93,67 @@ func (r EOFReader) ReadRecord() (Record, error) {
 	return rec, nil
 }
 
+// linkKey identifies the file that hardlinked records refer to.
+//
+// Inode numbers are only unique within the archive or file system a record
+// came from, its source, so records from different sources never share a
+// linkKey.
+type linkKey struct {
+	source            interface{}
+	major, minor, ino uint64
+}
+
+// hardlink returns the linkKey of r if r is a regular file with more than
+// one link.
+func hardlink(r Record) (linkKey, bool) {
+	if r.Mode&modeTypeMask != modeFile || r.NLink < 2 {
+		return linkKey{}, false
+	}
+	if r.link.source != nil {
+		return r.link, true
+	}
+	return linkKey{major: r.Major, minor: r.Minor, ino: r.Ino}, true
+}
+
+// fromSource marks r as read from source, which is a RecordReader or a
+// Recorder, if r is hardlinked.
+func fromSource(r Record, source interface{}) Record {
+	if key, ok := hardlink(r); ok {
+		key.source = source
+		r.link = key
+	}
+	return r
+}
+
 // DedupWriter is a RecordWriter that does not write more than one record with
 // the same path.
 //
 // There seems to be no harm done in stripping duplicate names when the record
 // is written, and lots of harm done if we don't do it.
+//
+// DedupWriter also writes hardlinks the way GNU cpio does: records of a
+// regular file with more than one link are held back until all NLink of
+// them have been seen, or until the trailer is written. They are then
+// written together, with the file contents only on the last one.
+//
+// Hardlinked records get inode numbers of the archive's own, counting from
+// 1 in the order their files are first seen, so that files from different
+// sources, e.g. a base archive and the local file system, are not linked
+// because their inode numbers collide.
 type DedupWriter struct {
 	rw RecordWriter
 
 	// alreadyWritten keeps track of paths already written to rw.
 	alreadyWritten map[string]struct{}
+
+	// links are the hardlinked records held back, by file.
+	links map[linkKey][]Record
+
+	// linkOrder is the order in which files in links were first seen.
+	linkOrder []linkKey
+
+	// inos are the inode numbers given to files in the archive.
+	inos map[linkKey]uint64
 }
 
 // NewDedupWriter returns a new deduplicating rw.
@@ -110,6 +161,8 @@ func NewDedupWriter(rw RecordWriter) RecordWriter {
 	return &DedupWriter{
 		rw:             rw,
 		alreadyWritten: make(map[string]struct{}),
+		links:          make(map[linkKey][]Record),
+		inos:           make(map[linkKey]uint64),
 	}
 }
 
@@ -120,11 +173,72 @@ func NewDedupWriter(rw RecordWriter) RecordWriter {
 func (dw *DedupWriter) WriteRecord(rec Record) error {
 	rec.Name = Normalize(rec.Name)
 
+	if rec.Name == Trailer {
+		for _, key := range dw.linkOrder {
+			if err := dw.writeLinks(key); err != nil {
+				return err
+			}
+		}
+		dw.linkOrder = nil
+		return dw.rw.WriteRecord(rec)
+	}
+
 	if _, ok := dw.alreadyWritten[rec.Name]; ok {
 		return nil
 	}
 	dw.alreadyWritten[rec.Name] = struct{}{}
-	return dw.rw.WriteRecord(rec)
+
+	key, ok := hardlink(rec)
+	if !ok {
+		return dw.rw.WriteRecord(rec)
+	}
+	ino, ok := dw.inos[key]
+	if !ok {
+		ino = uint64(len(dw.inos)) + 1
+		dw.inos[key] = ino
+	}
+	rec.Ino = ino
+	if _, ok := dw.links[key]; !ok {
+		dw.linkOrder = append(dw.linkOrder, key)
+	}
+	dw.links[key] = append(dw.links[key], rec)
+	if uint64(len(dw.links[key])) < rec.NLink {
+		return nil
+	}
+	return dw.writeLinks(key)
+}
+
+// writeLinks writes the held back records of the file key.
+//
+// Any of the records may carry the contents, e.g. if they were read from an
+// archive written by GNU cpio and then reordered. The contents are moved to
+// the last record written.
+func (dw *DedupWriter) writeLinks(key linkKey) error {
+	recs, ok := dw.links[key]
+	if !ok {
+		return nil
+	}
+	delete(dw.links, key)
+
+	var content Record
+	for _, r := range recs {
+		if r.ReaderAt != nil && (r.FileSize > 0 || content.ReaderAt == nil) {
+			content = r
+		}
+	}
+	for i, r := range recs {
+		if i == len(recs)-1 {
+			r.ReaderAt = content.ReaderAt
+			r.FileSize = content.FileSize
+		} else {
+			r.ReaderAt = nil
+			r.FileSize = 0
+		}
+		if err := dw.rw.WriteRecord(r); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // WriteRecords writes multiple records to w.
@@ -216,8 +330,18 @@ func Normalize(path string) string {
 // again, with the same files presented to it in the same order, and those
 // files have unchanged contents, the cpio file it produces will be bit-for-bit
 // identical. This is an essential property for firmware-embedded payloads.
+//
+// Regular files with more than one link keep their link count, so that
+// hardlinks survive, and DedupWriter gives them inode numbers that only
+// depend on the order in which they are written.
 func MakeReproducible(r Record) Record {
-	r.Ino = 0
+	if key, linked := hardlink(r); linked {
+		// Keep the device, which is part of the file's identity.
+		r.link = key
+	} else {
+		r.Ino = 0
+		r.NLink = 0
+	}
 	r.Name = Normalize(r.Name)
 	r.MTime = 0
 	r.UID = 0
@@ -225,7 +349,6 @@ func MakeReproducible(r Record) Record {
 	r.Dev = 0
 	r.Major = 0
 	r.Minor = 0
-	r.NLink = 0
 	return r
 }
 
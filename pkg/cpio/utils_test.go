@@ -5,7 +5,14 @@
 package cpio
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/u-root/u-root/pkg/uio"
+	"golang.org/x/sys/unix"
 )
 
 func TestNormalize(t *testing.T) {
@@ -51,3 +58,171 @@ func TestNormalize(t *testing.T) {
 		}
 	}
 }
+
+// recordingWriter is a RecordWriter that keeps all records written to it.
+type recordingWriter struct {
+	recs []Record
+}
+
+func (rw *recordingWriter) WriteRecord(r Record) error {
+	rw.recs = append(rw.recs, r)
+	return nil
+}
+
+func TestDedupWriterHardlinks(t *testing.T) {
+	link := func(name string, content string, nlink uint64) Record {
+		return StaticRecord([]byte(content), Info{
+			Name:  name,
+			Mode:  unix.S_IFREG | 0755,
+			Ino:   7,
+			NLink: nlink,
+		})
+	}
+	for _, tt := range []struct {
+		name   string
+		in     []Record
+		want   []string
+		dataOn string
+	}{
+		{
+			name:   "all links",
+			in:     []Record{link("bin/a", "data", 3), StaticFile("etc/x", "x", 0644), link("bin/b", "data", 3), link("bin/c", "data", 3)},
+			want:   []string{"etc/x", "bin/a", "bin/b", "bin/c", Trailer},
+			dataOn: "bin/c",
+		},
+		{
+			name:   "missing link flushed by trailer",
+			in:     []Record{link("bin/a", "data", 3), link("bin/b", "data", 3), StaticFile("etc/x", "x", 0644)},
+			want:   []string{"etc/x", "bin/a", "bin/b", Trailer},
+			dataOn: "bin/b",
+		},
+		{
+			name:   "contents on first link",
+			in:     []Record{link("bin/a", "data", 2), link("bin/b", "", 2)},
+			want:   []string{"bin/a", "bin/b", Trailer},
+			dataOn: "bin/b",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := &recordingWriter{}
+			w := NewDedupWriter(rw)
+			if err := WriteRecords(w, tt.in); err != nil {
+				t.Fatal(err)
+			}
+			if err := WriteTrailer(w); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, r := range rw.recs {
+				got = append(got, r.Name)
+				if r.Mode&unix.S_IFMT != unix.S_IFREG || r.NLink < 2 {
+					continue
+				}
+				var content []byte
+				if r.ReaderAt != nil {
+					content, _ = ioutil.ReadAll(uio.Reader(r))
+				}
+				if r.Name == tt.dataOn && string(content) != "data" {
+					t.Errorf("%s has contents %q, want %q", r.Name, content, "data")
+				}
+				if r.Name != tt.dataOn && (len(content) != 0 || r.FileSize != 0) {
+					t.Errorf("%s has contents %q (size %d), want none", r.Name, content, r.FileSize)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("wrote %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("wrote %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestDedupWriterInodeCollision(t *testing.T) {
+	// A base archive whose hardlinked file has inode 0.
+	var base bytes.Buffer
+	bw := Newc.Writer(&base)
+	for _, name := range []string{"bin/a", "bin/b"} {
+		if err := bw.WriteRecord(StaticRecord([]byte("base"), Info{
+			Name:  name,
+			Mode:  unix.S_IFREG | 0755,
+			NLink: 2,
+		})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := WriteTrailer(bw); err != nil {
+		t.Fatal(err)
+	}
+
+	// New files whose hardlinked file a Recorder also gives inode 0.
+	dir, err := ioutil.TempDir("", "cpio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "x"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(dir, "x"), filepath.Join(dir, "y")); err != nil {
+		t.Fatal(err)
+	}
+
+	recs, err := ReadAllRecords(EOFReader{Newc.Reader(bytes.NewReader(base.Bytes()))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr := NewRecorder()
+	for _, name := range []string{"x", "y"} {
+		r, err := cr.GetRecord(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Name = name
+		recs = append(recs, r)
+	}
+
+	var out bytes.Buffer
+	w := Newc.Writer(&out)
+	for _, r := range recs {
+		if err := w.WriteRecord(MakeReproducible(r)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := WriteTrailer(w); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadAllRecords(EOFReader{Newc.Reader(bytes.NewReader(out.Bytes()))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ino := make(map[string]uint64)
+	contents := make(map[uint64]string)
+	for _, r := range got {
+		ino[r.Name] = r.Ino
+		if r.FileSize > 0 {
+			c, err := ioutil.ReadAll(uio.Reader(r))
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents[r.Ino] = string(c)
+		}
+	}
+	if ino["bin/a"] != ino["bin/b"] || ino["x"] != ino["y"] {
+		t.Errorf("links have different inodes: %v", ino)
+	}
+	if ino["bin/a"] == ino["x"] {
+		t.Errorf("files of the base archive and the file system share inode %d", ino["x"])
+	}
+	for name, want := range map[string]string{"bin/a": "base", "x": "new"} {
+		if c := contents[ino[name]]; c != want {
+			t.Errorf("%s has contents %q, want %q", name, c, want)
+		}
+	}
+}
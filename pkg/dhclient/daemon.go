@@ -0,0 +1,273 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// minRetry is the shortest time between attempts to renew or rebind
+	// a lease, as in RFC 2131, Section 4.4.5.
+	minRetry = 60 * time.Second
+
+	// requestRetry is the time between attempts to get a new lease.
+	requestRetry = 10 * time.Second
+)
+
+// forever is longer than any lease.
+const forever = time.Duration(math.MaxInt64)
+
+// timedLease is a lease that must be renewed.
+type timedLease interface {
+	Lease
+
+	// Timers returns when the lease must be renewed (T1) and rebound
+	// (T2), and when it expires, counted from when it was granted. An
+	// expiry of 0 means the lease never expires.
+	Timers() (t1, t2, expiry time.Duration)
+
+	// Deconfigure removes the configuration of the lease from its
+	// interface.
+	Deconfigure() error
+}
+
+// leaser obtains and extends the leases of one protocol on one interface.
+type leaser interface {
+	request(ctx context.Context) (timedLease, error)
+
+	// renew and rebind return errNAK if the server refuses to extend
+	// the lease.
+	renew(ctx context.Context, l timedLease) (timedLease, error)
+	rebind(ctx context.Context, l timedLease) (timedLease, error)
+}
+
+// keeper keeps a lease of one protocol on one interface configured.
+type keeper struct {
+	name string
+	l    leaser
+
+	lease timedLease
+	bound time.Time
+
+	// up is whether the link is up. links receives changes.
+	up    bool
+	links chan bool
+
+	// rebindNow makes the keeper rebind its lease when the link comes
+	// back up, in case it moved to another network.
+	rebindNow bool
+}
+
+func newKeeper(name string, l leaser, up bool) *keeper {
+	return &keeper{
+		name:  name,
+		l:     l,
+		up:    up,
+		links: make(chan bool, 8),
+	}
+}
+
+// run keeps the lease until ctx is done.
+func (k *keeper) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		// While the link is down, only wait for it to come up.
+		d := forever
+		if k.up {
+			if d = k.step(ctx); d == 0 {
+				continue
+			}
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+		case up := <-k.links:
+			k.link(up)
+		case <-t.C:
+		}
+		t.Stop()
+	}
+}
+
+// step takes the next step to keep the lease and returns how long to wait
+// before the next one.
+func (k *keeper) step(ctx context.Context) time.Duration {
+	if k.lease == nil {
+		l, err := k.l.request(ctx)
+		if err != nil {
+			log.Printf("%s: could not get a lease: %v", k.name, err)
+			return requestRetry
+		}
+		k.bind(l)
+		return 0
+	}
+
+	t1, t2, expiry := k.lease.Timers()
+	elapsed := time.Since(k.bound)
+	left := forever
+	if expiry > 0 {
+		left = expiry - elapsed
+	}
+	switch {
+	case left <= 0:
+		log.Printf("%s: %s expired", k.name, k.lease)
+		k.unbind()
+		return 0
+	case k.rebindNow:
+		k.rebindNow = false
+		return k.extend(ctx, "rebind", k.l.rebind, left)
+	case expiry == 0:
+		return forever
+	case elapsed >= t2:
+		return k.extend(ctx, "rebind", k.l.rebind, left)
+	case elapsed >= t1:
+		return k.extend(ctx, "renew", k.l.renew, t2-elapsed)
+	}
+	return t1 - elapsed
+}
+
+// extend renews or rebinds the lease. If that fails, it waits half of the
+// time left until the next stage, but at least minRetry.
+func (k *keeper) extend(ctx context.Context, what string, f func(context.Context, timedLease) (timedLease, error), left time.Duration) time.Duration {
+	log.Printf("%s: trying to %s %s", k.name, what, k.lease)
+	l, err := f(ctx, k.lease)
+	if err == errNAK {
+		log.Printf("%s: could not %s %s: %v", k.name, what, k.lease, err)
+		k.unbind()
+		return 0
+	}
+	if err != nil {
+		log.Printf("%s: could not %s %s: %v", k.name, what, k.lease, err)
+		d := left / 2
+		if d < minRetry {
+			d = minRetry
+		}
+		if d > left {
+			d = left
+		}
+		return d
+	}
+	k.bind(l)
+	return 0
+}
+
+func (k *keeper) bind(l timedLease) {
+	if err := l.Configure(); err != nil {
+		log.Printf("%s: could not configure %s: %v", k.name, l, err)
+	} else {
+		log.Printf("%s: configured %s", k.name, l)
+	}
+	k.lease, k.bound = l, time.Now()
+}
+
+func (k *keeper) unbind() {
+	if err := k.lease.Deconfigure(); err != nil {
+		log.Printf("%s: could not deconfigure %s: %v", k.name, k.lease, err)
+	}
+	k.lease = nil
+}
+
+func (k *keeper) link(up bool) {
+	if up == k.up {
+		return
+	}
+	k.up = up
+	if !up {
+		log.Printf("%s: link down", k.name)
+		return
+	}
+	log.Printf("%s: link up", k.name)
+	k.rebindNow = k.lease != nil
+}
+
+// notify tells the keeper listening on ch whether the link is up. If the
+// keeper is busy and ch is full, the oldest state is dropped.
+func notify(ch chan bool, up bool) {
+	for {
+		select {
+		case ch <- up:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// Daemon gets leases on ifs like SendRequests and keeps them until ctx is
+// done.
+//
+// It configures each lease and renews it at T1, or rebinds it at T2. If the
+// server refuses to extend a lease or it expires, Daemon removes its
+// configuration and gets a new lease. While an interface is down, its
+// leases are left alone; when it comes back up, they are rebound.
+//
+// For IPv6, it configures interfaces as their routers advertise, like
+// SendRequests, and solicits the routers again before the lifetimes of the
+// SLAAC addresses and routes run out.
+func Daemon(ctx context.Context, ifs []netlink.Link, ipv4, ipv6 bool, c Config) error {
+	updates := make(chan netlink.LinkUpdate)
+	done := make(chan struct{})
+	defer close(done)
+	if err := netlink.LinkSubscribe(updates, done); err != nil {
+		return fmt.Errorf("cannot watch links: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	keepers := make(map[int][]*keeper)
+	for _, iface := range ifs {
+		name := iface.Attrs().Name
+		up := true
+		log.Printf("Bringing up interface %s...", name)
+		if _, err := IfUp(name); err != nil {
+			log.Printf("Could not bring up interface %s: %v", name, err)
+			up = false
+		}
+
+		idx := iface.Attrs().Index
+		if ipv4 {
+			keepers[idx] = append(keepers[idx], newKeeper(fmt.Sprintf("%s (%s)", name, NetIPv4), &client4{iface, c}, up))
+		}
+		if ipv6 {
+			keepers[idx] = append(keepers[idx], newKeeper(fmt.Sprintf("%s (%s)", name, NetIPv6), &client6{iface, c}, up))
+		}
+		for _, k := range keepers[idx] {
+			wg.Add(1)
+			go func(k *keeper) {
+				defer wg.Done()
+				k.run(ctx)
+			}(k)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case u, ok := <-updates:
+			if !ok {
+				return fmt.Errorf("link updates stopped")
+			}
+			up := u.IfInfomsg.Flags&unix.IFF_UP != 0 && u.IfInfomsg.Flags&unix.IFF_RUNNING != 0
+			for _, k := range keepers[int(u.Index)] {
+				notify(k.links, up)
+			}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+// fakeLease records its configuration in its leaser's events.
+type fakeLease struct {
+	n          int
+	t1, t2, ex time.Duration
+	f          *fakeLeaser
+}
+
+func (l *fakeLease) String() string                           { return fmt.Sprintf("lease %d", l.n) }
+func (l *fakeLease) Configure() error                         { l.f.event("configure %d", l.n); return nil }
+func (l *fakeLease) Deconfigure() error                       { l.f.event("deconfigure %d", l.n); return nil }
+func (l *fakeLease) Timers() (t1, t2, expiry time.Duration)   { return l.t1, l.t2, l.ex }
+func (l *fakeLease) Boot() (*url.URL, error)                  { return nil, ErrNoBootFile }
+func (l *fakeLease) ISCSIBoot() (*net.TCPAddr, string, error) { return nil, "", ErrNoBootFile }
+func (l *fakeLease) Link() netlink.Link                       { return nil }
+
+// fakeLeaser grants leases with the timers of lease, and fails renewals and
+// rebinds with renewErr and rebindErr.
+type fakeLeaser struct {
+	lease     fakeLease
+	renewErr  error
+	rebindErr error
+
+	mu     sync.Mutex
+	n      int
+	events []string
+}
+
+func (f *fakeLeaser) event(format string, v ...interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, fmt.Sprintf(format, v...))
+}
+
+func (f *fakeLeaser) next() timedLease {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.n++
+	l := f.lease
+	l.n, l.f = f.n, f
+	return &l
+}
+
+func (f *fakeLeaser) request(ctx context.Context) (timedLease, error) {
+	f.event("request")
+	return f.next(), nil
+}
+
+func (f *fakeLeaser) renew(ctx context.Context, l timedLease) (timedLease, error) {
+	f.event("renew %d", l.(*fakeLease).n)
+	if f.renewErr != nil {
+		return nil, f.renewErr
+	}
+	return f.next(), nil
+}
+
+func (f *fakeLeaser) rebind(ctx context.Context, l timedLease) (timedLease, error) {
+	f.event("rebind %d", l.(*fakeLease).n)
+	if f.rebindErr != nil {
+		return nil, f.rebindErr
+	}
+	return f.next(), nil
+}
+
+// waitEvents waits until f recorded n events and returns them. Retries make
+// the number of attempts vary, so repeated events count once.
+func (f *fakeLeaser) waitEvents(t *testing.T, n int) []string {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		f.mu.Lock()
+		events := dedup(f.events)
+		f.mu.Unlock()
+		if len(events) >= n {
+			return events[:n]
+		}
+		time.Sleep(time.Millisecond)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t.Fatalf("timed out waiting for %d events, got %v", n, f.events)
+	return nil
+}
+
+func TestKeeper(t *testing.T) {
+	defer func(m, r time.Duration) {
+		minRetry, requestRetry = m, r
+	}(minRetry, requestRetry)
+	minRetry, requestRetry = time.Millisecond, time.Millisecond
+
+	short := fakeLease{t1: 10 * time.Millisecond, t2: 20 * time.Millisecond, ex: 30 * time.Millisecond}
+	for _, tt := range []struct {
+		name string
+		f    *fakeLeaser
+		want []string
+	}{
+		{
+			name: "renew",
+			f:    &fakeLeaser{lease: short},
+			want: []string{"request", "configure 1", "renew 1", "configure 2", "renew 2", "configure 3"},
+		},
+		{
+			name: "rebind",
+			f:    &fakeLeaser{lease: short, renewErr: errors.New("timeout")},
+			want: []string{"request", "configure 1", "renew 1", "rebind 1", "configure 2"},
+		},
+		{
+			name: "nak",
+			f:    &fakeLeaser{lease: short, renewErr: errNAK},
+			want: []string{"request", "configure 1", "renew 1", "deconfigure 1", "request", "configure 2"},
+		},
+		{
+			name: "expire",
+			f:    &fakeLeaser{lease: short, renewErr: errors.New("timeout"), rebindErr: errors.New("timeout")},
+			want: []string{"request", "configure 1", "renew 1", "rebind 1", "deconfigure 1", "request", "configure 2"},
+		},
+		{
+			name: "infinite",
+			f:    &fakeLeaser{},
+			want: []string{"request", "configure 1"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			k := newKeeper(tt.name, tt.f, true)
+			done := make(chan struct{})
+			go func() {
+				k.run(ctx)
+				close(done)
+			}()
+			got := tt.f.waitEvents(t, len(tt.want))
+			cancel()
+			<-done
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig6Timers(t *testing.T) {
+	ra := &PacketRA{RA: &RouterAdvertisement{RouterLifetime: 30 * time.Minute}}
+	for _, tt := range []struct {
+		name           string
+		l              *config6
+		t1, t2, expiry time.Duration
+	}{
+		{"router", &config6{ra: ra}, 15 * time.Minute, 24 * time.Minute, 30 * time.Minute},
+		{"dhcp", &config6{dhcp: NewPacket6(nil, reply6(t, 60, 120, 300, 600))}, time.Minute, 2 * time.Minute, 10 * time.Minute},
+		{"both", &config6{ra: ra, dhcp: NewPacket6(nil, reply6(t, 0, 0, 3600, 7200))}, 15 * time.Minute, 24 * time.Minute, 30 * time.Minute},
+		{"infinite lease", &config6{ra: ra, dhcp: NewPacket6(nil, reply6(t, 0, 0, 0xffffffff, 0xffffffff))}, 15 * time.Minute, 24 * time.Minute, 30 * time.Minute},
+		{"infinite", &config6{ra: &PacketRA{RA: &RouterAdvertisement{}}}, 0, 0, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if t1, t2, expiry := tt.l.Timers(); t1 != tt.t1 || t2 != tt.t2 || expiry != tt.expiry {
+				t.Errorf("Timers() = %v, %v, %v, want %v, %v, %v", t1, t2, expiry, tt.t1, tt.t2, tt.expiry)
+			}
+		})
+	}
+}
+
+// dedup removes repeated events.
+func dedup(events []string) []string {
+	var d []string
+	for i, e := range events {
+		if i == 0 || e != events[i-1] {
+			d = append(d, e)
+		}
+	}
+	return d
+}
+
+func TestKeeperLink(t *testing.T) {
+	f := &fakeLeaser{lease: fakeLease{t1: time.Hour, t2: 2 * time.Hour, ex: 3 * time.Hour}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	k := newKeeper("link", f, false)
+	done := make(chan struct{})
+	go func() {
+		k.run(ctx)
+		close(done)
+	}()
+
+	// The lease is requested once the link is up.
+	notify(k.links, true)
+	f.waitEvents(t, 2)
+
+	// The lease is rebound when the link comes back up.
+	notify(k.links, false)
+	notify(k.links, true)
+	got := f.waitEvents(t, 4)
+	cancel()
+	<-done
+	if want := []string{"request", "configure 1", "rebind 1", "configure 2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
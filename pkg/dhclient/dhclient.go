@@ -23,6 +23,7 @@ import (
 	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/insomniacslk/dhcp/dhcpv6/nclient6"
+	"github.com/insomniacslk/dhcp/iana"
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
 )
@@ -135,7 +136,7 @@ type Config struct {
 	LogLevel LogLevel
 }
 
-func lease4(ctx context.Context, iface netlink.Link, c Config) (Lease, error) {
+func (c Config) opts4() []nclient4.ClientOpt {
 	mods := []nclient4.ClientOpt{
 		nclient4.WithTimeout(c.Timeout),
 		nclient4.WithRetry(c.Retries),
@@ -146,10 +147,29 @@ func lease4(ctx context.Context, iface netlink.Link, c Config) (Lease, error) {
 	case LogDebug:
 		mods = append(mods, nclient4.WithDebugLogger())
 	}
-	client, err := nclient4.New(iface.Attrs().Name, mods...)
+	return mods
+}
+
+func (c Config) opts6() []nclient6.ClientOpt {
+	mods := []nclient6.ClientOpt{
+		nclient6.WithTimeout(c.Timeout),
+		nclient6.WithRetry(c.Retries),
+	}
+	switch c.LogLevel {
+	case LogSummary:
+		mods = append(mods, nclient6.WithSummaryLogger())
+	case LogDebug:
+		mods = append(mods, nclient6.WithDebugLogger())
+	}
+	return mods
+}
+
+func lease4(ctx context.Context, iface netlink.Link, c Config) (Lease, error) {
+	client, err := nclient4.New(iface.Attrs().Name, c.opts4()...)
 	if err != nil {
 		return nil, err
 	}
+	defer client.Close()
 
 	log.Printf("Attempting to get DHCPv4 lease on %s", iface.Attrs().Name)
 	_, p, err := client.Request(ctx, dhcpv4.WithNetboot,
@@ -164,7 +184,9 @@ func lease4(ctx context.Context, iface netlink.Link, c Config) (Lease, error) {
 	return packet, nil
 }
 
-func lease6(ctx context.Context, iface netlink.Link, c Config) (Lease, error) {
+// waitIPv6LinkReady waits until iface has a link-local address that is not
+// tentative.
+func waitIPv6LinkReady(ctx context.Context, iface netlink.Link, c Config) error {
 	// For ipv6, we cannot bind to the port until Duplicate Address
 	// Detection (DAD) is complete which is indicated by the link being no
 	// longer marked as "tentative". This usually takes about a second.
@@ -174,34 +196,31 @@ func lease6(ctx context.Context, iface netlink.Link, c Config) (Lease, error) {
 	linkTimeout := time.After(c.Timeout)
 	for {
 		if ready, err := isIpv6LinkReady(iface); err != nil {
-			return nil, err
+			return err
 		} else if ready {
-			break
+			return nil
 		}
 		select {
 		case <-time.After(100 * time.Millisecond):
 			continue
 		case <-linkTimeout:
-			return nil, errors.New("timeout after waiting for a non-tentative IPv6 address")
+			return errors.New("timeout after waiting for a non-tentative IPv6 address")
 		case <-ctx.Done():
-			return nil, errors.New("timeout after waiting for a non-tentative IPv6 address")
+			return errors.New("timeout after waiting for a non-tentative IPv6 address")
 		}
 	}
+}
 
-	mods := []nclient6.ClientOpt{
-		nclient6.WithTimeout(c.Timeout),
-		nclient6.WithRetry(c.Retries),
-	}
-	switch c.LogLevel {
-	case LogSummary:
-		mods = append(mods, nclient6.WithSummaryLogger())
-	case LogDebug:
-		mods = append(mods, nclient6.WithDebugLogger())
+func lease6(ctx context.Context, iface netlink.Link, c Config) (Lease, error) {
+	if err := waitIPv6LinkReady(ctx, iface, c); err != nil {
+		return nil, err
 	}
-	client, err := nclient6.New(iface.Attrs().Name, mods...)
+
+	client, err := nclient6.New(iface.Attrs().Name, c.opts6()...)
 	if err != nil {
 		return nil, err
 	}
+	defer client.Close()
 
 	log.Printf("Attempting to get DHCPv6 lease on %s", iface.Attrs().Name)
 	p, err := client.RapidSolicit(ctx, dhcpv6.WithNetboot)
@@ -214,6 +233,83 @@ func lease6(ctx context.Context, iface netlink.Link, c Config) (Lease, error) {
 	return packet, nil
 }
 
+// info6 asks DHCPv6 servers for the configuration other than addresses, as
+// in RFC 8415, Section 18.2.6.
+func info6(ctx context.Context, iface netlink.Link, c Config) (*dhcpv6.Message, error) {
+	client, err := nclient6.New(iface.Attrs().Name, c.opts6()...)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	msg, err := dhcpv6.NewMessage()
+	if err != nil {
+		return nil, err
+	}
+	msg.MessageType = dhcpv6.MessageTypeInformationRequest
+	msg.AddOption(&dhcpv6.OptClientId{Cid: dhcpv6.Duid{
+		Type:          dhcpv6.DUID_LL,
+		HwType:        iana.HWTypeEthernet,
+		LinkLayerAddr: iface.Attrs().HardwareAddr,
+	}})
+	msg.AddOption(&dhcpv6.OptElapsedTime{})
+	oro := &dhcpv6.OptRequestedOption{}
+	oro.SetRequestedOptions([]dhcpv6.OptionCode{
+		dhcpv6.OptionDNSRecursiveNameServer,
+		dhcpv6.OptionDomainSearchList,
+	})
+	msg.AddOption(oro)
+	dhcpv6.WithNetboot(msg)
+
+	log.Printf("Attempting to get DHCPv6 information on %s", iface.Attrs().Name)
+	return client.SendAndRead(ctx, nclient6.AllDHCPRelayAgentsAndServers, msg, nclient6.IsMessageType(dhcpv6.MessageTypeReply))
+}
+
+// requests6 configures iface as the routers on its link advertise: with
+// SLAAC, with SLAAC and stateless DHCPv6, or with stateful DHCPv6. Without
+// routers, only DHCPv6 can configure it.
+//
+// The router advertisement and the DHCPv6 lease are separate results.
+func requests6(ctx context.Context, iface netlink.Link, c Config) []*Result {
+	name := iface.Attrs().Name
+	if err := waitIPv6LinkReady(ctx, iface, c); err != nil {
+		return []*Result{{NetIPv6, iface, nil, err}}
+	}
+
+	ra, err := packetRA(ctx, iface, c)
+	if err != nil {
+		log.Printf("No IPv6 router on %s, trying DHCPv6: %v", name, err)
+		lease, err := lease6(ctx, iface, c)
+		return []*Result{{NetIPv6, iface, lease, err}}
+	}
+	results := []*Result{{NetIPv6, iface, ra, nil}}
+	if ra.RA.Autoconf() == StatefulDHCPv6 {
+		lease, err := lease6(ctx, iface, c)
+		results = append(results, &Result{NetIPv6, iface, lease, err})
+	}
+	return results
+}
+
+// packetRA solicits routers on iface and returns the configuration they
+// advertise, with the DHCPv6 information for stateless DHCPv6.
+func packetRA(ctx context.Context, iface netlink.Link, c Config) (*PacketRA, error) {
+	name := iface.Attrs().Name
+	log.Printf("Soliciting IPv6 routers on %s", name)
+	ra, err := SolicitRouter(ctx, iface)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Got router advertisement on %s from %s: %s", name, ra.Router, ra.Autoconf())
+
+	var info *dhcpv6.Message
+	if ra.Autoconf() == StatelessDHCPv6 {
+		if info, err = info6(ctx, iface, c); err != nil {
+			log.Printf("Could not get DHCPv6 information on %s: %v", name, err)
+		}
+	}
+	return NewPacketRA(iface, ra, info), nil
+}
+
 type NetworkProtocol int
 
 const (
@@ -254,7 +350,8 @@ type Result struct {
 // SendRequests coordinates soliciting DHCP configuration on all ifs.
 //
 // ipv4 and ipv6 determine whether to send DHCPv4 and DHCPv6 requests,
-// respectively.
+// respectively. For IPv6, routers are solicited first; their advertisements
+// are results of their own, and decide whether DHCPv6 is needed.
 //
 // The *Result channel will be closed when all requests have completed.
 func SendRequests(ctx context.Context, ifs []netlink.Link, ipv4, ipv6 bool, c Config) chan *Result {
@@ -286,8 +383,9 @@ func SendRequests(ctx context.Context, ifs []netlink.Link, ipv4, ipv6 bool, c Co
 				wg.Add(1)
 				go func(iface netlink.Link) {
 					defer wg.Done()
-					lease, err := lease6(ctx, iface, c)
-					r <- &Result{NetIPv6, iface, lease, err}
+					for _, result := range requests6(ctx, iface, c) {
+						r <- result
+					}
 				}(iface)
 			}
 		}(iface)
@@ -7,9 +7,11 @@ package dhclient
 import (
 	"errors"
 	"fmt"
+	"log"
 	"net"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/vishvananda/netlink"
@@ -72,9 +74,26 @@ func (p *Packet4) Configure() error {
 		return fmt.Errorf("add/replace %s to %v: %v", dst, p.iface, err)
 	}
 
+	for _, r := range p.routes() {
+		if err := netlink.RouteReplace(r); err != nil {
+			return fmt.Errorf("%s: add %s: %v", p.iface.Attrs().Name, r, err)
+		}
+	}
+
+	nameServers, searchList, domain := p.GatherDNSSettings()
+	if err := WriteDNSSettings(nameServers, searchList, domain); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// routes returns the routes of the lease.
+func (p *Packet4) routes() []*netlink.Route {
 	// RFC 3442 notes that if classless static routes are available, they
 	// have priority. You have to ignore the Route Option.
 	if routes := p.P.ClasslessStaticRoute(); routes != nil {
+		var rs []*netlink.Route
 		for _, route := range routes {
 			r := &netlink.Route{
 				LinkIndex: p.iface.Attrs().Index,
@@ -85,28 +104,66 @@ func (p *Packet4) Configure() error {
 			if r.Gw == nil || r.Gw.Equal(net.IPv4zero) {
 				r.Scope = netlink.SCOPE_LINK
 			}
-
-			if err := netlink.RouteReplace(r); err != nil {
-				return fmt.Errorf("%s: add %s: %v", p.iface.Attrs().Name, r, err)
-			}
+			rs = append(rs, r)
 		}
-	} else if gw := p.P.Router(); gw != nil && len(gw) > 0 {
-		r := &netlink.Route{
+		return rs
+	}
+	if gw := p.P.Router(); len(gw) > 0 {
+		return []*netlink.Route{{
 			LinkIndex: p.iface.Attrs().Index,
 			Gw:        gw[0],
-		}
+		}}
+	}
+	return nil
+}
 
-		if err := netlink.RouteReplace(r); err != nil {
-			return fmt.Errorf("%s: add %s: %v", p.iface.Attrs().Name, r, err)
+// Deconfigure removes the address and routes of the lease from the
+// interface.
+func (p *Packet4) Deconfigure() error {
+	for _, r := range p.routes() {
+		// The kernel may already have removed the route.
+		if err := netlink.RouteDel(r); err != nil {
+			log.Printf("%s: delete %s: %v", p.iface.Attrs().Name, r, err)
 		}
 	}
+	dst := &netlink.Addr{
+		IPNet: p.Lease(),
+	}
+	if err := netlink.AddrDel(p.iface, dst); err != nil {
+		return fmt.Errorf("delete %s from %v: %v", dst, p.iface.Attrs().Name, err)
+	}
+	return nil
+}
 
-	nameServers, searchList, domain := p.GatherDNSSettings()
-	if err := WriteDNSSettings(nameServers, searchList, domain); err != nil {
-		return err
+// Timers returns when the lease must be renewed (T1) and rebound (T2), and
+// when it expires, counted from when it was acknowledged. Without renewal
+// and rebinding time options, T1 is half and T2 seven eighths of the lease
+// time, as RFC 2131 suggests.
+//
+// An expiry of 0 means the lease never expires.
+func (p *Packet4) Timers() (t1, t2, expiry time.Duration) {
+	expiry = p.P.IPAddressLeaseTime(0)
+	if expiry == infiniteLease4 {
+		return 0, 0, 0
 	}
+	return durationOption(p.P, dhcpv4.OptionRenewTimeValue, expiry/2),
+		durationOption(p.P, dhcpv4.OptionRebindingTimeValue, expiry*7/8),
+		expiry
+}
 
-	return nil
+// infiniteLease4 is the lease time of leases that never expire.
+const infiniteLease4 = 0xffffffff * time.Second
+
+func durationOption(p *dhcpv4.DHCPv4, code dhcpv4.OptionCode, def time.Duration) time.Duration {
+	v := p.Options.Get(code)
+	if v == nil {
+		return def
+	}
+	var d dhcpv4.Duration
+	if err := d.FromBytes(v); err != nil {
+		return def
+	}
+	return time.Duration(d)
 }
 
 func (p *Packet4) String() string {
@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/insomniacslk/dhcp/dhcpv4"
 )
@@ -103,3 +104,44 @@ func TestBoot(t *testing.T) {
 		})
 	}
 }
+
+func TestTimers4(t *testing.T) {
+	for i, tt := range []struct {
+		message        *dhcpv4.DHCPv4
+		t1, t2, expiry time.Duration
+	}{
+		{
+			message: mustNew(t),
+		},
+		{
+			message: mustNew(t,
+				dhcpv4.WithLeaseTime(3600),
+			),
+			t1:     30 * time.Minute,
+			t2:     52*time.Minute + 30*time.Second,
+			expiry: time.Hour,
+		},
+		{
+			message: mustNew(t,
+				dhcpv4.WithLeaseTime(3600),
+				dhcpv4.WithGeneric(dhcpv4.OptionRenewTimeValue, []byte{0, 0, 0, 60}),
+				dhcpv4.WithGeneric(dhcpv4.OptionRebindingTimeValue, []byte{0, 0, 0, 120}),
+			),
+			t1:     time.Minute,
+			t2:     2 * time.Minute,
+			expiry: time.Hour,
+		},
+		{
+			message: mustNew(t,
+				dhcpv4.WithLeaseTime(0xffffffff),
+			),
+		},
+	} {
+		t.Run(fmt.Sprintf("test%d", i), func(t *testing.T) {
+			t1, t2, expiry := NewPacket4(nil, tt.message).Timers()
+			if t1 != tt.t1 || t2 != tt.t2 || expiry != tt.expiry {
+				t.Errorf("Timers() = %v, %v, %v, want %v, %v, %v", t1, t2, expiry, tt.t1, tt.t2, tt.expiry)
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"net"
 	"net/url"
 	"os"
+	"time"
 
 	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/vishvananda/netlink"
@@ -48,10 +49,7 @@ func (p *Packet6) Configure() error {
 
 	// Add the address to the iface.
 	dst := &netlink.Addr{
-		IPNet: &net.IPNet{
-			IP:   l.IPv6Addr,
-			Mask: net.IPMask(net.ParseIP("ffff:ffff:ffff:ffff::")),
-		},
+		IPNet:       addr6(l),
 		PreferedLft: int(l.PreferredLifetime),
 		ValidLft:    int(l.ValidLifetime),
 		// Optimistic DAD (Duplicate Address Detection) means we can
@@ -74,6 +72,54 @@ func (p *Packet6) Configure() error {
 	return nil
 }
 
+func addr6(l *dhcpv6.OptIAAddress) *net.IPNet {
+	return &net.IPNet{
+		IP:   l.IPv6Addr,
+		Mask: net.IPMask(net.ParseIP("ffff:ffff:ffff:ffff::")),
+	}
+}
+
+// Deconfigure removes the address of the lease from the interface.
+func (p *Packet6) Deconfigure() error {
+	l := p.Lease()
+	if l == nil {
+		return fmt.Errorf("no lease returned")
+	}
+	dst := &netlink.Addr{
+		IPNet: addr6(l),
+	}
+	if err := netlink.AddrDel(p.iface, dst); err != nil {
+		return fmt.Errorf("delete %s from %v: %v", dst, p.iface.Attrs().Name, err)
+	}
+	return nil
+}
+
+// Timers returns when the lease must be renewed (T1) and rebound (T2), and
+// when it expires, counted from when it was received. If the server leaves
+// T1 and T2 to the client, they are half and 0.8 times the preferred
+// lifetime, as RFC 8415 suggests.
+//
+// An expiry of 0 means the lease never expires.
+func (p *Packet6) Timers() (t1, t2, expiry time.Duration) {
+	iana, ok := p.p.GetOneOption(dhcpv6.OptionIANA).(*dhcpv6.OptIANA)
+	l := p.Lease()
+	if !ok || l == nil || l.ValidLifetime == infiniteLifetime6 {
+		return 0, 0, 0
+	}
+	t1 = time.Duration(iana.T1) * time.Second
+	t2 = time.Duration(iana.T2) * time.Second
+	if iana.T1 == 0 {
+		t1 = time.Duration(l.PreferredLifetime) * time.Second / 2
+	}
+	if iana.T2 == 0 {
+		t2 = time.Duration(l.PreferredLifetime) * time.Second * 4 / 5
+	}
+	return t1, t2, time.Duration(l.ValidLifetime) * time.Second
+}
+
+// infiniteLifetime6 is the lifetime of addresses that never expire.
+const infiniteLifetime6 = 0xffffffff
+
 func (p *Packet6) String() string {
 	return fmt.Sprintf("IPv6 DHCP Lease IP %s", p.Lease().IPv6Addr)
 }
@@ -0,0 +1,101 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+func reply6(t *testing.T, t1, t2, preferred, valid uint32, opts ...dhcpv6.Option) *dhcpv6.Message {
+	m, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatalf("NewMessage() = %v", err)
+	}
+	m.MessageType = dhcpv6.MessageTypeReply
+	duid := dhcpv6.Duid{Type: dhcpv6.DUID_LL, HwType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{1, 2, 3, 4, 5, 6}}
+	m.AddOption(&dhcpv6.OptClientId{Cid: duid})
+	m.AddOption(&dhcpv6.OptServerId{Sid: duid})
+	ia := &dhcpv6.OptIANA{T1: t1, T2: t2}
+	ia.AddOption(&dhcpv6.OptIAAddress{
+		IPv6Addr:          net.ParseIP("2001:db8::1"),
+		PreferredLifetime: preferred,
+		ValidLifetime:     valid,
+	})
+	m.AddOption(ia)
+	for _, o := range opts {
+		m.AddOption(o)
+	}
+	return m
+}
+
+func TestTimers6(t *testing.T) {
+	for i, tt := range []struct {
+		message        *dhcpv6.Message
+		t1, t2, expiry time.Duration
+	}{
+		{
+			message: reply6(t, 60, 120, 300, 600),
+			t1:      time.Minute,
+			t2:      2 * time.Minute,
+			expiry:  10 * time.Minute,
+		},
+		{
+			message: reply6(t, 0, 0, 300, 600),
+			t1:      150 * time.Second,
+			t2:      4 * time.Minute,
+			expiry:  10 * time.Minute,
+		},
+		{
+			message: reply6(t, 0, 0, 0xffffffff, 0xffffffff),
+		},
+	} {
+		t.Run(fmt.Sprintf("test%d", i), func(t *testing.T) {
+			t1, t2, expiry := NewPacket6(nil, tt.message).Timers()
+			if t1 != tt.t1 || t2 != tt.t2 || expiry != tt.expiry {
+				t.Errorf("Timers() = %v, %v, %v, want %v, %v, %v", t1, t2, expiry, tt.t1, tt.t2, tt.expiry)
+			}
+		})
+	}
+}
+
+func TestNewExtend6(t *testing.T) {
+	reply := reply6(t, 60, 120, 300, 600)
+	for _, tt := range []struct {
+		typ      dhcpv6.MessageType
+		serverID bool
+	}{
+		{dhcpv6.MessageTypeRenew, true},
+		{dhcpv6.MessageTypeRebind, false},
+	} {
+		m, err := newExtend6(reply, tt.typ)
+		if err != nil {
+			t.Fatalf("newExtend6(%s) = %v", tt.typ, err)
+		}
+		if m.MessageType != tt.typ {
+			t.Errorf("newExtend6(%s) is a %s", tt.typ, m.MessageType)
+		}
+		if m.GetOneOption(dhcpv6.OptionClientID) == nil || m.GetOneOption(dhcpv6.OptionIANA) == nil {
+			t.Errorf("newExtend6(%s) = %s, want client ID and IA_NA", tt.typ, m)
+		}
+		if got := m.GetOneOption(dhcpv6.OptionServerID) != nil; got != tt.serverID {
+			t.Errorf("newExtend6(%s) has server ID: %t, want %t", tt.typ, got, tt.serverID)
+		}
+	}
+}
+
+func TestNoBinding(t *testing.T) {
+	if noBinding(reply6(t, 60, 120, 300, 600)) {
+		t.Errorf("noBinding(reply) = true, want false")
+	}
+	if !noBinding(reply6(t, 60, 120, 300, 600, &dhcpv6.OptStatusCode{StatusCode: iana.StatusNoBinding})) {
+		t.Errorf("noBinding(NoBinding reply) = false, want true")
+	}
+}
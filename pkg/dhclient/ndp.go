@@ -0,0 +1,148 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/u-root/u-root/pkg/ubinary"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// routerSolicitations and routerSolicitationInterval are
+	// MAX_RTR_SOLICITATIONS and RTR_SOLICITATION_INTERVAL of RFC 4861.
+	routerSolicitations        = 3
+	routerSolicitationInterval = 4 * time.Second
+
+	allRouters = net.ParseIP("ff02::2")
+)
+
+// ErrNoRouter is returned when no router answers router solicitations.
+var ErrNoRouter = errors.New("no router advertisement received")
+
+// SolicitRouter sends router solicitations on iface and returns the first
+// router advertisement, as in RFC 4861, Section 6.3.7. It returns
+// ErrNoRouter if no router answers.
+func SolicitRouter(ctx context.Context, iface netlink.Link) (*RouterAdvertisement, error) {
+	name := iface.Attrs().Name
+	conn, err := listenICMPv6(name)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	rs := routerSolicitation(iface.Attrs().HardwareAddr)
+	dst := &net.IPAddr{IP: allRouters, Zone: name}
+	for i := 0; i < routerSolicitations; i++ {
+		if _, err := conn.WriteToIP(rs, dst); err != nil {
+			return nil, fmt.Errorf("cannot send router solicitation on %s: %v", name, err)
+		}
+		deadline := time.Now().Add(routerSolicitationInterval)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		ra, err := readRouterAdvertisement(conn, deadline)
+		if err == nil {
+			return ra, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if e, ok := err.(net.Error); !ok || !e.Timeout() {
+			return nil, err
+		}
+	}
+	return nil, ErrNoRouter
+}
+
+// listenICMPv6 returns a raw ICMPv6 socket on the interface called name
+// that sends neighbor discovery messages with a hop limit of 255, and
+// reports the hop limit of received ones.
+func listenICMPv6(name string) (*net.IPConn, error) {
+	conn, err := net.ListenIP("ip6:ipv6-icmp", &net.IPAddr{IP: net.IPv6unspecified})
+	if err != nil {
+		return nil, err
+	}
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		if serr = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, name); serr != nil {
+			return
+		}
+		if serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_HOPS, 255); serr != nil {
+			return
+		}
+		serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_RECVHOPLIMIT, 1)
+	}); err != nil {
+		serr = err
+	}
+	if serr != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot set up ICMPv6 socket on %s: %v", name, serr)
+	}
+	return conn, nil
+}
+
+// routerSolicitation returns a router solicitation with the source link
+// address hw.
+func routerSolicitation(hw net.HardwareAddr) []byte {
+	// Type, code, checksum (filled in by the kernel) and reserved.
+	rs := []byte{icmpRouterSolicitation, 0, 0, 0, 0, 0, 0, 0}
+	if len(hw) == 6 {
+		rs = append(rs, optSourceLinkAddr, 1)
+		rs = append(rs, hw...)
+	}
+	return rs
+}
+
+// readRouterAdvertisement returns the first valid router advertisement
+// received on conn before deadline. Advertisements must come from a
+// link-local address with a hop limit of 255, or they were forwarded.
+func readRouterAdvertisement(conn *net.IPConn, deadline time.Time) (*RouterAdvertisement, error) {
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 1500)
+	oob := make([]byte, 64)
+	for {
+		n, oobn, _, addr, err := conn.ReadMsgIP(b, oob)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 || b[0] != icmpRouterAdvertisement || !addr.IP.IsLinkLocalUnicast() || hopLimit(oob[:oobn]) != 255 {
+			continue
+		}
+		ra, err := ParseRouterAdvertisement(b[:n])
+		if err != nil {
+			continue
+		}
+		ra.Router = addr.IP
+		return ra, nil
+	}
+}
+
+// hopLimit returns the hop limit in the control messages oob, or -1.
+func hopLimit(oob []byte) int {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return -1
+	}
+	for _, m := range msgs {
+		if m.Header.Level == unix.IPPROTO_IPV6 && m.Header.Type == unix.IPV6_HOPLIMIT && len(m.Data) >= 4 {
+			return int(int32(ubinary.NativeEndian.Uint32(m.Data)))
+		}
+	}
+	return -1
+}
@@ -0,0 +1,420 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/u-root/u-root/pkg/uio"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// ICMPv6 message types of RFC 4861.
+const (
+	icmpRouterSolicitation  = 133
+	icmpRouterAdvertisement = 134
+)
+
+// Neighbor discovery option types of RFC 4861, 6106 and 8106.
+const (
+	optSourceLinkAddr = 1
+	optPrefixInfo     = 3
+	optMTU            = 5
+	optRDNSS          = 25
+	optDNSSL          = 31
+)
+
+// Autoconf is how hosts configure their IPv6 addresses, as routers tell them
+// with the M and O flags of their advertisements.
+type Autoconf int
+
+const (
+	// SLAAC means hosts make up their addresses from the advertised
+	// prefixes.
+	SLAAC Autoconf = iota
+
+	// StatelessDHCPv6 is SLAAC, with other configuration such as DNS
+	// servers from DHCPv6.
+	StatelessDHCPv6
+
+	// StatefulDHCPv6 means DHCPv6 assigns the addresses.
+	StatefulDHCPv6
+)
+
+func (a Autoconf) String() string {
+	switch a {
+	case SLAAC:
+		return "SLAAC"
+	case StatelessDHCPv6:
+		return "stateless DHCPv6"
+	case StatefulDHCPv6:
+		return "stateful DHCPv6"
+	}
+	return fmt.Sprintf("unknown autoconfiguration (%d)", int(a))
+}
+
+// Prefix is a prefix information option of a router advertisement.
+type Prefix struct {
+	Prefix *net.IPNet
+
+	// OnLink means the addresses of the prefix are on the link.
+	OnLink bool
+
+	// Autonomous means hosts may make up addresses in the prefix.
+	Autonomous bool
+
+	ValidLifetime     time.Duration
+	PreferredLifetime time.Duration
+}
+
+// RouterAdvertisement is a router advertisement of RFC 4861.
+type RouterAdvertisement struct {
+	// Router is the link-local address the advertisement came from.
+	Router net.IP
+
+	CurHopLimit uint8
+
+	// Managed and Other are the M and O flags.
+	Managed bool
+	Other   bool
+
+	// RouterLifetime is how long the router is a default router. Zero
+	// means it is not one.
+	RouterLifetime time.Duration
+	ReachableTime  time.Duration
+	RetransTimer   time.Duration
+
+	SourceLinkAddr net.HardwareAddr
+	MTU            uint32
+	Prefixes       []Prefix
+
+	// DNS and SearchList are the recursive DNS servers and DNS search
+	// list of RFC 8106.
+	DNS        []net.IP
+	SearchList []string
+}
+
+// ParseRouterAdvertisement parses the ICMPv6 message b as a router
+// advertisement.
+func ParseRouterAdvertisement(b []byte) (*RouterAdvertisement, error) {
+	buf := uio.NewBigEndianBuffer(b)
+	if typ := buf.Read8(); typ != icmpRouterAdvertisement {
+		return nil, fmt.Errorf("ICMPv6 type %d is not a router advertisement", typ)
+	}
+	if code := buf.Read8(); code != 0 {
+		return nil, fmt.Errorf("invalid router advertisement code %d", code)
+	}
+	buf.Read16() // Checksum, checked by the kernel.
+
+	ra := &RouterAdvertisement{
+		CurHopLimit: buf.Read8(),
+	}
+	flags := buf.Read8()
+	ra.Managed = flags&0x80 != 0
+	ra.Other = flags&0x40 != 0
+	ra.RouterLifetime = time.Duration(buf.Read16()) * time.Second
+	ra.ReachableTime = time.Duration(buf.Read32()) * time.Millisecond
+	ra.RetransTimer = time.Duration(buf.Read32()) * time.Millisecond
+	if err := buf.Error(); err != nil {
+		return nil, fmt.Errorf("short router advertisement: %v", err)
+	}
+
+	for buf.Len() > 0 {
+		typ, length := buf.Read8(), int(buf.Read8())
+		if err := buf.Error(); err != nil {
+			return nil, fmt.Errorf("short router advertisement option: %v", err)
+		}
+		if length == 0 {
+			return nil, errors.New("router advertisement option with length 0")
+		}
+		// The length counts units of 8 bytes, including type and length.
+		data := buf.Consume(8*length - 2)
+		if err := buf.Error(); err != nil {
+			return nil, fmt.Errorf("router advertisement option %d: %v", typ, err)
+		}
+		if err := ra.parseOption(typ, data); err != nil {
+			return nil, err
+		}
+	}
+	return ra, nil
+}
+
+func (ra *RouterAdvertisement) parseOption(typ uint8, data []byte) error {
+	buf := uio.NewBigEndianBuffer(data)
+	switch typ {
+	case optSourceLinkAddr:
+		ra.SourceLinkAddr = net.HardwareAddr(data)
+		return nil
+	case optPrefixInfo:
+		length := int(buf.Read8())
+		flags := buf.Read8()
+		p := Prefix{
+			OnLink:            flags&0x80 != 0,
+			Autonomous:        flags&0x40 != 0,
+			ValidLifetime:     time.Duration(buf.Read32()) * time.Second,
+			PreferredLifetime: time.Duration(buf.Read32()) * time.Second,
+		}
+		buf.Read32() // Reserved.
+		ip := net.IP(buf.CopyN(net.IPv6len))
+		if length > 128 {
+			return fmt.Errorf("invalid prefix length %d", length)
+		}
+		p.Prefix = &net.IPNet{IP: ip, Mask: net.CIDRMask(length, 128)}
+		ra.Prefixes = append(ra.Prefixes, p)
+	case optMTU:
+		buf.Read16() // Reserved.
+		ra.MTU = buf.Read32()
+	case optRDNSS:
+		buf.Read16() // Reserved.
+		if lifetime := buf.Read32(); lifetime == 0 {
+			// The servers must no longer be used.
+			return nil
+		}
+		for buf.Len() >= net.IPv6len {
+			ra.DNS = append(ra.DNS, net.IP(buf.CopyN(net.IPv6len)))
+		}
+	case optDNSSL:
+		buf.Read16() // Reserved.
+		if lifetime := buf.Read32(); lifetime == 0 {
+			return nil
+		}
+		l, err := rfc1035label.FromBytes(buf.ReadAll())
+		if err != nil {
+			return fmt.Errorf("invalid DNS search list: %v", err)
+		}
+		// Padding to 8 bytes looks like empty names.
+		for _, name := range l.Labels {
+			if name != "" {
+				ra.SearchList = append(ra.SearchList, name)
+			}
+		}
+	}
+	if err := buf.Error(); err != nil {
+		return fmt.Errorf("router advertisement option %d: %v", typ, err)
+	}
+	return nil
+}
+
+// Autoconf returns how the router wants hosts to configure their
+// addresses.
+func (ra *RouterAdvertisement) Autoconf() Autoconf {
+	switch {
+	case ra.Managed:
+		return StatefulDHCPv6
+	case ra.Other:
+		return StatelessDHCPv6
+	}
+	return SLAAC
+}
+
+// slaacAddr returns the address of the interface with hardware address hw
+// in prefix, with the modified EUI-64 interface identifier of RFC 4291,
+// Appendix A. It returns nil if hw is not a MAC address or prefix is not a
+// /64.
+func slaacAddr(prefix *net.IPNet, hw net.HardwareAddr) net.IP {
+	if ones, bits := prefix.Mask.Size(); ones != 64 || bits != 128 || len(hw) != 6 {
+		return nil
+	}
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, prefix.IP.To16()[:8])
+	ip[8] = hw[0] ^ 0x02
+	ip[9], ip[10] = hw[1], hw[2]
+	ip[11], ip[12] = 0xff, 0xfe
+	ip[13], ip[14], ip[15] = hw[3], hw[4], hw[5]
+	return ip
+}
+
+// PacketRA is the IPv6 configuration of a router advertisement and, for
+// stateless DHCPv6, the reply to the DHCPv6 information request.
+type PacketRA struct {
+	iface netlink.Link
+	RA    *RouterAdvertisement
+
+	// Info is the DHCPv6 reply. It may be nil.
+	Info *dhcpv6.Message
+}
+
+var _ Lease = &PacketRA{}
+
+// NewPacketRA wraps a router advertisement and the DHCPv6 reply info, which
+// may be nil, with some convenience methods.
+func NewPacketRA(iface netlink.Link, ra *RouterAdvertisement, info *dhcpv6.Message) *PacketRA {
+	return &PacketRA{
+		iface: iface,
+		RA:    ra,
+		Info:  info,
+	}
+}
+
+// Link is a netlink link
+func (p *PacketRA) Link() netlink.Link {
+	return p.iface
+}
+
+// Addrs returns the addresses that SLAAC makes up for the interface in the
+// autonomous prefixes. Routers may advertise them even if DHCPv6 assigns
+// addresses, too.
+func (p *PacketRA) Addrs() []*netlink.Addr {
+	var addrs []*netlink.Addr
+	for _, prefix := range p.RA.Prefixes {
+		if !prefix.Autonomous || prefix.ValidLifetime == 0 || prefix.PreferredLifetime > prefix.ValidLifetime {
+			continue
+		}
+		ip := slaacAddr(prefix.Prefix, p.iface.Attrs().HardwareAddr)
+		if ip == nil || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		mask := prefix.Prefix.Mask
+		if !prefix.OnLink {
+			// Without the prefix route, the other addresses of the
+			// prefix are reached through the router.
+			mask = net.CIDRMask(128, 128)
+		}
+		addrs = append(addrs, &netlink.Addr{
+			IPNet:       &net.IPNet{IP: ip, Mask: mask},
+			PreferedLft: lifetime(prefix.PreferredLifetime),
+			ValidLft:    lifetime(prefix.ValidLifetime),
+		})
+	}
+	return addrs
+}
+
+// lifetime returns d in seconds as netlink wants it; 0xffffffff seconds is
+// forever, for netlink too.
+func lifetime(d time.Duration) int {
+	return int(d / time.Second)
+}
+
+// Routes returns the on-link prefix routes without an address, and the
+// default route.
+func (p *PacketRA) Routes() []*netlink.Route {
+	var routes []*netlink.Route
+	for _, prefix := range p.RA.Prefixes {
+		if prefix.OnLink && !prefix.Autonomous && prefix.ValidLifetime > 0 && !prefix.Prefix.IP.IsLinkLocalUnicast() {
+			routes = append(routes, &netlink.Route{
+				LinkIndex: p.iface.Attrs().Index,
+				Dst:       prefix.Prefix,
+				Scope:     netlink.SCOPE_LINK,
+			})
+		}
+	}
+	if p.RA.RouterLifetime > 0 {
+		routes = append(routes, &netlink.Route{
+			LinkIndex: p.iface.Attrs().Index,
+			Dst:       &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+			Gw:        p.RA.Router,
+		})
+	}
+	return routes
+}
+
+// GatherDNSSettings returns the DNS servers and search list of the router
+// advertisement or, if it has none, of the DHCPv6 reply.
+func (p *PacketRA) GatherDNSSettings() (ns []net.IP, sl []string) {
+	ns, sl = p.RA.DNS, p.RA.SearchList
+	if p.Info == nil {
+		return ns, sl
+	}
+	if ns == nil {
+		ns = NewPacket6(p.iface, p.Info).DNS()
+	}
+	if o, ok := p.Info.GetOneOption(dhcpv6.OptionDomainSearchList).(*dhcpv6.OptDomainSearchList); ok && sl == nil && o.DomainSearchList != nil {
+		sl = o.DomainSearchList.Labels
+	}
+	return ns, sl
+}
+
+// Configure adds the SLAAC addresses, routes, and DNS servers to the
+// system.
+func (p *PacketRA) Configure() error {
+	for _, a := range p.Addrs() {
+		// The router assigned the prefix; there is little risk of a
+		// collision.
+		a.Flags = unix.IFA_F_OPTIMISTIC
+		if err := netlink.AddrReplace(p.iface, a); err != nil {
+			return fmt.Errorf("add/replace %s to %v: %v", a, p.iface.Attrs().Name, err)
+		}
+	}
+	for _, r := range p.Routes() {
+		if err := netlink.RouteReplace(r); err != nil {
+			return fmt.Errorf("%s: add %s: %v", p.iface.Attrs().Name, r, err)
+		}
+	}
+	if ns, sl := p.GatherDNSSettings(); ns != nil || sl != nil {
+		if err := WriteDNSSettings(ns, sl, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deconfigure removes the SLAAC addresses and routes from the system.
+func (p *PacketRA) Deconfigure() error {
+	var err error
+	for _, a := range p.Addrs() {
+		if e := netlink.AddrDel(p.iface, a); e != nil && err == nil {
+			err = fmt.Errorf("delete %s from %v: %v", a, p.iface.Attrs().Name, e)
+		}
+	}
+	for _, r := range p.Routes() {
+		if e := netlink.RouteDel(r); e != nil && err == nil {
+			err = fmt.Errorf("%s: delete %s: %v", p.iface.Attrs().Name, r, e)
+		}
+	}
+	return err
+}
+
+// Timers returns when to solicit the routers again to refresh the
+// configuration: at half and 4/5 of the shortest lifetime of the default
+// route and the prefixes, like the timers of DHCPv6, and when that lifetime
+// runs out.
+//
+// An expiry of 0 means the configuration never expires.
+func (p *PacketRA) Timers() (t1, t2, expiry time.Duration) {
+	lifetimes := []time.Duration{p.RA.RouterLifetime}
+	for _, prefix := range p.RA.Prefixes {
+		if prefix.OnLink || prefix.Autonomous {
+			lifetimes = append(lifetimes, prefix.ValidLifetime)
+		}
+	}
+	for _, d := range lifetimes {
+		if d > 0 && d != infiniteLifetime6*time.Second && (expiry == 0 || d < expiry) {
+			expiry = d
+		}
+	}
+	return expiry / 2, expiry * 4 / 5, expiry
+}
+
+func (p *PacketRA) String() string {
+	var ips []string
+	for _, a := range p.Addrs() {
+		ips = append(ips, a.IP.String())
+	}
+	return fmt.Sprintf("IPv6 %s from router %s, IPs %s", p.RA.Autoconf(), p.RA.Router, strings.Join(ips, ", "))
+}
+
+// Boot returns the boot file URL of the DHCPv6 reply.
+func (p *PacketRA) Boot() (*url.URL, error) {
+	if p.Info == nil {
+		return nil, fmt.Errorf("router advertisement does not contain boot file URL")
+	}
+	return NewPacket6(p.iface, p.Info).Boot()
+}
+
+// ISCSIBoot returns the iSCSI target of the DHCPv6 reply.
+func (p *PacketRA) ISCSIBoot() (*net.TCPAddr, string, error) {
+	if p.Info == nil {
+		return nil, "", fmt.Errorf("router advertisement does not contain boot file URL")
+	}
+	return NewPacket6(p.iface, p.Info).ISCSIBoot()
+}
@@ -0,0 +1,237 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/vishvananda/netlink"
+)
+
+var testRA = []byte{
+	// Type, code, checksum.
+	134, 0, 0, 0,
+	// Hop limit, O flag, router lifetime 1800s.
+	64, 0x40, 0x07, 0x08,
+	// Reachable time 30000ms, retrans timer 1000ms.
+	0, 0, 0x75, 0x30,
+	0, 0, 0x03, 0xe8,
+
+	// Source link address.
+	1, 1, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01,
+
+	// MTU 1500.
+	5, 1, 0, 0, 0, 0, 0x05, 0xdc,
+
+	// Prefix 2001:db8:1::/64, on-link and autonomous, valid 86400s,
+	// preferred 14400s.
+	3, 4, 64, 0xc0,
+	0, 0x01, 0x51, 0x80,
+	0, 0, 0x38, 0x40,
+	0, 0, 0, 0,
+	0x20, 0x01, 0x0d, 0xb8, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+
+	// Prefix 2001:db8:2::/64, on-link only.
+	3, 4, 64, 0x80,
+	0, 0x01, 0x51, 0x80,
+	0, 0x01, 0x51, 0x80,
+	0, 0, 0, 0,
+	0x20, 0x01, 0x0d, 0xb8, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+
+	// RDNSS 2001:db8::53, lifetime 600s.
+	25, 3, 0, 0,
+	0, 0, 0x02, 0x58,
+	0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x53,
+
+	// DNSSL example.com, lifetime 600s, padded to 8 bytes.
+	31, 3, 0, 0,
+	0, 0, 0x02, 0x58,
+	7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0, 0, 0,
+}
+
+func TestParseRouterAdvertisement(t *testing.T) {
+	ra, err := ParseRouterAdvertisement(testRA)
+	if err != nil {
+		t.Fatalf("ParseRouterAdvertisement() = %v", err)
+	}
+	want := &RouterAdvertisement{
+		CurHopLimit:    64,
+		Other:          true,
+		RouterLifetime: 30 * time.Minute,
+		ReachableTime:  30 * time.Second,
+		RetransTimer:   time.Second,
+		SourceLinkAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01},
+		MTU:            1500,
+		Prefixes: []Prefix{
+			{
+				Prefix:            &net.IPNet{IP: net.ParseIP("2001:db8:1::"), Mask: net.CIDRMask(64, 128)},
+				OnLink:            true,
+				Autonomous:        true,
+				ValidLifetime:     24 * time.Hour,
+				PreferredLifetime: 4 * time.Hour,
+			},
+			{
+				Prefix:            &net.IPNet{IP: net.ParseIP("2001:db8:2::"), Mask: net.CIDRMask(64, 128)},
+				OnLink:            true,
+				ValidLifetime:     24 * time.Hour,
+				PreferredLifetime: 24 * time.Hour,
+			},
+		},
+		DNS:        []net.IP{net.ParseIP("2001:db8::53")},
+		SearchList: []string{"example.com"},
+	}
+	if !reflect.DeepEqual(ra, want) {
+		t.Errorf("ParseRouterAdvertisement() = %+v, want %+v", ra, want)
+	}
+}
+
+func TestParseRouterAdvertisementErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"solicitation", []byte{133, 0, 0, 0, 0, 0, 0, 0}},
+		{"short", testRA[:12]},
+		{"zero length option", append(append([]byte{}, testRA[:16]...), 1, 0, 0, 0, 0, 0, 0, 0)},
+		{"truncated option", testRA[:len(testRA)-4]},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseRouterAdvertisement(tt.b); err == nil {
+				t.Errorf("ParseRouterAdvertisement() = nil, want error")
+			}
+		})
+	}
+}
+
+func TestAutoconf(t *testing.T) {
+	for _, tt := range []struct {
+		managed, other bool
+		want           Autoconf
+	}{
+		{false, false, SLAAC},
+		{false, true, StatelessDHCPv6},
+		{true, false, StatefulDHCPv6},
+		{true, true, StatefulDHCPv6},
+	} {
+		ra := &RouterAdvertisement{Managed: tt.managed, Other: tt.other}
+		if got := ra.Autoconf(); got != tt.want {
+			t.Errorf("Autoconf(M=%t, O=%t) = %s, want %s", tt.managed, tt.other, got, tt.want)
+		}
+	}
+}
+
+func TestSLAACAddr(t *testing.T) {
+	hw := net.HardwareAddr{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+	for _, tt := range []struct {
+		prefix string
+		hw     net.HardwareAddr
+		want   net.IP
+	}{
+		{"2001:db8::/64", hw, net.ParseIP("2001:db8::5054:ff:fe12:3456")},
+		{"2001:db8::/48", hw, nil},
+		{"2001:db8::/64", net.HardwareAddr{1, 2, 3, 4, 5, 6, 7, 8}, nil},
+	} {
+		_, prefix, err := net.ParseCIDR(tt.prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := slaacAddr(prefix, tt.hw); !got.Equal(tt.want) {
+			t.Errorf("slaacAddr(%s, %s) = %s, want %s", tt.prefix, tt.hw, got, tt.want)
+		}
+	}
+}
+
+func TestPacketRA(t *testing.T) {
+	ra, err := ParseRouterAdvertisement(testRA)
+	if err != nil {
+		t.Fatalf("ParseRouterAdvertisement() = %v", err)
+	}
+	ra.Router = net.ParseIP("fe80::1")
+	iface := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{
+		Index:        2,
+		Name:         "eth0",
+		HardwareAddr: net.HardwareAddr{0x52, 0x54, 0x00, 0x12, 0x34, 0x56},
+	}}
+	p := NewPacketRA(iface, ra, nil)
+
+	wantAddrs := []*netlink.Addr{{
+		IPNet:       &net.IPNet{IP: net.ParseIP("2001:db8:1::5054:ff:fe12:3456"), Mask: net.CIDRMask(64, 128)},
+		PreferedLft: 14400,
+		ValidLft:    86400,
+	}}
+	if got := p.Addrs(); !reflect.DeepEqual(got, wantAddrs) {
+		t.Errorf("Addrs() = %v, want %v", got, wantAddrs)
+	}
+
+	wantRoutes := []*netlink.Route{
+		{
+			LinkIndex: 2,
+			Dst:       &net.IPNet{IP: net.ParseIP("2001:db8:2::"), Mask: net.CIDRMask(64, 128)},
+			Scope:     netlink.SCOPE_LINK,
+		},
+		{
+			LinkIndex: 2,
+			Dst:       &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+			Gw:        net.ParseIP("fe80::1"),
+		},
+	}
+	if got := p.Routes(); !reflect.DeepEqual(got, wantRoutes) {
+		t.Errorf("Routes() = %v, want %v", got, wantRoutes)
+	}
+
+	ns, sl := p.GatherDNSSettings()
+	if want := []net.IP{net.ParseIP("2001:db8::53")}; !reflect.DeepEqual(ns, want) {
+		t.Errorf("GatherDNSSettings() servers = %v, want %v", ns, want)
+	}
+	if want := []string{"example.com"}; !reflect.DeepEqual(sl, want) {
+		t.Errorf("GatherDNSSettings() search list = %v, want %v", sl, want)
+	}
+
+	if _, err := p.Boot(); err == nil {
+		t.Errorf("Boot() = nil, want error without DHCPv6 reply")
+	}
+
+	// The router lifetime is shorter than those of the prefixes.
+	if t1, t2, expiry := p.Timers(); t1 != 15*time.Minute || t2 != 24*time.Minute || expiry != 30*time.Minute {
+		t.Errorf("Timers() = %v, %v, %v, want 15m, 24m, 30m", t1, t2, expiry)
+	}
+	p.RA.RouterLifetime = 0
+	p.RA.Prefixes[1].ValidLifetime = 0xffffffff * time.Second
+	if _, _, expiry := p.Timers(); expiry != 24*time.Hour {
+		t.Errorf("Timers() expiry = %v, want 24h", expiry)
+	}
+	p.RA.Prefixes = nil
+	if t1, t2, expiry := p.Timers(); t1 != 0 || t2 != 0 || expiry != 0 {
+		t.Errorf("Timers() = %v, %v, %v, want no expiry", t1, t2, expiry)
+	}
+}
+
+func TestPacketRADNSFromInfo(t *testing.T) {
+	info := reply6(t, 0, 0, 0, 0, &dhcpv6.OptDNSRecursiveNameServer{
+		NameServers: []net.IP{net.ParseIP("2001:db8::35")},
+	})
+	p := NewPacketRA(nil, &RouterAdvertisement{}, info)
+	ns, _ := p.GatherDNSSettings()
+	if want := []net.IP{net.ParseIP("2001:db8::35")}; !reflect.DeepEqual(ns, want) {
+		t.Errorf("GatherDNSSettings() servers = %v, want %v", ns, want)
+	}
+}
+
+func TestRouterSolicitation(t *testing.T) {
+	hw := net.HardwareAddr{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+	want := []byte{133, 0, 0, 0, 0, 0, 0, 0, 1, 1, 0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+	if got := routerSolicitation(hw); !bytes.Equal(got, want) {
+		t.Errorf("routerSolicitation(%s) = %v, want %v", hw, got, want)
+	}
+	if got := routerSolicitation(nil); len(got) != 8 {
+		t.Errorf("routerSolicitation(nil) = %v, want no source link address", got)
+	}
+}
@@ -0,0 +1,332 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/dhcpv6/nclient6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/vishvananda/netlink"
+)
+
+// errNAK is returned when the server refuses to extend a lease.
+var errNAK = errors.New("server refused to extend the lease")
+
+// client4 obtains and extends DHCPv4 leases on an interface.
+type client4 struct {
+	iface netlink.Link
+	c     Config
+}
+
+func (c *client4) request(ctx context.Context) (timedLease, error) {
+	l, err := lease4(ctx, c.iface, c.c)
+	if err != nil {
+		return nil, err
+	}
+	return l.(*Packet4), nil
+}
+
+// renew asks the server that granted l to extend it, as in RFC 2131,
+// Section 4.4.5.
+func (c *client4) renew(ctx context.Context, l timedLease) (timedLease, error) {
+	dest := nclient4.DefaultServers
+	if server := l.(*Packet4).P.ServerIdentifier(); server != nil {
+		dest = &net.UDPAddr{IP: server, Port: nclient4.ServerPort}
+	}
+	return c.extend(ctx, l.(*Packet4), dest)
+}
+
+// rebind asks any server to extend l.
+func (c *client4) rebind(ctx context.Context, l timedLease) (timedLease, error) {
+	return c.extend(ctx, l.(*Packet4), nclient4.DefaultServers)
+}
+
+func (c *client4) extend(ctx context.Context, p *Packet4, dest *net.UDPAddr) (timedLease, error) {
+	client, err := nclient4.New(c.iface.Attrs().Name, c.c.opts4()...)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	// Unlike the request after an offer, a renewing or rebinding
+	// request has the address in ciaddr and no server identifier.
+	req, err := dhcpv4.New(
+		dhcpv4.WithHwAddr(c.iface.Attrs().HardwareAddr),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithClientIP(p.P.YourIPAddr),
+		dhcpv4.WithNetboot,
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXE UROOT")),
+		dhcpv4.WithRequestedOptions(
+			dhcpv4.OptionSubnetMask,
+			dhcpv4.OptionRouter,
+			dhcpv4.OptionDomainName,
+			dhcpv4.OptionDomainNameServer,
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+	ack, err := client.SendAndRead(ctx, dest, req, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch ack.MessageType() {
+	case dhcpv4.MessageTypeAck:
+		// The server may leave out the address it keeps.
+		if ack.YourIPAddr == nil || ack.YourIPAddr.IsUnspecified() {
+			ack.YourIPAddr = p.P.YourIPAddr
+		}
+		return NewPacket4(c.iface, ack), nil
+	case dhcpv4.MessageTypeNak:
+		return nil, errNAK
+	}
+	return nil, fmt.Errorf("unexpected %s in reply to renewal", ack.MessageType())
+}
+
+// config6 is the IPv6 configuration of an interface: that of its router
+// advertisement, if there is a router, and its DHCPv6 lease, if the routers
+// want stateful DHCPv6 or there are none.
+type config6 struct {
+	iface netlink.Link
+	ra    *PacketRA
+	dhcp  *Packet6
+}
+
+// leases returns the parts of l.
+func (l *config6) leases() []timedLease {
+	var leases []timedLease
+	if l.ra != nil {
+		leases = append(leases, l.ra)
+	}
+	if l.dhcp != nil {
+		leases = append(leases, l.dhcp)
+	}
+	return leases
+}
+
+func (l *config6) String() string {
+	var s []string
+	for _, p := range l.leases() {
+		s = append(s, p.String())
+	}
+	return strings.Join(s, "; ")
+}
+
+// Configure configures the router advertisement and the DHCPv6 lease.
+func (l *config6) Configure() error {
+	for _, p := range l.leases() {
+		if err := p.Configure(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deconfigure removes the configuration of the router advertisement and
+// the DHCPv6 lease.
+func (l *config6) Deconfigure() error {
+	var err error
+	for _, p := range l.leases() {
+		if e := p.Deconfigure(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Timers returns the earliest timers of the router advertisement and the
+// DHCPv6 lease that expire.
+func (l *config6) Timers() (t1, t2, expiry time.Duration) {
+	for _, p := range l.leases() {
+		a, b, e := p.Timers()
+		if e == 0 {
+			continue
+		}
+		if expiry == 0 || a < t1 {
+			t1 = a
+		}
+		if expiry == 0 || b < t2 {
+			t2 = b
+		}
+		if expiry == 0 || e < expiry {
+			expiry = e
+		}
+	}
+	return t1, t2, expiry
+}
+
+// Boot returns the boot file URL of the DHCPv6 lease or, for stateless
+// DHCPv6, of the DHCPv6 information.
+func (l *config6) Boot() (*url.URL, error) {
+	if l.dhcp != nil {
+		return l.dhcp.Boot()
+	}
+	return l.ra.Boot()
+}
+
+// ISCSIBoot returns the iSCSI target like Boot.
+func (l *config6) ISCSIBoot() (*net.TCPAddr, string, error) {
+	if l.dhcp != nil {
+		return l.dhcp.ISCSIBoot()
+	}
+	return l.ra.ISCSIBoot()
+}
+
+// Link is the interface the configuration is for.
+func (l *config6) Link() netlink.Link {
+	return l.iface
+}
+
+// client6 obtains and extends the IPv6 configuration of an interface as its
+// routers advertise, like requests6.
+type client6 struct {
+	iface netlink.Link
+	c     Config
+}
+
+func (c *client6) request(ctx context.Context) (timedLease, error) {
+	if err := waitIPv6LinkReady(ctx, c.iface, c.c); err != nil {
+		return nil, err
+	}
+	l := &config6{iface: c.iface}
+	ra, err := packetRA(ctx, c.iface, c.c)
+	if err != nil {
+		log.Printf("No IPv6 router on %s, trying DHCPv6: %v", c.iface.Attrs().Name, err)
+	} else {
+		l.ra = ra
+	}
+	if l.ra == nil || l.ra.RA.Autoconf() == StatefulDHCPv6 {
+		p, err := lease6(ctx, c.iface, c.c)
+		if err != nil {
+			return nil, err
+		}
+		l.dhcp = p.(*Packet6)
+	}
+	return l, nil
+}
+
+// renew solicits the routers again and asks the DHCPv6 server that granted
+// the lease of l to extend it, as in RFC 8415, Section 18.2.4.
+func (c *client6) renew(ctx context.Context, l timedLease) (timedLease, error) {
+	return c.refresh(ctx, l.(*config6), dhcpv6.MessageTypeRenew)
+}
+
+// rebind solicits the routers again and asks any DHCPv6 server to extend
+// the lease of l.
+func (c *client6) rebind(ctx context.Context, l timedLease) (timedLease, error) {
+	return c.refresh(ctx, l.(*config6), dhcpv6.MessageTypeRebind)
+}
+
+// refresh refreshes the router advertisement of l, if there was a router,
+// and extends its DHCPv6 lease with a message of type typ. If the routers
+// newly want stateful DHCPv6, it gets a lease.
+func (c *client6) refresh(ctx context.Context, l *config6, typ dhcpv6.MessageType) (timedLease, error) {
+	n := &config6{iface: c.iface}
+	if l.ra != nil {
+		ra, err := packetRA(ctx, c.iface, c.c)
+		if err != nil {
+			return nil, err
+		}
+		n.ra = ra
+	}
+	switch {
+	case l.dhcp != nil:
+		p, err := c.extend(ctx, l.dhcp, typ)
+		if err != nil {
+			return nil, err
+		}
+		n.dhcp = p
+	case n.ra != nil && n.ra.RA.Autoconf() == StatefulDHCPv6:
+		p, err := lease6(ctx, c.iface, c.c)
+		if err != nil {
+			return nil, err
+		}
+		n.dhcp = p.(*Packet6)
+	}
+	return n, nil
+}
+
+func (c *client6) extend(ctx context.Context, p *Packet6, typ dhcpv6.MessageType) (*Packet6, error) {
+	client, err := nclient6.New(c.iface.Attrs().Name, c.c.opts6()...)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	msg, err := newExtend6(p.p, typ)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := client.SendAndRead(ctx, nclient6.AllDHCPRelayAgentsAndServers, msg, nclient6.IsMessageType(dhcpv6.MessageTypeReply))
+	if err != nil {
+		return nil, err
+	}
+	r := NewPacket6(c.iface, reply)
+	if noBinding(reply) || r.Lease() == nil {
+		return nil, errNAK
+	}
+	return r, nil
+}
+
+// newExtend6 returns a RENEW or REBIND message that extends the lease in
+// reply. Only a RENEW names the server.
+func newExtend6(reply *dhcpv6.Message, typ dhcpv6.MessageType) (*dhcpv6.Message, error) {
+	msg, err := dhcpv6.NewMessage()
+	if err != nil {
+		return nil, err
+	}
+	msg.MessageType = typ
+	cid := reply.GetOneOption(dhcpv6.OptionClientID)
+	if cid == nil {
+		return nil, errors.New("lease has no client ID")
+	}
+	msg.AddOption(cid)
+	if typ == dhcpv6.MessageTypeRenew {
+		sid := reply.GetOneOption(dhcpv6.OptionServerID)
+		if sid == nil {
+			return nil, errors.New("lease has no server ID")
+		}
+		msg.AddOption(sid)
+	}
+	msg.AddOption(&dhcpv6.OptElapsedTime{})
+	ia := reply.GetOneOption(dhcpv6.OptionIANA)
+	if ia == nil {
+		return nil, errors.New("lease has no IA_NA")
+	}
+	msg.AddOption(ia)
+	oro := &dhcpv6.OptRequestedOption{}
+	oro.SetRequestedOptions([]dhcpv6.OptionCode{
+		dhcpv6.OptionDNSRecursiveNameServer,
+		dhcpv6.OptionDomainSearchList,
+	})
+	msg.AddOption(oro)
+	dhcpv6.WithNetboot(msg)
+	return msg, nil
+}
+
+// noBinding returns true if the server no longer knows the lease.
+func noBinding(reply *dhcpv6.Message) bool {
+	opts := []dhcpv6.Option{reply.GetOneOption(dhcpv6.OptionStatusCode)}
+	if ia, ok := reply.GetOneOption(dhcpv6.OptionIANA).(*dhcpv6.OptIANA); ok {
+		opts = append(opts, ia.GetOneOption(dhcpv6.OptionStatusCode))
+	}
+	for _, o := range opts {
+		if s, ok := o.(*dhcpv6.OptStatusCode); ok && s.StatusCode == iana.StatusNoBinding {
+			return true
+		}
+	}
+	return false
+}
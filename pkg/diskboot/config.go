@@ -13,8 +13,11 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/boot/vboot"
 	"github.com/u-root/u-root/pkg/cmdline"
 	"github.com/u-root/u-root/pkg/kexec"
+	"github.com/u-root/u-root/pkg/uio"
 )
 
 // Config contains boot entries for a single configuration file
@@ -62,6 +65,52 @@ type Entry struct {
 	Modules []Module
 }
 
+// OSImage returns the image that boots the entry. Files are opened when
+// the image is loaded. Its signatures are the detached signatures next to
+// the files, see package vboot.
+func (e *Entry) OSImage(mountPath string, filterCmdline cmdline.Filter) (boot.OSImage, error) {
+	if len(e.Modules) < 1 {
+		return nil, fmt.Errorf("missing kernel")
+	}
+	commandline := e.Modules[0].Params
+	if filterCmdline != nil {
+		commandline = filterCmdline.Update(commandline)
+	}
+	kernel := filepath.Join(mountPath, e.Modules[0].Path)
+	switch e.Type {
+	case Multiboot:
+		img := &boot.MultibootImage{
+			Path:       kernel,
+			Cmdline:    commandline,
+			KernelSig:  vboot.Detached(kernel),
+			CmdlineSig: vboot.DetachedCmdline(kernel),
+		}
+		for _, m := range e.Modules[1:] {
+			module := filepath.Join(mountPath, m.Path)
+			img.ModuleSigs = append(img.ModuleSigs, vboot.Detached(module))
+			if m.Params != "" {
+				module += " " + m.Params
+			}
+			img.Modules = append(img.Modules, module)
+		}
+		return img, nil
+	case Elf:
+		img := &boot.LinuxImage{
+			Kernel:     uio.NewLazyFile(kernel),
+			Cmdline:    commandline,
+			KernelSig:  vboot.Detached(kernel),
+			CmdlineSig: vboot.DetachedCmdline(kernel),
+		}
+		if len(e.Modules) > 1 {
+			initrd := filepath.Join(mountPath, e.Modules[1].Path)
+			img.Initrd = uio.NewLazyFile(initrd)
+			img.InitrdSig = vboot.Detached(initrd)
+		}
+		return img, nil
+	}
+	return nil, fmt.Errorf("unknown entry type %v", e.Type)
+}
+
 // KexecLoad calls the appropriate kexec load routines based on the
 // type of Entry
 func (e *Entry) KexecLoad(mountPath string, filterCmdline cmdline.Filter, dryrun bool) error {
@@ -112,9 +161,9 @@ type location struct {
 
 var (
 	locations = []location{
-		{"boot/grub/grub.cfg", grub},
-		{"grub/grub.cfg", grub},
-		{"grub2/grub.cfg", grub},
+		{"boot/grub/grub.cfg", grubConfig},
+		{"grub/grub.cfg", grubConfig},
+		{"grub2/grub.cfg", grubConfig},
 		// following entries from the syslinux wiki
 		// TODO: add priorities override (top over bottom)
 		{"boot/isolinux/isolinux.cfg", syslinux},
@@ -135,19 +184,20 @@ func FindConfigs(mountPath string) []*Config {
 
 	for _, location := range locations {
 		configPath := filepath.Join(mountPath, location.Path)
+		if location.Type == grubConfig {
+			if _, err := os.Stat(configPath); err == nil {
+				configs = append(configs, parseGrubConfig(mountPath, configPath))
+			}
+			continue
+		}
+
 		contents, err := ioutil.ReadFile(configPath)
 		if err != nil {
 			// TODO: log error
 			continue
 		}
 
-		var lines []string
-		if location.Type == syslinux {
-			lines = loadSyslinuxLines(configPath, contents)
-		} else {
-			lines = strings.Split(string(contents), "\n")
-		}
-
+		lines := loadSyslinuxLines(configPath, contents)
 		configs = append(configs, ParseConfig(mountPath, configPath, lines))
 	}
 
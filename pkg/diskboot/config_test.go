@@ -12,6 +12,8 @@ import (
 	"testing"
 
 	"github.com/go-test/deep"
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/cmdline"
 )
 
 func TestParseEmpty(t *testing.T) {
@@ -52,3 +54,57 @@ func TestConfigs(t *testing.T) {
 		}
 	}
 }
+
+func TestOSImage(t *testing.T) {
+	filter := cmdline.NewUpdateFilter("quiet", []string{"console"}, nil)
+
+	multiboot := Entry{
+		Type: Multiboot,
+		Modules: []Module{
+			{Path: "/boot/xen.gz", Params: "dom0_mem=1G console=vga"},
+			{Path: "/boot/vmlinuz", Params: "root=/dev/sda1"},
+			{Path: "/boot/initrd.img"},
+		},
+	}
+	img, err := multiboot.OSImage("/mnt/sda1", filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb, ok := img.(*boot.MultibootImage)
+	if !ok {
+		t.Fatalf("OSImage() = %T, want *boot.MultibootImage", img)
+	}
+	// The detached signatures are opened lazily and cannot be compared.
+	if mb.KernelSig == nil || mb.CmdlineSig == nil || len(mb.ModuleSigs) != 2 {
+		t.Errorf("OSImage() = %+v, want signatures of the kernel, command line and 2 modules", mb)
+	}
+	mb.KernelSig, mb.CmdlineSig, mb.ModuleSigs = nil, nil, nil
+	want := &boot.MultibootImage{
+		Path:    "/mnt/sda1/boot/xen.gz",
+		Cmdline: "dom0_mem=1G quiet",
+		Modules: []string{"/mnt/sda1/boot/vmlinuz root=/dev/sda1", "/mnt/sda1/boot/initrd.img"},
+	}
+	if diff := deep.Equal(mb, want); diff != nil {
+		t.Error(diff)
+	}
+
+	elf := Entry{
+		Type:    Elf,
+		Modules: []Module{{Path: "/vmlinuz", Params: "console=ttyS0 ro"}},
+	}
+	img, err = elf.OSImage("/mnt/sda1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	li, ok := img.(*boot.LinuxImage)
+	if !ok {
+		t.Fatalf("OSImage() = %T, want *boot.LinuxImage", img)
+	}
+	if li.Kernel == nil || li.Initrd != nil || li.InitrdSig != nil || li.Cmdline != "console=ttyS0 ro" {
+		t.Errorf("OSImage() = %v, want kernel /mnt/sda1/vmlinuz without initrd", li)
+	}
+
+	if _, err := (&Entry{Type: Elf}).OSImage("/", nil); err == nil {
+		t.Error("OSImage() of an entry without kernel succeeded")
+	}
+}
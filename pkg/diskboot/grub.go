@@ -0,0 +1,57 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package diskboot
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/boot/grub"
+)
+
+// parseGrubConfig evaluates the grub configuration at configPath. Files
+// on other devices are looked up on the device mounted at mountPath.
+func parseGrubConfig(mountPath, configPath string) *Config {
+	config := &Config{
+		MountPath:    mountPath,
+		ConfigPath:   configPath,
+		DefaultEntry: -1,
+	}
+	c, err := grub.ParseConfigFile(configPath, []grub.Mount{{Name: "hd0", Path: mountPath}})
+	if err != nil {
+		log.Printf("Failed to parse %v: %v", configPath, err)
+		return config
+	}
+
+	// Module paths are relative to the mount path.
+	rel := func(path string) string {
+		r, err := filepath.Rel(mountPath, path)
+		if err != nil {
+			return path
+		}
+		return filepath.Join("/", r)
+	}
+	for _, e := range c.Entries {
+		entry := Entry{
+			Name:    e.Title,
+			Type:    Elf,
+			Modules: []Module{{Path: rel(e.Kernel), Params: e.Cmdline}},
+		}
+		if e.Multiboot {
+			entry.Type = Multiboot
+			for _, m := range e.Modules {
+				f := strings.Fields(m)
+				entry.Modules = append(entry.Modules, NewModule(rel(f[0]), f[1:]))
+			}
+		}
+		for _, initrd := range e.Initrds {
+			entry.Modules = append(entry.Modules, NewModule(rel(initrd), nil))
+		}
+		config.Entries = append(config.Entries, entry)
+	}
+	config.DefaultEntry = c.DefaultEntry
+	return config
+}
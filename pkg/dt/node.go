@@ -67,6 +67,48 @@ func (n *Node) Walk(f func(*Node) error) error {
 	return nil
 }
 
+// LookChild finds the direct child of n with the given name.
+func (n *Node) LookChild(name string) (*Node, bool) {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// LookProperty finds the property of n with the given name.
+func (n *Node) LookProperty(name string) (*Property, bool) {
+	for i := range n.Properties {
+		if n.Properties[i].Name == name {
+			return &n.Properties[i], true
+		}
+	}
+	return nil, false
+}
+
+// UpdateProperty sets the value of the property with the given name, and adds
+// the property if n does not have it yet.
+func (n *Node) UpdateProperty(name string, value []byte) {
+	if p, ok := n.LookProperty(name); ok {
+		p.Value = value
+		return
+	}
+	n.Properties = append(n.Properties, Property{Name: name, Value: value})
+}
+
+// RemoveProperty removes the property with the given name and returns whether
+// n had it.
+func (n *Node) RemoveProperty(name string) bool {
+	for i := range n.Properties {
+		if n.Properties[i].Name == name {
+			n.Properties = append(n.Properties[:i], n.Properties[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
 // Property is a name-value pair. Note the PropertyType of Value is not
 // encoded.
 type Property struct {
@@ -194,7 +236,7 @@ func (p *Property) AsStringList() ([]string, error) {
 	}
 	value := p.Value
 	strs := []string{}
-	for len(p.Value) > 0 {
+	for len(value) > 0 {
 		nextNull := bytes.IndexByte(value, 0) // cannot be -1
 		var str []byte
 		str, value = value[:nextNull], value[nextNull+1:]
@@ -203,7 +245,7 @@ func (p *Property) AsStringList() ([]string, error) {
 		}
 		strs = append(strs, string(str))
 	}
-	return []string{}, nil
+	return strs, nil
 }
 
 func isPrintableASCII(s []byte) bool {
@@ -0,0 +1,75 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNodeProperties(t *testing.T) {
+	chosen := &Node{
+		Name: "chosen",
+		Properties: []Property{
+			{Name: "bootargs", Value: []byte("console=ttyS0\x00")},
+			{Name: "linux,initrd-start", Value: []byte{0, 0, 0, 0, 0x44, 0, 0, 0}},
+		},
+	}
+	root := &Node{
+		Children: []*Node{
+			{Name: "memory@40000000"},
+			chosen,
+		},
+	}
+
+	if n, ok := root.LookChild("chosen"); !ok || n != chosen {
+		t.Errorf("LookChild(chosen) = %v, %t, want %v, true", n, ok, chosen)
+	}
+	if n, ok := root.LookChild("cpus"); ok {
+		t.Errorf("LookChild(cpus) = %v, true, want false", n)
+	}
+	if p, ok := chosen.LookProperty("bootargs"); !ok || p != &chosen.Properties[0] {
+		t.Errorf("LookProperty(bootargs) = %v, %t, want %v, true", p, ok, &chosen.Properties[0])
+	}
+
+	chosen.UpdateProperty("bootargs", []byte("quiet\x00"))
+	chosen.UpdateProperty("kaslr-seed", []byte{1, 2, 3, 4, 5, 6, 7, 8})
+	if !chosen.RemoveProperty("linux,initrd-start") {
+		t.Errorf("RemoveProperty(linux,initrd-start) = false, want true")
+	}
+	if chosen.RemoveProperty("linux,initrd-end") {
+		t.Errorf("RemoveProperty(linux,initrd-end) = true, want false")
+	}
+
+	want := []Property{
+		{Name: "bootargs", Value: []byte("quiet\x00")},
+		{Name: "kaslr-seed", Value: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+	}
+	if !reflect.DeepEqual(chosen.Properties, want) {
+		t.Errorf("Properties = %v, want %v", chosen.Properties, want)
+	}
+}
+
+func TestAsStringList(t *testing.T) {
+	for _, tt := range []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{value: "fdt-1\x00", want: []string{"fdt-1"}},
+		{value: "fdt-1\x00overlay-1\x00", want: []string{"fdt-1", "overlay-1"}},
+		{value: "fdt-1", wantErr: true},
+		{value: "", wantErr: true},
+	} {
+		p := &Property{Name: "fdt", Value: []byte(tt.value)}
+		got, err := p.AsStringList()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("AsStringList(%q) = %v, want error %t", tt.value, err, tt.wantErr)
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("AsStringList(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
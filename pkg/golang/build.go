@@ -6,6 +6,7 @@
 package golang
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"go/build"
@@ -17,27 +18,48 @@ import (
 
 type Environ struct {
 	build.Context
+
+	// GO111MODULE is the module mode of the go tool: "on", "off", "auto",
+	// or empty for the go tool's default.
+	GO111MODULE string
 }
 
 // Default is the default build environment comprised of the default GOPATH,
-// GOROOT, GOOS, GOARCH, and CGO_ENABLED values.
+// GOROOT, GOOS, GOARCH, CGO_ENABLED, and GO111MODULE values.
 func Default() Environ {
-	return Environ{Context: build.Default}
+	return Environ{
+		Context:     build.Default,
+		GO111MODULE: os.Getenv("GO111MODULE"),
+	}
 }
 
 // PackageByPath retrieves information about a package by its file system path.
 //
-// `path` is assumed to be the directory containing the package.
+// `path` is assumed to be the directory containing the package. If the
+// directory is part of a Go module, the package is looked up in module mode.
 func (c Environ) PackageByPath(path string) (*build.Package, error) {
 	abs, err := filepath.Abs(path)
 	if err != nil {
 		return nil, err
 	}
+	if c.UseModules(abs) {
+		return c.listBuildPackage(abs, ".")
+	}
 	return c.Context.ImportDir(abs, 0)
 }
 
 // Package retrieves information about a package by its Go import path.
+//
+// importPath may also be an absolute directory, as returned by
+// uroot.ResolvePackagePaths for packages in Go modules. Import paths are
+// resolved in module mode if the current directory is part of a Go module.
 func (c Environ) Package(importPath string) (*build.Package, error) {
+	if filepath.IsAbs(importPath) {
+		return c.PackageByPath(importPath)
+	}
+	if c.UseModules("") {
+		return c.listBuildPackage("", importPath)
+	}
 	return c.Context.Import(importPath, "", 0)
 }
 
@@ -48,14 +70,36 @@ func (c Environ) Package(importPath string) (*build.Package, error) {
 //
 // This currently contains an incomplete list of dependencies.
 type ListPackage struct {
-	Dir        string
-	Deps       []string
-	GoFiles    []string
-	SFiles     []string
-	HFiles     []string
-	Goroot     bool
-	Root       string
-	ImportPath string
+	Dir          string
+	Name         string
+	Deps         []string
+	Imports      []string
+	GoFiles      []string
+	CgoFiles     []string
+	CFiles       []string
+	SFiles       []string
+	HFiles       []string
+	CXXFiles     []string
+	MFiles       []string
+	FFiles       []string
+	SwigFiles    []string
+	SwigCXXFiles []string
+	SysoFiles    []string
+	Goroot       bool
+	Root         string
+	ImportPath   string
+
+	// Export is the file holding the package's export data, if
+	// requested.
+	Export string
+
+	// ImportMap maps import paths in the package's source to the
+	// import paths of the packages they resolve to, if they differ.
+	ImportMap map[string]string
+
+	// Module is the module containing the package, or nil in GOPATH
+	// mode.
+	Module *Module
 }
 
 func (c Environ) goCmd(args ...string) *exec.Cmd {
@@ -79,14 +123,42 @@ func (c Environ) Version() (string, error) {
 	return s[2], nil
 }
 
+// Root returns the GOROOT of the Go compiler in this environ, as `go env
+// GOROOT` says.
+func (c Environ) Root() (string, error) {
+	out, err := c.goCmd("env", "GOROOT").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
 // Deps lists all dependencies of the package given by `importPath`.
+//
+// Like for Package, importPath may also be an absolute directory.
 func (c Environ) Deps(importPath string) (*ListPackage, error) {
+	if filepath.IsAbs(importPath) {
+		return c.listPackage(importPath, ".")
+	}
+	return c.listPackage("", importPath)
+}
+
+// listPackage runs `go list -json pkg` in dir, or in the current directory
+// if dir is empty.
+func (c Environ) listPackage(dir, pkg string) (*ListPackage, error) {
 	// The output of this is almost the same as build.Import, except for
 	// the dependencies.
-	cmd := c.goCmd("list", "-json", importPath)
-	out, err := cmd.CombinedOutput()
+	args := []string{"list", "-json"}
+	if len(c.BuildTags) > 0 {
+		args = append(args, "-tags", strings.Join(c.BuildTags, " "))
+	}
+	cmd := c.goCmd(append(args, pkg)...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("go list %s: %v: %s", pkg, err, stderr.String())
 	}
 
 	var p ListPackage
@@ -96,6 +168,43 @@ func (c Environ) Deps(importPath string) (*ListPackage, error) {
 	return &p, nil
 }
 
+// listBuildPackage is like listPackage, but returns the subset of
+// build.Package that go list fills in.
+func (c Environ) listBuildPackage(dir, pkg string) (*build.Package, error) {
+	p, err := c.listPackage(dir, pkg)
+	if err != nil {
+		return nil, err
+	}
+	bp := p.BuildPackage()
+	if len(bp.GoFiles)+len(bp.CgoFiles) == 0 {
+		return bp, &build.NoGoError{Dir: p.Dir}
+	}
+	return bp, nil
+}
+
+// BuildPackage returns the subset of build.Package that go list fills in.
+func (p *ListPackage) BuildPackage() *build.Package {
+	return &build.Package{
+		Dir:          p.Dir,
+		Name:         p.Name,
+		ImportPath:   p.ImportPath,
+		Root:         p.Root,
+		Goroot:       p.Goroot,
+		GoFiles:      p.GoFiles,
+		CgoFiles:     p.CgoFiles,
+		CFiles:       p.CFiles,
+		SFiles:       p.SFiles,
+		HFiles:       p.HFiles,
+		CXXFiles:     p.CXXFiles,
+		MFiles:       p.MFiles,
+		FFiles:       p.FFiles,
+		SwigFiles:    p.SwigFiles,
+		SwigCXXFiles: p.SwigCXXFiles,
+		SysoFiles:    p.SysoFiles,
+		Imports:      p.Imports,
+	}
+}
+
 func (c Environ) Env() []string {
 	var env []string
 	if c.GOARCH != "" {
@@ -110,6 +219,9 @@ func (c Environ) Env() []string {
 	if c.GOPATH != "" {
 		env = append(env, fmt.Sprintf("GOPATH=%s", c.GOPATH))
 	}
+	if c.GO111MODULE != "" {
+		env = append(env, fmt.Sprintf("GO111MODULE=%s", c.GO111MODULE))
+	}
 	var cgo int8
 	if c.CgoEnabled {
 		cgo = 1
@@ -126,6 +238,16 @@ func (c Environ) String() string {
 type BuildOpts struct {
 	// ExtraArgs to `go build`.
 	ExtraArgs []string
+
+	// NoForceRebuild omits `-a`, letting the go tool reuse up-to-date
+	// packages from its build cache.
+	NoForceRebuild bool
+
+	// Reproducible builds binaries that only depend on their source:
+	// file system paths are trimmed and no build ID is embedded.
+	//
+	// This requires Go 1.13 or later.
+	Reproducible bool
 }
 
 // Build compiles the package given by `importPath`, writing the build object
@@ -142,13 +264,20 @@ func (c Environ) Build(importPath string, binaryPath string, opts BuildOpts) err
 // BuildDir compiles the package in the directory `dirPath`, writing the build
 // object to `binaryPath`.
 func (c Environ) BuildDir(dirPath string, binaryPath string, opts BuildOpts) error {
-	args := []string{
-		"build",
-		"-a", // Force rebuilding of packages.
+	args := []string{"build"}
+	if !opts.NoForceRebuild {
+		args = append(args, "-a") // Force rebuilding of packages.
+	}
+	ldflags := "-s -w" // Strip all symbols.
+	if opts.Reproducible {
+		ldflags += " -buildid="
+		args = append(args, "-trimpath")
+	}
+	args = append(args,
 		"-o", binaryPath,
 		"-installsuffix", "uroot",
-		"-ldflags", "-s -w", // Strip all symbols.
-	}
+		"-ldflags", ldflags,
+	)
 	if len(c.BuildTags) > 0 {
 		args = append(args, []string{"-tags", strings.Join(c.BuildTags, " ")}...)
 	}
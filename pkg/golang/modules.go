@@ -0,0 +1,155 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package golang
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Module matches a subset of the JSON output of the `go list -m -json`
+// command.
+//
+// See `go help list` for the full structure.
+type Module struct {
+	Path      string
+	Version   string
+	Replace   *Module
+	Main      bool
+	Indirect  bool
+	Dir       string
+	GoMod     string
+	GoVersion string
+}
+
+// goModCache caches the go.mod file of directories by environment.
+var goModCache sync.Map
+
+// GoMod returns the go.mod file of the main module that the go tool uses in
+// dir, or "" if the go tool is in GOPATH mode in dir. An empty dir is the
+// current directory.
+func (c Environ) GoMod(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	key := c.String() + "\x00" + abs
+	if gomod, ok := goModCache.Load(key); ok {
+		return gomod.(string), nil
+	}
+
+	cmd := c.goCmd("env", "GOMOD")
+	cmd.Dir = abs
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("go env GOMOD in %q: %v", abs, err)
+	}
+	gomod := strings.TrimSpace(string(out))
+	// In module mode outside of any module, GOMOD is os.DevNull.
+	if gomod == os.DevNull {
+		gomod = ""
+	}
+	goModCache.Store(key, gomod)
+	return gomod, nil
+}
+
+// UseModules returns true if packages in dir are resolved in module mode,
+// i.e. if dir is part of a Go module and module mode is not turned off.
+func (c Environ) UseModules(dir string) bool {
+	gomod, err := c.GoMod(dir)
+	return err == nil && len(gomod) > 0
+}
+
+// ListModules lists the modules in the build list of the main module that
+// dir is part of, starting with the main module.
+func (c Environ) ListModules(dir string) ([]*Module, error) {
+	cmd := c.goCmd("list", "-m", "-json", "all")
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list -m all in %q: %v: %s", dir, err, stderr.String())
+	}
+
+	var mods []*Module
+	d := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var m Module
+		if err := d.Decode(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		mods = append(mods, &m)
+	}
+	return mods, nil
+}
+
+// ListDeps lists the package in dir and all of its transitive dependencies,
+// dependencies first.
+//
+// If export is true, the packages are compiled and ListPackage.Export is set.
+func (c Environ) ListDeps(dir string, export bool) ([]*ListPackage, error) {
+	args := []string{"list", "-deps", "-json"}
+	if export {
+		args = append(args, "-export")
+	}
+	if len(c.BuildTags) > 0 {
+		args = append(args, "-tags", strings.Join(c.BuildTags, " "))
+	}
+	cmd := c.goCmd(append(args, ".")...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list -deps in %q: %v: %s", dir, err, stderr.String())
+	}
+	return decodeListPackages(out)
+}
+
+// ListPackages lists the packages pkgs in dir, or in the current directory if
+// dir is empty.
+//
+// Unlike Package, ListPackages runs the go tool only once for all pkgs.
+func (c Environ) ListPackages(dir string, pkgs ...string) ([]*ListPackage, error) {
+	args := []string{"list", "-json"}
+	if len(c.BuildTags) > 0 {
+		args = append(args, "-tags", strings.Join(c.BuildTags, " "))
+	}
+	cmd := c.goCmd(append(args, pkgs...)...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list %v: %v: %s", pkgs, err, stderr.String())
+	}
+	return decodeListPackages(out)
+}
+
+// decodeListPackages decodes the concatenated JSON objects that `go list
+// -json` prints.
+func decodeListPackages(out []byte) ([]*ListPackage, error) {
+	var pkgs []*ListPackage
+	d := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var p ListPackage
+		if err := d.Decode(&p); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, &p)
+	}
+	return pkgs, nil
+}
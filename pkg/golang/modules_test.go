@@ -0,0 +1,89 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package golang
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestModules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, content := range map[string]string{
+		"a/go.mod":          "module example.com/a\n\nrequire example.com/b v1.0.0\n\nreplace example.com/b => ../b\n",
+		"a/cmds/foo/foo.go": "package main\n\nimport _ \"example.com/b/bar\"\n\nfunc main() {}\n",
+		"a/cmds/foo/tag.go": "// +build footag\n\npackage main\n",
+		"b/go.mod":          "module example.com/b\n",
+		"b/bar/bar.go":      "package bar\n",
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	env := Default()
+	env.GO111MODULE = "on"
+
+	foo := filepath.Join(dir, "a", "cmds", "foo")
+	if !env.UseModules(foo) {
+		t.Errorf("UseModules(%q) = false, want true", foo)
+	}
+	p, err := env.Package(foo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ImportPath != "example.com/a/cmds/foo" || p.Name != "main" {
+		t.Errorf("Package(%q) = %s (package %s), want example.com/a/cmds/foo (package main)", foo, p.ImportPath, p.Name)
+	}
+	if len(p.GoFiles) != 1 {
+		t.Errorf("Package(%q) has files %v, want only foo.go", foo, p.GoFiles)
+	}
+
+	tagged := env
+	tagged.BuildTags = []string{"footag"}
+	if p, err := tagged.Package(foo); err != nil {
+		t.Fatal(err)
+	} else if len(p.GoFiles) != 2 {
+		t.Errorf("Package(%q) with tag footag has files %v, want foo.go and tag.go", foo, p.GoFiles)
+	}
+
+	mods, err := env.ListModules(foo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mods) != 2 {
+		t.Fatalf("ListModules(%q) returned %d modules, want 2", foo, len(mods))
+	}
+	if !mods[0].Main || mods[0].Path != "example.com/a" {
+		t.Errorf("ListModules(%q)[0] = %+v, want main module example.com/a", foo, mods[0])
+	}
+	if r := mods[1].Replace; mods[1].Path != "example.com/b" || r == nil || r.Dir != filepath.Join(dir, "b") {
+		t.Errorf("ListModules(%q)[1] = %+v, want example.com/b replaced by %s", foo, mods[1], filepath.Join(dir, "b"))
+	}
+
+	deps, err := env.ListDeps(foo, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := deps[len(deps)-1]; last.ImportPath != "example.com/a/cmds/foo" || last.Module == nil || last.Module.Path != "example.com/a" {
+		t.Errorf("ListDeps(%q) ends with %+v, want example.com/a/cmds/foo in module example.com/a", foo, last)
+	}
+
+	env.GO111MODULE = "off"
+	if env.UseModules(foo) {
+		t.Errorf("UseModules(%q) with GO111MODULE=off = true, want false", foo)
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipconfig
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/acpi"
+	"github.com/u-root/u-root/pkg/dhclient"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// readIBFT is a variable so tests can replace it.
+var readIBFT = acpi.ReadIBFT
+
+// Setup configures the network as the ip= parameters of the kernel command
+// line say or, without them, as the iBFT says. It does nothing if there is
+// neither. dc is used for DHCP.
+func Setup(ctx context.Context, dc dhclient.Config) error {
+	configs, err := FromCmdline()
+	if err != nil {
+		return err
+	}
+	implicit := len(configs) == 0
+	if implicit {
+		configs = []*Config{{Method: IBFT}}
+	}
+
+	var all []*Config
+	for _, c := range configs {
+		if c.Method != IBFT {
+			all = append(all, c)
+			continue
+		}
+		t, err := readIBFT()
+		if os.IsNotExist(err) && implicit {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read iBFT: %v", err)
+		}
+		ic, err := FromIBFT(t)
+		if err != nil {
+			return err
+		}
+		for _, i := range ic {
+			i.DNS = append(i.DNS, c.DNS...)
+		}
+		all = append(all, ic...)
+	}
+	return Configure(ctx, all, dc)
+}
+
+// Configure configures the interfaces of configs. The DNS servers of all
+// configurations are written to resolv.conf last, so they replace those of
+// DHCP servers.
+func Configure(ctx context.Context, configs []*Config, dc dhclient.Config) error {
+	var dns []net.IP
+	var errs []string
+	for _, c := range configs {
+		log.Printf("ipconfig: configuring %s", c)
+		if err := c.configure(ctx, dc); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", c.link(), err))
+			continue
+		}
+		dns = append(dns, c.DNS...)
+	}
+	if len(dns) > 0 {
+		if err := dhclient.WriteDNSSettings(dns, nil, ""); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (c *Config) configure(ctx context.Context, dc dhclient.Config) error {
+	if c.Method == Off {
+		return nil
+	}
+	ifs, err := c.links()
+	if err != nil {
+		return err
+	}
+	switch c.Method {
+	case Static:
+		err = c.static(ifs[0])
+	case DHCP:
+		err = dhcp(ctx, ifs, true, false, dc)
+	case DHCP6:
+		err = dhcp(ctx, ifs, false, true, dc)
+	case Any:
+		err = dhcp(ctx, ifs, true, true, dc)
+	default:
+		err = fmt.Errorf("cannot configure %s", c.Method)
+	}
+	if err != nil {
+		return err
+	}
+	if c.Hostname != "" {
+		if err := unix.Sethostname([]byte(c.Hostname)); err != nil {
+			return fmt.Errorf("cannot set hostname %q: %v", c.Hostname, err)
+		}
+	}
+	return nil
+}
+
+// links returns the interfaces to configure: the named one, the one with
+// the MAC address, or all physical interfaces. With a VLAN, they are the
+// VLAN interfaces on top of those.
+func (c *Config) links() ([]netlink.Link, error) {
+	var ifs []netlink.Link
+	if c.Device != "" {
+		l, err := netlink.LinkByName(c.Device)
+		if err != nil {
+			return nil, err
+		}
+		ifs = append(ifs, l)
+	} else {
+		all, err := netlink.LinkList()
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range all {
+			if l.Type() != "device" || l.Attrs().Flags&net.FlagLoopback != 0 {
+				continue
+			}
+			if c.HardwareAddr != nil && !bytes.Equal(l.Attrs().HardwareAddr, c.HardwareAddr) {
+				continue
+			}
+			ifs = append(ifs, l)
+		}
+		if len(ifs) == 0 {
+			return nil, errors.New("no such interface")
+		}
+	}
+
+	if c.VLAN == 0 {
+		return ifs, nil
+	}
+	for i, l := range ifs {
+		v, err := vlan(l, c.VLAN)
+		if err != nil {
+			return nil, err
+		}
+		ifs[i] = v
+	}
+	return ifs, nil
+}
+
+// vlan returns the VLAN interface with id on parent, and creates it if it
+// does not exist.
+func vlan(parent netlink.Link, id int) (netlink.Link, error) {
+	name := fmt.Sprintf("%s.%d", parent.Attrs().Name, id)
+	if l, err := netlink.LinkByName(name); err == nil {
+		return l, nil
+	}
+	attrs := netlink.NewLinkAttrs()
+	attrs.Name = name
+	attrs.ParentIndex = parent.Attrs().Index
+	if err := netlink.LinkAdd(&netlink.Vlan{LinkAttrs: attrs, VlanId: id}); err != nil {
+		return nil, fmt.Errorf("cannot add vlan %s: %v", name, err)
+	}
+	// The VLAN interface only comes up if its parent is.
+	if _, err := dhclient.IfUp(parent.Attrs().Name); err != nil {
+		return nil, err
+	}
+	return netlink.LinkByName(name)
+}
+
+func (c *Config) static(iface netlink.Link) error {
+	name := iface.Attrs().Name
+	if _, err := dhclient.IfUp(name); err != nil {
+		return err
+	}
+	if err := netlink.AddrReplace(iface, &netlink.Addr{IPNet: c.IP}); err != nil {
+		return fmt.Errorf("add/replace %s to %v: %v", c.IP, name, err)
+	}
+	if c.Gateway != nil {
+		r := &netlink.Route{LinkIndex: iface.Attrs().Index, Gw: c.Gateway}
+		if err := netlink.RouteReplace(r); err != nil {
+			return fmt.Errorf("%s: add default route via %s: %v", name, c.Gateway, err)
+		}
+	}
+	return nil
+}
+
+// dhcp configures ifs with dhclient. It fails if no interface got a lease.
+func dhcp(ctx context.Context, ifs []netlink.Link, ipv4, ipv6 bool, dc dhclient.Config) error {
+	configured := false
+	for result := range dhclient.SendRequests(ctx, ifs, ipv4, ipv6, dc) {
+		name := result.Interface.Attrs().Name
+		if result.Err != nil {
+			log.Printf("ipconfig: could not get %s lease on %s: %v", result.Protocol, name, result.Err)
+		} else if err := result.Lease.Configure(); err != nil {
+			log.Printf("ipconfig: could not configure %s on %s: %v", result.Lease, name, err)
+		} else {
+			log.Printf("ipconfig: configured %s with %s", name, result.Lease)
+			configured = true
+		}
+	}
+	if !configured {
+		return errors.New("no DHCP lease")
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipconfig
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/u-root/u-root/pkg/acpi"
+)
+
+// iBFT NIC origins, which are the NL_PREFIX_ORIGIN values of Windows.
+const (
+	originDHCP               = 3
+	originRouterAdvertisment = 4
+)
+
+// FromIBFT returns the configurations of the valid NICs of t. NICs are
+// found by their MAC address.
+func FromIBFT(t *acpi.IBFT) ([]*Config, error) {
+	var configs []*Config
+	for _, n := range []acpi.IBFTNIC{t.NIC0, t.NIC1} {
+		if n.Valid != "1" {
+			continue
+		}
+		c, err := fromIBFTNIC(n)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, c)
+	}
+	return configs, nil
+}
+
+func fromIBFTNIC(n acpi.IBFTNIC) (*Config, error) {
+	hw, err := net.ParseMAC(string(n.MACAddress))
+	if err != nil {
+		return nil, fmt.Errorf("iBFT NIC %s: %v", n.Index, err)
+	}
+	c := &Config{
+		HardwareAddr: hw,
+		Hostname:     string(n.HostName),
+	}
+	if n.VLAN != "" {
+		if c.VLAN, err = strconv.Atoi(string(n.VLAN)); err != nil {
+			return nil, fmt.Errorf("iBFT NIC %s: invalid VLAN %q", n.Index, n.VLAN)
+		}
+	}
+	for _, d := range []string{string(n.PrimaryDNS), string(n.SecondaryDNS)} {
+		if ip := net.ParseIP(d); ip != nil {
+			c.DNS = append(c.DNS, ip)
+		}
+	}
+
+	origin, _ := strconv.Atoi(string(n.Origin))
+	ip := net.ParseIP(string(n.IPAddress))
+	switch {
+	case origin == originRouterAdvertisment:
+		c.Method = DHCP6
+	case ip == nil, origin == originDHCP:
+		// The firmware got its address with DHCP, so do we.
+		c.Method = DHCP
+	default:
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			bits = 8 * net.IPv4len
+		}
+		prefix, err := strconv.Atoi(string(n.SubNet))
+		if err != nil || prefix > bits {
+			return nil, fmt.Errorf("iBFT NIC %s: invalid prefix length %q", n.Index, n.SubNet)
+		}
+		c.Method = Static
+		c.IP = &net.IPNet{IP: ip, Mask: net.CIDRMask(prefix, bits)}
+		c.Gateway = net.ParseIP(string(n.Gateway))
+	}
+	return c, nil
+}
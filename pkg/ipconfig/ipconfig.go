@@ -0,0 +1,285 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ipconfig configures network interfaces as the kernel command line
+// or the firmware say, so that init can bring up the network before uinit
+// runs.
+//
+// The ip= parameter has the syntax of the kernel's nfsroot documentation:
+//
+//	ip=<client-ip>:<server-ip>:<gw-ip>:<netmask>:<hostname>:<device>:<autoconf>:<dns0-ip>:<dns1-ip>:<ntp0-ip>
+//
+// It may also be just an autoconf method, e.g. ip=dhcp, or, as in dracut,
+// <device>:<autoconf>. IPv6 addresses go in brackets. Additional DNS servers
+// may be given with nameserver=.
+//
+// Without ip=, interfaces are configured from the iSCSI Boot Firmware Table
+// (iBFT), if the firmware has one.
+package ipconfig
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/cmdline"
+)
+
+// Method is how an interface gets its addresses.
+type Method int
+
+const (
+	// Off means the interface is not configured.
+	Off Method = iota
+
+	// Static means the addresses are in the configuration.
+	Static
+
+	// DHCP means the interface is configured with DHCPv4.
+	DHCP
+
+	// DHCP6 means the interface is configured with router advertisements
+	// and DHCPv6.
+	DHCP6
+
+	// Any means the interface is configured with DHCPv4 and DHCPv6.
+	Any
+
+	// IBFT means the configuration is in the iBFT.
+	IBFT
+)
+
+func (m Method) String() string {
+	switch m {
+	case Off:
+		return "off"
+	case Static:
+		return "static"
+	case DHCP:
+		return "dhcp"
+	case DHCP6:
+		return "dhcp6"
+	case Any:
+		return "any"
+	case IBFT:
+		return "ibft"
+	}
+	return fmt.Sprintf("Method(%d)", int(m))
+}
+
+// methods maps the autoconf values of the kernel and dracut to methods. The
+// kernel only knows DHCP for IPv4, so BOOTP and RARP get DHCP too.
+var methods = map[string]Method{
+	"off":   Off,
+	"none":  Off,
+	"on":    Any,
+	"any":   Any,
+	"both":  DHCP,
+	"dhcp":  DHCP,
+	"bootp": DHCP,
+	"rarp":  DHCP,
+	"dhcp6": DHCP6,
+	"auto6": DHCP6,
+	"ibft":  IBFT,
+}
+
+// Config is the configuration of one interface.
+type Config struct {
+	// Device is the name of the interface. If it is empty, the interface
+	// with HardwareAddr is configured; if that is empty too, DHCP tries
+	// all interfaces and static configuration uses the first one.
+	Device       string
+	HardwareAddr net.HardwareAddr
+
+	// VLAN, if not 0, is the VLAN to configure on top of the interface.
+	VLAN int
+
+	Method Method
+
+	// IP, Gateway and Hostname are for static configuration.
+	IP       *net.IPNet
+	Gateway  net.IP
+	Hostname string
+
+	// DNS are the DNS servers. With DHCP, they replace those of the
+	// DHCP server.
+	DNS []net.IP
+}
+
+func (c *Config) String() string {
+	s := fmt.Sprintf("%s on %s", c.Method, c.link())
+	if c.IP != nil {
+		s += fmt.Sprintf(" ip %s", c.IP)
+	}
+	if c.Gateway != nil {
+		s += fmt.Sprintf(" via %s", c.Gateway)
+	}
+	return s
+}
+
+func (c *Config) link() string {
+	var s string
+	switch {
+	case c.Device != "":
+		s = c.Device
+	case c.HardwareAddr != nil:
+		s = c.HardwareAddr.String()
+	default:
+		s = "all interfaces"
+	}
+	if c.VLAN != 0 {
+		s += fmt.Sprintf(" vlan %d", c.VLAN)
+	}
+	return s
+}
+
+// ParseIP parses the value of an ip= parameter.
+func ParseIP(s string) (*Config, error) {
+	f := fields(s)
+	c := &Config{}
+	switch len(f) {
+	case 1:
+		m, ok := methods[f[0]]
+		if !ok {
+			return nil, fmt.Errorf("ip=%s: unknown autoconf method %q", s, f[0])
+		}
+		c.Method = m
+		return c, nil
+	case 2:
+		// dracut's <device>:<autoconf>.
+		if m, ok := methods[f[1]]; ok {
+			c.Device, c.Method = f[0], m
+			return c, nil
+		}
+	}
+	f = append(f, make([]string, 10)...)
+
+	if f[0] != "" {
+		ip := net.ParseIP(f[0])
+		if ip == nil {
+			return nil, fmt.Errorf("ip=%s: invalid client address %q", s, f[0])
+		}
+		mask, err := parseMask(ip, f[3])
+		if err != nil {
+			return nil, fmt.Errorf("ip=%s: %v", s, err)
+		}
+		c.IP = &net.IPNet{IP: ip, Mask: mask}
+	}
+	// f[1], the NFS server, is not used.
+	if f[2] != "" {
+		if c.Gateway = net.ParseIP(f[2]); c.Gateway == nil {
+			return nil, fmt.Errorf("ip=%s: invalid gateway %q", s, f[2])
+		}
+	}
+	c.Hostname = f[4]
+	c.Device = f[5]
+
+	switch {
+	case f[6] != "":
+		m, ok := methods[f[6]]
+		if !ok {
+			return nil, fmt.Errorf("ip=%s: unknown autoconf method %q", s, f[6])
+		}
+		c.Method = m
+	case c.IP != nil:
+		c.Method = Static
+	default:
+		c.Method = Any
+	}
+	// With an address, off means static configuration.
+	if c.Method == Off && c.IP != nil {
+		c.Method = Static
+	}
+	if c.Method == Static && c.IP == nil {
+		return nil, fmt.Errorf("ip=%s: static configuration needs a client address", s)
+	}
+
+	// f[9], the NTP server, is not used.
+	for _, d := range f[7:9] {
+		if d == "" {
+			continue
+		}
+		ip := net.ParseIP(d)
+		if ip == nil {
+			return nil, fmt.Errorf("ip=%s: invalid DNS server %q", s, d)
+		}
+		c.DNS = append(c.DNS, ip)
+	}
+	return c, nil
+}
+
+// fields splits s at colons that are not in brackets, and removes the
+// brackets.
+func fields(s string) []string {
+	var f []string
+	var cur strings.Builder
+	inBrackets := false
+	for _, r := range s {
+		switch {
+		case r == '[' && !inBrackets:
+			inBrackets = true
+		case r == ']' && inBrackets:
+			inBrackets = false
+		case r == ':' && !inBrackets:
+			f = append(f, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	return append(f, cur.String())
+}
+
+// parseMask parses a dotted netmask or a prefix length. Without either,
+// the mask of the address class is used for IPv4, and /64 for IPv6.
+func parseMask(ip net.IP, s string) (net.IPMask, error) {
+	bits := 8 * net.IPv6len
+	if ip.To4() != nil {
+		bits = 8 * net.IPv4len
+	}
+	if s == "" {
+		if bits == 8*net.IPv4len {
+			return ip.DefaultMask(), nil
+		}
+		return net.CIDRMask(64, bits), nil
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 && n <= bits {
+		return net.CIDRMask(n, bits), nil
+	}
+	m := net.ParseIP(s).To4()
+	if m == nil || bits != 8*net.IPv4len {
+		return nil, fmt.Errorf("invalid netmask %q", s)
+	}
+	mask := net.IPMask(m)
+	if ones, _ := mask.Size(); ones == 0 && !m.Equal(net.IPv4zero) {
+		return nil, fmt.Errorf("netmask %q is not contiguous", s)
+	}
+	return mask, nil
+}
+
+// FromCmdline returns the configurations of the ip= parameters of the
+// kernel command line. The servers of the nameserver= parameters are added
+// to each. It returns nil if there is no ip= parameter.
+func FromCmdline() ([]*Config, error) {
+	var dns []net.IP
+	for _, ns := range cmdline.FlagValues("nameserver") {
+		ip := net.ParseIP(strings.Trim(ns, "[]"))
+		if ip == nil {
+			return nil, fmt.Errorf("nameserver=%s: invalid address", ns)
+		}
+		dns = append(dns, ip)
+	}
+
+	var configs []*Config
+	for _, s := range cmdline.FlagValues("ip") {
+		c, err := ParseIP(s)
+		if err != nil {
+			return nil, err
+		}
+		c.DNS = append(c.DNS, dns...)
+		configs = append(configs, c)
+	}
+	return configs, nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipconfig
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/acpi"
+)
+
+func TestParseIP(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want *Config
+	}{
+		{"dhcp", &Config{Method: DHCP}},
+		{"off", &Config{Method: Off}},
+		{"on", &Config{Method: Any}},
+		{"ibft", &Config{Method: IBFT}},
+		{"eth1:dhcp6", &Config{Device: "eth1", Method: DHCP6}},
+		{
+			"192.168.1.5::192.168.1.1:255.255.255.0:myhost:eth0:off:8.8.8.8:8.8.4.4:",
+			&Config{
+				Device:   "eth0",
+				Method:   Static,
+				IP:       &net.IPNet{IP: net.ParseIP("192.168.1.5"), Mask: net.CIDRMask(24, 32)},
+				Gateway:  net.ParseIP("192.168.1.1"),
+				Hostname: "myhost",
+				DNS:      []net.IP{net.ParseIP("8.8.8.8"), net.ParseIP("8.8.4.4")},
+			},
+		},
+		{
+			// No autoconf with an address is static, and the class
+			// gives the mask.
+			"10.0.0.2::10.0.0.1",
+			&Config{
+				Method:  Static,
+				IP:      &net.IPNet{IP: net.ParseIP("10.0.0.2"), Mask: net.CIDRMask(8, 32)},
+				Gateway: net.ParseIP("10.0.0.1"),
+			},
+		},
+		{
+			"[2001:db8::5]::[2001:db8::1]:64::eth0:none",
+			&Config{
+				Device:  "eth0",
+				Method:  Static,
+				IP:      &net.IPNet{IP: net.ParseIP("2001:db8::5"), Mask: net.CIDRMask(64, 128)},
+				Gateway: net.ParseIP("2001:db8::1"),
+			},
+		},
+		{":::::eth0:dhcp", &Config{Device: "eth0", Method: DHCP}},
+		{":::::eth0", &Config{Device: "eth0", Method: Any}},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseIP(tt.in)
+			if err != nil {
+				t.Fatalf("ParseIP(%q) = %v", tt.in, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseIP(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIPErrors(t *testing.T) {
+	for _, in := range []string{
+		"bogus",
+		"192.168.1.500",
+		"192.168.1.5::192.168.1.1:255.0.255.0",
+		"192.168.1.5::192.168.1.1:33",
+		"192.168.1.5::gateway",
+		":::::eth0:off-ish",
+		":::::eth0:off:not-an-ip",
+		"[2001:db8::5]:::255.255.255.0",
+	} {
+		if _, err := ParseIP(in); err == nil {
+			t.Errorf("ParseIP(%q) = nil, want error", in)
+		}
+	}
+}
+
+func TestFromIBFT(t *testing.T) {
+	ibft := &acpi.IBFT{
+		NIC0: acpi.IBFTNIC{
+			Valid:      "1",
+			Index:      "0",
+			IPAddress:  "192.168.1.5",
+			SubNet:     "24",
+			Origin:     "1",
+			Gateway:    "192.168.1.1",
+			PrimaryDNS: "8.8.8.8",
+			VLAN:       "10",
+			MACAddress: "00:0c:29:12:a4:2e",
+			HostName:   "somehost",
+		},
+		NIC1: acpi.IBFTNIC{
+			Valid:      "1",
+			Index:      "1",
+			IPAddress:  "10.0.0.7",
+			SubNet:     "8",
+			Origin:     "3",
+			VLAN:       "0",
+			MACAddress: "00:0c:29:12:a4:2f",
+		},
+	}
+	got, err := FromIBFT(ibft)
+	if err != nil {
+		t.Fatalf("FromIBFT() = %v", err)
+	}
+	want := []*Config{
+		{
+			HardwareAddr: net.HardwareAddr{0, 0x0c, 0x29, 0x12, 0xa4, 0x2e},
+			VLAN:         10,
+			Method:       Static,
+			IP:           &net.IPNet{IP: net.ParseIP("192.168.1.5"), Mask: net.CIDRMask(24, 32)},
+			Gateway:      net.ParseIP("192.168.1.1"),
+			Hostname:     "somehost",
+			DNS:          []net.IP{net.ParseIP("8.8.8.8")},
+		},
+		{
+			HardwareAddr: net.HardwareAddr{0, 0x0c, 0x29, 0x12, 0xa4, 0x2f},
+			Method:       DHCP,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FromIBFT() = %v, want %v", got, want)
+	}
+
+	ibft.NIC1.Valid = "0"
+	if got, err := FromIBFT(ibft); err != nil || len(got) != 1 {
+		t.Errorf("FromIBFT() with invalid NIC1 = %v, %v, want 1 config", got, err)
+	}
+}
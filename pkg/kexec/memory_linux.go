@@ -184,6 +184,25 @@ func (rs Ranges) FindSpaceIn(sz uint, limit Range) (space Range, err error) {
 	return Range{}, ErrNotEnoughSpace{Size: sz}
 }
 
+// FindAlignedSpaceIn finds a continguous piece of sz points within Ranges
+// and returns a Range where space.Start >= limit.Start, with space.End() <
+// limit.End(), and space.Start a multiple of align. align must be a power of
+// two.
+func (rs Ranges) FindAlignedSpaceIn(sz, align uint, limit Range) (space Range, err error) {
+	mask := uintptr(align - 1)
+	for _, r := range rs {
+		overlap := r.Intersect(limit)
+		if overlap == nil {
+			continue
+		}
+		start := (overlap.Start + mask) &^ mask
+		if start >= overlap.Start && start < overlap.End() && uint(overlap.End()-start) >= sz {
+			return Range{Start: start, Size: sz}, nil
+		}
+	}
+	return Range{}, ErrNotEnoughSpace{Size: sz}
+}
+
 // Sort sorts ranges by their start point.
 func (rs Ranges) Sort() {
 	sort.Slice(rs, func(i, j int) bool {
@@ -499,6 +518,64 @@ func internalParseMemoryMap(memoryMapDir string) (MemoryMap, error) {
 	return phys, nil
 }
 
+var iomemPath = "/proc/iomem"
+
+// ParseIOMem reads the physical memory map from /proc/iomem.
+//
+// Only top-level "System RAM" ranges are RAM. Nested "reserved" ranges, such
+// as memory reserved by the device tree, are carved out of them. Architectures
+// without /sys/firmware/memmap, such as arm and arm64, need this.
+func ParseIOMem() (MemoryMap, error) {
+	return internalParseIOMem(iomemPath)
+}
+
+func internalParseIOMem(path string) (MemoryMap, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var phys, reserved MemoryMap
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		// Lines look like "  00200000-0120ffff : Kernel code", with two
+		// spaces of indentation per level of nesting.
+		f := strings.SplitN(line, " : ", 2)
+		if len(f) != 2 {
+			return nil, fmt.Errorf("invalid iomem line %q", line)
+		}
+		nested := strings.HasPrefix(f[0], " ")
+		addrs := strings.SplitN(strings.TrimSpace(f[0]), "-", 2)
+		if len(addrs) != 2 {
+			return nil, fmt.Errorf("invalid iomem range %q", f[0])
+		}
+		start, err := strconv.ParseUint(addrs[0], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid iomem range %q: %v", f[0], err)
+		}
+		end, err := strconv.ParseUint(addrs[1], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid iomem range %q: %v", f[0], err)
+		}
+		// As in sysfs, the end address is inclusive.
+		r := RangeFromInterval(uintptr(start), uintptr(end)+1)
+
+		switch name := strings.TrimSpace(f[1]); {
+		case name == "System RAM" && !nested:
+			phys = append(phys, TypedRange{Range: r, Type: RangeRAM})
+		case strings.EqualFold(name, "reserved"):
+			reserved = append(reserved, TypedRange{Range: r, Type: RangeReserved})
+		}
+	}
+	for _, r := range reserved {
+		phys.Insert(r)
+	}
+	phys.sort()
+	return phys, nil
+}
+
 // M1 is 1 Megabyte in bits.
 const M1 = 1 << 20
 
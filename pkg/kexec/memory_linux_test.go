@@ -64,6 +64,47 @@ func TestParseMemoryMap(t *testing.T) {
 	}
 }
 
+func TestParseIOMem(t *testing.T) {
+	f, err := ioutil.TempFile("", "iomem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(`00000000-3fffffff : 0.flash flash@0
+09000000-09000fff : pl011@9000000
+  09000000-09000fff : pl011@9000000
+40000000-4fffffff : System RAM
+  40080000-40c3ffff : Kernel code
+  40c40000-40d7ffff : reserved
+  40d80000-40ffffff : Kernel data
+  48000000-480fffff : reserved
+50000000-5fffffff : System RAM
+`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	want := MemoryMap{
+		{Range: RangeFromInterval(0x40000000, 0x40c40000), Type: RangeRAM},
+		{Range: RangeFromInterval(0x40c40000, 0x40d80000), Type: RangeReserved},
+		{Range: RangeFromInterval(0x40d80000, 0x48000000), Type: RangeRAM},
+		{Range: RangeFromInterval(0x48000000, 0x48100000), Type: RangeReserved},
+		{Range: RangeFromInterval(0x48100000, 0x50000000), Type: RangeRAM},
+		{Range: RangeFromInterval(0x50000000, 0x60000000), Type: RangeRAM},
+	}
+	phys, err := internalParseIOMem(f.Name())
+	if err != nil {
+		t.Fatalf("ParseIOMem() error: %v", err)
+	}
+	if !reflect.DeepEqual(phys, want) {
+		t.Errorf("ParseIOMem() got %v, want %v", phys, want)
+	}
+
+	if _, err := internalParseIOMem("/does/not/exist"); err == nil {
+		t.Errorf("ParseIOMem(nonexistent) = nil, want error")
+	}
+}
+
 func TestAvailableRAM(t *testing.T) {
 	old := pageMask
 	defer func() {
@@ -388,6 +429,75 @@ func TestFindSpaceIn(t *testing.T) {
 	}
 }
 
+func TestFindAlignedSpaceIn(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		rs    Ranges
+		size  uint
+		align uint
+		limit Range
+		want  Range
+		err   error
+	}{
+		{
+			name: "aligned start",
+			rs: Ranges{
+				Range{Start: 0x2000, Size: 0x2000},
+			},
+			size:  0x1000,
+			align: 0x1000,
+			limit: RangeFromInterval(0, MaxAddr),
+			want:  Range{Start: 0x2000, Size: 0x1000},
+		},
+		{
+			name: "unaligned start",
+			rs: Ranges{
+				Range{Start: 0x1010, Size: 0x2000},
+			},
+			size:  0x1000,
+			align: 0x1000,
+			limit: RangeFromInterval(0, MaxAddr),
+			want:  Range{Start: 0x2000, Size: 0x1000},
+		},
+		{
+			name: "not enough space after aligning",
+			rs: Ranges{
+				Range{Start: 0x1010, Size: 0x1fe0},
+				Range{Start: 0x10000, Size: 0x1000},
+			},
+			size:  0x1000,
+			align: 0x2000,
+			limit: RangeFromInterval(0, MaxAddr),
+			want:  Range{Start: 0x10000, Size: 0x1000},
+		},
+		{
+			name: "aligned start past the limit",
+			rs: Ranges{
+				Range{Start: 0x1000, Size: 0x10000},
+			},
+			size:  0x1000,
+			align: 0x4000,
+			limit: RangeFromInterval(0x1000, 0x4000),
+			err:   ErrNotEnoughSpace{Size: 0x1000},
+		},
+		{
+			name:  "no ranges",
+			rs:    Ranges{},
+			size:  0x10,
+			align: 0x10,
+			limit: RangeFromInterval(0, MaxAddr),
+			err:   ErrNotEnoughSpace{Size: 0x10},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.rs.FindAlignedSpaceIn(tt.size, tt.align, tt.limit)
+			if !reflect.DeepEqual(got, tt.want) || err != tt.err {
+				t.Errorf("%s.FindAlignedSpaceIn(%#x, %#x, limit = %s) = (%#x, %v), want (%#x, %v)", tt.rs, tt.size, tt.align, tt.limit, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
 func TestIntersection(t *testing.T) {
 	for i, tt := range []struct {
 		r             Range
@@ -0,0 +1,172 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lz4
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	minMatch = 4
+	// The last match must start at least mfLimit bytes before the end of
+	// the block, and the last lastLiterals bytes are always literals.
+	mfLimit      = 12
+	lastLiterals = 5
+	maxOffset    = 1<<16 - 1
+
+	hashLog = 16
+)
+
+var errCorrupt = errors.New("lz4: corrupt block")
+
+// compressBound is the largest compressed size of n bytes of input.
+func compressBound(n int) int {
+	return n + n/255 + 16
+}
+
+func hash(v uint32) uint32 {
+	return (v * 2654435761) >> (32 - hashLog)
+}
+
+func appendLen(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+func appendSequence(dst, lits []byte, offset, matchLen int) []byte {
+	token := byte(0)
+	if len(lits) >= 15 {
+		token = 15 << 4
+	} else {
+		token = byte(len(lits)) << 4
+	}
+	ml := matchLen - minMatch
+	if matchLen > 0 {
+		if ml >= 15 {
+			token |= 15
+		} else {
+			token |= byte(ml)
+		}
+	}
+	dst = append(dst, token)
+	if len(lits) >= 15 {
+		dst = appendLen(dst, len(lits)-15)
+	}
+	dst = append(dst, lits...)
+	if matchLen == 0 {
+		return dst
+	}
+	dst = append(dst, byte(offset), byte(offset>>8))
+	if ml >= 15 {
+		dst = appendLen(dst, ml-15)
+	}
+	return dst
+}
+
+// compressBlock appends the LZ4 block compression of src to dst.
+func compressBlock(dst, src []byte) []byte {
+	if len(src) < mfLimit+1 {
+		return appendSequence(dst, src, 0, 0)
+	}
+
+	var table [1 << hashLog]int32
+	anchor := 0
+	limit := len(src) - mfLimit
+	matchLimit := len(src) - lastLiterals
+	for i := 0; i < limit; {
+		seq := binary.LittleEndian.Uint32(src[i:])
+		h := hash(seq)
+		ref := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if ref < 0 || i-ref > maxOffset || binary.LittleEndian.Uint32(src[ref:]) != seq {
+			i++
+			continue
+		}
+
+		// Extend the match backwards over pending literals.
+		for i > anchor && ref > 0 && src[i-1] == src[ref-1] {
+			i--
+			ref--
+		}
+		n := minMatch
+		for i+n < matchLimit && src[i+n] == src[ref+n] {
+			n++
+		}
+		dst = appendSequence(dst, src[anchor:i], i-ref, n)
+		i += n
+		anchor = i
+		if i-2 < limit {
+			table[hash(binary.LittleEndian.Uint32(src[i-2:]))] = int32(i - 2 + 1)
+		}
+	}
+	return appendSequence(dst, src[anchor:], 0, 0)
+}
+
+// decompressBlock decompresses the LZ4 block src into dst, which must be
+// large enough, and returns the number of bytes written.
+func decompressBlock(dst, src []byte) (int, error) {
+	var si, di int
+	readLen := func(n int) (int, error) {
+		if n != 15 {
+			return n, nil
+		}
+		for {
+			if si >= len(src) {
+				return 0, errCorrupt
+			}
+			b := src[si]
+			si++
+			n += int(b)
+			if b != 255 {
+				return n, nil
+			}
+		}
+	}
+
+	for si < len(src) {
+		token := src[si]
+		si++
+
+		litLen, err := readLen(int(token >> 4))
+		if err != nil {
+			return 0, err
+		}
+		if si+litLen > len(src) || di+litLen > len(dst) {
+			return 0, errCorrupt
+		}
+		di += copy(dst[di:], src[si:si+litLen])
+		si += litLen
+		if si == len(src) {
+			// The last sequence has no match.
+			break
+		}
+
+		if si+2 > len(src) {
+			return 0, errCorrupt
+		}
+		offset := int(src[si]) | int(src[si+1])<<8
+		si += 2
+		if offset == 0 || offset > di {
+			return 0, errCorrupt
+		}
+		matchLen, err := readLen(int(token & 0xF))
+		if err != nil {
+			return 0, err
+		}
+		matchLen += minMatch
+		if di+matchLen > len(dst) {
+			return 0, errCorrupt
+		}
+		// Matches may overlap their own output.
+		for i := 0; i < matchLen; i++ {
+			dst[di] = dst[di-offset]
+			di++
+		}
+	}
+	return di, nil
+}
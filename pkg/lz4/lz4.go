@@ -0,0 +1,170 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package lz4 implements the LZ4 legacy frame format.
+//
+// The legacy format is what `lz4 -l` produces and the only LZ4 format the
+// Linux kernel accepts for compressed kernels and initramfs archives. A
+// legacy stream is the magic number 0x184C2102 followed by blocks that each
+// decompress to at most 8 MiB, each prefixed by its little endian 32-bit
+// compressed size.
+package lz4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// Magic is the legacy frame magic number.
+	Magic = 0x184C2102
+
+	// BlockSize is the uncompressed size of every block but the last.
+	BlockSize = 8 << 20
+)
+
+// ErrFormat is returned when the input is not a legacy LZ4 stream.
+var ErrFormat = errors.New("lz4: invalid legacy format")
+
+// Writer compresses data written to it into a legacy LZ4 stream.
+type Writer struct {
+	w           io.Writer
+	buf         []byte
+	out         []byte
+	wroteHeader bool
+	err         error
+}
+
+// NewWriter returns a Writer that compresses into w.
+//
+// Callers must call Close to flush the last block.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:   w,
+		buf: make([]byte, 0, BlockSize),
+	}
+}
+
+// Write implements io.Writer.
+func (z *Writer) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 && z.err == nil {
+		c := copy(z.buf[len(z.buf):cap(z.buf)], p)
+		z.buf = z.buf[:len(z.buf)+c]
+		p = p[c:]
+		if len(z.buf) == cap(z.buf) {
+			z.err = z.flush()
+		}
+	}
+	if z.err != nil {
+		return n - len(p), z.err
+	}
+	return n, nil
+}
+
+func (z *Writer) flush() error {
+	if !z.wroteHeader {
+		z.wroteHeader = true
+		var magic [4]byte
+		binary.LittleEndian.PutUint32(magic[:], Magic)
+		if _, err := z.w.Write(magic[:]); err != nil {
+			return err
+		}
+	}
+	if len(z.buf) == 0 {
+		return nil
+	}
+	z.out = compressBlock(append(z.out[:0], 0, 0, 0, 0), z.buf)
+	binary.LittleEndian.PutUint32(z.out, uint32(len(z.out)-4))
+	z.buf = z.buf[:0]
+	_, err := z.w.Write(z.out)
+	return err
+}
+
+// Close flushes remaining data. It does not close the underlying writer.
+func (z *Writer) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	z.err = z.flush()
+	return z.err
+}
+
+// Reader decompresses a legacy LZ4 stream.
+type Reader struct {
+	r   io.Reader
+	in  []byte
+	buf []byte
+	off int
+	err error
+}
+
+// NewReader returns a Reader decompressing the legacy LZ4 stream in r.
+//
+// NewReader reads and validates the magic number.
+func NewReader(r io.Reader) (*Reader, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, ErrFormat
+	}
+	if binary.LittleEndian.Uint32(magic[:]) != Magic {
+		return nil, ErrFormat
+	}
+	return &Reader{r: r}, nil
+}
+
+// Read implements io.Reader.
+func (z *Reader) Read(p []byte) (int, error) {
+	for z.off == len(z.buf) {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.nextBlock()
+	}
+	n := copy(p, z.buf[z.off:])
+	z.off += n
+	return n, nil
+}
+
+func (z *Reader) nextBlock() error {
+	var size [4]byte
+	for {
+		if _, err := io.ReadFull(z.r, size[:]); err == io.EOF {
+			return io.EOF
+		} else if err != nil {
+			return err
+		}
+		// Like the kernel, accept concatenated streams.
+		if binary.LittleEndian.Uint32(size[:]) != Magic {
+			break
+		}
+	}
+	n := int(binary.LittleEndian.Uint32(size[:]))
+	if n > compressBound(BlockSize) {
+		return fmt.Errorf("lz4: block size %d exceeds maximum", n)
+	}
+	if cap(z.in) < n {
+		z.in = make([]byte, n)
+	}
+	z.in = z.in[:n]
+	if _, err := io.ReadFull(z.r, z.in); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if z.buf == nil {
+		z.buf = make([]byte, BlockSize)
+	}
+	z.buf = z.buf[:cap(z.buf)]
+	m, err := decompressBlock(z.buf, z.in)
+	if err != nil {
+		return err
+	}
+	z.buf = z.buf[:m]
+	z.off = 0
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lz4
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func roundTrip(t *testing.T, data []byte) ([]byte, []byte) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	return buf.Bytes(), got
+}
+
+func TestRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(0))
+	random := make([]byte, 1<<20)
+	rnd.Read(random)
+
+	var text bytes.Buffer
+	words := strings.Fields("the quick brown fox jumps over a lazy dog u-root initramfs cpio kexec")
+	for text.Len() < 10<<20 {
+		text.WriteString(words[rnd.Intn(len(words))])
+		text.WriteByte(' ')
+	}
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "short", data: []byte("abc")},
+		{name: "just below the match limit", data: []byte("aaaaaaaaaaaa")},
+		{name: "zeros", data: make([]byte, 100000)},
+		{name: "random", data: random},
+		{name: "text spanning blocks", data: text.Bytes()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, got := roundTrip(t, tt.data)
+			if !bytes.Equal(got, tt.data) {
+				t.Errorf("round trip of %d bytes returned %d different bytes", len(tt.data), len(got))
+			}
+		})
+	}
+}
+
+func TestCompresses(t *testing.T) {
+	data := bytes.Repeat([]byte("u-root is a universal root. "), 10000)
+	if c, _ := roundTrip(t, data); len(c) > len(data)/20 {
+		t.Errorf("compressed %d bytes to %d bytes, want at most %d", len(data), len(c), len(data)/20)
+	}
+}
+
+func TestReadExternal(t *testing.T) {
+	want, err := ioutil.ReadFile("testdata/text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile("testdata/text.lz4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Concatenated streams are valid, too.
+	for _, in := range [][]byte{b, append(append([]byte{}, b...), b...)} {
+		r, err := NewReader(bytes.NewReader(in))
+		if err != nil {
+			t.Fatalf("NewReader: %v", err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+		if !bytes.Equal(got, bytes.Repeat(want, len(in)/len(b))) {
+			t.Errorf("decompressed testdata/text.lz4 does not match testdata/text")
+		}
+	}
+}
+
+func TestDecompressBlockCorrupt(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		in   []byte
+	}{
+		{name: "literals past end", in: []byte{0x50, 'a'}},
+		{name: "zero offset", in: []byte{0x14, 'a', 0, 0}},
+		{name: "offset before start", in: []byte{0x14, 'a', 2, 0}},
+		{name: "truncated offset", in: []byte{0x14, 'a', 1}},
+		{name: "truncated length", in: []byte{0xF0}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decompressBlock(make([]byte, 64), tt.in); err == nil {
+				t.Errorf("decompressBlock(%#x) succeeded", tt.in)
+			}
+		})
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
-	"strings"
 )
 
 const DebugPrefix = "MULTIBOOT_DEBUG_INFO:"
@@ -39,8 +38,11 @@ type Description struct {
 func (m multiboot) description() (string, error) {
 	var modules []ModuleDesc
 	for i, mod := range m.loadedModules {
-		name := strings.Fields(m.modules[i])[0]
-		b, err := readFile(name)
+		name, err := ModulePath(m.modules[i])
+		if err != nil {
+			return "", err
+		}
+		b, err := m.readFile(name)
 		if err != nil {
 			return "", nil
 		}
@@ -0,0 +1,125 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package multiboot
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// framebufferTypeRGB is a direct color framebuffer.
+const framebufferTypeRGB = 1
+
+// framebuffer is the framebuffer info of the Multiboot2 boot information.
+type framebuffer struct {
+	Addr     uint64
+	Pitch    uint32
+	Width    uint32
+	Height   uint32
+	BPP      uint8
+	Type     uint8
+	Reserved uint16
+
+	RedFieldPosition   uint8
+	RedMaskSize        uint8
+	GreenFieldPosition uint8
+	GreenMaskSize      uint8
+	BlueFieldPosition  uint8
+	BlueMaskSize       uint8
+}
+
+// Linux framebuffer ioctls and structs from include/uapi/linux/fb.h.
+const (
+	fbiogetVScreenInfo = 0x4600
+	fbiogetFScreenInfo = 0x4602
+
+	fbVisualTrueColor   = 2
+	fbVisualDirectColor = 4
+)
+
+type fbFixScreenInfo struct {
+	ID           [16]byte
+	SmemStart    uintptr
+	SmemLen      uint32
+	Type         uint32
+	TypeAux      uint32
+	Visual       uint32
+	XPanStep     uint16
+	YPanStep     uint16
+	YWrapStep    uint16
+	LineLength   uint32
+	MmioStart    uintptr
+	MmioLen      uint32
+	Accel        uint32
+	Capabilities uint16
+	Reserved     [2]uint16
+}
+
+type fbBitfield struct {
+	Offset   uint32
+	Length   uint32
+	MSBRight uint32
+}
+
+type fbVarScreenInfo struct {
+	XRes, YRes               uint32
+	XResVirtual, YResVirtual uint32
+	XOffset, YOffset         uint32
+	BitsPerPixel             uint32
+	Grayscale                uint32
+	Red, Green, Blue, Transp fbBitfield
+	NonStd                   uint32
+	Activate                 uint32
+	Height, Width            uint32
+	AccelFlags               uint32
+	PixClock                 uint32
+	LeftMargin, RightMargin  uint32
+	UpperMargin, LowerMargin uint32
+	HSyncLen, VSyncLen       uint32
+	Sync, VMode, Rotate      uint32
+	Colorspace               uint32
+	Reserved                 [4]uint32
+}
+
+var framebufferDevice = "/dev/fb0"
+
+// currentFramebuffer returns the framebuffer Linux is using, so the loaded
+// kernel can keep using it.
+func currentFramebuffer() (*framebuffer, error) {
+	f, err := os.Open(framebufferDevice)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var fix fbFixScreenInfo
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fbiogetFScreenInfo, uintptr(unsafe.Pointer(&fix))); errno != 0 {
+		return nil, fmt.Errorf("FBIOGET_FSCREENINFO on %s: %v", framebufferDevice, errno)
+	}
+	var v fbVarScreenInfo
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fbiogetVScreenInfo, uintptr(unsafe.Pointer(&v))); errno != 0 {
+		return nil, fmt.Errorf("FBIOGET_VSCREENINFO on %s: %v", framebufferDevice, errno)
+	}
+	if fix.Visual != fbVisualTrueColor && fix.Visual != fbVisualDirectColor {
+		return nil, fmt.Errorf("%s is not a direct color framebuffer", framebufferDevice)
+	}
+	return &framebuffer{
+		Addr:               uint64(fix.SmemStart),
+		Pitch:              fix.LineLength,
+		Width:              v.XRes,
+		Height:             v.YRes,
+		BPP:                uint8(v.BitsPerPixel),
+		Type:               framebufferTypeRGB,
+		RedFieldPosition:   uint8(v.Red.Offset),
+		RedMaskSize:        uint8(v.Red.Length),
+		GreenFieldPosition: uint8(v.Green.Offset),
+		GreenMaskSize:      uint8(v.Green.Length),
+		BlueFieldPosition:  uint8(v.Blue.Offset),
+		BlueMaskSize:       uint8(v.Blue.Length),
+	}, nil
+}
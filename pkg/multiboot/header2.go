@@ -0,0 +1,166 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Multiboot2 header as defined in
+// https://www.gnu.org/software/grub/manual/multiboot2/multiboot.html#OS-image-format
+package multiboot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/u-root/u-root/pkg/ubinary"
+)
+
+const (
+	header2Magic = 0xE85250D6
+
+	// header2ArchI386 is the 32-bit protected mode of i386, the only
+	// architecture supported.
+	header2ArchI386 = 0
+)
+
+// Multiboot2 header tag types.
+const (
+	header2TagEnd = iota
+	header2TagInfoRequest
+	header2TagAddress
+	header2TagEntryAddress
+	header2TagConsoleFlags
+	header2TagFramebuffer
+	header2TagModuleAlign
+	header2TagEFIBootServices
+	header2TagEFIi386EntryAddress
+	header2TagEFIamd64EntryAddress
+	header2TagRelocatable
+)
+
+// header2TagOptional marks a tag the kernel can do without.
+const header2TagOptional = 1
+
+// mandatory2 is the fixed part of a Multiboot2 header.
+type mandatory2 struct {
+	Magic        uint32
+	Architecture uint32
+	HeaderLength uint32
+	Checksum     uint32
+}
+
+// tagHeader starts every Multiboot2 header tag.
+type tagHeader struct {
+	Type  uint16
+	Flags uint16
+	Size  uint32
+}
+
+// header2 represents a Multiboot2 header loaded from the file.
+type header2 struct {
+	mandatory2
+
+	// entryAddr overrides the ELF entry point if it is not 0.
+	entryAddr uint32
+
+	// requests are the boot information tag types the kernel cannot
+	// boot without.
+	requests []uint32
+}
+
+// parseHeader2 parses a Multiboot2 header as defined in
+// https://www.gnu.org/software/grub/manual/multiboot2/multiboot.html#Header-layout
+func parseHeader2(r io.Reader) (*header2, error) {
+	mandatorySize := binary.Size(mandatory2{})
+	// The Multiboot2 header must be contained completely within the
+	// first 32768 bytes of the OS image.
+	buf := make([]byte, 32768)
+	n, err := io.ReadAtLeast(r, buf, mandatorySize)
+	if err != nil {
+		return nil, err
+	}
+	buf = buf[:n]
+
+	// The Multiboot2 header must be 64-bit aligned.
+	for off := 0; off+mandatorySize <= len(buf); off += 8 {
+		var hdr header2
+		if err := binary.Read(bytes.NewReader(buf[off:]), ubinary.NativeEndian, &hdr.mandatory2); err != nil {
+			return nil, err
+		}
+		if hdr.Magic != header2Magic || hdr.Magic+hdr.Architecture+hdr.HeaderLength+hdr.Checksum != 0 {
+			continue
+		}
+		if hdr.Architecture != header2ArchI386 {
+			return nil, fmt.Errorf("multiboot2 architecture %d not supported", hdr.Architecture)
+		}
+		if int(hdr.HeaderLength) < mandatorySize || off+int(hdr.HeaderLength) > len(buf) {
+			return nil, fmt.Errorf("multiboot2 header length %d is invalid", hdr.HeaderLength)
+		}
+		if err := hdr.parseTags(buf[off+mandatorySize : off+int(hdr.HeaderLength)]); err != nil {
+			return nil, err
+		}
+		return &hdr, nil
+	}
+	return nil, ErrHeaderNotFound
+}
+
+// parseTags parses the tags following the fixed part of the header.
+func (hdr *header2) parseTags(tags []byte) error {
+	tagSize := binary.Size(tagHeader{})
+	for len(tags) >= tagSize {
+		var tag tagHeader
+		if err := binary.Read(bytes.NewReader(tags), ubinary.NativeEndian, &tag); err != nil {
+			return err
+		}
+		if int(tag.Size) < tagSize || int(tag.Size) > len(tags) {
+			return fmt.Errorf("multiboot2 header tag %d has invalid size %d", tag.Type, tag.Size)
+		}
+		data := tags[tagSize:tag.Size]
+		optional := tag.Flags&header2TagOptional != 0
+
+		switch tag.Type {
+		case header2TagEnd:
+			return nil
+
+		case header2TagInfoRequest:
+			if optional {
+				break
+			}
+			for i := 0; i+4 <= len(data); i += 4 {
+				hdr.requests = append(hdr.requests, ubinary.NativeEndian.Uint32(data[i:]))
+			}
+
+		case header2TagEntryAddress:
+			if len(data) < 4 {
+				return fmt.Errorf("multiboot2 entry address tag is too short")
+			}
+			hdr.entryAddr = ubinary.NativeEndian.Uint32(data)
+
+		case header2TagAddress, header2TagModuleAlign, header2TagRelocatable:
+			// The kernel is loaded from its ELF program headers, and
+			// modules are page-aligned anyway.
+
+		case header2TagConsoleFlags, header2TagFramebuffer:
+			// The console and video mode are left as they are.
+			log.Printf("Ignoring multiboot2 header tag %d", tag.Type)
+
+		case header2TagEFIi386EntryAddress, header2TagEFIamd64EntryAddress:
+			// Only used if EFI boot services are not terminated.
+
+		default:
+			// EFI boot services are already terminated by Linux.
+			if !optional {
+				return fmt.Errorf("multiboot2 header tag %d is not supported: %v", tag.Type, ErrFlagsNotSupported)
+			}
+		}
+
+		// Tags are padded to be 64-bit aligned.
+		next := (int(tag.Size) + 7) &^ 7
+		if next > len(tags) {
+			break
+		}
+		tags = tags[next:]
+	}
+	return fmt.Errorf("multiboot2 header has no end tag")
+}
@@ -0,0 +1,203 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Multiboot2 boot information as defined in
+// https://www.gnu.org/software/grub/manual/multiboot2/multiboot.html#Boot-information-format
+package multiboot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/u-root/u-root/pkg/ubinary"
+)
+
+// Multiboot2 boot information tag types.
+const (
+	info2TagEnd         = 0
+	info2TagCmdLine     = 1
+	info2TagBootLoader  = 2
+	info2TagModule      = 3
+	info2TagMemory      = 4
+	info2TagMemMap      = 6
+	info2TagFramebuffer = 8
+	info2TagEFI32       = 11
+	info2TagEFI64       = 12
+	info2TagACPIOld     = 14
+	info2TagACPINew     = 15
+)
+
+// moduleTag is a module for the Multiboot2 boot information.
+type moduleTag struct {
+	Start   uint32
+	End     uint32
+	CmdLine string
+}
+
+// mmapEntry2 is a Multiboot2 memory map entry.
+type mmapEntry2 struct {
+	BaseAddr uint64
+	Length   uint64
+	Type     uint32
+	Reserved uint32
+}
+
+// info2 represents the Multiboot2 boot information passed to the loaded
+// kernel.
+type info2 struct {
+	CmdLine        string
+	BootLoaderName string
+
+	MemLower uint32
+	MemUpper uint32
+	Mmap     memoryMaps
+
+	Modules []moduleTag
+
+	// RSDP is a copy of the ACPI RSDP, if it was found.
+	RSDP []byte
+
+	// Framebuffer is the current framebuffer, if there is one.
+	Framebuffer *framebuffer
+
+	// EFISystab is the physical address of the EFI system table, if the
+	// machine booted with EFI. EFI64 is set if it is a 64-bit table.
+	EFISystab uint64
+	EFI64     bool
+}
+
+// tagWriter writes 64-bit aligned boot information tags. It keeps the first
+// error and the types of the tags written.
+type tagWriter struct {
+	buf   bytes.Buffer
+	types []uint32
+	err   error
+}
+
+func (w *tagWriter) tag(typ uint32, data ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	var payload bytes.Buffer
+	for _, d := range data {
+		if s, ok := d.(string); ok {
+			payload.WriteString(s)
+			payload.WriteByte(0)
+		} else if w.err = binary.Write(&payload, ubinary.NativeEndian, d); w.err != nil {
+			return
+		}
+	}
+	binary.Write(&w.buf, ubinary.NativeEndian, [2]uint32{typ, uint32(8 + payload.Len())})
+	w.buf.Write(payload.Bytes())
+	w.buf.Write(make([]byte, (w.buf.Len()+7)&^7-w.buf.Len()))
+	w.types = append(w.types, typ)
+}
+
+// marshal writes out the exact bytes of the Multiboot2 boot information
+// expected by the kernel being loaded. It fails if the boot information does
+// not contain all of the requested tag types.
+func (i *info2) marshal(requests []uint32) ([]byte, error) {
+	var w tagWriter
+	// total_size and reserved are filled in below.
+	w.buf.Write(make([]byte, 8))
+
+	w.tag(info2TagCmdLine, i.CmdLine)
+	w.tag(info2TagBootLoader, i.BootLoaderName)
+	w.tag(info2TagMemory, i.MemLower, i.MemUpper)
+	w.tag(info2TagMemMap, uint32(binary.Size(mmapEntry2{})), uint32(0), i.mmap())
+	for _, mod := range i.Modules {
+		w.tag(info2TagModule, mod.Start, mod.End, mod.CmdLine)
+	}
+	if i.Framebuffer != nil {
+		w.tag(info2TagFramebuffer, i.Framebuffer)
+	}
+	if i.EFISystab != 0 {
+		if i.EFI64 {
+			w.tag(info2TagEFI64, i.EFISystab)
+		} else {
+			w.tag(info2TagEFI32, uint32(i.EFISystab))
+		}
+	}
+	// ACPI 2.0 RSDPs have a revision of 2 or higher and are 36 bytes
+	// long; ACPI 1.0 RSDPs are 20 bytes long.
+	switch {
+	case len(i.RSDP) >= 36 && i.RSDP[15] >= 2:
+		w.tag(info2TagACPINew, i.RSDP[:36])
+	case len(i.RSDP) >= 20:
+		w.tag(info2TagACPIOld, i.RSDP[:20])
+	}
+	w.tag(info2TagEnd)
+	if w.err != nil {
+		return nil, w.err
+	}
+
+	for _, r := range requests {
+		if !hasType(w.types, r) {
+			return nil, fmt.Errorf("kernel requires multiboot2 boot information %d, which is not available", r)
+		}
+	}
+
+	b := w.buf.Bytes()
+	ubinary.NativeEndian.PutUint32(b, uint32(len(b)))
+	return b, nil
+}
+
+// infoV1 returns the Multiboot v1 info fields that describe the same boot
+// information as i, marshaled to b at addr. Description reports them.
+func (i *info2) infoV1(addr uint32, b []byte) info {
+	inf := info{
+		Flags:      flagInfoMemory | flagInfoMemMap | flagInfoCmdLine | flagInfoBootLoaderName,
+		MemLower:   i.MemLower,
+		MemUpper:   i.MemUpper,
+		MmapLength: uint32(len(i.Mmap) * binary.Size(mmapEntry2{})),
+	}
+	if len(i.Modules) > 0 {
+		inf.Flags |= flagInfoMods
+		inf.ModsCount = uint32(len(i.Modules))
+	}
+	// The entries follow the tag header, the entry size and the entry
+	// version.
+	if off := tagOffset(b, info2TagMemMap); off >= 0 {
+		inf.MmapAddr = addr + uint32(off) + 16
+	}
+	return inf
+}
+
+// tagOffset returns the offset of the first tag of type typ in the marshaled
+// boot information b, or -1 if there is none.
+func tagOffset(b []byte, typ uint32) int {
+	for off := 8; off+8 <= len(b); {
+		t, size := ubinary.NativeEndian.Uint32(b[off:]), ubinary.NativeEndian.Uint32(b[off+4:])
+		if t == typ {
+			return off
+		}
+		if t == info2TagEnd || size < 8 {
+			break
+		}
+		off += (int(size) + 7) &^ 7
+	}
+	return -1
+}
+
+func hasType(types []uint32, typ uint32) bool {
+	for _, t := range types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *info2) mmap() []mmapEntry2 {
+	entries := make([]mmapEntry2, 0, len(i.Mmap))
+	for _, m := range i.Mmap {
+		entries = append(entries, mmapEntry2{
+			BaseAddr: m.BaseAddr,
+			Length:   m.Length,
+			Type:     m.Type,
+		})
+	}
+	return entries
+}
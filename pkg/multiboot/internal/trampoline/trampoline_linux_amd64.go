@@ -3,7 +3,7 @@
 // license that can be found in the LICENSE file.
 
 // Trampoline sets machine to a specific state defined
-// by multiboot v1 spec and boots the final kernel.
+// by multiboot v1 and v2 specs and boots the final kernel.
 // https://www.gnu.org/software/grub/manual/multiboot/multiboot.html#Machine-state.
 package trampoline
 
@@ -23,6 +23,7 @@ const (
 
 	trampolineEntry = "u-root-entry-long"
 	trampolineInfo  = "u-root-info-long"
+	trampolineMagic = "u-root-magic-long"
 )
 
 var trampolineBegin []byte
@@ -51,14 +52,14 @@ func alignUp(x int) int {
 	return (x + mask) & ^mask
 }
 
-// Setup scans file for trampoline code and sets
-// values for multiboot info address and kernel entry point.
-func Setup(path string, infoAddr, entryPoint uintptr) ([]byte, error) {
+// Setup scans file for trampoline code and sets values for the boot loader
+// magic value, multiboot info address and kernel entry point.
+func Setup(path string, magic uint32, infoAddr, entryPoint uintptr) ([]byte, error) {
 	d, err := extract(path)
 	if err != nil {
 		return nil, err
 	}
-	return patch(d, infoAddr, entryPoint)
+	return patch(d, magic, infoAddr, entryPoint)
 }
 
 // extract extracts trampoline segment from file.
@@ -89,9 +90,11 @@ func extract(path string) ([]byte, error) {
 }
 
 // patch patches the trampoline code to store value for multiboot info address
-// after "u-root-header-long" byte sequence + padding and value
-// for kernel entry point, after "u-root-entry-long" byte sequence + padding.
-func patch(trampoline []byte, infoAddr, entryPoint uintptr) ([]byte, error) {
+// after "u-root-header-long" byte sequence + padding, value
+// for kernel entry point, after "u-root-entry-long" byte sequence + padding,
+// and the boot loader magic value after "u-root-magic-long" byte sequence +
+// padding.
+func patch(trampoline []byte, magic uint32, infoAddr, entryPoint uintptr) ([]byte, error) {
 	replace := func(d, label []byte, val uint32) error {
 		buf := make([]byte, 4)
 		ubinary.NativeEndian.PutUint32(buf, val)
@@ -114,5 +117,8 @@ func patch(trampoline []byte, infoAddr, entryPoint uintptr) ([]byte, error) {
 	if err := replace(trampoline, []byte(trampolineEntry), uint32(entryPoint)); err != nil {
 		return nil, err
 	}
+	if err := replace(trampoline, []byte(trampolineMagic), magic); err != nil {
+		return nil, err
+	}
 	return trampoline, nil
 }
@@ -16,8 +16,6 @@
 #define DATA_SEGMENT	0x00CF92000000FFFF
 #define CODE_SEGMENT	0x00CF9A000000FFFF
 
-#define MAGIC	0x2BADB002
-
 TEXT begin(SB),NOSPLIT,$0
 	// u-root-trampoline-begin
 	BYTE $'u'; BYTE $'-'; BYTE $'r'; BYTE $'o'; BYTE $'o';
@@ -39,6 +37,10 @@ TEXT ·start(SB),NOSPLIT,$0
 	// Don't modify BX.
 	MOVL	info(SB), BX
 
+	// Store the boot loader magic value in SI.
+	// Don't modify SI.
+	MOVL	magic(SB), SI
+
 	// Far return doesn't work on QEMU in 64-bit mode,
 	// let's do far jump.
 	//
@@ -92,7 +94,7 @@ TEXT boot(SB),NOSPLIT,$0
 	BYTE	$0x8e; BYTE $0xe0 // MOVL AX, FS
 	BYTE	$0x8e; BYTE $0xe8 // MOVL AX, GS
 
-	MOVL	$MAGIC, AX
+	MOVL	SI, AX
 	JMP	farjump32(SB)
 
 	// Unreachable code.
@@ -101,6 +103,7 @@ TEXT boot(SB),NOSPLIT,$0
 	JMP	begin(SB)
 	JMP	infotext(SB)
 	JMP	entrytext(SB)
+	JMP	magictext(SB)
 	JMP	end(SB)
 
 TEXT farjump64(SB),NOSPLIT,$0
@@ -139,6 +142,15 @@ TEXT entrytext(SB),NOSPLIT,$0
 TEXT entry(SB),NOSPLIT,$0
 	LONG	$0x0
 
+TEXT magictext(SB),NOSPLIT,$0
+	// u-root-magic-long
+	BYTE $'u'; BYTE $'-'; BYTE $'r'; BYTE $'o'; BYTE $'o';
+	BYTE $'t'; BYTE $'-'; BYTE $'m'; BYTE $'a'; BYTE $'g';
+	BYTE $'i'; BYTE $'c'; BYTE $'-'; BYTE $'l'; BYTE $'o';
+	BYTE $'n'; BYTE $'g';
+TEXT magic(SB),NOSPLIT,$0
+	LONG	$0x0
+
 TEXT end(SB),NOSPLIT,$0
 	// u-root-trampoline-end
 	BYTE $'u'; BYTE $'-'; BYTE $'r'; BYTE $'o'; BYTE $'o';
@@ -35,8 +35,18 @@ type module struct {
 
 type modules []module
 
+// ModulePath returns the path of the file of module, a path followed by the
+// module's command line arguments.
+func ModulePath(module string) (string, error) {
+	f := strings.Fields(module)
+	if len(f) == 0 {
+		return "", fmt.Errorf("module %q has no file name", module)
+	}
+	return f[0], nil
+}
+
 func (m *multiboot) addModules() (uintptr, error) {
-	loaded, data, err := loadModules(m.modules)
+	loaded, data, err := loadModules(m.modules, m.readFile)
 	if err != nil {
 		return 0, err
 	}
@@ -61,7 +71,7 @@ func (m *multiboot) addModules() (uintptr, error) {
 	return modRange.Start, nil
 }
 
-// loadModules loads module files.
+// loadModules loads module files with readFile.
 // Returns loaded modules description and buffer storing loaded modules.
 // Memory layout of the loaded modules is following:
 //			cmdLine_1
@@ -77,7 +87,7 @@ func (m *multiboot) addModules() (uintptr, error) {
 //			modules_n
 //
 // <padding> aligns the start of each module to a page beginning.
-func loadModules(cmds []string) (loaded modules, data []byte, err error) {
+func loadModules(cmds []string, readFile func(string) ([]byte, error)) (loaded modules, data []byte, err error) {
 	loaded = make(modules, len(cmds))
 	buf := bytes.Buffer{}
 
@@ -88,8 +98,11 @@ func loadModules(cmds []string) (loaded modules, data []byte, err error) {
 	}
 
 	for i, cmd := range cmds {
-		name := strings.Fields(cmd)[0]
-		if err := loaded[i].loadModule(&buf, name); err != nil {
+		name, err := ModulePath(cmd)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := loaded[i].loadModule(&buf, name, readFile); err != nil {
 			return nil, nil, fmt.Errorf("error adding module %v: %v", name, err)
 		}
 	}
@@ -105,7 +118,7 @@ func alignUp(buf *bytes.Buffer) error {
 	return err
 }
 
-func (m *module) loadModule(buf *bytes.Buffer, name string) error {
+func (m *module) loadModule(buf *bytes.Buffer, name string, readFile func(string) ([]byte, error)) error {
 	log.Printf("Adding module %v", name)
 
 	b, err := readFile(name)
@@ -3,7 +3,11 @@
 // license that can be found in the LICENSE file.
 
 // Package multiboot implements bootloading multiboot kernels as defined by
-// https://www.gnu.org/software/grub/manual/multiboot/multiboot.html.
+// https://www.gnu.org/software/grub/manual/multiboot/multiboot.html and
+// https://www.gnu.org/software/grub/manual/multiboot2/multiboot.html.
+//
+// Kernels with both a Multiboot and a Multiboot2 header are booted with
+// Multiboot.
 //
 // Package multiboot crafts kexec segments that can be used with the kexec_load
 // system call.
@@ -35,6 +39,9 @@ type multiboot struct {
 	file    string
 	modules []string
 
+	// readFile returns the contents of the kernel and module files.
+	readFile func(name string) ([]byte, error)
+
 	cmdLine    string
 	bootloader string
 
@@ -44,6 +51,9 @@ type multiboot struct {
 	trampoline string
 
 	header header
+	// header2 is the Multiboot2 header, if the kernel has no Multiboot
+	// header.
+	header2 *header2
 
 	// infoAddr is a pointer to multiboot info.
 	infoAddr uintptr
@@ -52,6 +62,8 @@ type multiboot struct {
 	// EntryPoint is a pointer to trampoline.
 	entryPoint uintptr
 
+	// info is the Multiboot info or, for Multiboot2, its equivalent,
+	// which Description reports.
 	info          info
 	loadedModules []module
 }
@@ -97,14 +109,16 @@ func (m memoryMaps) String() string {
 	return strings.Join(s, "\n")
 }
 
-// Probe checks if file is multiboot v1 kernel.
+// Probe checks if file is a multiboot v1 or v2 kernel.
 func Probe(file string) error {
 	b, err := readFile(file)
 	if err != nil {
 		return err
 	}
-	kernel := &kernelReader{buf: b}
-	_, err = parseHeader(kernel)
+	_, err = parseHeader(&kernelReader{buf: b})
+	if err == ErrHeaderNotFound {
+		_, err = parseHeader2(&kernelReader{buf: b})
+	}
 	return err
 }
 
@@ -123,6 +137,7 @@ func newMB(file, cmdLine string, modules []string) (*multiboot, error) {
 	return &multiboot{
 		file:       file,
 		modules:    modules,
+		readFile:   readFile,
 		cmdLine:    cmdLine,
 		trampoline: trampoline,
 		bootloader: bootloader,
@@ -147,6 +162,32 @@ func Load(debug bool, file, cmdline string, modules []string, ibft *ibft.IBFT) e
 	if err != nil {
 		return err
 	}
+	return m.kexecLoad(debug, ibft)
+}
+
+// LoadFiles is like Load, but takes the contents of the kernel and module
+// files from files, keyed by their paths, instead of reading them. The
+// contents may be gzip compressed, like the files Load reads.
+//
+// Callers that verify the files before loading them pass the contents they
+// verified, so that the files cannot change in between.
+func LoadFiles(debug bool, file, cmdline string, modules []string, ibft *ibft.IBFT, files map[string][]byte) error {
+	m, err := newMB(file, cmdline, modules)
+	if err != nil {
+		return err
+	}
+	m.readFile = func(name string) ([]byte, error) {
+		b, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("no contents for %s", name)
+		}
+		return uncompress(b), nil
+	}
+	return m.kexecLoad(debug, ibft)
+}
+
+// kexecLoad loads m and kexec_load's it.
+func (m *multiboot) kexecLoad(debug bool, ibft *ibft.IBFT) error {
 	if err := m.load(debug, ibft); err != nil {
 		return err
 	}
@@ -159,13 +200,18 @@ func Load(debug bool, file, cmdline string, modules []string, ibft *ibft.IBFT) e
 // load loads and parses multiboot information from m.file.
 func (m *multiboot) load(debug bool, ibft *ibft.IBFT) error {
 	log.Printf("Parsing file %v", m.file)
-	b, err := readFile(m.file)
+	b, err := m.readFile(m.file)
 	if err != nil {
 		return err
 	}
 	kernel := kernelReader{buf: b}
 	log.Println("Parsing multiboot header")
-	if m.header, err = parseHeader(&kernel); err != nil {
+	m.header, err = parseHeader(&kernel)
+	if err == ErrHeaderNotFound {
+		log.Println("Parsing multiboot2 header")
+		m.header2, err = parseHeader2(&kernelReader{buf: b})
+	}
+	if err != nil {
 		return fmt.Errorf("error parsing headers: %v", err)
 	}
 
@@ -173,6 +219,9 @@ func (m *multiboot) load(debug bool, ibft *ibft.IBFT) error {
 	if m.kernelEntry, err = getEntryPoint(kernel); err != nil {
 		return fmt.Errorf("error getting kernel entry point: %v", err)
 	}
+	if m.header2 != nil && m.header2.entryAddr != 0 {
+		m.kernelEntry = uintptr(m.header2.entryAddr)
+	}
 	log.Printf("Kernel entry point at %#x", m.kernelEntry)
 
 	log.Printf("Parsing ELF segments")
@@ -204,8 +253,14 @@ func (m *multiboot) load(debug bool, ibft *ibft.IBFT) error {
 		m.mem.Segments.Insert(kexec.NewSegment(ibuf, r))
 	}
 
-	log.Printf("Preparing multiboot info")
-	if m.infoAddr, err = m.addInfo(); err != nil {
+	if m.header2 != nil {
+		log.Printf("Preparing multiboot2 info")
+		m.infoAddr, err = m.addInfo2()
+	} else {
+		log.Printf("Preparing multiboot info")
+		m.infoAddr, err = m.addInfo()
+	}
+	if err != nil {
 		return fmt.Errorf("error preparing multiboot info: %v", err)
 	}
 
@@ -370,7 +425,11 @@ func (m memoryMaps) marshal() ([]byte, error) {
 func (m *multiboot) addTrampoline() (entry uintptr, err error) {
 	// Trampoline setups the machine registers to desired state
 	// and executes the loaded kernel.
-	d, err := trampoline.Setup(m.trampoline, m.infoAddr, m.kernelEntry)
+	magic := uint32(bootloaderMagic)
+	if m.header2 != nil {
+		magic = bootloader2Magic
+	}
+	d, err := trampoline.Setup(m.trampoline, magic, m.infoAddr, m.kernelEntry)
 	if err != nil {
 		return 0, err
 	}
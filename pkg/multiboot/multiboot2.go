@@ -0,0 +1,114 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package multiboot
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	"github.com/u-root/u-root/pkg/acpi"
+)
+
+// Values of EAX the loaded kernel is entered with.
+const (
+	bootloaderMagic  = 0x2BADB002
+	bootloader2Magic = 0x36D76289
+)
+
+var bootParamsPath = "/sys/kernel/boot_params/data"
+
+// Offsets of the efi_info fields in the x86 boot_params.
+const (
+	efiLoaderSignature = 0x1c0
+	efiSystab          = 0x1c4
+	efiSystabHi        = 0x1d8
+)
+
+// currentEFISystab returns the physical address of the EFI system table the
+// running kernel was booted with, and whether the firmware is 64-bit.
+func currentEFISystab() (uint64, bool, error) {
+	b, err := ioutil.ReadFile(bootParamsPath)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(b) < efiSystabHi+4 {
+		return 0, false, fmt.Errorf("%s is too short", bootParamsPath)
+	}
+	lo := uint64(binary.LittleEndian.Uint32(b[efiSystab:]))
+	hi := uint64(binary.LittleEndian.Uint32(b[efiSystabHi:]))
+	switch string(b[efiLoaderSignature : efiLoaderSignature+4]) {
+	case "EL64":
+		return hi<<32 | lo, true, nil
+	case "EL32":
+		return lo, false, nil
+	}
+	return 0, false, fmt.Errorf("not booted with EFI")
+}
+
+// newMultiboot2Info returns the Multiboot2 boot information, adding the
+// memory map entries and modules it refers to to m.mem.
+func (m *multiboot) newMultiboot2Info() (*info2, error) {
+	lower, upper := m.memoryBoundaries()
+	inf := &info2{
+		CmdLine:        m.cmdLine,
+		BootLoaderName: m.bootloader,
+		MemLower:       lower >> 10,
+		MemUpper:       upper >> 10,
+		Mmap:           m.memoryMap(),
+	}
+	log.Printf("Memory map:\n%s", inf.Mmap)
+
+	if len(m.modules) > 0 {
+		loaded, data, err := loadModules(m.modules, m.readFile)
+		if err != nil {
+			return nil, err
+		}
+		r, err := m.mem.AddKexecSegment(data)
+		if err != nil {
+			return nil, err
+		}
+		loaded.fix(uint32(r.Start))
+		m.loadedModules = loaded
+		for i, mod := range loaded {
+			inf.Modules = append(inf.Modules, moduleTag{
+				Start:   mod.Start,
+				End:     mod.End,
+				CmdLine: m.modules[i],
+			})
+		}
+	}
+
+	if _, rsdp, err := acpi.GetRSDP(); err == nil {
+		inf.RSDP = rsdp.AllData()
+	}
+	if fb, err := currentFramebuffer(); err == nil {
+		inf.Framebuffer = fb
+	} else {
+		log.Printf("Not passing a framebuffer: %v", err)
+	}
+	if addr, is64, err := currentEFISystab(); err == nil {
+		inf.EFISystab, inf.EFI64 = addr, is64
+	}
+	return inf, nil
+}
+
+func (m *multiboot) addInfo2() (addr uintptr, err error) {
+	inf, err := m.newMultiboot2Info()
+	if err != nil {
+		return 0, err
+	}
+	d, err := inf.marshal(m.header2.requests)
+	if err != nil {
+		return 0, err
+	}
+	r, err := m.mem.AddKexecSegment(d)
+	if err != nil {
+		return 0, err
+	}
+	m.info = inf.infoV1(uint32(r.Start), d)
+	return r.Start, nil
+}
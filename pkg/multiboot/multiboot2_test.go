@@ -0,0 +1,208 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package multiboot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// header2Tag is a Multiboot2 header tag for tests.
+type header2Tag struct {
+	typ, flags uint16
+	data       []uint32
+}
+
+// createHeader2 returns a Multiboot2 header with tags and an end tag.
+func createHeader2(arch uint32, tags ...header2Tag) []byte {
+	var b bytes.Buffer
+	for _, t := range append(tags, header2Tag{typ: header2TagEnd}) {
+		binary.Write(&b, binary.LittleEndian, tagHeader{Type: t.typ, Flags: t.flags, Size: uint32(8 + 4*len(t.data))})
+		binary.Write(&b, binary.LittleEndian, t.data)
+		b.Write(make([]byte, (b.Len()+7)&^7-b.Len()))
+	}
+	length := uint32(16 + b.Len())
+	h := mandatory2{
+		Magic:        header2Magic,
+		Architecture: arch,
+		HeaderLength: length,
+		Checksum:     -(header2Magic + arch + length),
+	}
+	var hdr bytes.Buffer
+	binary.Write(&hdr, binary.LittleEndian, h)
+	return append(hdr.Bytes(), b.Bytes()...)
+}
+
+func TestParseHeader2(t *testing.T) {
+	entry := header2Tag{typ: header2TagEntryAddress, data: []uint32{0x100000}}
+	for _, tt := range []struct {
+		name   string
+		hdr    []byte
+		offset int
+		want   *header2
+		err    string
+	}{
+		{
+			name: "entry address",
+			hdr:  createHeader2(header2ArchI386, entry),
+			want: &header2{entryAddr: 0x100000},
+		},
+		{
+			name:   "aligned",
+			hdr:    createHeader2(header2ArchI386, entry),
+			offset: 32768 - 64,
+			want:   &header2{entryAddr: 0x100000},
+		},
+		{
+			name: "requests",
+			hdr: createHeader2(header2ArchI386,
+				header2Tag{typ: header2TagInfoRequest, data: []uint32{info2TagCmdLine, info2TagMemMap}},
+				header2Tag{typ: header2TagInfoRequest, flags: header2TagOptional, data: []uint32{info2TagEFI64}},
+			),
+			want: &header2{requests: []uint32{info2TagCmdLine, info2TagMemMap}},
+		},
+		{
+			name: "optional EFI boot services",
+			hdr: createHeader2(header2ArchI386,
+				header2Tag{typ: header2TagModuleAlign},
+				header2Tag{typ: header2TagEFIBootServices, flags: header2TagOptional},
+			),
+			want: &header2{},
+		},
+		{
+			name: "EFI boot services",
+			hdr:  createHeader2(header2ArchI386, header2Tag{typ: header2TagEFIBootServices}),
+			err:  "tag 7 is not supported",
+		},
+		{
+			name: "MIPS",
+			hdr:  createHeader2(4),
+			err:  "architecture 4 not supported",
+		},
+		{
+			name:   "misaligned",
+			hdr:    createHeader2(header2ArchI386),
+			offset: 4,
+			err:    ErrHeaderNotFound.Error(),
+		},
+		{
+			name:   "too far",
+			hdr:    createHeader2(header2ArchI386),
+			offset: 32768,
+			err:    ErrHeaderNotFound.Error(),
+		},
+		{
+			name: "v1 header",
+			hdr: func() []byte {
+				var b bytes.Buffer
+				binary.Write(&b, binary.LittleEndian, createHeader(flagGood))
+				return b.Bytes()
+			}(),
+			err: ErrHeaderNotFound.Error(),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			kernel := make([]byte, tt.offset+len(tt.hdr)+1024)
+			copy(kernel[tt.offset:], tt.hdr)
+			got, err := parseHeader2(bytes.NewReader(kernel))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("parseHeader2() = %v, want error containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseHeader2() = %v", err)
+			}
+			got.mandatory2 = mandatory2{}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseHeader2() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInfo2Marshal(t *testing.T) {
+	rsdp := append([]byte("RSD PTR \x00OEMID \x02"), make([]byte, 20)...)
+	inf := &info2{
+		CmdLine:        "console=ttyS0",
+		BootLoaderName: bootloader,
+		MemLower:       639,
+		MemUpper:       0x7fb00,
+		Mmap: memoryMaps{
+			{Size: 20, BaseAddr: 0, Length: 0x9fc00, Type: 1},
+			{Size: 20, BaseAddr: 0x100000, Length: 0x7ff00000, Type: 1},
+		},
+		Modules: []moduleTag{
+			{Start: 0x200000, End: 0x201000, CmdLine: "xen.gz dom0_mem=1G"},
+		},
+		RSDP:      rsdp,
+		EFISystab: 0x7fbee018,
+		EFI64:     true,
+	}
+	b, err := inf.marshal([]uint32{info2TagMemMap, info2TagACPINew})
+	if err != nil {
+		t.Fatalf("marshal() = %v", err)
+	}
+	le := binary.LittleEndian
+	if size := le.Uint32(b); int(size) != len(b) {
+		t.Fatalf("total_size = %d, want %d", size, len(b))
+	}
+
+	tags := map[uint32][]byte{}
+	var types []uint32
+	for off := 8; off < len(b); {
+		typ, size := le.Uint32(b[off:]), le.Uint32(b[off+4:])
+		tags[typ] = b[off+8 : off+int(size)]
+		types = append(types, typ)
+		off += (int(size) + 7) &^ 7
+	}
+	wantTypes := []uint32{info2TagCmdLine, info2TagBootLoader, info2TagMemory, info2TagMemMap, info2TagModule, info2TagEFI64, info2TagACPINew, info2TagEnd}
+	if !reflect.DeepEqual(types, wantTypes) {
+		t.Fatalf("tag types = %v, want %v", types, wantTypes)
+	}
+
+	for _, tt := range []struct {
+		typ  uint32
+		want []byte
+	}{
+		{info2TagCmdLine, []byte("console=ttyS0\x00")},
+		{info2TagModule, append([]byte{0, 0, 0x20, 0, 0, 0x10, 0x20, 0}, "xen.gz dom0_mem=1G\x00"...)},
+		{info2TagEFI64, []byte{0x18, 0xe0, 0xbe, 0x7f, 0, 0, 0, 0}},
+		{info2TagACPINew, rsdp},
+		{info2TagEnd, []byte{}},
+	} {
+		if got := tags[tt.typ]; !bytes.Equal(got, tt.want) {
+			t.Errorf("tag %d = %q, want %q", tt.typ, got, tt.want)
+		}
+	}
+
+	mmap := tags[info2TagMemMap]
+	if entrySize := le.Uint32(mmap); entrySize != 24 || len(mmap) != 8+2*24 {
+		t.Fatalf("memory map tag has %d bytes with entry size %d, want 2 entries of 24", len(mmap), entrySize)
+	}
+	if base, length, typ := le.Uint64(mmap[32:]), le.Uint64(mmap[40:]), le.Uint32(mmap[48:]); base != 0x100000 || length != 0x7ff00000 || typ != 1 {
+		t.Errorf("memory map entry 1 = (%#x, %#x, %d), want (0x100000, 0x7ff00000, 1)", base, length, typ)
+	}
+
+	// The description reports the memory map in b at 0x1000.
+	v1 := inf.infoV1(0x1000, b)
+	if want := flagInfoMemory | flagInfoMemMap | flagInfoCmdLine | flagInfoBootLoaderName | flagInfoMods; v1.Flags != want {
+		t.Errorf("infoV1() flags = %#x, want %#x", v1.Flags, want)
+	}
+	if v1.MemLower != 639 || v1.MemUpper != 0x7fb00 || v1.ModsCount != 1 || v1.MmapLength != 2*24 {
+		t.Errorf("infoV1() = %+v, want the memory, one module and 2 memory map entries", v1)
+	}
+	if off := int(v1.MmapAddr) - 0x1000; off < 0 || off+int(v1.MmapLength) > len(b) || le.Uint64(b[off+24:]) != 0x100000 {
+		t.Errorf("infoV1() memory map address %#x does not point to the memory map", v1.MmapAddr)
+	}
+
+	if _, err := inf.marshal([]uint32{info2TagFramebuffer}); err == nil {
+		t.Errorf("marshal() without framebuffer = nil, want error for requested framebuffer")
+	}
+}
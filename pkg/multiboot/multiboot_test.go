@@ -118,3 +118,21 @@ func TestParseHeader(t *testing.T) {
 		})
 	}
 }
+
+func TestModulePath(t *testing.T) {
+	for _, tt := range []struct {
+		module  string
+		want    string
+		wantErr bool
+	}{
+		{module: "/boot/mod arg1 arg2", want: "/boot/mod"},
+		{module: "  /boot/mod", want: "/boot/mod"},
+		{module: "", wantErr: true},
+		{module: " \t ", wantErr: true},
+	} {
+		got, err := ModulePath(tt.module)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ModulePath(%q) = %q, %v, want %q, error %t", tt.module, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -5,6 +5,7 @@
 package multiboot
 
 import (
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io"
@@ -44,6 +45,15 @@ func readGzip(r io.Reader) ([]byte, error) {
 	return ioutil.ReadAll(z)
 }
 
+// uncompress returns b, gunzipped if it is gzip compressed, as readFile
+// reads files.
+func uncompress(b []byte) []byte {
+	if u, err := readGzip(bytes.NewReader(b)); err == nil {
+		return u
+	}
+	return b
+}
+
 func readFile(name string) ([]byte, error) {
 	f, err := os.Open(name)
 	if err != nil {
@@ -51,6 +51,10 @@ func (r *RTC) Read() (time.Time, error) {
 		time.UTC), nil
 }
 
+func (r *RTC) Close() error {
+	return r.file.Close()
+}
+
 func (r *RTC) Set(tu time.Time) error {
 	rt := unix.RTCTime{Sec: int32(tu.Second()),
 		Min:   int32(tu.Minute()),
@@ -6,6 +6,7 @@ package storage
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -26,9 +27,10 @@ var (
 
 // BlockDev maps a device name to a BlockStat structure for a given block device
 type BlockDev struct {
-	Name   string
-	Stat   BlockStat
-	FsUUID string
+	Name    string
+	Stat    BlockStat
+	FsUUID  string
+	FsLabel string
 }
 
 // Summary prints a multiline summary of the BlockDev object
@@ -173,7 +175,7 @@ func GetBlockStats() ([]BlockDev, error) {
 		}
 		devpath := path.Join("/dev/", devname)
 		uuid := getUUID(devpath)
-		blockdevs = append(blockdevs, BlockDev{Name: devname, Stat: *bstat, FsUUID: uuid})
+		blockdevs = append(blockdevs, BlockDev{Name: devname, Stat: *bstat, FsUUID: uuid, FsLabel: getLabel(devpath)})
 	}
 	return blockdevs, nil
 }
@@ -203,6 +205,8 @@ const (
 	EXT2SprblkMagic     = '\uEF53' // fixed value
 	EXT2SprblkUUIDOff   = 104      // Offset of UUID in superblock
 	EXT2SprblkUUIDSize  = 16
+	EXT2SprblkLabelOff  = 120 // Offset of volume name in superblock
+	EXT2SprblkLabelSize = 16
 )
 
 func tryEXT4(devname string) (uuid string) {
@@ -261,6 +265,8 @@ const (
 	FAT32Magic     = "FAT32   " // fixed value
 	FAT32IDOff     = 67         // Offset of filesystem-ID / serielnumber. Treated as short filesystem UUID
 	FAT32IDSize    = 4
+	FAT32LabelOff  = 71 // Offset of volume label, padded with spaces
+	FAT32LabelSize = 11
 )
 
 func tryVFAT(devname string) (uuid string) {
@@ -311,6 +317,41 @@ func tryVFAT(devname string) (uuid string) {
 	return uuid
 }
 
+// getLabel returns the label of the ext2/3/4 or FAT32 file system on
+// devpath, or "" if it has none.
+func getLabel(devpath string) string {
+	file, err := os.Open(devpath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	b := make([]byte, EXT2SprblkMagicSize)
+	if _, err := file.ReadAt(b, EXT2SprblkOff+EXT2SprblkMagicOff); err == nil && uint16(b[1])<<8+uint16(b[0]) == EXT2SprblkMagic {
+		b = make([]byte, EXT2SprblkLabelSize)
+		if _, err := file.ReadAt(b, EXT2SprblkOff+EXT2SprblkLabelOff); err != nil {
+			return ""
+		}
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+		return string(b)
+	}
+
+	b = make([]byte, FAT32MagicSize)
+	if _, err := file.ReadAt(b, FAT32MagicOff); err == nil && string(b) == FAT32Magic {
+		b = make([]byte, FAT32LabelSize)
+		if _, err := file.ReadAt(b, FAT32LabelOff); err != nil {
+			return ""
+		}
+		// Formatting tools write NO NAME for no label.
+		if label := strings.TrimRight(string(b), " "); label != "NO NAME" {
+			return label
+		}
+	}
+	return ""
+}
+
 // GetGPTTable tries to read a GPT table from the block device described by the
 // passed BlockDev object, and returns a gpt.Table object, or an error if any
 func GetGPTTable(device BlockDev) (*gpt.Table, error) {
@@ -5,6 +5,8 @@
 package storage
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -51,3 +53,37 @@ func TestBlockStatFromBytesNotEnoughFields(t *testing.T) {
 	_, err := BlockStatFromBytes(input)
 	require.Error(t, err)
 }
+
+func TestGetLabel(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		image func(b []byte)
+		want  string
+	}{
+		{"ext4", func(b []byte) {
+			copy(b[EXT2SprblkOff+EXT2SprblkMagicOff:], []byte{0x53, 0xef})
+			copy(b[EXT2SprblkOff+EXT2SprblkLabelOff:], "rootfs")
+		}, "rootfs"},
+		{"vfat", func(b []byte) {
+			copy(b[FAT32MagicOff:], FAT32Magic)
+			copy(b[FAT32LabelOff:], "EFI        ")
+		}, "EFI"},
+		{"vfat without label", func(b []byte) {
+			copy(b[FAT32MagicOff:], FAT32Magic)
+			copy(b[FAT32LabelOff:], "NO NAME    ")
+		}, ""},
+		{"unknown", func([]byte) {}, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "storage")
+			require.NoError(t, err)
+			defer os.Remove(f.Name())
+			b := make([]byte, 4096)
+			tt.image(b)
+			_, err = f.Write(b)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+			require.Equal(t, tt.want, getLabel(f.Name()))
+		})
+	}
+}
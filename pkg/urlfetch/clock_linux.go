@@ -0,0 +1,47 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package urlfetch
+
+import (
+	"time"
+
+	"github.com/u-root/u-root/pkg/rtc"
+	"golang.org/x/sys/unix"
+)
+
+// timeError is the clock state adjtimex returns while the clock is not
+// synchronized.
+const timeError = 5
+
+// minTime is earlier than any time a clock that is set can say: it is
+// about when this was written.
+var minTime = time.Date(2019, time.August, 1, 0, 0, 0, 0, time.UTC)
+
+// Now returns the time at which certificates must be valid.
+//
+// Machines that netboot often have no NTP yet, and an RTC that was never
+// set or lost its battery. Now returns the system time if NTP synchronized
+// it or it is later than minTime, else the RTC time if that is later than
+// minTime, else minTime. With an unset clock, certificates that expired
+// before minTime are still refused.
+func Now() time.Time {
+	t := time.Now()
+	if synchronized() || t.After(minTime) {
+		return t
+	}
+	if r, err := rtc.OpenRTC(); err == nil {
+		defer r.Close()
+		if t, err := r.Read(); err == nil && t.After(minTime) {
+			return t
+		}
+	}
+	return minTime
+}
+
+// synchronized returns whether the kernel says NTP synchronized the clock.
+func synchronized() bool {
+	state, err := unix.Adjtimex(&unix.Timex{})
+	return err == nil && state != timeError
+}
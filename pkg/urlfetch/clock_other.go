@@ -0,0 +1,14 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package urlfetch
+
+import "time"
+
+// Now returns the time at which certificates must be valid.
+func Now() time.Time {
+	return time.Now()
+}
@@ -0,0 +1,211 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package urlfetch
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// The ASN.1 structures of OCSP responses, as in RFC 6960, Section 4.2.1.
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response responseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type responseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type basicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type responseData struct {
+	Raw            asn1.RawContent
+	Version        int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID asn1.RawValue
+	ProducedAt     time.Time `asn1:"generalized"`
+	Responses      []singleResponse
+	Extensions     []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type singleResponse struct {
+	CertID           certID
+	Good             asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked          revokedInfo      `asn1:"tag:1,optional"`
+	Unknown          asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate       time.Time        `asn1:"generalized"`
+	NextUpdate       time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	SingleExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type revokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+type certID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type subjectPublicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+var oidBasicResponse = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+// ocspHashes are the hashes that CertIDs may use.
+var ocspHashes = map[string]crypto.Hash{
+	"1.3.14.3.2.26":          crypto.SHA1,
+	"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+	"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+	"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+}
+
+// signatureAlgorithms are the algorithms that responses may be signed
+// with.
+var signatureAlgorithms = map[string]x509.SignatureAlgorithm{
+	"1.2.840.113549.1.1.5":  x509.SHA1WithRSA,
+	"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+	"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+	"1.2.840.113549.1.1.13": x509.SHA512WithRSA,
+	"1.2.840.10045.4.1":     x509.ECDSAWithSHA1,
+	"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+	"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+	"1.2.840.10045.4.3.4":   x509.ECDSAWithSHA512,
+}
+
+// checkOCSP checks that der is an OCSP response signed by issuer, or by a
+// responder issuer delegated to, that says cert is good at now.
+func checkOCSP(der []byte, cert, issuer *x509.Certificate, now time.Time) error {
+	var resp ocspResponse
+	if rest, err := asn1.Unmarshal(der, &resp); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return errors.New("trailing data after response")
+	}
+	if resp.Status != 0 {
+		return fmt.Errorf("responder returned status %d", resp.Status)
+	}
+	if !resp.Response.ResponseType.Equal(oidBasicResponse) {
+		return fmt.Errorf("unsupported response type %v", resp.Response.ResponseType)
+	}
+
+	var basic basicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return err
+	}
+	var data responseData
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &data); err != nil {
+		return err
+	}
+
+	signer, err := ocspSigner(basic.Certificates, issuer, now)
+	if err != nil {
+		return err
+	}
+	algo, ok := signatureAlgorithms[basic.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return fmt.Errorf("unsupported signature algorithm %v", basic.SignatureAlgorithm.Algorithm)
+	}
+	if err := signer.CheckSignature(algo, basic.TBSResponseData.FullBytes, basic.Signature.RightAlign()); err != nil {
+		return fmt.Errorf("bad signature: %v", err)
+	}
+
+	for _, r := range data.Responses {
+		match, err := r.CertID.matches(cert, issuer)
+		if err != nil {
+			return err
+		}
+		if !match {
+			continue
+		}
+		if r.Unknown {
+			return errors.New("certificate is unknown to the responder")
+		}
+		if !r.Good {
+			return fmt.Errorf("certificate was revoked at %v", r.Revoked.RevocationTime)
+		}
+		if now.Before(r.ThisUpdate) {
+			return fmt.Errorf("response is not valid before %v", r.ThisUpdate)
+		}
+		if !r.NextUpdate.IsZero() && now.After(r.NextUpdate) {
+			return fmt.Errorf("response expired at %v", r.NextUpdate)
+		}
+		return nil
+	}
+	return errors.New("response is not about the server's certificate")
+}
+
+// ocspSigner returns the certificate that must have signed a response: the
+// issuer itself, or the responder certificate of certs that the issuer
+// delegated to.
+func ocspSigner(certs []asn1.RawValue, issuer *x509.Certificate, now time.Time) (*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return issuer, nil
+	}
+	responder, err := x509.ParseCertificate(certs[0].FullBytes)
+	if err != nil {
+		return nil, fmt.Errorf("responder certificate: %v", err)
+	}
+	if bytes.Equal(responder.Raw, issuer.Raw) {
+		return issuer, nil
+	}
+	if err := responder.CheckSignatureFrom(issuer); err != nil {
+		return nil, fmt.Errorf("responder certificate is not signed by the issuer: %v", err)
+	}
+	delegated := false
+	for _, u := range responder.ExtKeyUsage {
+		if u == x509.ExtKeyUsageOCSPSigning {
+			delegated = true
+		}
+	}
+	if !delegated {
+		return nil, errors.New("responder certificate is not for OCSP signing")
+	}
+	if now.Before(responder.NotBefore) || now.After(responder.NotAfter) {
+		return nil, errors.New("responder certificate is not valid now")
+	}
+	return responder, nil
+}
+
+// matches returns whether id identifies cert issued by issuer.
+func (id certID) matches(cert, issuer *x509.Certificate) (bool, error) {
+	hash, ok := ocspHashes[id.HashAlgorithm.Algorithm.String()]
+	if !ok || !hash.Available() {
+		return false, fmt.Errorf("unsupported hash %v", id.HashAlgorithm.Algorithm)
+	}
+	if id.SerialNumber == nil || id.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		return false, nil
+	}
+
+	var spki subjectPublicKeyInfo
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return false, err
+	}
+	h := hash.New()
+	h.Write(issuer.RawSubject)
+	nameHash := h.Sum(nil)
+	h.Reset()
+	h.Write(spki.PublicKey.RightAlign())
+	keyHash := h.Sum(nil)
+	return bytes.Equal(id.NameHash, nameHash) && bytes.Equal(id.IssuerKeyHash, keyHash), nil
+}
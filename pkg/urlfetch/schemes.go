@@ -4,8 +4,9 @@
 
 // Package urlfetch implements routines to fetch files given a URL.
 //
-// urlfetch currently supports HTTP, TFTP, local files, and a retrying HTTP
-// client.
+// urlfetch currently supports HTTP, TFTP, local files, a retrying HTTP
+// client, and HTTPS with client certificates, pinned public keys and OCSP
+// stapling.
 package urlfetch
 
 import (
@@ -47,8 +48,8 @@ type FileScheme interface {
 var (
 	// DefaultHTTPClient is the default HTTP FileScheme.
 	//
-	// It is not recommended to use this for HTTPS. We recommend creating an
-	// http.Client that accepts only a private pool of certificates.
+	// It is not recommended to use this for HTTPS. We recommend using
+	// NewHTTPSClient with a private pool of certificates.
 	DefaultHTTPClient = NewHTTPClient(http.DefaultClient)
 
 	// DefaultTFTPClient is the default TFTP FileScheme.
@@ -0,0 +1,187 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package urlfetch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// TLSConfig configures an HTTPS FileScheme.
+type TLSConfig struct {
+	// CAFiles are PEM files of the CA certificates that servers must
+	// chain to. If there are none, the system's CAs are used.
+	CAFiles []string
+
+	// SystemCAs, if true, trusts the system's CAs in addition to those
+	// of CAFiles.
+	SystemCAs bool
+
+	// CertFile and KeyFile are PEM files of the client certificate and
+	// its key, which are sent to servers that ask for one.
+	CertFile string
+	KeyFile  string
+
+	// Pins are base64-encoded SHA-256 hashes of DER-encoded
+	// SubjectPublicKeyInfos, as in HPKP. If there are any, some
+	// certificate of the server's verified chain must have one of these
+	// public keys.
+	Pins []string
+
+	// RequireOCSP, if true, refuses servers that do not staple a valid
+	// OCSP response saying their certificate is good.
+	RequireOCSP bool
+
+	// Now returns the time at which certificates and OCSP responses
+	// must be valid. If it is nil, the package's Now is used, which
+	// copes with unset clocks.
+	Now func() time.Time
+}
+
+// NewHTTPSClient returns a new HTTPS FileScheme that verifies servers as
+// c says.
+func NewHTTPSClient(c *TLSConfig) (*HTTPClient, error) {
+	t, err := c.Transport()
+	if err != nil {
+		return nil, err
+	}
+	return NewHTTPClient(&http.Client{Transport: t}), nil
+}
+
+// Transport returns an http.Transport that verifies servers as c says.
+//
+// It does not use proxies, as the checks of c are done on connections the
+// transport dials itself.
+func (c *TLSConfig) Transport() (*http.Transport, error) {
+	config, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	pins, err := parsePins(c.Pins)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Transport{
+		DialTLS: func(network, addr string) (net.Conn, error) {
+			conn, err := tls.DialWithDialer(dialer, network, addr, config)
+			if err != nil {
+				return nil, err
+			}
+			if err := c.verify(conn.ConnectionState(), pins, config.Time()); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("%s: %v", addr, err)
+			}
+			return conn, nil
+		},
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+	}, nil
+}
+
+func (c *TLSConfig) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		Time: c.Now,
+	}
+	if config.Time == nil {
+		config.Time = Now
+	}
+
+	if len(c.CAFiles) > 0 {
+		pool := x509.NewCertPool()
+		if c.SystemCAs {
+			sys, err := x509.SystemCertPool()
+			if err != nil {
+				return nil, fmt.Errorf("cannot load system CAs: %v", err)
+			}
+			pool = sys
+		}
+		for _, f := range c.CAFiles {
+			pem, err := ioutil.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no CA certificates in %s", f)
+			}
+		}
+		config.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// parsePins decodes the base64 of pins.
+func parsePins(pins []string) ([][]byte, error) {
+	var p [][]byte
+	for _, s := range pins {
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("pin %q is not a base64-encoded SHA-256 hash", s)
+		}
+		p = append(p, b)
+	}
+	return p, nil
+}
+
+// verify does the checks of c that crypto/tls does not do on a connection
+// whose certificates crypto/tls verified.
+func (c *TLSConfig) verify(cs tls.ConnectionState, pins [][]byte, now time.Time) error {
+	if len(cs.VerifiedChains) == 0 {
+		return errors.New("server certificate was not verified")
+	}
+	if len(pins) > 0 && !pinned(cs.VerifiedChains, pins) {
+		return errors.New("no public key of the server's certificate chain is pinned")
+	}
+	if c.RequireOCSP {
+		chain := cs.VerifiedChains[0]
+		leaf, issuer := chain[0], chain[0]
+		if len(chain) > 1 {
+			issuer = chain[1]
+		}
+		if cs.OCSPResponse == nil {
+			return errors.New("server did not staple an OCSP response")
+		}
+		if err := checkOCSP(cs.OCSPResponse, leaf, issuer, now); err != nil {
+			return fmt.Errorf("stapled OCSP response: %v", err)
+		}
+	}
+	return nil
+}
+
+// pinned returns whether some certificate of chains has one of the public
+// keys of pins.
+func pinned(chains [][]*x509.Certificate, pins [][]byte) bool {
+	for _, chain := range chains {
+		for _, cert := range chain {
+			h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, p := range pins {
+				if bytes.Equal(h[:], p) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
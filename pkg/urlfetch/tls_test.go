@@ -0,0 +1,362 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package urlfetch
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/u-root/u-root/pkg/uio"
+)
+
+var (
+	testNow    = time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC)
+	testSerial = int64(1)
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert returns a certificate made from tmpl that parent signed, or
+// a self-signed one if parent is nil.
+func newTestCert(t *testing.T, tmpl *x509.Certificate, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSerial++
+	tmpl.SerialNumber = big.NewInt(testSerial)
+	tmpl.NotBefore = time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	tmpl.NotAfter = time.Date(2040, time.January, 1, 0, 0, 0, 0, time.UTC)
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, key.Public(), signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func newTestCA(t *testing.T, name string) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+}
+
+// writePEM writes the certificate and key of c to dir, and returns their
+// paths.
+func (c *testCert) writePEM(t *testing.T, dir string) (string, string) {
+	name := filepath.Join(dir, c.cert.Subject.CommonName)
+	if err := ioutil.WriteFile(name+".crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name+".key", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return name + ".crt", name + ".key"
+}
+
+func (c *testCert) pin() string {
+	h := sha256.Sum256(c.cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// staple returns an OCSP response about cert, issued by issuer, that
+// signer signed.
+func staple(t *testing.T, cert, issuer, signer *testCert, r singleResponse) []byte {
+	var spki subjectPublicKeyInfo
+	if _, err := asn1.Unmarshal(issuer.cert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		t.Fatal(err)
+	}
+	nameHash := sha256.Sum256(issuer.cert.RawSubject)
+	keyHash := sha256.Sum256(spki.PublicKey.RightAlign())
+	r.CertID = certID{
+		HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}, Parameters: asn1.NullRawValue},
+		NameHash:      nameHash[:],
+		IssuerKeyHash: keyHash[:],
+		SerialNumber:  cert.cert.SerialNumber,
+	}
+	signerHash := sha256.Sum256(signer.cert.RawSubjectPublicKeyInfo)
+	responderID, err := asn1.Marshal(signerHash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbs, err := asn1.Marshal(responseData{
+		RawResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: responderID},
+		ProducedAt:     r.ThisUpdate,
+		Responses:      []singleResponse{r},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(tbs)
+	sig, err := signer.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	basic := basicResponse{
+		TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		Signature:          asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)},
+	}
+	if signer != issuer {
+		basic.Certificates = []asn1.RawValue{{FullBytes: signer.cert.Raw}}
+	}
+	b, err := asn1.Marshal(basic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(ocspResponse{
+		Response: responseBytes{ResponseType: oidBasicResponse, Response: b},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestHTTPSClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "urlfetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, "ca")
+	otherCA := newTestCA(t, "otherca")
+	server := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	client := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "client"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, otherCA)
+	responder := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "responder"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+	}, ca)
+	notResponder := newTestCert(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "notresponder"},
+	}, ca)
+
+	caFile, _ := ca.writePEM(t, dir)
+	otherCAFile, _ := otherCA.writePEM(t, dir)
+	clientCert, clientKey := client.writePEM(t, dir)
+
+	good := singleResponse{
+		Good:       true,
+		ThisUpdate: testNow.Add(-time.Hour),
+		NextUpdate: testNow.Add(time.Hour),
+	}
+	revoked := singleResponse{
+		Revoked:    revokedInfo{RevocationTime: testNow.Add(-2 * time.Hour)},
+		ThisUpdate: testNow.Add(-time.Hour),
+		NextUpdate: testNow.Add(time.Hour),
+	}
+	expired := singleResponse{
+		Good:       true,
+		ThisUpdate: testNow.Add(-2 * time.Hour),
+		NextUpdate: testNow.Add(-time.Hour),
+	}
+
+	for _, tt := range []struct {
+		name       string
+		config     TLSConfig
+		staple     []byte
+		clientAuth bool
+		err        string
+	}{
+		{
+			name:   "trusted CA",
+			config: TLSConfig{CAFiles: []string{caFile}},
+		},
+		{
+			name:   "untrusted CA",
+			config: TLSConfig{CAFiles: []string{otherCAFile}},
+			err:    "certificate signed by unknown authority",
+		},
+		{
+			name: "clock before the certificate",
+			config: TLSConfig{
+				CAFiles: []string{caFile},
+				Now:     func() time.Time { return time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC) },
+			},
+			err: "certificate has expired or is not yet valid",
+		},
+		{
+			name:   "pinned CA",
+			config: TLSConfig{CAFiles: []string{caFile}, Pins: []string{otherCA.pin(), ca.pin()}},
+		},
+		{
+			name:   "pinned server",
+			config: TLSConfig{CAFiles: []string{caFile}, Pins: []string{server.pin()}},
+		},
+		{
+			name:   "not pinned",
+			config: TLSConfig{CAFiles: []string{caFile}, Pins: []string{otherCA.pin()}},
+			err:    "no public key of the server's certificate chain is pinned",
+		},
+		{
+			name:   "invalid pin",
+			config: TLSConfig{CAFiles: []string{caFile}, Pins: []string{"c2hhMQ=="}},
+			err:    `pin "c2hhMQ==" is not a base64-encoded SHA-256 hash`,
+		},
+		{
+			name: "client certificate",
+			config: TLSConfig{
+				CAFiles:  []string{caFile},
+				CertFile: clientCert,
+				KeyFile:  clientKey,
+			},
+			clientAuth: true,
+		},
+		{
+			name:       "no client certificate",
+			config:     TLSConfig{CAFiles: []string{caFile}},
+			clientAuth: true,
+			err:        "remote error: tls:",
+		},
+		{
+			name:   "good staple",
+			config: TLSConfig{CAFiles: []string{caFile}, RequireOCSP: true},
+			staple: staple(t, server, ca, ca, good),
+		},
+		{
+			name:   "good staple from a delegated responder",
+			config: TLSConfig{CAFiles: []string{caFile}, RequireOCSP: true},
+			staple: staple(t, server, ca, responder, good),
+		},
+		{
+			name:   "staple from a certificate that is not a responder",
+			config: TLSConfig{CAFiles: []string{caFile}, RequireOCSP: true},
+			staple: staple(t, server, ca, notResponder, good),
+			err:    "responder certificate is not for OCSP signing",
+		},
+		{
+			name:   "staple signed by another CA",
+			config: TLSConfig{CAFiles: []string{caFile}, RequireOCSP: true},
+			staple: staple(t, server, ca, otherCA, good),
+			err:    "responder certificate is not signed by the issuer",
+		},
+		{
+			name:   "revoked",
+			config: TLSConfig{CAFiles: []string{caFile}, RequireOCSP: true},
+			staple: staple(t, server, ca, ca, revoked),
+			err:    "certificate was revoked",
+		},
+		{
+			name:   "expired staple",
+			config: TLSConfig{CAFiles: []string{caFile}, RequireOCSP: true},
+			staple: staple(t, server, ca, ca, expired),
+			err:    "response expired",
+		},
+		{
+			name:   "staple about another certificate",
+			config: TLSConfig{CAFiles: []string{caFile}, RequireOCSP: true},
+			staple: staple(t, responder, ca, ca, good),
+			err:    "response is not about the server's certificate",
+		},
+		{
+			name:   "no staple",
+			config: TLSConfig{CAFiles: []string{caFile}, RequireOCSP: true},
+			err:    "server did not staple an OCSP response",
+		},
+		{
+			name:   "staple not required",
+			config: TLSConfig{CAFiles: []string{caFile}},
+			staple: staple(t, server, ca, ca, revoked),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "haha")
+			}))
+			s.TLS = &tls.Config{
+				Certificates: []tls.Certificate{{
+					Certificate: [][]byte{server.cert.Raw},
+					PrivateKey:  server.key,
+					OCSPStaple:  tt.staple,
+				}},
+			}
+			if tt.clientAuth {
+				pool := x509.NewCertPool()
+				pool.AddCert(otherCA.cert)
+				s.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+				s.TLS.ClientCAs = pool
+			}
+			// Do not log the handshake errors of the failing cases.
+			s.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+			s.StartTLS()
+			defer s.Close()
+
+			if tt.config.Now == nil {
+				tt.config.Now = func() time.Time { return testNow }
+			}
+			u, err := url.Parse(s.URL + "/file")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []byte
+			c, err := NewHTTPSClient(&tt.config)
+			if err == nil {
+				var r io.ReaderAt
+				if r, err = c.Fetch(u); err == nil {
+					got, err = ioutil.ReadAll(uio.Reader(r))
+				}
+			}
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("Fetch(%s) = %v, want no error", u, err)
+				}
+				if string(got) != "haha" {
+					t.Errorf("Fetch(%s) = %q, want %q", u, got, "haha")
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Fetch(%s) = %v, want error containing %q", u, err, tt.err)
+			}
+		})
+	}
+}
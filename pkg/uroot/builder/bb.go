@@ -6,11 +6,13 @@ package builder
 
 import (
 	"fmt"
+	"io"
 	"path"
 	"path/filepath"
 
 	"github.com/u-root/u-root/pkg/bb"
 	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/golang"
 	"github.com/u-root/u-root/pkg/uroot/initramfs"
 )
 
@@ -31,7 +33,16 @@ var skip = map[string]struct{}{
 //
 // See bb/README.md for a detailed explanation of the implementation of busybox
 // mode.
-type BBBuilder struct{}
+type BBBuilder struct {
+	// CacheDir, if not empty, is a bb.Cache directory. Rewritten packages
+	// and busybox binaries are reused from and saved to it, making
+	// incremental rebuilds fast.
+	CacheDir string
+
+	// SizeReport, if not nil, receives a bb.SizeReport of the busybox:
+	// how much code each command and package contributes to it.
+	SizeReport io.Writer
+}
 
 // DefaultBinaryDir implements Builder.DefaultBinaryDir.
 //
@@ -41,13 +52,28 @@ func (BBBuilder) DefaultBinaryDir() string {
 }
 
 // Build is an implementation of Builder.Build for a busybox-like initramfs.
-func (BBBuilder) Build(af *initramfs.Files, opts Opts) error {
+func (b BBBuilder) Build(af *initramfs.Files, opts Opts) error {
+	var cache *bb.Cache
+	if len(b.CacheDir) > 0 {
+		cache = &bb.Cache{Dir: b.CacheDir}
+	}
+
 	// Build the busybox binary.
 	bbPath := filepath.Join(opts.TempDir, "bb")
-	if err := bb.BuildBusybox(opts.Env, opts.Packages, bbPath); err != nil {
+	if err := bb.BuildBusyboxCached(opts.Env, opts.Packages, bbPath, cache, golang.BuildOpts{Reproducible: opts.Reproducible}); err != nil {
 		return err
 	}
 
+	if b.SizeReport != nil {
+		r, err := bb.NewSizeReport(opts.Env, opts.Packages, bbPath)
+		if err != nil {
+			return fmt.Errorf("could not attribute busybox size: %v", err)
+		}
+		if _, err := r.WriteTo(b.SizeReport); err != nil {
+			return err
+		}
+	}
+
 	if len(opts.BinaryDir) == 0 {
 		return fmt.Errorf("must specify binary directory")
 	}
@@ -5,6 +5,8 @@
 package builder
 
 import (
+	"fmt"
+
 	"github.com/u-root/u-root/pkg/golang"
 	"github.com/u-root/u-root/pkg/uroot/initramfs"
 )
@@ -13,8 +15,26 @@ var (
 	BusyBox = BBBuilder{}
 	Source  = SourceBuilder{}
 	Binary  = BinaryBuilder{}
+
+	// Builders are the builders known by name.
+	Builders = map[string]Builder{
+		"bb":     BusyBox,
+		"source": Source,
+		"binary": Binary,
+	}
 )
 
+// GetBuilder finds a registered builder by name.
+//
+// Good to use with command-line arguments.
+func GetBuilder(name string) (Builder, error) {
+	b, ok := Builders[name]
+	if !ok {
+		return nil, fmt.Errorf("couldn't find builder %q", name)
+	}
+	return b, nil
+}
+
 // Opts are options passed to the Builder.Build function.
 type Opts struct {
 	// Env is the Go compiler environment.
@@ -37,6 +57,10 @@ type Opts struct {
 	//
 	// BinaryDir must be specified.
 	BinaryDir string
+
+	// Reproducible builds binaries without build IDs and file system
+	// paths, as in golang.BuildOpts.
+	Reproducible bool
 }
 
 // Builder builds Go packages and adds the binaries to an initramfs.
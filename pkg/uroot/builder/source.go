@@ -99,6 +99,10 @@ func (sb SourceBuilder) Build(af *initramfs.Files, opts Opts) error {
 		if p == nil {
 			continue
 		}
+		if p.Module != nil {
+			// The initramfs GOPATH cannot hold module sources.
+			return fmt.Errorf("source mode does not support packages in Go modules, but %s is in module %s", p.ImportPath, p.Module.Path)
+		}
 		for _, d := range p.Deps {
 			deps[d] = struct{}{}
 		}
@@ -147,7 +151,7 @@ func (sb SourceBuilder) Build(af *initramfs.Files, opts Opts) error {
 		return err
 	}
 	if !sb.FourBins {
-		if err := opts.Env.Build(installcommand, filepath.Join(opts.TempDir, opts.BinaryDir, "installcommand"), golang.BuildOpts{}); err != nil {
+		if err := opts.Env.Build(installcommand, filepath.Join(opts.TempDir, opts.BinaryDir, "installcommand"), golang.BuildOpts{Reproducible: opts.Reproducible}); err != nil {
 			return err
 		}
 	}
@@ -161,7 +165,8 @@ func (sb SourceBuilder) Build(af *initramfs.Files, opts Opts) error {
 func buildToolchain(opts Opts) error {
 	goBin := filepath.Join(opts.TempDir, "go/bin/go")
 	tcbo := golang.BuildOpts{
-		ExtraArgs: []string{"-tags", "cmd_go_bootstrap"},
+		ExtraArgs:    []string{"-tags", "cmd_go_bootstrap"},
+		Reproducible: opts.Reproducible,
 	}
 	if err := opts.Env.Build("cmd/go", goBin, tcbo); err != nil {
 		return err
@@ -170,7 +175,7 @@ func buildToolchain(opts Opts) error {
 	toolDir := filepath.Join(opts.TempDir, fmt.Sprintf("go/pkg/tool/%v_%v", opts.Env.GOOS, opts.Env.GOARCH))
 	for _, pkg := range []string{"compile", "link", "asm"} {
 		c := filepath.Join(toolDir, pkg)
-		if err := opts.Env.Build(fmt.Sprintf("cmd/%s", pkg), c, golang.BuildOpts{}); err != nil {
+		if err := opts.Env.Build(fmt.Sprintf("cmd/%s", pkg), c, golang.BuildOpts{Reproducible: opts.Reproducible}); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,215 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package hashmanifest implements signed hash manifests of the files in an
+// initramfs.
+//
+// A hash manifest has one line per file: the SHA-256 hash of its contents, its
+// mode in octal, and its name, separated by two spaces. The contents of a
+// symlink are its target. Files without contents, such as directories, hash
+// the empty string.
+//
+// A signed initramfs contains its hash manifest at Path and an ed25519
+// signature of the manifest at SignaturePath. The manifest lists every other
+// file, but neither itself nor the signature. The public key to verify the
+// signature with does not ship in the initramfs: whoever verifies it must
+// already trust the key.
+package hashmanifest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// Paths of the signed manifest files in an initramfs.
+const (
+	Path          = "etc/uroot/manifest"
+	SignaturePath = "etc/uroot/manifest.sig"
+)
+
+// Unlisted are the files that Verify allows to be missing from the manifest:
+// the manifest and its signature, and the console that the kernel's built-in
+// initramfs creates.
+var Unlisted = []string{Path, SignaturePath, "dev/console"}
+
+// pemType is the PEM block type of public keys, as in pkg/crypto.
+const pemType = "PUBLIC KEY"
+
+// EncodePublicKey PEM-encodes pub.
+func EncodePublicKey(pub ed25519.PublicKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: pub})
+}
+
+// DecodePublicKey decodes a PEM-encoded ed25519 public key.
+func DecodePublicKey(data []byte) (ed25519.PublicKey, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no %s PEM block found", pemType)
+		}
+		if block.Type != pemType {
+			continue
+		}
+		if len(block.Bytes) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("public key has %d bytes, want %d", len(block.Bytes), ed25519.PublicKeySize)
+		}
+		return ed25519.PublicKey(block.Bytes), nil
+	}
+}
+
+// Entry is one line of a hash manifest.
+type Entry struct {
+	Hash []byte
+	Mode uint64
+	Name string
+}
+
+// String formats e as a manifest line, without a trailing newline.
+func (e Entry) String() string {
+	return fmt.Sprintf("%x  %06o  %s", e.Hash, e.Mode, e.Name)
+}
+
+// Parse parses a hash manifest.
+func Parse(manifest []byte) ([]Entry, error) {
+	var entries []Entry
+	s := bufio.NewScanner(bytes.NewReader(manifest))
+	for line := 1; s.Scan(); line++ {
+		f := strings.SplitN(s.Text(), "  ", 3)
+		if len(f) != 3 {
+			return nil, fmt.Errorf("manifest line %d: want hash, mode, and name, got %q", line, s.Text())
+		}
+		hash, err := hex.DecodeString(f[0])
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("manifest line %d: invalid SHA-256 hash %q", line, f[0])
+		}
+		mode, err := strconv.ParseUint(f[1], 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("manifest line %d: invalid mode %q", line, f[1])
+		}
+		entries = append(entries, Entry{Hash: hash, Mode: mode, Name: f[2]})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Verify verifies the signed hash manifest of the file system at root.
+//
+// Verify checks the manifest's signature with pub, that every file in the
+// manifest exists with the listed type and contents, and that every file in
+// the file system is in the manifest, except for directories and Unlisted.
+// Regular files must also have the listed permissions.
+//
+// If the file system matches the manifest, Verify returns the manifest.
+func Verify(root string, pub ed25519.PublicKey) ([]byte, error) {
+	manifest, err := ioutil.ReadFile(filepath.Join(root, Path))
+	if err != nil {
+		return nil, err
+	}
+	sig, err := ioutil.ReadFile(filepath.Join(root, SignaturePath))
+	if err != nil {
+		return nil, err
+	}
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key has %d bytes, want %d", len(pub), ed25519.PublicKeySize)
+	}
+	if !ed25519.Verify(pub, manifest, sig) {
+		return nil, fmt.Errorf("manifest signature does not match")
+	}
+
+	entries, err := Parse(manifest)
+	if err != nil {
+		return nil, err
+	}
+	listed := make(map[string]bool)
+	for _, name := range Unlisted {
+		listed[name] = true
+	}
+	for _, e := range entries {
+		if err := verifyFile(root, e); err != nil {
+			return nil, err
+		}
+		listed[filepath.Clean(e.Name)] = true
+	}
+	if err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if !listed[name] {
+			return fmt.Errorf("%s: not in the manifest", name)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// verifyFile checks that the file of e exists below root as listed.
+func verifyFile(root string, e Entry) error {
+	path := filepath.Join(root, e.Name)
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("%s: cannot get file mode", e.Name)
+	}
+	mode := uint64(st.Mode)
+
+	if mode&syscall.S_IFMT != e.Mode&syscall.S_IFMT {
+		return fmt.Errorf("%s: file type %06o, want %06o", e.Name, mode&syscall.S_IFMT, e.Mode&syscall.S_IFMT)
+	}
+
+	h := sha256.New()
+	switch mode & syscall.S_IFMT {
+	case syscall.S_IFREG:
+		// The kernel unpacks directories with the umask applied, but
+		// sets the exact mode of regular files.
+		if mode != e.Mode {
+			return fmt.Errorf("%s: mode %06o, want %06o", e.Name, mode, e.Mode)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.Copy(h, f); err != nil {
+			return fmt.Errorf("%s: %v", e.Name, err)
+		}
+	case syscall.S_IFLNK:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		io.WriteString(h, target)
+	}
+	if !bytes.Equal(h.Sum(nil), e.Hash) {
+		return fmt.Errorf("%s: contents do not match the manifest", e.Name)
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hashmanifest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+func hash(s string) []byte {
+	h := sha256.Sum256([]byte(s))
+	return h[:]
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		manifest string
+		want     []Entry
+		wantErr  string
+	}{
+		{
+			name: "ok",
+			manifest: Entry{hash("hello"), 0100644, "etc/motd"}.String() + "\n" +
+				Entry{hash(""), 040755, "etc dir"}.String() + "\n",
+			want: []Entry{
+				{hash("hello"), 0100644, "etc/motd"},
+				{hash(""), 040755, "etc dir"},
+			},
+		},
+		{
+			name:     "empty",
+			manifest: "",
+		},
+		{
+			name:     "missing name",
+			manifest: strings.Repeat("00", sha256.Size) + "  100644\n",
+			wantErr:  "line 1",
+		},
+		{
+			name:     "short hash",
+			manifest: "abcd  100644  etc/motd\n",
+			wantErr:  "invalid SHA-256 hash",
+		},
+		{
+			name:     "bad mode",
+			manifest: strings.Repeat("00", sha256.Size) + "  100648  etc/motd\n",
+			wantErr:  "invalid mode",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.manifest))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse() = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPublicKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodePublicKey(EncodePublicKey(pub))
+	if err != nil {
+		t.Fatalf("DecodePublicKey() = %v", err)
+	}
+	if !bytes.Equal(got, pub) {
+		t.Errorf("DecodePublicKey() = %x, want %x", got, pub)
+	}
+	if _, err := DecodePublicKey([]byte("not a key")); err == nil {
+		t.Errorf("DecodePublicKey(garbage) = nil, want error")
+	}
+}
+
+// signedRoot creates a file system with a signed manifest in a new temporary
+// directory, and returns it with the public key of the signature.
+func signedRoot(t *testing.T) (string, ed25519.PublicKey) {
+	root, err := ioutil.TempDir("", "hashmanifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, "etc/uroot"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(root, "etc/motd")
+	if err := ioutil.WriteFile(p, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(p, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../bbin/elvish", filepath.Join(root, "etc/sh")); err != nil {
+		t.Fatal(err)
+	}
+
+	var manifest strings.Builder
+	for _, e := range []Entry{
+		{hash(""), 040700, "etc"},
+		{hash("hello"), 0100644, "etc/motd"},
+		{hash("../bbin/elvish"), 0120777, "etc/sh"},
+	} {
+		manifest.WriteString(e.String() + "\n")
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, Path), []byte(manifest.String()), 0444); err != nil {
+		t.Fatal(err)
+	}
+	sig := ed25519.Sign(priv, []byte(manifest.String()))
+	if err := ioutil.WriteFile(filepath.Join(root, SignaturePath), sig, 0444); err != nil {
+		t.Fatal(err)
+	}
+	return root, pub
+}
+
+func TestVerify(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		modify  func(root string) error
+		key     func(pub ed25519.PublicKey) ed25519.PublicKey
+		wantErr string
+	}{
+		{
+			name:   "ok",
+			modify: func(string) error { return nil },
+		},
+		{
+			name: "extra file",
+			modify: func(root string) error {
+				return ioutil.WriteFile(filepath.Join(root, "etc/extra"), nil, 0644)
+			},
+			wantErr: "etc/extra: not in the manifest",
+		},
+		{
+			name: "extra symlink",
+			modify: func(root string) error {
+				return os.Symlink("/tmp/sh", filepath.Join(root, "etc/uroot/uinit"))
+			},
+			wantErr: "etc/uroot/uinit: not in the manifest",
+		},
+		{
+			name: "extra directory",
+			modify: func(root string) error {
+				return os.Mkdir(filepath.Join(root, "tmp"), 0755)
+			},
+		},
+		{
+			name: "console",
+			modify: func(root string) error {
+				if err := os.Mkdir(filepath.Join(root, "dev"), 0755); err != nil {
+					return err
+				}
+				return ioutil.WriteFile(filepath.Join(root, "dev/console"), nil, 0600)
+			},
+		},
+		{
+			name: "changed content",
+			modify: func(root string) error {
+				return ioutil.WriteFile(filepath.Join(root, "etc/motd"), []byte("bye"), 0644)
+			},
+			wantErr: "etc/motd: contents do not match",
+		},
+		{
+			name: "changed mode",
+			modify: func(root string) error {
+				return os.Chmod(filepath.Join(root, "etc/motd"), 0666)
+			},
+			wantErr: "etc/motd: mode 100666, want 100644",
+		},
+		{
+			name: "changed symlink",
+			modify: func(root string) error {
+				p := filepath.Join(root, "etc/sh")
+				if err := os.Remove(p); err != nil {
+					return err
+				}
+				return os.Symlink("/tmp/sh", p)
+			},
+			wantErr: "etc/sh: contents do not match",
+		},
+		{
+			name: "changed type",
+			modify: func(root string) error {
+				p := filepath.Join(root, "etc/sh")
+				if err := os.Remove(p); err != nil {
+					return err
+				}
+				return ioutil.WriteFile(p, []byte("../bbin/elvish"), 0777)
+			},
+			wantErr: "etc/sh: file type",
+		},
+		{
+			name: "missing file",
+			modify: func(root string) error {
+				return os.Remove(filepath.Join(root, "etc/motd"))
+			},
+			wantErr: "no such file",
+		},
+		{
+			name: "changed manifest",
+			modify: func(root string) error {
+				f, err := os.OpenFile(filepath.Join(root, Path), os.O_WRONLY|os.O_APPEND, 0)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				_, err = f.WriteString(Entry{hash(""), 040755, "tmp"}.String() + "\n")
+				return err
+			},
+			wantErr: "signature does not match",
+		},
+		{
+			name: "other key",
+			modify: func(root string) error {
+				_, priv, err := ed25519.GenerateKey(nil)
+				if err != nil {
+					return err
+				}
+				manifest, err := ioutil.ReadFile(filepath.Join(root, Path))
+				if err != nil {
+					return err
+				}
+				return ioutil.WriteFile(filepath.Join(root, SignaturePath), ed25519.Sign(priv, manifest), 0444)
+			},
+			wantErr: "signature does not match",
+		},
+		{
+			name:    "wrong trusted key",
+			modify:  func(string) error { return nil },
+			key:     func(ed25519.PublicKey) ed25519.PublicKey { return make(ed25519.PublicKey, ed25519.PublicKeySize) },
+			wantErr: "signature does not match",
+		},
+		{
+			name:    "no trusted key",
+			modify:  func(string) error { return nil },
+			key:     func(ed25519.PublicKey) ed25519.PublicKey { return nil },
+			wantErr: "public key has 0 bytes",
+		},
+		{
+			name: "missing signature",
+			modify: func(root string) error {
+				return os.Remove(filepath.Join(root, SignaturePath))
+			},
+			wantErr: "no such file",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root, pub := signedRoot(t)
+			defer os.RemoveAll(root)
+			if err := tt.modify(root); err != nil {
+				t.Fatal(err)
+			}
+			if tt.key != nil {
+				pub = tt.key(pub)
+			}
+
+			manifest, err := Verify(root, pub)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Verify() = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() = %v", err)
+			}
+			want, err := ioutil.ReadFile(filepath.Join(root, Path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(manifest, want) {
+				t.Errorf("Verify() = %q, want %q", manifest, want)
+			}
+		})
+	}
+}
@@ -5,11 +5,15 @@
 package initramfs
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"path"
 
 	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/uroot/hashmanifest"
 	"github.com/u-root/u-root/pkg/uroot/logger"
+	"golang.org/x/crypto/ed25519"
 )
 
 var (
@@ -86,11 +90,39 @@ type Opts struct {
 	// If this is false, the "init" file in BaseArchive will be renamed
 	// "inito" (for init-original) in the output archive.
 	UseExistingInit bool
+
+	// MTime is the modification time of all files in the archive, in
+	// seconds since the Unix epoch.
+	MTime uint64
+
+	// HashManifest, if not nil, receives the HashLine of every record
+	// written to OutputFile, in archive order.
+	//
+	// Diffing the hash manifests of two archives shows which files
+	// differ.
+	HashManifest io.Writer
+
+	// SigningKey, if not nil, signs the archive: the archive gets a hash
+	// manifest of all of its files, signed with SigningKey, as described
+	// in package hashmanifest.
+	SigningKey ed25519.PrivateKey
+}
+
+// isSignature returns true if name is one of the files of a signed hash
+// manifest.
+func isSignature(name string) bool {
+	switch name {
+	case hashmanifest.Path, hashmanifest.SignaturePath:
+		return true
+	}
+	return false
 }
 
 // Write uses the given options to determine which files to write to the output
 // initramfs.
 func Write(opts *Opts) error {
+	var manifest bytes.Buffer
+
 	// Write base archive.
 	if opts.BaseArchive != nil {
 		transform := cpio.MakeReproducible
@@ -118,14 +150,55 @@ func Write(opts *Opts) error {
 			if err != nil {
 				return err
 			}
+			// The signature of a signed base archive does not
+			// match the new archive.
+			if isSignature(cpio.Normalize(f.Name)) {
+				continue
+			}
 			// TODO: ignore only the error where it already exists
 			// in archive.
 			opts.Files.AddRecord(transform(f))
 		}
 	}
 
-	if err := opts.Files.WriteTo(opts.OutputFile); err != nil {
+	if opts.SigningKey != nil {
+		// The kernel does not create missing parent directories when
+		// unpacking, so the manifest's directory must be in the
+		// archive. It is listed in the manifest like any other.
+		if dir := path.Dir(hashmanifest.Path); !opts.Contains(dir) {
+			if err := opts.Files.AddRecord(cpio.Directory(dir, 0755)); err != nil {
+				return err
+			}
+		}
+	}
+
+	w := &manifestWriter{
+		Writer:   opts.OutputFile,
+		mtime:    opts.MTime,
+		manifest: opts.HashManifest,
+	}
+	if opts.SigningKey != nil {
+		if opts.HashManifest != nil {
+			w.manifest = io.MultiWriter(opts.HashManifest, &manifest)
+		} else {
+			w.manifest = &manifest
+		}
+	}
+	if err := opts.Files.WriteTo(w); err != nil {
 		return err
 	}
+
+	if opts.SigningKey != nil {
+		sig := ed25519.Sign(opts.SigningKey, manifest.Bytes())
+		for _, r := range []cpio.Record{
+			cpio.StaticFile(hashmanifest.Path, manifest.String(), 0444),
+			cpio.StaticFile(hashmanifest.SignaturePath, string(sig), 0444),
+		} {
+			r.MTime = opts.MTime
+			if err := opts.OutputFile.WriteRecord(r); err != nil {
+				return err
+			}
+		}
+	}
 	return opts.OutputFile.Finish()
 }
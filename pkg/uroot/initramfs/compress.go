@@ -0,0 +1,143 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package initramfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/u-root/u-root/pkg/lz4"
+	"github.com/u-root/u-root/pkg/xz"
+)
+
+var (
+	// Gzip compresses archives with gzip.
+	Gzip Compressor = gzipCompressor{}
+
+	// XZ compresses archives with xz, using the CRC32 integrity check
+	// the kernel requires and a 1 MiB dictionary.
+	XZ Compressor = xzCompressor{}
+
+	// LZ4 compresses archives with the LZ4 legacy format the kernel
+	// requires.
+	LZ4 Compressor = lz4Compressor{}
+
+	// Compressors are the supported initramfs compression formats.
+	//
+	// - gzip: gzip at best compression.
+	// - xz:   xz with LZMA2, a 1 MiB dictionary, and CRC32 checks.
+	// - lz4:  LZ4 legacy frames.
+	Compressors = map[string]Compressor{
+		"gzip": Gzip,
+		"xz":   XZ,
+		"lz4":  LZ4,
+	}
+)
+
+// Compressor compresses the byte stream of an archive.
+type Compressor interface {
+	// Writer returns a writer compressing into w. Closing the returned
+	// writer flushes all data to w, but does not close w.
+	Writer(w io.Writer) (io.WriteCloser, error)
+
+	// Extension is the file name extension for this compression format,
+	// e.g. ".xz".
+	Extension() string
+}
+
+// GetCompressor finds a registered compressor by name.
+//
+// The names "" and "none" return a nil Compressor, which means no
+// compression. Good to use with command-line arguments.
+func GetCompressor(name string) (Compressor, error) {
+	if name == "" || name == "none" {
+		return nil, nil
+	}
+	c, ok := Compressors[name]
+	if !ok {
+		return nil, fmt.Errorf("couldn't find compression format %q", name)
+	}
+	return c, nil
+}
+
+type gzipCompressor struct{}
+
+// Writer implements Compressor.Writer.
+func (gzipCompressor) Writer(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, gzip.BestCompression)
+}
+
+// Extension implements Compressor.Extension.
+func (gzipCompressor) Extension() string {
+	return ".gz"
+}
+
+type xzCompressor struct{}
+
+// Writer implements Compressor.Writer.
+func (xzCompressor) Writer(w io.Writer) (io.WriteCloser, error) {
+	return xz.NewWriterDictSize(w, 1<<20), nil
+}
+
+// Extension implements Compressor.Extension.
+func (xzCompressor) Extension() string {
+	return ".xz"
+}
+
+type lz4Compressor struct{}
+
+// Writer implements Compressor.Writer.
+func (lz4Compressor) Writer(w io.Writer) (io.WriteCloser, error) {
+	return lz4.NewWriter(w), nil
+}
+
+// Extension implements Compressor.Extension.
+func (lz4Compressor) Extension() string {
+	return ".lz4"
+}
+
+var (
+	gzipMagic = []byte{0x1F, 0x8B}
+	xzMagic   = []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}
+	lz4Magic  = []byte{0x02, 0x21, 0x4C, 0x18}
+)
+
+// decompress returns the decompressed contents of r if r starts with the
+// magic number of a supported compression format, and r itself otherwise.
+//
+// Compressed archives are decompressed into memory, since cpio readers need
+// random access.
+func decompress(r io.ReaderAt) (io.ReaderAt, error) {
+	magic := make([]byte, len(xzMagic))
+	n, err := r.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	magic = magic[:n]
+
+	sr := io.NewSectionReader(r, 0, 1<<63-1)
+	var zr io.Reader
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err = gzip.NewReader(sr)
+	case bytes.HasPrefix(magic, xzMagic):
+		zr, err = xz.NewReader(sr)
+	case bytes.HasPrefix(magic, lz4Magic):
+		zr, err = lz4.NewReader(sr)
+	default:
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing archive: %v", err)
+	}
+	return bytes.NewReader(b), nil
+}
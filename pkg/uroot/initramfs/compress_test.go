@@ -0,0 +1,99 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package initramfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/cpio"
+)
+
+func TestCPIOArchiverCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "initramfs-compress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	records := []cpio.Record{
+		cpio.Directory("etc", 0755),
+		cpio.StaticFile("etc/motd", strings.Repeat("welcome to u-root\n", 100), 0644),
+		cpio.Symlink("etc/issue", "motd"),
+	}
+
+	for _, name := range []string{"none", "gzip", "xz", "lz4"} {
+		t.Run(name, func(t *testing.T) {
+			c, err := GetCompressor(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ca := CPIOArchiver{
+				RecordFormat: cpio.Newc,
+				Compressor:   c,
+			}
+			path := filepath.Join(dir, name)
+			w, err := ca.OpenWriter(log.New(ioutil.Discard, "", 0), path, "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cpio.WriteRecords(w, records); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Finish(); err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c != nil && bytes.HasPrefix(b, []byte("070701")) {
+				t.Errorf("%s archive is not compressed", name)
+			}
+
+			got, err := cpio.ReadAllRecords(ca.Reader(bytes.NewReader(b)))
+			if err != nil {
+				t.Fatalf("reading %s archive: %v", name, err)
+			}
+			// Any archiver must be able to read compressed input.
+			if _, err := cpio.ReadAllRecords(CPIO.Reader(bytes.NewReader(b))); err != nil {
+				t.Errorf("reading %s archive with uncompressed archiver: %v", name, err)
+			}
+			if !cpio.AllEqual(got, records) {
+				t.Errorf("%s archive contains %v, want %v", name, got, records)
+			}
+		})
+	}
+}
+
+func TestCPIOArchiverDefaultPath(t *testing.T) {
+	ca := CPIOArchiver{
+		RecordFormat: cpio.Newc,
+		Compressor:   XZ,
+	}
+	w, err := ca.OpenWriter(log.New(ioutil.Discard, "", 0), "", "linux", "compresstest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("/tmp/initramfs.linux_compresstest.cpio.xz")
+	if err := w.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat("/tmp/initramfs.linux_compresstest.cpio.xz"); err != nil {
+		t.Errorf("default path of xz archive: %v", err)
+	}
+}
+
+func TestGetCompressor(t *testing.T) {
+	if _, err := GetCompressor("bzip2"); err == nil {
+		t.Errorf("GetCompressor(bzip2) succeeded, want error")
+	}
+}
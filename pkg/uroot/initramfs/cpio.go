@@ -16,43 +16,86 @@ import (
 // CPIOArchiver is an implementation of Archiver for the cpio format.
 type CPIOArchiver struct {
 	cpio.RecordFormat
+
+	// Compressor compresses the archive. If nil, the archive is written
+	// uncompressed.
+	Compressor Compressor
 }
 
 // OpenWriter opens `path` as the correct file type and returns an
 // Writer pointing to `path`.
 //
 // If `path` is empty, a default path of /tmp/initramfs.GOOS_GOARCH.cpio is
-// used.
+// used, with the Compressor's extension appended.
 func (ca CPIOArchiver) OpenWriter(l logger.Logger, path, goos, goarch string) (Writer, error) {
 	if len(path) == 0 && len(goos) == 0 && len(goarch) == 0 {
 		return nil, fmt.Errorf("passed no path, GOOS, and GOARCH to CPIOArchiver.OpenWriter")
 	}
 	if len(path) == 0 {
 		path = fmt.Sprintf("/tmp/initramfs.%s_%s.cpio", goos, goarch)
+		if ca.Compressor != nil {
+			path += ca.Compressor.Extension()
+		}
 	}
 	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
 	if err != nil {
 		return nil, err
 	}
 	l.Printf("Filename is %s", path)
-	return osWriter{ca.RecordFormat.Writer(f), f}, nil
+	if ca.Compressor == nil {
+		return osWriter{RecordWriter: ca.RecordFormat.Writer(f), f: f}, nil
+	}
+	zw, err := ca.Compressor.Writer(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return osWriter{RecordWriter: ca.RecordFormat.Writer(zw), zw: zw, f: f}, nil
 }
 
 // osWriter implements Writer.
 type osWriter struct {
 	cpio.RecordWriter
 
-	f *os.File
+	// zw is the compressing writer between RecordWriter and f, if any.
+	zw io.WriteCloser
+	f  *os.File
 }
 
 // Finish implements Writer.Finish.
 func (o osWriter) Finish() error {
 	err := cpio.WriteTrailer(o)
+	if o.zw != nil {
+		if zerr := o.zw.Close(); err == nil {
+			err = zerr
+		}
+	}
 	o.f.Close()
 	return err
 }
 
 // Reader implements Archiver.Reader.
+//
+// Reader transparently decompresses gzip, xz, and lz4 compressed archives.
+// Archives in any cpio format known to pkg/cpio are read, not just the
+// archiver's RecordFormat.
 func (ca CPIOArchiver) Reader(r io.ReaderAt) Reader {
-	return ca.RecordFormat.Reader(r)
+	ur, err := decompress(r)
+	if err != nil {
+		return errReader{err}
+	}
+	if f, err := cpio.Detect(ur); err == nil {
+		return f.Reader(ur)
+	}
+	return ca.RecordFormat.Reader(ur)
+}
+
+// errReader is a Reader that always fails.
+type errReader struct {
+	err error
+}
+
+// ReadRecord implements Reader.ReadRecord.
+func (e errReader) ReadRecord() (cpio.Record, error) {
+	return cpio.Record{}, e.err
 }
@@ -41,17 +41,17 @@ func (da DirArchiver) OpenWriter(l logger.Logger, path, goos, goarch string) (Wr
 		}
 	}
 	l.Printf("Path is %s", path)
-	return dirWriter{path}, nil
+	return dirWriter{cpio.NewExtractor(path, false)}, nil
 }
 
 // dirWriter implements Writer.
 type dirWriter struct {
-	dir string
+	e *cpio.Extractor
 }
 
 // WriteRecord implements Writer.WriteRecord.
 func (dw dirWriter) WriteRecord(r cpio.Record) error {
-	return cpio.CreateFileInRoot(r, dw.dir, false)
+	return dw.e.CreateFile(r)
 }
 
 // Finish implements Writer.Finish.
@@ -0,0 +1,54 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package initramfs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/uio"
+	"github.com/u-root/u-root/pkg/uroot/hashmanifest"
+)
+
+// HashLine returns the hash manifest line of r: the SHA-256 hash of its
+// contents, its mode in octal, and its name, separated by two spaces.
+//
+// The contents of a symlink are its target. Records without contents, such
+// as directories, hash the empty string. See package hashmanifest.
+func HashLine(r cpio.Record) (string, error) {
+	h := sha256.New()
+	if r.ReaderAt != nil {
+		if _, err := io.Copy(h, io.LimitReader(uio.Reader(r), int64(r.FileSize))); err != nil {
+			return "", fmt.Errorf("could not hash %q: %v", r.Name, err)
+		}
+	}
+	return hashmanifest.Entry{Hash: h.Sum(nil), Mode: r.Mode, Name: r.Name}.String(), nil
+}
+
+// manifestWriter is a Writer that sets the modification time of all records
+// and writes their hash manifest lines.
+type manifestWriter struct {
+	Writer
+
+	mtime    uint64
+	manifest io.Writer
+}
+
+// WriteRecord implements cpio.RecordWriter.
+func (w *manifestWriter) WriteRecord(r cpio.Record) error {
+	r.MTime = w.mtime
+	if w.manifest != nil {
+		line, err := HashLine(r)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(w.manifest, line); err != nil {
+			return err
+		}
+	}
+	return w.Writer.WriteRecord(r)
+}
@@ -0,0 +1,189 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package initramfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/uio"
+	"github.com/u-root/u-root/pkg/uroot/hashmanifest"
+	"golang.org/x/crypto/ed25519"
+)
+
+func sha256Hex(s string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(s)))
+}
+
+func TestHashLine(t *testing.T) {
+	for _, tt := range []struct {
+		r    cpio.Record
+		want string
+	}{
+		{
+			r:    cpio.StaticFile("etc/motd", "hello", 0644),
+			want: sha256Hex("hello") + "  100644  etc/motd",
+		},
+		{
+			r:    cpio.Directory("etc", 0755),
+			want: sha256Hex("") + "  040755  etc",
+		},
+		{
+			r:    cpio.Symlink("bin/sh", "../bbin/elvish"),
+			want: sha256Hex("../bbin/elvish") + "  120777  bin/sh",
+		},
+	} {
+		t.Run(tt.r.Name, func(t *testing.T) {
+			got, err := HashLine(tt.r)
+			if err != nil {
+				t.Fatalf("HashLine(%v) = %v", tt.r, err)
+			}
+			if got != tt.want {
+				t.Errorf("HashLine(%v) = %q, want %q", tt.r, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteMTimeHashManifest(t *testing.T) {
+	ma := &MockArchiver{
+		Records: make(Records),
+		BaseArchive: []cpio.Record{
+			cpio.StaticFile("init", "boo", 0555),
+		},
+	}
+	var manifest bytes.Buffer
+	opts := &Opts{
+		Files: &Files{
+			Records: map[string]cpio.Record{
+				"etc/motd": cpio.StaticFile("etc/motd", "hello", 0644),
+				"bin/sh":   cpio.Symlink("bin/sh", "../bbin/elvish"),
+			},
+		},
+		BaseArchive:     ma,
+		OutputFile:      ma,
+		UseExistingInit: true,
+		MTime:           1500000000,
+		HashManifest:    &manifest,
+	}
+	if err := Write(opts); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+
+	for name, r := range ma.Records {
+		if r.MTime != opts.MTime {
+			t.Errorf("record %q has mtime %d, want %d", name, r.MTime, opts.MTime)
+		}
+	}
+
+	want := strings.Join([]string{
+		sha256Hex("") + "  040755  bin",
+		sha256Hex("../bbin/elvish") + "  120777  bin/sh",
+		sha256Hex("") + "  040755  etc",
+		sha256Hex("hello") + "  100644  etc/motd",
+		sha256Hex("boo") + "  100555  init",
+	}, "\n") + "\n"
+	if got := manifest.String(); got != want {
+		t.Errorf("hash manifest = \n%s, want \n%s", got, want)
+	}
+}
+
+// recordList is an archive that keeps its records in the order they were
+// written.
+type recordList []cpio.Record
+
+func (l *recordList) WriteRecord(r cpio.Record) error {
+	*l = append(*l, r)
+	return nil
+}
+
+func (l *recordList) Finish() error {
+	return nil
+}
+
+func TestWriteSigned(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ma := &MockArchiver{
+		Records: make(Records),
+		BaseArchive: []cpio.Record{
+			cpio.StaticFile("init", "boo", 0555),
+			// The signature of the base archive is replaced.
+			cpio.StaticFile(hashmanifest.SignaturePath, "old", 0444),
+		},
+	}
+	var manifest bytes.Buffer
+	var out recordList
+	opts := &Opts{
+		Files: &Files{
+			Records: map[string]cpio.Record{
+				"etc/motd": cpio.StaticFile("etc/motd", "hello", 0644),
+				"bin/sh":   cpio.Symlink("bin/sh", "../bbin/elvish"),
+			},
+		},
+		BaseArchive:     ma,
+		OutputFile:      &out,
+		UseExistingInit: true,
+		HashManifest:    &manifest,
+		SigningKey:      priv,
+	}
+	if err := Write(opts); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+
+	records := make(map[string]cpio.Record)
+	for _, r := range out {
+		records[r.Name] = r
+	}
+	for _, name := range []string{hashmanifest.Path, hashmanifest.SignaturePath} {
+		if _, ok := records[name]; !ok {
+			t.Fatalf("archive has no record %q", name)
+		}
+	}
+	if got := readRecord(t, records[hashmanifest.Path]); got != manifest.String() {
+		t.Errorf("archive manifest = \n%s, want \n%s", got, manifest.String())
+	}
+	sig := readRecord(t, records[hashmanifest.SignaturePath])
+	if !ed25519.Verify(pub, manifest.Bytes(), []byte(sig)) {
+		t.Errorf("manifest signature does not match")
+	}
+
+	// Unpack the archive the way the kernel does, in order and without
+	// creating missing parent directories, and verify it.
+	dir, err := ioutil.TempDir("", "initramfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, r := range out {
+		parent := filepath.Dir(filepath.Join(dir, r.Name))
+		if fi, err := os.Stat(parent); err != nil || !fi.IsDir() {
+			t.Fatalf("archive has no directory record for %q before %q", filepath.Dir(r.Name), r.Name)
+		}
+		if err := cpio.CreateFileInRoot(r, dir, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := hashmanifest.Verify(dir, pub); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+}
+
+func readRecord(t *testing.T, r cpio.Record) string {
+	b, err := uio.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,180 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uroot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/u-root/u-root/pkg/golang"
+	"github.com/u-root/u-root/pkg/uroot/builder"
+)
+
+// CurrentManifestVersion is the version of manifests written by this
+// package.
+const CurrentManifestVersion = 1
+
+// Manifest is a declarative description of an initramfs.
+//
+// A manifest holds everything that determines the contents of an initramfs,
+// so that the same initramfs can be built again from it. Host-specific
+// settings such as temporary and cache directories are not part of it.
+type Manifest struct {
+	// Version is the version of the manifest format. It is used to
+	// introduce breaking changes to the format.
+	Version int `json:"version"`
+
+	// GOOS and GOARCH override the build environment's, if set.
+	GOOS   string `json:"goos,omitempty"`
+	GOARCH string `json:"goarch,omitempty"`
+
+	// Commands are the sets of commands to build, each with one builder.
+	Commands []ManifestCommands `json:"commands,omitempty"`
+
+	// Files are additional files to add, in the format of
+	// Opts.ExtraFiles.
+	Files []string `json:"files,omitempty"`
+
+	// SkipLDD is Opts.SkipLDD.
+	SkipLDD bool `json:"skip_ldd,omitempty"`
+
+	// Base is the path of a base archive. If empty, DefaultRamfs is the
+	// base archive.
+	Base string `json:"base,omitempty"`
+
+	// UseExistingInit is Opts.UseExistingInit.
+	UseExistingInit bool `json:"use_existing_init,omitempty"`
+
+	// Reproducible and MTime are Opts.Reproducible and Opts.MTime.
+	Reproducible bool   `json:"reproducible,omitempty"`
+	MTime        uint64 `json:"mtime,omitempty"`
+
+	// Init, Uinit, and Shell are Opts.InitCmd, Opts.UinitCmd, and
+	// Opts.DefaultShell.
+	Init  string `json:"init,omitempty"`
+	Uinit string `json:"uinit,omitempty"`
+	Shell string `json:"shell,omitempty"`
+
+	// Format is the name of the initramfs.Archiver to write the initramfs
+	// with.
+	Format string `json:"format,omitempty"`
+
+	// Compress is the name of the initramfs.Compressor to compress a cpio
+	// initramfs with.
+	Compress string `json:"compress,omitempty"`
+
+	// Output is the path of the initramfs to write. If empty, the
+	// archiver chooses a default.
+	Output string `json:"output,omitempty"`
+}
+
+// ManifestCommands are a set of commands built with one builder.
+type ManifestCommands struct {
+	// Builder is the name of a builder in builder.Builders.
+	Builder string `json:"builder"`
+
+	// Packages are the Go commands to build, in the format of
+	// Commands.Packages.
+	Packages []string `json:"packages"`
+
+	// BinaryDir is Commands.BinaryDir.
+	BinaryDir string `json:"binary_dir,omitempty"`
+
+	// FourBins is builder.SourceBuilder.FourBins. It may only be set for
+	// the source builder.
+	FourBins bool `json:"fourbins,omitempty"`
+}
+
+// NewManifest returns a new empty Manifest with the current version.
+func NewManifest() *Manifest {
+	return &Manifest{
+		Version: CurrentManifestVersion,
+	}
+}
+
+// ParseManifest parses a JSON manifest.
+func ParseManifest(data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("could not parse manifest: %v", err)
+	}
+	if m.Version < 1 || m.Version > CurrentManifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d, want 1 through %d", m.Version, CurrentManifestVersion)
+	}
+	return &m, nil
+}
+
+// ReadManifest reads the JSON manifest at path.
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ParseManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return m, nil
+}
+
+// WriteTo writes m as JSON to w.
+func (m *Manifest) WriteTo(w io.Writer) (int64, error) {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(append(b, '\n'))
+	return int64(n), err
+}
+
+// Opts returns the options to build the initramfs described by m in env.
+//
+// The caller has to fill in the file-related fields of Opts: TempDir,
+// OutputFile from m.Output, m.Format and m.Compress, and BaseArchive from
+// m.Base.
+func (m *Manifest) Opts(env golang.Environ) (Opts, error) {
+	if len(m.GOOS) > 0 {
+		env.GOOS = m.GOOS
+	}
+	if len(m.GOARCH) > 0 {
+		env.GOARCH = m.GOARCH
+	}
+
+	var cmds []Commands
+	for _, mc := range m.Commands {
+		b, err := builder.GetBuilder(mc.Builder)
+		if err != nil {
+			return Opts{}, err
+		}
+		if mc.FourBins {
+			sb, ok := b.(builder.SourceBuilder)
+			if !ok {
+				return Opts{}, fmt.Errorf("fourbins is only supported by the source builder, not %q", mc.Builder)
+			}
+			sb.FourBins = true
+			b = sb
+		}
+		cmds = append(cmds, Commands{
+			Builder:   b,
+			Packages:  mc.Packages,
+			BinaryDir: mc.BinaryDir,
+		})
+	}
+
+	return Opts{
+		Env:             env,
+		Commands:        cmds,
+		ExtraFiles:      m.Files,
+		SkipLDD:         m.SkipLDD,
+		UseExistingInit: m.UseExistingInit,
+		Reproducible:    m.Reproducible,
+		MTime:           m.MTime,
+		InitCmd:         m.Init,
+		UinitCmd:        m.Uinit,
+		DefaultShell:    m.Shell,
+	}, nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uroot
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/golang"
+	"github.com/u-root/u-root/pkg/uroot/builder"
+)
+
+func TestParseManifest(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		in   string
+		want *Manifest
+		err  string
+	}{
+		{
+			name: "full",
+			in: `{
+  "version": 1,
+  "goarch": "arm64",
+  "commands": [
+    {"builder": "bb", "packages": ["github.com/u-root/u-root/cmds/core/*"]},
+    {"builder": "binary", "packages": ["./cmds/foo"], "binary_dir": "sbin"}
+  ],
+  "files": ["/bin/bash", "hello.ko:lib/hello.ko"],
+  "init": "init",
+  "uinit": "foo",
+  "shell": "elvish",
+  "format": "cpio",
+  "compress": "xz",
+  "output": "/tmp/initramfs.cpio.xz"
+}`,
+			want: &Manifest{
+				Version: 1,
+				GOARCH:  "arm64",
+				Commands: []ManifestCommands{
+					{Builder: "bb", Packages: []string{"github.com/u-root/u-root/cmds/core/*"}},
+					{Builder: "binary", Packages: []string{"./cmds/foo"}, BinaryDir: "sbin"},
+				},
+				Files:    []string{"/bin/bash", "hello.ko:lib/hello.ko"},
+				Init:     "init",
+				Uinit:    "foo",
+				Shell:    "elvish",
+				Format:   "cpio",
+				Compress: "xz",
+				Output:   "/tmp/initramfs.cpio.xz",
+			},
+		},
+		{
+			name: "no version",
+			in:   `{"init": "init"}`,
+			err:  "unsupported manifest version 0",
+		},
+		{
+			name: "future version",
+			in:   `{"version": 2}`,
+			err:  "unsupported manifest version 2",
+		},
+		{
+			name: "bad json",
+			in:   `{"version": 1`,
+			err:  "could not parse manifest",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseManifest([]byte(tt.in))
+			if len(tt.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("ParseManifest() = %v, want error containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseManifest() = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseManifest() = %#v, want %#v", got, tt.want)
+			}
+
+			// Writing and parsing again must be lossless.
+			var b bytes.Buffer
+			if _, err := got.WriteTo(&b); err != nil {
+				t.Fatal(err)
+			}
+			again, err := ParseManifest(b.Bytes())
+			if err != nil {
+				t.Fatalf("ParseManifest(%s) = %v", b.String(), err)
+			}
+			if !reflect.DeepEqual(again, got) {
+				t.Errorf("round trip = %#v, want %#v", again, got)
+			}
+		})
+	}
+}
+
+func TestManifestOpts(t *testing.T) {
+	env := golang.Default()
+	env.GOARCH = "amd64"
+
+	m := &Manifest{
+		Version: CurrentManifestVersion,
+		GOARCH:  "arm",
+		Commands: []ManifestCommands{
+			{Builder: "bb", Packages: []string{"a", "b"}},
+			{Builder: "source", Packages: []string{"c"}, BinaryDir: "src", FourBins: true},
+		},
+		Files:           []string{"/bin/bash"},
+		SkipLDD:         true,
+		UseExistingInit: true,
+		Init:            "init",
+		Uinit:           "a",
+		Shell:           "b",
+	}
+	opts, err := m.Opts(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantEnv := env
+	wantEnv.GOARCH = "arm"
+	want := Opts{
+		Env: wantEnv,
+		Commands: []Commands{
+			{Builder: builder.BusyBox, Packages: []string{"a", "b"}},
+			{Builder: builder.SourceBuilder{FourBins: true}, Packages: []string{"c"}, BinaryDir: "src"},
+		},
+		ExtraFiles:      []string{"/bin/bash"},
+		SkipLDD:         true,
+		UseExistingInit: true,
+		InitCmd:         "init",
+		UinitCmd:        "a",
+		DefaultShell:    "b",
+	}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("Opts() = %#v, want %#v", opts, want)
+	}
+
+	for _, mc := range []ManifestCommands{
+		{Builder: "foo"},
+		{Builder: "bb", FourBins: true},
+	} {
+		m := &Manifest{Version: CurrentManifestVersion, Commands: []ManifestCommands{mc}}
+		if _, err := m.Opts(env); err == nil {
+			t.Errorf("Opts() with commands %+v succeeded, want error", mc)
+		}
+	}
+}
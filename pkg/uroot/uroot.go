@@ -6,6 +6,7 @@ package uroot
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
@@ -18,6 +19,7 @@ import (
 	"github.com/u-root/u-root/pkg/uroot/builder"
 	"github.com/u-root/u-root/pkg/uroot/initramfs"
 	"github.com/u-root/u-root/pkg/uroot/logger"
+	"golang.org/x/crypto/ed25519"
 )
 
 // These constants are used in DefaultRamfs.
@@ -150,6 +152,9 @@ type Opts struct {
 	SkipLDD bool
 
 	// OutputFile is the archive output file.
+	//
+	// To compress the archive, open OutputFile with an
+	// initramfs.CPIOArchiver that has a Compressor.
 	OutputFile initramfs.Writer
 
 	// BaseArchive is an existing initramfs to include in the resulting
@@ -178,6 +183,38 @@ type Opts struct {
 	//
 	// This must be specified to have a default shell.
 	DefaultShell string
+
+	// UinitCmd is the name of a command to link /bin/uinit to, which the
+	// u-root init runs after setting up the system.
+	//
+	// This can be an absolute path or the name of a command included in
+	// Commands.
+	//
+	// If this is empty, no uinit symlink will be created.
+	UinitCmd string
+
+	// Reproducible builds Go binaries without build IDs and with file
+	// system paths trimmed, so that the initramfs only depends on the
+	// source of the commands and the files included.
+	Reproducible bool
+
+	// MTime is the modification time of all files in the initramfs, in
+	// seconds since the Unix epoch. Reproducible builds usually set it
+	// to SOURCE_DATE_EPOCH.
+	MTime uint64
+
+	// HashManifest, if not nil, receives a line with the hash, mode, and
+	// name of every file in the initramfs. See initramfs.HashLine.
+	//
+	// Diffing the hash manifests of two builds shows which files differ.
+	HashManifest io.Writer
+
+	// SigningKey, if not nil, signs the initramfs: a hash manifest of all
+	// files, signed with SigningKey, is added to it. The public key is
+	// not added: u-root's init verifies the files against the manifest
+	// at boot with the key it finds in read-only VPD. See package
+	// hashmanifest.
+	SigningKey ed25519.PrivateKey
 }
 
 // CreateInitramfs creates an initramfs built to opts' specifications.
@@ -209,10 +246,11 @@ func CreateInitramfs(logger logger.Logger, opts Opts) error {
 
 		// Build packages.
 		bOpts := builder.Opts{
-			Env:       opts.Env,
-			Packages:  cmds.Packages,
-			TempDir:   builderTmpDir,
-			BinaryDir: cmds.TargetDir(),
+			Env:          opts.Env,
+			Packages:     cmds.Packages,
+			TempDir:      builderTmpDir,
+			BinaryDir:    cmds.TargetDir(),
+			Reproducible: opts.Reproducible,
 		}
 		if err := cmds.Builder.Build(files, bOpts); err != nil {
 			return fmt.Errorf("error building: %v", err)
@@ -225,6 +263,9 @@ func CreateInitramfs(logger logger.Logger, opts Opts) error {
 		OutputFile:      opts.OutputFile,
 		BaseArchive:     opts.BaseArchive,
 		UseExistingInit: opts.UseExistingInit,
+		MTime:           opts.MTime,
+		HashManifest:    opts.HashManifest,
+		SigningKey:      opts.SigningKey,
 	}
 
 	if len(opts.DefaultShell) > 0 {
@@ -261,6 +302,20 @@ func CreateInitramfs(logger logger.Logger, opts Opts) error {
 		}
 	}
 
+	if len(opts.UinitCmd) > 0 {
+		target, err := resolveCommandOrPath(opts.UinitCmd, opts.Commands)
+		if err != nil {
+			return fmt.Errorf("could not find uinit: %v", err)
+		}
+		rtarget, err := filepath.Rel("/", target)
+		if err != nil {
+			return err
+		}
+		if err := archive.AddRecord(cpio.Symlink("bin/uinit", filepath.Join("..", rtarget))); err != nil {
+			return err
+		}
+	}
+
 	if err := ParseExtraFiles(logger, archive.Files, opts.ExtraFiles, !opts.SkipLDD); err != nil {
 		return err
 	}
@@ -297,6 +352,11 @@ func resolvePackagePath(logger logger.Logger, env golang.Environ, pkg string) ([
 			p, err := env.PackageByPath(match)
 			if err != nil {
 				logger.Printf("Skipping package %q: %v", match, err)
+			} else if env.UseModules(p.Dir) {
+				// Import paths of packages in modules only
+				// resolve within their own module, so use
+				// the directory instead.
+				importPaths = append(importPaths, p.Dir)
 			} else if p.ImportPath == "." {
 				// TODO: I do not completely understand why
 				// this is triggered. This is only an issue
@@ -310,6 +370,14 @@ func resolvePackagePath(logger logger.Logger, env golang.Environ, pkg string) ([
 		return importPaths, nil
 	}
 
+	// In module mode, resolve import paths of packages in the build list
+	// of the current module, which may be replaced by other directories.
+	if env.UseModules("") && !filepath.IsAbs(pkg) {
+		if importPaths, err := resolveModulePackagePath(logger, env, pkg); err != nil || len(importPaths) > 0 {
+			return importPaths, err
+		}
+	}
+
 	// No file import paths found. Check if pkg still resolves as a package name.
 	if _, err := env.Package(pkg); err != nil {
 		return nil, fmt.Errorf("%q is neither package or path/glob: %v", pkg, err)
@@ -317,6 +385,51 @@ func resolvePackagePath(logger logger.Logger, env golang.Environ, pkg string) ([
 	return []string{pkg}, nil
 }
 
+// resolveModulePackagePath resolves an import path or import path glob of a
+// package in a module of the current module's build list to directories.
+func resolveModulePackagePath(logger logger.Logger, env golang.Environ, pkg string) ([]string, error) {
+	mods, err := env.ListModules("")
+	if err != nil {
+		return nil, err
+	}
+
+	// The longest matching module path is the module containing pkg.
+	var mod *golang.Module
+	for _, m := range mods {
+		if (pkg == m.Path || strings.HasPrefix(pkg, m.Path+"/")) && (mod == nil || len(m.Path) > len(mod.Path)) {
+			mod = m
+		}
+	}
+	if mod == nil {
+		return nil, nil
+	}
+	dir := mod.Dir
+	if mod.Replace != nil {
+		dir = mod.Replace.Dir
+	}
+	if len(dir) == 0 {
+		// The module has not been downloaded.
+		return nil, nil
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, strings.TrimPrefix(pkg, mod.Path)))
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, match := range matches {
+		if fi, err := os.Stat(match); err != nil || !fi.IsDir() {
+			continue
+		}
+		if _, err := env.PackageByPath(match); err != nil {
+			logger.Printf("Skipping package %q: %v", match, err)
+			continue
+		}
+		dirs = append(dirs, match)
+	}
+	return dirs, nil
+}
+
 func resolveCommandOrPath(cmd string, cmds []Commands) (string, error) {
 	if filepath.IsAbs(cmd) {
 		return cmd, nil
@@ -347,6 +460,11 @@ func resolveCommandOrPath(cmd string, cmds []Commands) (string, error) {
 //
 // Directories may be relative or absolute, with or without globs.
 // Globs are resolved using filepath.Glob.
+//
+// Packages that are part of a Go module are resolved to their absolute
+// directory instead of an import path, as their import paths do not resolve
+// outside of their module. golang.Environ accepts such directories wherever
+// it accepts import paths.
 func ResolvePackagePaths(logger logger.Logger, env golang.Environ, pkgs []string) ([]string, error) {
 	var importPaths []string
 	for _, pkg := range pkgs {
@@ -433,7 +551,7 @@ func (o *Opts) AddCommands(c ...Commands) {
 
 func (o *Opts) AddBusyBoxCommands(pkgs ...string) {
 	for i, cmds := range o.Commands {
-		if cmds.Builder == builder.BusyBox {
+		if _, ok := cmds.Builder.(builder.BBBuilder); ok {
 			o.Commands[i].Packages = append(cmds.Packages, pkgs...)
 			return
 		}
@@ -190,6 +190,7 @@ func TestCreateInitramfs(t *testing.T) {
 				ExtraFiles:      nil,
 				UseExistingInit: false,
 				InitCmd:         "init",
+				UinitCmd:        "ls",
 				DefaultShell:    "ls",
 				Commands: []Commands{
 					{
@@ -208,6 +209,7 @@ func TestCreateInitramfs(t *testing.T) {
 				itest.HasRecord{cpio.Symlink("bbin/ls", "bb")},
 				itest.HasRecord{cpio.Symlink("bin/defaultsh", "../bbin/ls")},
 				itest.HasRecord{cpio.Symlink("bin/sh", "../bbin/ls")},
+				itest.HasRecord{cpio.Symlink("bin/uinit", "../bbin/ls")},
 			},
 		},
 		{
@@ -0,0 +1,403 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var errCorrupt = errors.New("xz: corrupt LZMA2 data")
+
+// rangeDecoder decodes one LZMA2 chunk held in memory.
+type rangeDecoder struct {
+	in   []byte
+	off  int
+	rng  uint32
+	code uint32
+}
+
+func (rc *rangeDecoder) init(in []byte) error {
+	if len(in) < 5 || in[0] != 0 {
+		return errCorrupt
+	}
+	rc.rng = 0xFFFFFFFF
+	rc.code = uint32(in[1])<<24 | uint32(in[2])<<16 | uint32(in[3])<<8 | uint32(in[4])
+	rc.in = in
+	rc.off = 5
+	return nil
+}
+
+func (rc *rangeDecoder) normalize() {
+	if rc.rng < topValue {
+		rc.rng <<= 8
+		rc.code <<= 8
+		// Reading past the end is detected by overrun().
+		if rc.off < len(rc.in) {
+			rc.code |= uint32(rc.in[rc.off])
+		}
+		rc.off++
+	}
+}
+
+// finished reports whether the chunk was consumed exactly.
+func (rc *rangeDecoder) finished() bool {
+	return rc.off == len(rc.in) && rc.code == 0
+}
+
+func (rc *rangeDecoder) overrun() bool {
+	return rc.off > len(rc.in)
+}
+
+func (rc *rangeDecoder) decodeBit(p *prob) uint32 {
+	bound := (rc.rng >> probBits) * uint32(*p)
+	var bit uint32
+	if rc.code < bound {
+		rc.rng = bound
+		*p += ((1 << probBits) - *p) >> moveBits
+	} else {
+		rc.rng -= bound
+		rc.code -= bound
+		*p -= *p >> moveBits
+		bit = 1
+	}
+	rc.normalize()
+	return bit
+}
+
+func (rc *rangeDecoder) decodeDirect(n uint) uint32 {
+	var v uint32
+	for ; n > 0; n-- {
+		rc.rng >>= 1
+		v <<= 1
+		if rc.code >= rc.rng {
+			rc.code -= rc.rng
+			v |= 1
+		}
+		rc.normalize()
+	}
+	return v
+}
+
+func (rc *rangeDecoder) decodeTree(probs []prob, n uint) uint32 {
+	m := uint32(1)
+	for i := uint(0); i < n; i++ {
+		m = m<<1 | rc.decodeBit(&probs[m])
+	}
+	return m - (1 << n)
+}
+
+func (rc *rangeDecoder) decodeReverseTree(probs []prob, n uint) uint32 {
+	m := uint32(1)
+	var v uint32
+	for i := uint(0); i < n; i++ {
+		bit := rc.decodeBit(&probs[m])
+		m = m<<1 | bit
+		v |= bit << i
+	}
+	return v
+}
+
+func (rc *rangeDecoder) decodeLen(l *lenModel, posState int) int {
+	if rc.decodeBit(&l.choice) == 0 {
+		return matchLenMin + int(rc.decodeTree(l.low[posState][:], lenLowBits))
+	}
+	if rc.decodeBit(&l.choice2) == 0 {
+		return matchLenMin + lenLow + int(rc.decodeTree(l.mid[posState][:], lenMidBits))
+	}
+	return matchLenMin + lenLow + lenMid + int(rc.decodeTree(l.high[:], lenHighBits))
+}
+
+// dictionary is the decoder's history. It holds everything decoded since
+// the last dictionary reset that may still be referenced or has not been
+// read yet.
+type dictionary struct {
+	buf []byte
+	// base is the stream position of buf[0].
+	base int64
+	// read is the index in buf of the next byte to return to the reader.
+	read int
+	size int
+}
+
+func (d *dictionary) reset() {
+	d.buf = d.buf[:0]
+	d.base = 0
+	d.read = 0
+}
+
+func (d *dictionary) pos() int64 {
+	return d.base + int64(len(d.buf))
+}
+
+func (d *dictionary) byteAt(dist uint32) byte {
+	return d.buf[len(d.buf)-int(dist)]
+}
+
+// available is the largest valid match distance.
+func (d *dictionary) available() int64 {
+	if p := d.pos(); p < int64(d.size) {
+		return p
+	}
+	return int64(d.size)
+}
+
+// compact drops data that has been read and can no longer be referenced.
+func (d *dictionary) compact() {
+	drop := d.read
+	if keep := len(d.buf) - d.size; keep < drop {
+		drop = keep
+	}
+	if drop < d.size {
+		return
+	}
+	copy(d.buf, d.buf[drop:])
+	d.buf = d.buf[:len(d.buf)-drop]
+	d.base += int64(drop)
+	d.read -= drop
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// lzma2Decoder decodes the LZMA2 chunks of one xz block.
+//
+// It never reads past the end marker of the LZMA2 data.
+type lzma2Decoder struct {
+	r    byteReader
+	dict dictionary
+	m    *model
+
+	needDictReset bool
+	needProps     bool
+	eof           bool
+
+	packed []byte
+}
+
+func newLZMA2Decoder(r byteReader, dictSize int) *lzma2Decoder {
+	return &lzma2Decoder{
+		r:             r,
+		dict:          dictionary{size: dictSize},
+		needDictReset: true,
+		needProps:     true,
+	}
+}
+
+// Read implements io.Reader.
+func (d *lzma2Decoder) Read(p []byte) (int, error) {
+	for d.dict.read == len(d.dict.buf) {
+		if d.eof {
+			return 0, io.EOF
+		}
+		d.dict.compact()
+		if err := d.decodeChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.dict.buf[d.dict.read:])
+	d.dict.read += n
+	return n, nil
+}
+
+func (d *lzma2Decoder) decodeChunk() error {
+	control, err := d.r.ReadByte()
+	if err != nil {
+		return unexpected(err)
+	}
+	if control == 0 {
+		d.eof = true
+		return nil
+	}
+	if control >= 0xE0 || control == 1 {
+		d.needProps = true
+		d.needDictReset = false
+		d.dict.reset()
+	} else if d.needDictReset {
+		return errCorrupt
+	}
+
+	if control < 0x80 {
+		if control > 2 {
+			return fmt.Errorf("xz: invalid LZMA2 control byte %#x", control)
+		}
+		var hdr [2]byte
+		if _, err := io.ReadFull(d.r, hdr[:]); err != nil {
+			return unexpected(err)
+		}
+		n := int(hdr[0])<<8 | int(hdr[1]) + 1
+		start := len(d.dict.buf)
+		d.dict.buf = append(d.dict.buf, make([]byte, n)...)
+		if _, err := io.ReadFull(d.r, d.dict.buf[start:]); err != nil {
+			return unexpected(err)
+		}
+		return nil
+	}
+
+	var hdr [4]byte
+	if _, err := io.ReadFull(d.r, hdr[:]); err != nil {
+		return unexpected(err)
+	}
+	unpacked := int(control&0x1F)<<16 | int(hdr[0])<<8 | int(hdr[1]) + 1
+	packed := int(hdr[2])<<8 | int(hdr[3]) + 1
+
+	switch reset := (control >> 5) & 3; {
+	case reset >= 2:
+		props, err := d.r.ReadByte()
+		if err != nil {
+			return unexpected(err)
+		}
+		if props >= 9*5*5 {
+			return fmt.Errorf("xz: invalid LZMA properties %#x", props)
+		}
+		lc, lp, pb := uint(props%9), uint(props/9%5), uint(props/45)
+		if lc+lp > 4 {
+			return fmt.Errorf("xz: invalid LZMA2 properties lc=%d lp=%d", lc, lp)
+		}
+		d.m = newModel(lc, lp, pb)
+		d.needProps = false
+	case d.needProps:
+		return errCorrupt
+	case reset == 1:
+		d.m.reset()
+	}
+
+	if cap(d.packed) < packed {
+		d.packed = make([]byte, packed)
+	}
+	d.packed = d.packed[:packed]
+	if _, err := io.ReadFull(d.r, d.packed); err != nil {
+		return unexpected(err)
+	}
+	var rc rangeDecoder
+	if err := rc.init(d.packed); err != nil {
+		return err
+	}
+	if err := d.decodeLZMA(&rc, unpacked); err != nil {
+		return err
+	}
+	if !rc.finished() {
+		return errCorrupt
+	}
+	return nil
+}
+
+func (d *lzma2Decoder) decodeLZMA(rc *rangeDecoder, unpacked int) error {
+	m := d.m
+	dict := &d.dict
+	end := dict.pos() + int64(unpacked)
+	pbMask := int64(1)<<m.pb - 1
+
+	for dict.pos() < end {
+		if rc.overrun() {
+			return errCorrupt
+		}
+		pos := dict.pos()
+		posState := int(pos & pbMask)
+
+		if rc.decodeBit(&m.isMatch[m.state][posState]) == 0 {
+			var prev byte
+			if len(dict.buf) > 0 {
+				prev = dict.byteAt(1)
+			}
+			probs := m.literalProbs(pos, prev)
+			sym := uint32(1)
+			if m.state >= numLitStates {
+				if int64(m.reps[0]) >= dict.available() {
+					return errCorrupt
+				}
+				matchByte := uint32(dict.byteAt(m.reps[0] + 1))
+				for sym < 0x100 {
+					matchBit := (matchByte >> 7) & 1
+					matchByte <<= 1
+					bit := rc.decodeBit(&probs[(1+matchBit)<<8+sym])
+					sym = sym<<1 | bit
+					if matchBit != bit {
+						break
+					}
+				}
+			}
+			for sym < 0x100 {
+				sym = sym<<1 | rc.decodeBit(&probs[sym])
+			}
+			dict.buf = append(dict.buf, byte(sym))
+			m.updateLiteral()
+			continue
+		}
+
+		var length int
+		if rc.decodeBit(&m.isRep[m.state]) == 0 {
+			length = rc.decodeLen(&m.matchLen, posState)
+			slot := rc.decodeTree(m.posSlot[lenToPosState(length)][:], posSlotBits)
+			dist := slot
+			if slot >= startPosModel {
+				footerBits := uint(slot>>1) - 1
+				dist = (2 | slot&1) << footerBits
+				if slot < endPosModel {
+					dist += rc.decodeReverseTree(m.specPos[dist-slot:], footerBits)
+				} else {
+					dist += rc.decodeDirect(footerBits-alignBits) << alignBits
+					dist += rc.decodeReverseTree(m.align[:], alignBits)
+				}
+			}
+			m.reps[3], m.reps[2], m.reps[1], m.reps[0] = m.reps[2], m.reps[1], m.reps[0], dist
+			m.updateMatch()
+		} else {
+			if dict.pos() == 0 {
+				return errCorrupt
+			}
+			if rc.decodeBit(&m.isRepG0[m.state]) == 0 {
+				if rc.decodeBit(&m.isRep0Long[m.state][posState]) == 0 {
+					if int64(m.reps[0]) >= dict.available() {
+						return errCorrupt
+					}
+					dict.buf = append(dict.buf, dict.byteAt(m.reps[0]+1))
+					m.updateShortRep()
+					continue
+				}
+			} else {
+				var dist uint32
+				if rc.decodeBit(&m.isRepG1[m.state]) == 0 {
+					dist = m.reps[1]
+				} else {
+					if rc.decodeBit(&m.isRepG2[m.state]) == 0 {
+						dist = m.reps[2]
+					} else {
+						dist = m.reps[3]
+						m.reps[3] = m.reps[2]
+					}
+					m.reps[2] = m.reps[1]
+				}
+				m.reps[1] = m.reps[0]
+				m.reps[0] = dist
+			}
+			length = rc.decodeLen(&m.repLen, posState)
+			m.updateRep()
+		}
+
+		dist := int64(m.reps[0]) + 1
+		if dist > dict.available() {
+			return errCorrupt
+		}
+		if left := end - dict.pos(); int64(length) > left {
+			return errCorrupt
+		}
+		for i := 0; i < length; i++ {
+			dict.buf = append(dict.buf, dict.buf[len(dict.buf)-int(dist)])
+		}
+	}
+	return nil
+}
+
+// unexpected converts io.EOF to io.ErrUnexpectedEOF.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,583 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"io"
+)
+
+const (
+	// LZMA2 chunk limits.
+	chunkUnpackedMax = 1 << 21
+	chunkPackedMax   = 1 << 16
+
+	// chunkPackedSlack is the most a single LZMA symbol plus the range
+	// coder flush can add to a chunk.
+	chunkPackedSlack = 64
+
+	hashBits  = 17
+	hashSize  = 1 << hashBits
+	chainMax  = 16
+	niceLen   = 128
+	noPos     = -1
+	lookahead = matchLenMax
+)
+
+// rangeEncoder is the LZMA binary arithmetic coder.
+type rangeEncoder struct {
+	low       uint64
+	rng       uint32
+	cache     byte
+	cacheSize int64
+	out       []byte
+}
+
+func (rc *rangeEncoder) reset() {
+	rc.low = 0
+	rc.rng = 0xFFFFFFFF
+	rc.cache = 0
+	rc.cacheSize = 1
+	rc.out = rc.out[:0]
+}
+
+// pending is the number of bytes the coder has output or will output for
+// the symbols coded so far, not counting the flush.
+func (rc *rangeEncoder) pending() int {
+	return len(rc.out) + int(rc.cacheSize)
+}
+
+func (rc *rangeEncoder) shiftLow() {
+	if uint32(rc.low) < 0xFF000000 || rc.low>>32 != 0 {
+		carry := byte(rc.low >> 32)
+		temp := rc.cache
+		for {
+			rc.out = append(rc.out, temp+carry)
+			temp = 0xFF
+			rc.cacheSize--
+			if rc.cacheSize == 0 {
+				break
+			}
+		}
+		rc.cache = byte(rc.low >> 24)
+	}
+	rc.cacheSize++
+	rc.low = (rc.low & 0x00FFFFFF) << 8
+}
+
+func (rc *rangeEncoder) encodeBit(p *prob, bit uint32) {
+	bound := (rc.rng >> probBits) * uint32(*p)
+	if bit == 0 {
+		rc.rng = bound
+		*p += ((1 << probBits) - *p) >> moveBits
+	} else {
+		rc.low += uint64(bound)
+		rc.rng -= bound
+		*p -= *p >> moveBits
+	}
+	for rc.rng < topValue {
+		rc.rng <<= 8
+		rc.shiftLow()
+	}
+}
+
+func (rc *rangeEncoder) encodeDirect(v uint32, n uint) {
+	for n > 0 {
+		n--
+		rc.rng >>= 1
+		if (v>>n)&1 != 0 {
+			rc.low += uint64(rc.rng)
+		}
+		for rc.rng < topValue {
+			rc.rng <<= 8
+			rc.shiftLow()
+		}
+	}
+}
+
+func (rc *rangeEncoder) flush() {
+	for i := 0; i < 5; i++ {
+		rc.shiftLow()
+	}
+}
+
+func (rc *rangeEncoder) encodeTree(probs []prob, v uint32, n uint) {
+	m := uint32(1)
+	for n > 0 {
+		n--
+		bit := (v >> n) & 1
+		rc.encodeBit(&probs[m], bit)
+		m = m<<1 | bit
+	}
+}
+
+func (rc *rangeEncoder) encodeReverseTree(probs []prob, v uint32, n uint) {
+	m := uint32(1)
+	for ; n > 0; n-- {
+		bit := v & 1
+		v >>= 1
+		rc.encodeBit(&probs[m], bit)
+		m = m<<1 | bit
+	}
+}
+
+func (rc *rangeEncoder) encodeLen(l *lenModel, length int, posState int) {
+	v := uint32(length - matchLenMin)
+	switch {
+	case v < lenLow:
+		rc.encodeBit(&l.choice, 0)
+		rc.encodeTree(l.low[posState][:], v, lenLowBits)
+	case v < lenLow+lenMid:
+		rc.encodeBit(&l.choice, 1)
+		rc.encodeBit(&l.choice2, 0)
+		rc.encodeTree(l.mid[posState][:], v-lenLow, lenMidBits)
+	default:
+		rc.encodeBit(&l.choice, 1)
+		rc.encodeBit(&l.choice2, 1)
+		rc.encodeTree(l.high[:], v-lenLow-lenMid, lenHighBits)
+	}
+}
+
+// encoder is an LZMA2 encoder using a greedy hash chain match finder with
+// one step of lazy evaluation.
+//
+// Uncompressed data lives in a sliding window: buf[0] is the byte at
+// absolute stream position base. Hash chain entries are positions relative
+// to base and are rebased whenever the window slides.
+type encoder struct {
+	w        io.Writer
+	dictSize int
+
+	buf  []byte
+	base int64
+	pos  int64
+
+	head []int32
+	// chain links each position to the previous one with the same hash.
+	// It is indexed by position modulo its power-of-two length.
+	chain     []int32
+	chainMask int64
+	hashNext  int64
+
+	m  *model
+	rc rangeEncoder
+
+	chunkStart int64
+	// needDictReset, needProps and needStateReset track what the next
+	// chunk header must reset.
+	needDictReset  bool
+	needProps      bool
+	needStateReset bool
+
+	// written counts compressed bytes written to w.
+	written int64
+}
+
+func newEncoder(w io.Writer, dictSize int) *encoder {
+	chainSize := 1
+	for chainSize < dictSize {
+		chainSize <<= 1
+	}
+	e := &encoder{
+		w:             w,
+		dictSize:      dictSize,
+		buf:           make([]byte, 0, 2*dictSize+chunkUnpackedMax),
+		head:          make([]int32, hashSize),
+		chain:         make([]int32, chainSize),
+		chainMask:     int64(chainSize - 1),
+		m:             newModel(defaultLC, defaultLP, defaultPB),
+		needDictReset: true,
+		needProps:     true,
+	}
+	for i := range e.head {
+		e.head[i] = noPos
+	}
+	e.rc.reset()
+	return e
+}
+
+// Write buffers p and encodes as much of it as possible.
+func (e *encoder) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if len(e.buf) == cap(e.buf) {
+			e.slide()
+		}
+		c := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+c]
+		p = p[c:]
+		if err := e.encode(false); err != nil {
+			return n - len(p), err
+		}
+	}
+	return n, nil
+}
+
+// Close encodes all remaining data and writes the LZMA2 end marker.
+func (e *encoder) Close() error {
+	if err := e.encode(true); err != nil {
+		return err
+	}
+	if e.pos > e.chunkStart {
+		if err := e.flushChunk(); err != nil {
+			return err
+		}
+	}
+	return e.write([]byte{0})
+}
+
+func (e *encoder) write(b []byte) error {
+	n, err := e.w.Write(b)
+	e.written += int64(n)
+	return err
+}
+
+// slide drops data that can no longer be referenced from the window.
+func (e *encoder) slide() {
+	keep := e.pos - int64(e.dictSize)
+	if e.chunkStart < keep {
+		keep = e.chunkStart
+	}
+	delta := keep - e.base
+	if delta <= 0 {
+		return
+	}
+	copy(e.buf, e.buf[delta:])
+	e.buf = e.buf[:int64(len(e.buf))-delta]
+	e.base = keep
+
+	rebase := func(t []int32) {
+		for i, p := range t {
+			if int64(p) < delta {
+				t[i] = noPos
+			} else {
+				t[i] = p - int32(delta)
+			}
+		}
+	}
+	rebase(e.head)
+	rebase(e.chain)
+}
+
+func (e *encoder) end() int64 {
+	return e.base + int64(len(e.buf))
+}
+
+func (e *encoder) at(pos int64) byte {
+	return e.buf[pos-e.base]
+}
+
+func hash4(b []byte) uint32 {
+	return ((uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24) * 2654435761) >> (32 - hashBits)
+}
+
+// insertUntil adds all positions before pos to the hash chains.
+func (e *encoder) insertUntil(pos int64) {
+	if limit := e.end() - 3; pos > limit {
+		pos = limit
+	}
+	for ; e.hashNext < pos; e.hashNext++ {
+		i := e.hashNext - e.base
+		h := hash4(e.buf[i:])
+		e.chain[e.hashNext&e.chainMask] = e.head[h]
+		e.head[h] = int32(i)
+	}
+}
+
+// matchLen returns the length of the match at pos with the zero-based
+// distance dist, up to max bytes.
+func (e *encoder) matchLen(pos int64, dist uint32, max int) int {
+	ref := pos - int64(dist) - 1
+	if ref < e.base || ref < 0 {
+		return 0
+	}
+	a := e.buf[pos-e.base : pos-e.base+int64(max)]
+	b := e.buf[ref-e.base:]
+	n := 0
+	for n < max && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// findMatch returns the longest match of at least 4 bytes at pos found in
+// the hash chains.
+func (e *encoder) findMatch(pos int64, max int) (int, uint32) {
+	if max < 4 {
+		return 0, 0
+	}
+	e.insertUntil(pos)
+	i := pos - e.base
+	cand := e.head[hash4(e.buf[i:])]
+	var (
+		bestLen  int
+		bestDist uint32
+	)
+	for n := 0; n < chainMax && cand != noPos; n++ {
+		ref := int64(cand) + e.base
+		dist := pos - ref
+		if dist <= 0 || dist > int64(e.dictSize) {
+			break
+		}
+		if e.buf[int64(cand)+int64(bestLen)] == e.buf[i+int64(bestLen)] {
+			if l := e.matchLen(pos, uint32(dist-1), max); l > bestLen {
+				bestLen, bestDist = l, uint32(dist-1)
+				if l >= niceLen || l == max {
+					break
+				}
+			}
+		}
+		next := e.chain[ref&e.chainMask]
+		if next >= cand {
+			break
+		}
+		cand = next
+	}
+	if bestLen < 4 {
+		return 0, 0
+	}
+	return bestLen, bestDist
+}
+
+// bestRep returns the longest match at pos using one of the recent
+// distances.
+func (e *encoder) bestRep(pos int64, max int) (int, int) {
+	var bestLen, bestIdx int
+	if max < matchLenMin {
+		return 0, 0
+	}
+	for i, d := range e.m.reps {
+		if int64(d) >= pos {
+			continue
+		}
+		if l := e.matchLen(pos, d, max); l > bestLen {
+			bestLen, bestIdx = l, i
+		}
+	}
+	if bestLen < matchLenMin {
+		return 0, 0
+	}
+	return bestLen, bestIdx
+}
+
+// encode codes symbols while enough lookahead is available, or until all
+// input is consumed if final is true.
+func (e *encoder) encode(final bool) error {
+	for {
+		avail := e.end() - e.pos
+		if avail == 0 || (!final && avail < lookahead) {
+			return nil
+		}
+		unpacked := e.pos - e.chunkStart
+		if unpacked+lookahead > chunkUnpackedMax || e.rc.pending()+chunkPackedSlack > chunkPackedMax {
+			if err := e.flushChunk(); err != nil {
+				return err
+			}
+		}
+		max := matchLenMax
+		if avail < int64(max) {
+			max = int(avail)
+		}
+
+		repLen, repIdx := e.bestRep(e.pos, max)
+		if repLen >= niceLen {
+			e.encodeRep(repIdx, repLen)
+			continue
+		}
+		mainLen, mainDist := e.findMatch(e.pos, max)
+		if repLen >= matchLenMin && (repLen+1 >= mainLen ||
+			(repLen+2 >= mainLen && mainDist >= 1<<9) ||
+			(repLen+3 >= mainLen && mainDist >= 1<<15)) {
+			e.encodeRep(repIdx, repLen)
+			continue
+		}
+		if mainLen > 0 {
+			// Lazy evaluation: prefer a literal if the next position
+			// has a clearly better match.
+			if mainLen < niceLen && max > mainLen {
+				nextLen, nextDist := e.findMatch(e.pos+1, max-1)
+				if nextLen > mainLen+1 || (nextLen == mainLen+1 && nextDist>>7 <= mainDist) {
+					e.encodeLiteral()
+					continue
+				}
+			}
+			e.encodeMatch(mainDist, mainLen)
+			continue
+		}
+		if e.m.reps[0] < uint32(e.pos) && e.at(e.pos) == e.at(e.pos-int64(e.m.reps[0])-1) {
+			e.encodeShortRep()
+			continue
+		}
+		e.encodeLiteral()
+	}
+}
+
+func (e *encoder) posState() int {
+	return int(e.pos) & (1<<e.m.pb - 1)
+}
+
+func (e *encoder) encodeLiteral() {
+	m := e.m
+	posState := e.posState()
+	e.rc.encodeBit(&m.isMatch[m.state][posState], 0)
+
+	var prev byte
+	if e.pos > 0 {
+		prev = e.at(e.pos - 1)
+	}
+	probs := m.literalProbs(e.pos, prev)
+	sym := uint32(e.at(e.pos)) | 0x100
+	if m.state < numLitStates {
+		e.rc.encodeTree(probs, sym, 8)
+	} else {
+		matchByte := uint32(e.at(e.pos - int64(m.reps[0]) - 1))
+		offs := uint32(0x100)
+		ctx := uint32(1)
+		for i := 7; i >= 0; i-- {
+			matchByte <<= 1
+			matchBit := matchByte & offs
+			bit := (sym >> uint(i)) & 1
+			e.rc.encodeBit(&probs[offs+matchBit+ctx], bit)
+			ctx = ctx<<1 | bit
+			if bit != 0 {
+				offs &= matchBit
+			} else {
+				offs &^= matchBit
+			}
+		}
+	}
+	m.updateLiteral()
+	e.pos++
+}
+
+func (e *encoder) encodeMatch(dist uint32, length int) {
+	m := e.m
+	posState := e.posState()
+	e.rc.encodeBit(&m.isMatch[m.state][posState], 1)
+	e.rc.encodeBit(&m.isRep[m.state], 0)
+	e.rc.encodeLen(&m.matchLen, length, posState)
+
+	slot := posSlotOf(dist)
+	e.rc.encodeTree(m.posSlot[lenToPosState(length)][:], slot, posSlotBits)
+	if slot >= startPosModel {
+		footerBits := uint(slot>>1) - 1
+		base := (2 | slot&1) << footerBits
+		reduced := dist - base
+		if slot < endPosModel {
+			e.rc.encodeReverseTree(m.specPos[base-slot:], reduced, footerBits)
+		} else {
+			e.rc.encodeDirect(reduced>>alignBits, footerBits-alignBits)
+			e.rc.encodeReverseTree(m.align[:], reduced&(alignSize-1), alignBits)
+		}
+	}
+	m.reps[3], m.reps[2], m.reps[1], m.reps[0] = m.reps[2], m.reps[1], m.reps[0], dist
+	m.updateMatch()
+	e.pos += int64(length)
+}
+
+func (e *encoder) encodeRep(idx int, length int) {
+	m := e.m
+	posState := e.posState()
+	e.rc.encodeBit(&m.isMatch[m.state][posState], 1)
+	e.rc.encodeBit(&m.isRep[m.state], 1)
+	if idx == 0 {
+		e.rc.encodeBit(&m.isRepG0[m.state], 0)
+		e.rc.encodeBit(&m.isRep0Long[m.state][posState], 1)
+	} else {
+		e.rc.encodeBit(&m.isRepG0[m.state], 1)
+		dist := m.reps[idx]
+		if idx == 1 {
+			e.rc.encodeBit(&m.isRepG1[m.state], 0)
+		} else {
+			e.rc.encodeBit(&m.isRepG1[m.state], 1)
+			e.rc.encodeBit(&m.isRepG2[m.state], uint32(idx-2))
+			if idx == 3 {
+				m.reps[3] = m.reps[2]
+			}
+			m.reps[2] = m.reps[1]
+		}
+		m.reps[1] = m.reps[0]
+		m.reps[0] = dist
+	}
+	e.rc.encodeLen(&m.repLen, length, posState)
+	m.updateRep()
+	e.pos += int64(length)
+}
+
+func (e *encoder) encodeShortRep() {
+	m := e.m
+	posState := e.posState()
+	e.rc.encodeBit(&m.isMatch[m.state][posState], 1)
+	e.rc.encodeBit(&m.isRep[m.state], 1)
+	e.rc.encodeBit(&m.isRepG0[m.state], 0)
+	e.rc.encodeBit(&m.isRep0Long[m.state][posState], 0)
+	m.updateShortRep()
+	e.pos++
+}
+
+// propsByte returns the LZMA properties byte for the encoder's lc, lp and
+// pb.
+func propsByte(lc, lp, pb uint) byte {
+	return byte((pb*5+lp)*9 + lc)
+}
+
+// flushChunk writes all symbols coded since the last chunk as one LZMA2
+// chunk, or as uncompressed chunks if that turns out smaller.
+func (e *encoder) flushChunk() error {
+	e.rc.flush()
+	unpacked := int(e.pos - e.chunkStart)
+	packed := e.rc.out
+
+	if len(packed) < unpacked && len(packed) <= chunkPackedMax {
+		var reset byte
+		switch {
+		case e.needDictReset:
+			reset = 3
+		case e.needProps:
+			reset = 2
+		case e.needStateReset:
+			reset = 1
+		}
+		u, p := unpacked-1, len(packed)-1
+		hdr := []byte{0x80 | reset<<5 | byte(u>>16), byte(u >> 8), byte(u), byte(p >> 8), byte(p)}
+		if reset >= 2 {
+			hdr = append(hdr, propsByte(e.m.lc, e.m.lp, e.m.pb))
+		}
+		if err := e.write(hdr); err != nil {
+			return err
+		}
+		if err := e.write(packed); err != nil {
+			return err
+		}
+		e.needDictReset, e.needProps, e.needStateReset = false, false, false
+	} else {
+		data := e.buf[e.chunkStart-e.base : e.pos-e.base]
+		for len(data) > 0 {
+			n := len(data)
+			if n > chunkPackedMax {
+				n = chunkPackedMax
+			}
+			control := byte(2)
+			if e.needDictReset {
+				control = 1
+				e.needDictReset = false
+				e.needProps = true
+			}
+			if err := e.write([]byte{control, byte((n - 1) >> 8), byte(n - 1)}); err != nil {
+				return err
+			}
+			if err := e.write(data[:n]); err != nil {
+				return err
+			}
+			data = data[n:]
+		}
+		// The decoder never saw the symbols we coded, so both sides
+		// have to start over with a fresh model.
+		e.m.reset()
+		e.needStateReset = true
+	}
+	e.rc.reset()
+	e.chunkStart = e.pos
+	return nil
+}
@@ -0,0 +1,185 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+// LZMA constants as defined by the LZMA SDK.
+const (
+	numStates    = 12
+	numLitStates = 7
+
+	// Probabilities are 11-bit fixed point numbers.
+	probBits  = 11
+	probInit  = 1 << (probBits - 1)
+	moveBits  = 5
+	topValue  = 1 << 24
+	posBitMax = 4
+
+	matchLenMin = 2
+	matchLenMax = 273
+
+	lenLowBits  = 3
+	lenMidBits  = 3
+	lenHighBits = 8
+	lenLow      = 1 << lenLowBits
+	lenMid      = 1 << lenMidBits
+
+	lenToPosStates   = 4
+	posSlotBits      = 6
+	startPosModel    = 4
+	endPosModel      = 14
+	fullDistances    = 1 << (endPosModel >> 1)
+	alignBits        = 4
+	alignSize        = 1 << alignBits
+	literalCoderSize = 0x300
+
+	// Fixed literal and position parameters used by the encoder. These
+	// are also the xz defaults.
+	defaultLC = 3
+	defaultLP = 0
+	defaultPB = 2
+)
+
+type prob uint16
+
+// lenModel holds the probabilities of a match length coder.
+type lenModel struct {
+	choice  prob
+	choice2 prob
+	low     [1 << posBitMax][lenLow]prob
+	mid     [1 << posBitMax][lenMid]prob
+	high    [1 << lenHighBits]prob
+}
+
+// model is the complete adaptive probability model of an LZMA coder along
+// with the coder state machine and the recent distances.
+type model struct {
+	isMatch    [numStates][1 << posBitMax]prob
+	isRep      [numStates]prob
+	isRepG0    [numStates]prob
+	isRepG1    [numStates]prob
+	isRepG2    [numStates]prob
+	isRep0Long [numStates][1 << posBitMax]prob
+	posSlot    [lenToPosStates][1 << posSlotBits]prob
+	specPos    [fullDistances - endPosModel + 1]prob
+	align      [alignSize]prob
+	matchLen   lenModel
+	repLen     lenModel
+	literal    []prob
+
+	state int
+	reps  [4]uint32
+
+	lc, lp, pb uint
+}
+
+func newModel(lc, lp, pb uint) *model {
+	m := &model{
+		lc:      lc,
+		lp:      lp,
+		pb:      pb,
+		literal: make([]prob, literalCoderSize<<(lc+lp)),
+	}
+	m.reset()
+	return m
+}
+
+func initProbs(p []prob) {
+	for i := range p {
+		p[i] = probInit
+	}
+}
+
+// reset resets all probabilities, the state, and the recent distances.
+func (m *model) reset() {
+	for i := range m.isMatch {
+		initProbs(m.isMatch[i][:])
+		initProbs(m.isRep0Long[i][:])
+	}
+	initProbs(m.isRep[:])
+	initProbs(m.isRepG0[:])
+	initProbs(m.isRepG1[:])
+	initProbs(m.isRepG2[:])
+	for i := range m.posSlot {
+		initProbs(m.posSlot[i][:])
+	}
+	initProbs(m.specPos[:])
+	initProbs(m.align[:])
+	for _, l := range []*lenModel{&m.matchLen, &m.repLen} {
+		l.choice = probInit
+		l.choice2 = probInit
+		for i := range l.low {
+			initProbs(l.low[i][:])
+			initProbs(l.mid[i][:])
+		}
+		initProbs(l.high[:])
+	}
+	initProbs(m.literal)
+	m.state = 0
+	m.reps = [4]uint32{}
+}
+
+// literalProbs returns the literal coder for a literal at pos following
+// prevByte.
+func (m *model) literalProbs(pos int64, prevByte byte) []prob {
+	lpMask := int64(1)<<m.lp - 1
+	i := (uint(pos&lpMask) << m.lc) + uint(prevByte)>>(8-m.lc)
+	return m.literal[literalCoderSize*i : literalCoderSize*(i+1)]
+}
+
+func (m *model) updateLiteral() {
+	switch {
+	case m.state < 4:
+		m.state = 0
+	case m.state < 10:
+		m.state -= 3
+	default:
+		m.state -= 6
+	}
+}
+
+func (m *model) updateMatch() {
+	if m.state < numLitStates {
+		m.state = 7
+	} else {
+		m.state = 10
+	}
+}
+
+func (m *model) updateRep() {
+	if m.state < numLitStates {
+		m.state = 8
+	} else {
+		m.state = 11
+	}
+}
+
+func (m *model) updateShortRep() {
+	if m.state < numLitStates {
+		m.state = 9
+	} else {
+		m.state = 11
+	}
+}
+
+// lenToPosState returns the distance coder to use for a match of length l.
+func lenToPosState(l int) int {
+	l -= matchLenMin
+	if l < lenToPosStates-1 {
+		return l
+	}
+	return lenToPosStates - 1
+}
+
+// posSlotOf returns the distance slot of the zero-based distance dist.
+func posSlotOf(dist uint32) uint32 {
+	if dist < startPosModel {
+		return dist
+	}
+	n := uint32(31)
+	for dist&(1<<n) == 0 {
+		n--
+	}
+	return n<<1 | (dist>>(n-1))&1
+}
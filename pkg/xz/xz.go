@@ -0,0 +1,543 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package xz implements reading and writing of xz compressed streams.
+//
+// The Writer produces single-block streams with one LZMA2 filter and a CRC32
+// integrity check, which is the flavor the Linux kernel decompressor
+// accepts for initramfs archives and compressed kernels.
+//
+// The Reader accepts any xz stream (or concatenation of streams) whose
+// blocks use only the LZMA2 filter, with no, CRC32, CRC64 or SHA-256
+// integrity checks.
+package xz
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+)
+
+const (
+	// DefaultDictSize is the LZMA2 dictionary size used by NewWriter.
+	DefaultDictSize = 8 << 20
+
+	filterLZMA2 = 0x21
+
+	checkNone   = 0x0
+	checkCRC32  = 0x1
+	checkCRC64  = 0x4
+	checkSHA256 = 0xA
+)
+
+var (
+	headerMagic = []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}
+	footerMagic = []byte{'Y', 'Z'}
+
+	crc64Table = crc64.MakeTable(crc64.ECMA)
+
+	// ErrFormat is returned when the input is not a valid xz stream.
+	ErrFormat = errors.New("xz: invalid format")
+)
+
+// Writer compresses data written to it into an xz stream.
+type Writer struct {
+	w   io.Writer
+	enc *encoder
+	crc hash.Hash32
+
+	dictSize     int
+	uncompressed int64
+	wroteHeader  bool
+	closed       bool
+	err          error
+}
+
+// NewWriter returns a Writer that compresses into w using DefaultDictSize.
+//
+// Callers must call Close to finish the stream.
+func NewWriter(w io.Writer) *Writer {
+	return NewWriterDictSize(w, DefaultDictSize)
+}
+
+// NewWriterDictSize returns a Writer that compresses into w using a
+// dictionary of dictSize bytes.
+//
+// dictSize is rounded up to the next size that can be encoded in the xz
+// filter properties, and is at least 1 MiB.
+func NewWriterDictSize(w io.Writer, dictSize int) *Writer {
+	dictSize = validDictSize(dictSize)
+	return &Writer{
+		w:        w,
+		enc:      newEncoder(w, dictSize),
+		crc:      crc32.NewIEEE(),
+		dictSize: dictSize,
+	}
+}
+
+// validDictSize returns the smallest dictionary size of the form 2^n or
+// 2^n + 2^(n-1) that is at least size.
+func validDictSize(size int) int {
+	for b := 16; b < 40; b++ {
+		if s := dictSizeOf(byte(b)); s >= size {
+			return s
+		}
+	}
+	return dictSizeOf(39)
+}
+
+// dictSizeOf decodes an LZMA2 dictionary size properties byte.
+func dictSizeOf(b byte) int {
+	return (2 | int(b)&1) << (b/2 + 11)
+}
+
+func dictSizeByte(size int) byte {
+	for b := byte(0); b < 40; b++ {
+		if dictSizeOf(b) >= size {
+			return b
+		}
+	}
+	return 40
+}
+
+func (x *Writer) writeHeader() error {
+	x.wroteHeader = true
+	hdr := append([]byte{}, headerMagic...)
+	flags := []byte{0, checkCRC32}
+	hdr = append(hdr, flags...)
+	hdr = appendUint32(hdr, crc32.ChecksumIEEE(flags))
+
+	// Block header: size, flags (one filter, no sizes), filter flags,
+	// padding, CRC32.
+	block := []byte{0, 0, filterLZMA2, 1, dictSizeByte(x.dictSize)}
+	for len(block)%4 != 0 {
+		block = append(block, 0)
+	}
+	block[0] = byte((len(block)+4)/4 - 1)
+	block = appendUint32(block, crc32.ChecksumIEEE(block))
+	hdr = append(hdr, block...)
+
+	_, err := x.w.Write(hdr)
+	return err
+}
+
+// blockHeaderSize is the size of the block header written by writeHeader.
+const blockHeaderSize = 12
+
+// Write implements io.Writer.
+func (x *Writer) Write(p []byte) (int, error) {
+	if x.err != nil {
+		return 0, x.err
+	}
+	if x.closed {
+		return 0, errors.New("xz: write to closed Writer")
+	}
+	if !x.wroteHeader {
+		if x.err = x.writeHeader(); x.err != nil {
+			return 0, x.err
+		}
+	}
+	var n int
+	n, x.err = x.enc.Write(p)
+	x.crc.Write(p[:n])
+	x.uncompressed += int64(n)
+	return n, x.err
+}
+
+// Close finishes the xz stream. It does not close the underlying writer.
+func (x *Writer) Close() error {
+	if x.err != nil || x.closed {
+		return x.err
+	}
+	x.closed = true
+	if !x.wroteHeader {
+		if x.err = x.writeHeader(); x.err != nil {
+			return x.err
+		}
+	}
+	if x.err = x.enc.Close(); x.err != nil {
+		return x.err
+	}
+
+	compressed := x.enc.written
+	var tail []byte
+	for (compressed+int64(len(tail)))%4 != 0 {
+		tail = append(tail, 0)
+	}
+	tail = appendUint32(tail, x.crc.Sum32())
+
+	// Index with a single record.
+	index := []byte{0}
+	index = appendVarint(index, 1)
+	index = appendVarint(index, uint64(blockHeaderSize+compressed+4))
+	index = appendVarint(index, uint64(x.uncompressed))
+	for len(index)%4 != 0 {
+		index = append(index, 0)
+	}
+	index = appendUint32(index, crc32.ChecksumIEEE(index))
+	tail = append(tail, index...)
+
+	// Stream footer.
+	footer := appendUint32(nil, uint32(len(index)/4-1))
+	footer = append(footer, 0, checkCRC32)
+	tail = appendUint32(tail, crc32.ChecksumIEEE(footer))
+	tail = append(tail, footer...)
+	tail = append(tail, footerMagic...)
+
+	_, x.err = x.w.Write(tail)
+	return x.err
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// countingReader counts the bytes read through it and optionally hashes
+// them.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+	h hash.Hash32
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.h != nil {
+		c.h.Write(p[:n])
+	}
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+		if c.h != nil {
+			c.h.Write([]byte{b})
+		}
+	}
+	return b, err
+}
+
+// Reader decompresses an xz stream.
+type Reader struct {
+	r *countingReader
+
+	check  byte
+	blocks int64
+
+	block      *lzma2Decoder
+	blockStart int64
+	hash       hash.Hash
+	err        error
+}
+
+// NewReader returns a Reader decompressing the xz stream in r.
+//
+// NewReader reads and validates the stream header.
+func NewReader(r io.Reader) (*Reader, error) {
+	cr := &countingReader{r: bufio.NewReader(r)}
+	x := &Reader{r: cr}
+	if err := x.readStreamHeader(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func (x *Reader) readStreamHeader() error {
+	var hdr [12]byte
+	if _, err := io.ReadFull(x.r, hdr[:]); err != nil {
+		if err == io.EOF {
+			return ErrFormat
+		}
+		return unexpected(err)
+	}
+	if !bytes.Equal(hdr[:6], headerMagic) {
+		return ErrFormat
+	}
+	if hdr[6] != 0 || hdr[7] > 0xF {
+		return fmt.Errorf("xz: unsupported stream flags %#x", hdr[6:8])
+	}
+	if crc32.ChecksumIEEE(hdr[6:8]) != binary.LittleEndian.Uint32(hdr[8:]) {
+		return errors.New("xz: stream header checksum mismatch")
+	}
+	x.check = hdr[7]
+	x.blocks = 0
+	return nil
+}
+
+func checkSize(check byte) int {
+	if check == 0 {
+		return 0
+	}
+	return 4 << ((check - 1) / 3)
+}
+
+func (x *Reader) newHash() hash.Hash {
+	switch x.check {
+	case checkCRC32:
+		return crc32.NewIEEE()
+	case checkCRC64:
+		return crc64.New(crc64Table)
+	case checkSHA256:
+		return sha256.New()
+	}
+	return nil
+}
+
+// Read implements io.Reader.
+func (x *Reader) Read(p []byte) (int, error) {
+	for x.err == nil {
+		if x.block == nil {
+			x.err = x.nextBlock()
+			continue
+		}
+		n, err := x.block.Read(p)
+		if x.hash != nil {
+			x.hash.Write(p[:n])
+		}
+		if err == io.EOF {
+			x.err = x.finishBlock()
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		if err != nil {
+			x.err = err
+		}
+		return n, err
+	}
+	return 0, x.err
+}
+
+func (x *Reader) readVarint() (uint64, error) {
+	var v uint64
+	for i := uint(0); i < 9; i++ {
+		b, err := x.r.ReadByte()
+		if err != nil {
+			return 0, unexpected(err)
+		}
+		v |= uint64(b&0x7F) << (7 * i)
+		if b&0x80 == 0 {
+			if b == 0 && i > 0 {
+				return 0, ErrFormat
+			}
+			return v, nil
+		}
+	}
+	return 0, ErrFormat
+}
+
+// nextBlock starts decoding the next block, or reads the index and footer
+// if there are no more blocks in the stream.
+func (x *Reader) nextBlock() error {
+	size, err := x.r.ReadByte()
+	if err != nil {
+		return unexpected(err)
+	}
+	if size == 0 {
+		if err := x.readIndex(); err != nil {
+			return err
+		}
+		return x.nextStream()
+	}
+	x.blockStart = x.r.n - 1
+
+	hdr := make([]byte, (int(size)+1)*4)
+	hdr[0] = size
+	if _, err := io.ReadFull(x.r, hdr[1:]); err != nil {
+		return unexpected(err)
+	}
+	n := len(hdr) - 4
+	if crc32.ChecksumIEEE(hdr[:n]) != binary.LittleEndian.Uint32(hdr[n:]) {
+		return errors.New("xz: block header checksum mismatch")
+	}
+	flags := hdr[1]
+	if flags&0x3C != 0 {
+		return fmt.Errorf("xz: unsupported block flags %#x", flags)
+	}
+	br := bytes.NewReader(hdr[2:n])
+	readVarint := func() (uint64, error) {
+		var v uint64
+		for i := uint(0); i < 9; i++ {
+			b, err := br.ReadByte()
+			if err != nil {
+				return 0, ErrFormat
+			}
+			v |= uint64(b&0x7F) << (7 * i)
+			if b&0x80 == 0 {
+				return v, nil
+			}
+		}
+		return 0, ErrFormat
+	}
+	if flags&0x40 != 0 {
+		if _, err := readVarint(); err != nil {
+			return err
+		}
+	}
+	if flags&0x80 != 0 {
+		if _, err := readVarint(); err != nil {
+			return err
+		}
+	}
+	if filters := flags&0x3 + 1; filters != 1 {
+		return fmt.Errorf("xz: %d filters in block, only LZMA2 alone is supported", filters)
+	}
+	id, err := readVarint()
+	if err != nil {
+		return err
+	}
+	if id != filterLZMA2 {
+		return fmt.Errorf("xz: unsupported filter %#x", id)
+	}
+	if propSize, err := readVarint(); err != nil || propSize != 1 {
+		return ErrFormat
+	}
+	prop, err := br.ReadByte()
+	if err != nil || prop > 40 {
+		return ErrFormat
+	}
+	dictSize := int64(0xFFFFFFFF)
+	if prop < 40 {
+		dictSize = int64(dictSizeOf(prop))
+	}
+	// Header padding must be zero.
+	for br.Len() > 0 {
+		if b, _ := br.ReadByte(); b != 0 {
+			return ErrFormat
+		}
+	}
+
+	x.block = newLZMA2Decoder(x.r, int(dictSize))
+	x.hash = x.newHash()
+	return nil
+}
+
+// finishBlock verifies block padding and the integrity check.
+func (x *Reader) finishBlock() error {
+	for (x.r.n-x.blockStart)%4 != 0 {
+		b, err := x.r.ReadByte()
+		if err != nil {
+			return unexpected(err)
+		}
+		if b != 0 {
+			return ErrFormat
+		}
+	}
+	sum := make([]byte, checkSize(x.check))
+	if _, err := io.ReadFull(x.r, sum); err != nil {
+		return unexpected(err)
+	}
+	if x.hash != nil {
+		want := x.hash.Sum(nil)
+		if x.check == checkCRC32 || x.check == checkCRC64 {
+			// CRCs are stored little endian.
+			for i, j := 0, len(want)-1; i < j; i, j = i+1, j-1 {
+				want[i], want[j] = want[j], want[i]
+			}
+		}
+		if !bytes.Equal(sum, want) {
+			return errors.New("xz: integrity check failed")
+		}
+	}
+	x.block = nil
+	x.hash = nil
+	x.blocks++
+	return nil
+}
+
+// readIndex reads the index after its indicator byte and validates it.
+func (x *Reader) readIndex() error {
+	start := x.r.n - 1
+	x.r.h = crc32.NewIEEE()
+	x.r.h.Write([]byte{0})
+	defer func() { x.r.h = nil }()
+
+	records, err := x.readVarint()
+	if err != nil {
+		return err
+	}
+	if records != uint64(x.blocks) {
+		return errors.New("xz: index does not match the number of blocks")
+	}
+	// The index content is only checksummed; the decoder does not need
+	// the block sizes.
+	for i := uint64(0); i < 2*records; i++ {
+		if _, err := x.readVarint(); err != nil {
+			return err
+		}
+	}
+	for (x.r.n-start)%4 != 0 {
+		if b, err := x.r.ReadByte(); err != nil || b != 0 {
+			return ErrFormat
+		}
+	}
+	want := x.r.h.Sum32()
+	x.r.h = nil
+	var sum [4]byte
+	if _, err := io.ReadFull(x.r, sum[:]); err != nil {
+		return unexpected(err)
+	}
+	if binary.LittleEndian.Uint32(sum[:]) != want {
+		return errors.New("xz: index checksum mismatch")
+	}
+	indexSize := x.r.n - start
+
+	var footer [12]byte
+	if _, err := io.ReadFull(x.r, footer[:]); err != nil {
+		return unexpected(err)
+	}
+	if !bytes.Equal(footer[10:], footerMagic) {
+		return ErrFormat
+	}
+	if crc32.ChecksumIEEE(footer[4:10]) != binary.LittleEndian.Uint32(footer[:4]) {
+		return errors.New("xz: stream footer checksum mismatch")
+	}
+	if footer[8] != 0 || footer[9] != x.check {
+		return errors.New("xz: stream footer flags do not match header")
+	}
+	if backward := int64(binary.LittleEndian.Uint32(footer[4:8])+1) * 4; backward != indexSize {
+		return errors.New("xz: stream footer index size mismatch")
+	}
+	return nil
+}
+
+// nextStream skips stream padding and reads the header of a concatenated
+// stream, if any.
+func (x *Reader) nextStream() error {
+	for {
+		b, err := x.r.r.Peek(4)
+		if err == io.EOF && len(b) == 0 {
+			return io.EOF
+		}
+		if err != nil {
+			return unexpected(err)
+		}
+		if !bytes.Equal(b, []byte{0, 0, 0, 0}) {
+			break
+		}
+		x.r.r.Discard(4)
+	}
+	return x.readStreamHeader()
+}
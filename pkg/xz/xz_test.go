@@ -0,0 +1,170 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func compress(t *testing.T, b []byte, dictSize int) []byte {
+	var buf bytes.Buffer
+	w := NewWriterDictSize(&buf, dictSize)
+	// Write in odd pieces to exercise buffering.
+	for len(b) > 0 {
+		n := 12345
+		if n > len(b) {
+			n = len(b)
+		}
+		if _, err := w.Write(b[:n]); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		b = b[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decompress(t *testing.T, b []byte) []byte {
+	r, err := NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	return out
+}
+
+func TestRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(0))
+	random := make([]byte, 3<<20)
+	rnd.Read(random)
+
+	// Compressible data with matches at all kinds of distances.
+	var text bytes.Buffer
+	words := strings.Fields("the quick brown fox jumps over a lazy dog u-root initramfs cpio kexec")
+	for text.Len() < 5<<20 {
+		text.WriteString(words[rnd.Intn(len(words))])
+		text.WriteByte(" \n"[rnd.Intn(2)])
+	}
+
+	for _, tt := range []struct {
+		name     string
+		data     []byte
+		dictSize int
+	}{
+		{name: "empty", data: nil},
+		{name: "one byte", data: []byte{'a'}},
+		{name: "short", data: []byte("hello, hello, hello world")},
+		{name: "zeros", data: make([]byte, 4<<20)},
+		{name: "random", data: random},
+		{name: "text", data: text.Bytes()},
+		{name: "text with small dictionary", data: text.Bytes(), dictSize: 1 << 20},
+		{name: "random then text", data: append(append([]byte{}, random[:1<<20]...), text.Bytes()[:1<<20]...)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dictSize := tt.dictSize
+			if dictSize == 0 {
+				dictSize = DefaultDictSize
+			}
+			c := compress(t, tt.data, dictSize)
+			if got := decompress(t, c); !bytes.Equal(got, tt.data) {
+				t.Errorf("round trip of %d bytes returned %d different bytes", len(tt.data), len(got))
+			}
+		})
+	}
+}
+
+func TestCompresses(t *testing.T) {
+	data := bytes.Repeat([]byte("u-root is a universal root. "), 10000)
+	if c := compress(t, data, DefaultDictSize); len(c) > len(data)/50 {
+		t.Errorf("compressed %d bytes to %d bytes, want at most %d", len(data), len(c), len(data)/50)
+	}
+}
+
+func TestReadExternal(t *testing.T) {
+	want, err := ioutil.ReadFile("testdata/text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		file string
+		want []byte
+	}{
+		{file: "testdata/text.none.xz", want: want},
+		{file: "testdata/text.crc64.xz", want: want},
+		{file: "testdata/text.sha256.xz", want: want},
+		{file: "testdata/text2.xz", want: append(append([]byte{}, want...), want...)},
+	} {
+		t.Run(tt.file, func(t *testing.T) {
+			b, err := ioutil.ReadFile(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := decompress(t, b); !bytes.Equal(got, tt.want) {
+				t.Errorf("decompressed %q does not match testdata/text", tt.file)
+			}
+		})
+	}
+}
+
+func TestReadCorrupt(t *testing.T) {
+	good := compress(t, bytes.Repeat([]byte("corrupt me "), 1000), DefaultDictSize)
+
+	for _, tt := range []struct {
+		name   string
+		modify func([]byte) []byte
+	}{
+		{
+			name:   "truncated",
+			modify: func(b []byte) []byte { return b[:len(b)-20] },
+		},
+		{
+			name: "flipped data bit",
+			modify: func(b []byte) []byte {
+				b[30] ^= 0x10
+				return b
+			},
+		},
+		{
+			name: "bad check",
+			modify: func(b []byte) []byte {
+				// The CRC32 precedes the 12 byte index and 12
+				// byte footer.
+				b[len(b)-25] ^= 0xFF
+				return b
+			},
+		},
+		{
+			name: "bad footer magic",
+			modify: func(b []byte) []byte {
+				b[len(b)-1] = 'X'
+				return b
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.modify(append([]byte{}, good...))
+			r, err := NewReader(bytes.NewReader(b))
+			if err != nil {
+				return
+			}
+			if _, err := ioutil.ReadAll(r); err == nil {
+				t.Errorf("ReadAll of corrupt stream succeeded")
+			}
+		})
+	}
+
+	if _, err := NewReader(strings.NewReader("not xz at all")); err != ErrFormat {
+		t.Errorf("NewReader(garbage) = %v, want %v", err, ErrFormat)
+	}
+}
@@ -97,6 +97,7 @@ func main() {
 	}
 
 	r := archiver.Reader(f)
+	e := cpio.NewExtractor(tempDir, false)
 	for {
 		rec, err := r.ReadRecord()
 		if err == io.EOF {
@@ -105,7 +106,7 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
-		cpio.CreateFileInRoot(rec, tempDir, false)
+		e.CreateFile(rec)
 	}
 
 	cmd, err := pty.New()
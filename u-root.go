@@ -7,16 +7,20 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 
+	"github.com/u-root/u-root/pkg/crypto"
 	"github.com/u-root/u-root/pkg/golang"
 	"github.com/u-root/u-root/pkg/uroot"
 	"github.com/u-root/u-root/pkg/uroot/builder"
 	"github.com/u-root/u-root/pkg/uroot/initramfs"
+	"golang.org/x/crypto/ed25519"
 )
 
 // multiFlag is used for flags that support multiple invocations, e.g. -files
@@ -34,8 +38,16 @@ func (m *multiFlag) Set(value string) error {
 // Flags for u-root builder.
 var (
 	build, format, tmpDir, base, outputPath *string
+	compress                                *string
+	bbCache                                 *string
+	bbSizeReport                            *string
 	initCmd                                 *string
+	uinitCmd                                *string
 	defaultShell                            *string
+	manifest, manifestOut                   *string
+	reproducible                            *bool
+	hashManifest                            *string
+	signKey                                 *string
 	useExistingInit                         *bool
 	fourbins                                *bool
 	noCommands                              *bool
@@ -46,6 +58,9 @@ func init() {
 	fourbins = flag.Bool("fourbins", false, "build installcommand on boot, no ahead of time, so we have only four binares")
 	build = flag.String("build", "source", "u-root build format (e.g. bb or source).")
 	format = flag.String("format", "cpio", "Archival format.")
+	compress = flag.String("compress", "none", "Compression of the cpio archive (none, gzip, xz, or lz4).")
+	bbCache = flag.String("bbcache", "", "Cache directory for rewritten packages and binaries of the bb build, e.g. $HOME/.cache/u-root/bb. Caching is off by default.")
+	bbSizeReport = flag.String("bbsizereport", "", "Write how much code each command and package contributes to the bb binary to this path. Use - for stdout.")
 
 	tmpDir = flag.String("tmpdir", "", "Temporary directory to put binaries in.")
 
@@ -54,9 +69,17 @@ func init() {
 	outputPath = flag.String("o", "", "Path to output initramfs file.")
 
 	initCmd = flag.String("initcmd", "init", "Symlink target for /init. Can be an absolute path or a u-root command name. Use initcmd=\"\" if you don't want the symlink.")
+	uinitCmd = flag.String("uinitcmd", "", "Symlink target for /bin/uinit, which init runs. Can be an absolute path or a u-root command name.")
 	defaultShell = flag.String("defaultsh", "elvish", "Default shell. Can be an absolute path or a u-root command name. Use defaultsh=\"\" if you don't want the symlink.")
 	noCommands = flag.Bool("nocmd", false, "Build no Go commands; initramfs only")
 
+	manifest = flag.String("manifest", "", "JSON manifest describing the initramfs to build. Flags given on the command line override the manifest.")
+	manifestOut = flag.String("manifestout", "", "Write a JSON manifest describing the initramfs to this path, to build it again with -manifest. Use - for stdout.")
+
+	reproducible = flag.Bool("reproducible", false, "Build a bit-for-bit reproducible initramfs: Go binaries without build IDs and file system paths, and all file modification times set to $SOURCE_DATE_EPOCH (or 0). Requires Go 1.13 or later.")
+	signKey = flag.String("signkey", "", "Sign the initramfs with this PEM-encoded ed25519 private key. init verifies it with the public key in the read-only VPD variable uroot_manifest_key.")
+	hashManifest = flag.String("hashmanifest", "", "Write the hash, mode, and name of every file in the initramfs to this path. Diff the hash manifests of two builds to find which files differ.")
+
 	flag.Var(&extraFiles, "files", "Additional files, directories, and binaries (with their ldd dependencies) to add to archive. Can be speficified multiple times.")
 }
 
@@ -84,17 +107,197 @@ func isRecommendedVersion(v string) bool {
 	return false
 }
 
+// manifestFromFlags returns the manifest given by -manifest with the flags
+// set on the command line applied, or the manifest described by all flags
+// if there is no -manifest.
+func manifestFromFlags() (*uroot.Manifest, error) {
+	m := uroot.NewManifest()
+	if *manifest != "" {
+		var err error
+		if m, err = uroot.ReadManifest(*manifest); err != nil {
+			return nil, err
+		}
+	}
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	apply := func(names ...string) bool {
+		if *manifest == "" {
+			return true
+		}
+		for _, name := range names {
+			if set[name] {
+				return true
+			}
+		}
+		return false
+	}
+
+	if apply("format") {
+		m.Format = *format
+	}
+	if apply("compress") {
+		m.Compress = *compress
+	}
+	if apply("base") {
+		m.Base = *base
+	}
+	if apply("useinit") {
+		m.UseExistingInit = *useExistingInit
+	}
+	if apply("o") {
+		m.Output = *outputPath
+	}
+	if apply("initcmd") {
+		m.Init = *initCmd
+	}
+	if apply("uinitcmd") {
+		m.Uinit = *uinitCmd
+	}
+	if apply("defaultsh") {
+		m.Shell = *defaultShell
+	}
+	if apply("files") {
+		m.Files = extraFiles
+	}
+	if apply("reproducible") {
+		m.Reproducible = *reproducible
+		m.MTime = 0
+		if *reproducible {
+			mtime, err := sourceDateEpoch()
+			if err != nil {
+				return nil, err
+			}
+			m.MTime = mtime
+		}
+	}
+
+	if !apply("build", "fourbins", "nocmd") && flag.NArg() == 0 {
+		return m, nil
+	}
+	m.Commands = nil
+	if *noCommands {
+		return m, nil
+	}
+	if _, ok := builder.Builders[*build]; !ok {
+		return nil, fmt.Errorf("could not find builder %q", *build)
+	}
+
+	// Resolve globs into package imports.
+	//
+	// Currently allowed formats:
+	//   Go package imports; e.g. github.com/u-root/u-root/cmds/ls (must be in $GOPATH)
+	//   Paths to Go package directories; e.g. $GOPATH/src/github.com/u-root/u-root/cmds/*
+	var pkgs []string
+	for _, a := range flag.Args() {
+		p, ok := templates[a]
+		if !ok {
+			pkgs = append(pkgs, a)
+			continue
+		}
+		pkgs = append(pkgs, p...)
+	}
+	if len(pkgs) == 0 {
+		pkgs = []string{"github.com/u-root/u-root/cmds/core/*"}
+	}
+
+	fourBins := *fourbins && *build == "source"
+	if fourBins && !set["initcmd"] {
+		m.Init = "/go/bin/go"
+	}
+
+	// The command-line tool only allows specifying one build mode
+	// right now.
+	m.Commands = []uroot.ManifestCommands{
+		{
+			Builder:  *build,
+			Packages: pkgs,
+			FourBins: fourBins,
+		},
+	}
+	return m, nil
+}
+
+// sourceDateEpoch returns the SOURCE_DATE_EPOCH environment variable, or 0 if
+// it is not set.
+//
+// See https://reproducible-builds.org/specs/source-date-epoch/.
+func sourceDateEpoch() (uint64, error) {
+	epoch := os.Getenv("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		return 0, nil
+	}
+	mtime, err := strconv.ParseUint(epoch, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %v", epoch, err)
+	}
+	return mtime, nil
+}
+
+// writeManifest writes m to path, or to stdout if path is "-".
+func writeManifest(m *uroot.Manifest, path string) error {
+	if path == "-" {
+		_, err := m.WriteTo(os.Stdout)
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := m.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 // Main is a separate function so defers are run on return, which they wouldn't
 // on exit.
 func Main() error {
-	env := golang.Default()
-	if *fourbins && env.GOROOT == "" {
-		log.Fatalf("You have to set GOROOT for fourbins to work")
+	m, err := manifestFromFlags()
+	if err != nil {
+		return err
 	}
+
+	env := golang.Default()
 	if env.CgoEnabled {
 		log.Printf("Disabling CGO for u-root...")
 		env.CgoEnabled = false
 	}
+	opts, err := m.Opts(env)
+	if err != nil {
+		return err
+	}
+	env = opts.Env
+
+	var sizeReport io.Writer
+	switch *bbSizeReport {
+	case "":
+	case "-":
+		sizeReport = os.Stdout
+	default:
+		f, err := os.Create(*bbSizeReport)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		sizeReport = f
+	}
+
+	for i, c := range opts.Commands {
+		switch b := c.Builder.(type) {
+		case builder.BBBuilder:
+			b.CacheDir = *bbCache
+			b.SizeReport = sizeReport
+			opts.Commands[i].Builder = b
+		case builder.SourceBuilder:
+			if b.FourBins && env.GOROOT == "" {
+				log.Fatalf("You have to set GOROOT for fourbins to work")
+			}
+		}
+	}
+
 	log.Printf("Build environment: %s", env)
 	if env.GOOS != "linux" {
 		log.Printf("GOOS is not linux. Did you mean to set GOOS=linux?")
@@ -113,28 +316,48 @@ func Main() error {
 			v, recommendedVersions, recommendedVersions[0])
 	}
 
-	archiver, err := initramfs.GetArchiver(*format)
+	if *manifestOut != "" {
+		// Pin the build environment for reproducibility.
+		m.GOOS, m.GOARCH = env.GOOS, env.GOARCH
+		if err := writeManifest(m, *manifestOut); err != nil {
+			return err
+		}
+	}
+
+	archiver, err := initramfs.GetArchiver(m.Format)
+	if err != nil {
+		return err
+	}
+	compressor, err := initramfs.GetCompressor(m.Compress)
 	if err != nil {
 		return err
 	}
+	if compressor != nil {
+		ca, ok := archiver.(initramfs.CPIOArchiver)
+		if !ok {
+			return fmt.Errorf("compression is only supported for the cpio format, not %q", m.Format)
+		}
+		ca.Compressor = compressor
+		archiver = ca
+	}
 
 	logger := log.New(os.Stderr, "", log.LstdFlags)
 	// Open the target initramfs file.
-	w, err := archiver.OpenWriter(logger, *outputPath, env.GOOS, env.GOARCH)
+	w, err := archiver.OpenWriter(logger, m.Output, env.GOOS, env.GOARCH)
 	if err != nil {
 		return err
 	}
+	opts.OutputFile = w
 
-	var baseFile initramfs.Reader
-	if *base != "" {
-		bf, err := os.Open(*base)
+	if m.Base != "" {
+		bf, err := os.Open(m.Base)
 		if err != nil {
 			return err
 		}
 		defer bf.Close()
-		baseFile = archiver.Reader(bf)
+		opts.BaseArchive = archiver.Reader(bf)
 	} else {
-		baseFile = uroot.DefaultRamfs.Reader()
+		opts.BaseArchive = uroot.DefaultRamfs.Reader()
 	}
 
 	tempDir := *tmpDir
@@ -150,66 +373,27 @@ func Main() error {
 			return fmt.Errorf("temporary directory %q did not exist; tried to mkdir but failed: %v", tempDir, err)
 		}
 	}
+	opts.TempDir = tempDir
 
-	var (
-		c           []uroot.Commands
-		initCommand = *initCmd
-	)
-	if !*noCommands {
-		var b builder.Builder
-		switch *build {
-		case "bb":
-			b = builder.BBBuilder{}
-		case "binary":
-			b = builder.BinaryBuilder{}
-		case "source":
-			b = builder.SourceBuilder{
-				FourBins: *fourbins,
-			}
-		default:
-			return fmt.Errorf("could not find builder %q", *build)
-		}
-
-		// Resolve globs into package imports.
-		//
-		// Currently allowed formats:
-		//   Go package imports; e.g. github.com/u-root/u-root/cmds/ls (must be in $GOPATH)
-		//   Paths to Go package directories; e.g. $GOPATH/src/github.com/u-root/u-root/cmds/*
-		var pkgs []string
-		for _, a := range flag.Args() {
-			p, ok := templates[a]
-			if !ok {
-				pkgs = append(pkgs, a)
-				continue
-			}
-			pkgs = append(pkgs, p...)
+	if *signKey != "" {
+		key, err := crypto.LoadPrivateKeyFromFile(*signKey, nil)
+		if err != nil {
+			return fmt.Errorf("could not load signing key: %v", err)
 		}
-		if len(pkgs) == 0 {
-			pkgs = []string{"github.com/u-root/u-root/cmds/core/*"}
+		if len(key) != ed25519.PrivateKeySize {
+			return fmt.Errorf("signing key %s is not an ed25519 private key", *signKey)
 		}
+		opts.SigningKey = key
+	}
 
-		if *fourbins && *build == "source" {
-			initCommand = "/go/bin/go"
+	if *hashManifest != "" {
+		hf, err := os.Create(*hashManifest)
+		if err != nil {
+			return err
 		}
-
-		// The command-line tool only allows specifying one build mode
-		// right now.
-		c = append(c, uroot.Commands{
-			Builder:  b,
-			Packages: pkgs,
-		})
+		defer hf.Close()
+		opts.HashManifest = hf
 	}
 
-	opts := uroot.Opts{
-		Env:             env,
-		Commands:        c,
-		TempDir:         tempDir,
-		ExtraFiles:      extraFiles,
-		OutputFile:      w,
-		BaseArchive:     baseFile,
-		UseExistingInit: *useExistingInit,
-		InitCmd:         initCommand,
-		DefaultShell:    *defaultShell,
-	}
 	return uroot.CreateInitramfs(logger, opts)
 }
@@ -16,7 +16,9 @@ import (
 	"testing"
 
 	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/crypto"
 	"github.com/u-root/u-root/pkg/testutil"
+	"github.com/u-root/u-root/pkg/uroot/hashmanifest"
 	itest "github.com/u-root/u-root/pkg/uroot/initramfs/test"
 )
 
@@ -79,6 +81,16 @@ func TestUrootCmdline(t *testing.T) {
 	samplef.Close()
 	defer os.RemoveAll(samplef.Name())
 
+	keyDir, err := ioutil.TempDir("", "u-root-key-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keyDir)
+	signKey := filepath.Join(keyDir, "key.pem")
+	if err := crypto.GeneratED25519Key(nil, signKey, filepath.Join(keyDir, "key.pub")); err != nil {
+		t.Fatal(err)
+	}
+
 	for _, tt := range []struct {
 		name       string
 		env        []string
@@ -118,6 +130,15 @@ func TestUrootCmdline(t *testing.T) {
 				itest.HasFile{"bin/bush"},
 			},
 		},
+		{
+			name: "signed",
+			args: []string{"-nocmd", "-files=/bin/bash", "-signkey=" + signKey},
+			validators: []itest.ArchiveValidator{
+				itest.HasFile{"bin/bash"},
+				itest.HasFile{hashmanifest.Path},
+				itest.HasFile{hashmanifest.SignaturePath},
+			},
+		},
 		{
 			name: "hosted source mode",
 			args: append([]string{"-build=source", "-base=/dev/null", "-defaultsh=", "-initcmd="}, srcmds...),
@@ -195,6 +216,101 @@ func TestUrootCmdline(t *testing.T) {
 	}
 }
 
+func TestManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-root-manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	manifest := filepath.Join(dir, "manifest.json")
+
+	f1, _ := buildIt(t, []string{"-nocmd", "-files=/bin/bash", "-uinitcmd=/bin/bash", "-manifestout=" + manifest}, nil, nil)
+	defer os.RemoveAll(f1.Name())
+
+	// Building from the manifest must produce the same archive.
+	f2, _ := buildIt(t, []string{"-manifest=" + manifest}, nil, nil)
+	defer os.RemoveAll(f2.Name())
+
+	b1, err := ioutil.ReadFile(f1.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := ioutil.ReadFile(f2.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b1, b2) {
+		t.Errorf("archive built from manifest differs from the original")
+	}
+
+	a, err := itest.ReadArchive(f2.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []itest.ArchiveValidator{
+		itest.HasFile{Path: "bin/bash"},
+		itest.HasRecord{R: cpio.Symlink("bin/uinit", "../bin/bash")},
+	} {
+		if err := v.Validate(a); err != nil {
+			t.Errorf("validator failed: %v / archive:\n%s", err, a)
+		}
+	}
+}
+
+func TestReproducible(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-root-reproducible")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var archives, manifests [2]string
+	for i := range archives {
+		manifests[i] = filepath.Join(dir, fmt.Sprintf("hashes%d.txt", i))
+		f, _ := buildIt(t, []string{
+			"-build=bb", "-bbcache=", "-initcmd=", "-defaultsh=", "-files=/bin/bash",
+			"-reproducible", "-hashmanifest=" + manifests[i],
+			"github.com/u-root/u-root/cmds/core/echo",
+		}, []string{"SOURCE_DATE_EPOCH=1546300800"}, nil)
+		defer os.RemoveAll(f.Name())
+		archives[i] = f.Name()
+	}
+
+	for _, files := range [][2]string{archives, manifests} {
+		b1, err := ioutil.ReadFile(files[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		b2, err := ioutil.ReadFile(files[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b1, b2) {
+			t.Errorf("%s and %s differ", files[0], files[1])
+		}
+	}
+
+	hashes, err := ioutil.ReadFile(manifests[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"bbin/bb", "bbin/echo", "bin/bash"} {
+		if !bytes.Contains(hashes, []byte("  "+name+"\n")) {
+			t.Errorf("hash manifest does not list %q:\n%s", name, hashes)
+		}
+	}
+
+	a, err := itest.ReadArchive(archives[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, r := range a.Files {
+		if r.MTime != 1546300800 {
+			t.Errorf("%q has mtime %d, want 1546300800", name, r.MTime)
+		}
+	}
+}
+
 func buildIt(t *testing.T, args, env []string, want error) (*os.File, []byte) {
 	f, err := ioutil.TempFile("", "u-root-")
 	if err != nil {